@@ -0,0 +1,162 @@
+package timeseries
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// JSONFormat selects the JSON representation of a Block.
+type JSONFormat int
+
+const (
+	// JSONBase64 represents a block as a base64 encoded string of the encoded bytes.
+	JSONBase64 JSONFormat = iota
+
+	// JSONPoints represents a block as an object which has the block timestamp
+	// and the decoded data points, for example
+	// {"t0":1427162400,"points":[{"Timestamp":1427162462,"Value":12}]}
+	JSONPoints
+)
+
+// Block is an encoded time-series block which holds a block timestamp and
+// data points.
+//
+// Block implements encoding.BinaryMarshaler, encoding.BinaryUnmarshaler,
+// json.Marshaler, json.Unmarshaler, sql.Scanner and driver.Valuer so that
+// it can be used in structs with encoding/json, encoding/gob and database/sql.
+// The zero value is an empty block which is encoded to null in JSON and to
+// NULL in SQL.
+type Block struct {
+	// Data is the encoded bytes made by Marshal or Encoder.
+	Data []byte
+
+	// JSONFormat selects the representation used by MarshalJSON.
+	// UnmarshalJSON accepts both representations regardless of this value.
+	JSONFormat JSONFormat
+}
+
+type blockJSON struct {
+	T0     uint32  `json:"t0"`
+	Points []Point `json:"points"`
+}
+
+// NewBlock encodes a block timestamp and data points to a block.
+func NewBlock(t0 uint32, points []Point) (Block, error) {
+	data, err := Marshal(t0, points)
+	if err != nil {
+		return Block{}, err
+	}
+	return Block{Data: data}, nil
+}
+
+// Points decodes the block to the block timestamp and data points.
+func (b Block) Points() (t0 uint32, points []Point, err error) {
+	return Unmarshal(b.Data)
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (b Block) MarshalBinary() ([]byte, error) {
+	return b.Data, nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+// It returns an error if data is not a valid encoded block.
+func (b *Block) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		b.Data = nil
+		return nil
+	}
+
+	_, _, err := Unmarshal(data)
+	if err != nil {
+		return err
+	}
+	b.Data = append([]byte(nil), data...)
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (b Block) MarshalJSON() ([]byte, error) {
+	if len(b.Data) == 0 {
+		return []byte("null"), nil
+	}
+
+	switch b.JSONFormat {
+	case JSONBase64:
+		return json.Marshal(b.Data)
+	case JSONPoints:
+		t0, points, err := b.Points()
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(blockJSON{T0: t0, Points: points})
+	default:
+		return nil, fmt.Errorf("unsupported block JSON format: %d", b.JSONFormat)
+	}
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+// It accepts both a base64 encoded string and an object with the block
+// timestamp and data points, and sets JSONFormat to the one it found.
+func (b *Block) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		return nil
+	case len(data) > 0 && data[0] == '"':
+		var s string
+		err := json.Unmarshal(data, &s)
+		if err != nil {
+			return err
+		}
+		decoded, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return err
+		}
+		err = b.UnmarshalBinary(decoded)
+		if err != nil {
+			return err
+		}
+		b.JSONFormat = JSONBase64
+		return nil
+	default:
+		var v blockJSON
+		err := json.Unmarshal(data, &v)
+		if err != nil {
+			return err
+		}
+		encoded, err := Marshal(v.T0, v.Points)
+		if err != nil {
+			return err
+		}
+		b.Data = encoded
+		b.JSONFormat = JSONPoints
+		return nil
+	}
+}
+
+// Scan implements the sql.Scanner interface.
+func (b *Block) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		b.Data = nil
+		return nil
+	case []byte:
+		return b.UnmarshalBinary(v)
+	case string:
+		return b.UnmarshalBinary([]byte(v))
+	default:
+		return fmt.Errorf("unsupported type for timeseries block scan: %T", src)
+	}
+}
+
+// Value implements the driver.Valuer interface.
+func (b Block) Value() (driver.Value, error) {
+	if len(b.Data) == 0 {
+		return nil, nil
+	}
+	return b.Data, nil
+}
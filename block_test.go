@@ -0,0 +1,135 @@
+package timeseries_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+)
+
+func TestBlockJSON(t *testing.T) {
+	data, err := hex.DecodeString("5510c52000f900a0000000000002fc6b07ffffffffe0")
+	if err != nil {
+		t.Fatalf("failed to decode hex string: err=%+v", err)
+	}
+
+	testCases := []struct {
+		block timeseries.Block
+		want  string
+	}{
+		{
+			block: timeseries.Block{Data: data, JSONFormat: timeseries.JSONBase64},
+			want:  `{"B":"VRDFIAD5AKAAAAAAAAL8awf/////4A=="}`,
+		},
+		{
+			block: timeseries.Block{Data: data, JSONFormat: timeseries.JSONPoints},
+			want:  `{"B":{"t0":1427162400,"points":[{"Timestamp":1427162462,"Value":12},{"Timestamp":1427162522,"Value":12},{"Timestamp":1427162582,"Value":24}]}}`,
+		},
+		{
+			block: timeseries.Block{},
+			want:  `{"B":null}`,
+		},
+	}
+
+	type record struct {
+		B timeseries.Block
+	}
+
+	for _, tc := range testCases {
+		buf, err := json.Marshal(record{B: tc.block})
+		if err != nil {
+			t.Fatalf("failed to marshal block to JSON: block=%+v, err=%+v", tc.block, err)
+		}
+		got := string(buf)
+		if got != tc.want {
+			t.Errorf("got=%s, want=%s", got, tc.want)
+		}
+
+		var r record
+		err = json.Unmarshal(buf, &r)
+		if err != nil {
+			t.Fatalf("failed to unmarshal block from JSON: input=%s, err=%+v", got, err)
+		}
+		if !reflect.DeepEqual(r.B, tc.block) {
+			t.Errorf("input=%s, gotBlock=%+v, wantBlock=%+v", got, r.B, tc.block)
+		}
+	}
+}
+
+func TestBlockGob(t *testing.T) {
+	block, err := timeseries.NewBlock(
+		uint32(time.Date(2015, 3, 24, 2, 0, 0, 0, time.UTC).Unix()),
+		[]timeseries.Point{
+			{
+				Timestamp: uint32(time.Date(2015, 3, 24, 2, 1, 2, 0, time.UTC).Unix()),
+				Value:     12.0,
+			},
+		},
+	)
+	if err != nil {
+		t.Fatalf("failed to create block: err=%+v", err)
+	}
+
+	var b bytes.Buffer
+	err = gob.NewEncoder(&b).Encode(block)
+	if err != nil {
+		t.Fatalf("failed to encode block with gob: err=%+v", err)
+	}
+	var got timeseries.Block
+	err = gob.NewDecoder(&b).Decode(&got)
+	if err != nil {
+		t.Fatalf("failed to decode block with gob: err=%+v", err)
+	}
+	if !bytes.Equal(got.Data, block.Data) {
+		t.Errorf("got=%x, want=%x", got.Data, block.Data)
+	}
+}
+
+func TestBlockSQL(t *testing.T) {
+	data, err := hex.DecodeString("5510c52000f900a0000000000003ffffffffc0")
+	if err != nil {
+		t.Fatalf("failed to decode hex string: err=%+v", err)
+	}
+
+	v, err := timeseries.Block{Data: data}.Value()
+	if err != nil {
+		t.Fatalf("failed to get driver value: err=%+v", err)
+	}
+	var b timeseries.Block
+	err = b.Scan(v)
+	if err != nil {
+		t.Fatalf("failed to scan block: err=%+v", err)
+	}
+	if !bytes.Equal(b.Data, data) {
+		t.Errorf("got=%x, want=%x", b.Data, data)
+	}
+
+	v, err = timeseries.Block{}.Value()
+	if err != nil {
+		t.Fatalf("failed to get driver value: err=%+v", err)
+	}
+	if v != nil {
+		t.Errorf("got=%v, want=nil", v)
+	}
+	err = b.Scan(nil)
+	if err != nil {
+		t.Fatalf("failed to scan NULL: err=%+v", err)
+	}
+	if b.Data != nil {
+		t.Errorf("got=%x, want=nil", b.Data)
+	}
+
+	err = b.Scan(int64(1))
+	if err == nil {
+		t.Error("got no error for scanning int64, want an error")
+	}
+	err = b.Scan([]byte{0x55, 0x10})
+	if err == nil {
+		t.Error("got no error for scanning invalid data, want an error")
+	}
+}
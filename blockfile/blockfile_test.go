@@ -0,0 +1,224 @@
+package blockfile_test
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/blockfile"
+	"github.com/hnakamur/timeseries/store"
+)
+
+func ts(hour, min int) uint32 {
+	return uint32(time.Date(2015, 3, 24, hour, min, 0, 0, time.UTC).Unix())
+}
+
+func newTestStore(t *testing.T) (*store.Store, map[string][]timeseries.Point) {
+	s, err := store.New(store.Options{})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+	series := map[string][]timeseries.Point{
+		"cpu": {
+			{Timestamp: ts(2, 1), Value: 12.0},
+			{Timestamp: ts(3, 1), Value: 12.5},
+			{Timestamp: ts(4, 1), Value: -24.2},
+			{Timestamp: ts(7, 0), Value: 3},
+		},
+		"mem": {
+			{Timestamp: ts(2, 0), Value: 1024},
+		},
+	}
+	for key, points := range series {
+		for _, p := range points {
+			err = s.Append(key, p)
+			if err != nil {
+				t.Fatalf("failed to append point: err=%+v", err)
+			}
+		}
+	}
+	err = s.Flush()
+	if err != nil {
+		t.Fatalf("failed to flush store: err=%+v", err)
+	}
+	return s, series
+}
+
+func TestWriteRead(t *testing.T) {
+	s, series := newTestStore(t)
+	name := filepath.Join(t.TempDir(), "blocks")
+	w, err := blockfile.Create(name)
+	if err != nil {
+		t.Fatalf("failed to create block file: err=%+v", err)
+	}
+	err = w.WriteStore(s)
+	if err != nil {
+		t.Fatalf("failed to write store: err=%+v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("failed to close block file: err=%+v", err)
+	}
+
+	r, err := blockfile.Open(name)
+	if err != nil {
+		t.Fatalf("failed to open block file: err=%+v", err)
+	}
+	defer r.Close()
+
+	if !reflect.DeepEqual(r.Keys(), []string{"cpu", "mem"}) {
+		t.Errorf("got keys=%v, want [cpu mem]", r.Keys())
+	}
+	metas := r.Blocks("cpu")
+	if len(metas) != 3 {
+		t.Fatalf("got %d blocks, want 3", len(metas))
+	}
+	if metas[0].T0 != ts(2, 0) || metas[0].Last != ts(3, 1) {
+		t.Errorf("got T0=%d, Last=%d, want T0=%d, Last=%d", metas[0].T0, metas[0].Last, ts(2, 0), ts(3, 1))
+	}
+
+	dec, err := r.Decoder(metas[1])
+	if err != nil {
+		t.Fatalf("failed to get decoder: err=%+v", err)
+	}
+	t0, err := dec.DecodeHeader()
+	if err != nil {
+		t.Fatalf("failed to decode header: err=%+v", err)
+	}
+	if t0 != ts(4, 0) {
+		t.Errorf("got t0=%d, want %d", t0, ts(4, 0))
+	}
+	p, err := dec.DecodePoint()
+	if err != nil {
+		t.Fatalf("failed to decode point: err=%+v", err)
+	}
+	if p != series["cpu"][2] {
+		t.Errorf("got point=%+v, want %+v", p, series["cpu"][2])
+	}
+
+	for key, want := range series {
+		got, err := r.Query(key, 0, ts(23, 0))
+		if err != nil {
+			t.Fatalf("failed to query: key=%s, err=%+v", key, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("key=%s, got=%+v, want=%+v", key, got, want)
+		}
+
+		got, err = timeseries.Collect(r.Iterator(key))
+		if err != nil {
+			t.Fatalf("failed to iterate: key=%s, err=%+v", key, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("iterator key=%s, got=%+v, want=%+v", key, got, want)
+		}
+	}
+	got, err := r.Query("cpu", ts(3, 0), ts(4, 30))
+	if err != nil {
+		t.Fatalf("failed to query: err=%+v", err)
+	}
+	if !reflect.DeepEqual(got, series["cpu"][1:3]) {
+		t.Errorf("got=%+v, want=%+v", got, series["cpu"][1:3])
+	}
+}
+
+func TestCorruption(t *testing.T) {
+	s, _ := newTestStore(t)
+	name := filepath.Join(t.TempDir(), "blocks")
+	w, err := blockfile.Create(name)
+	if err != nil {
+		t.Fatalf("failed to create block file: err=%+v", err)
+	}
+	err = w.WriteStore(s)
+	if err != nil {
+		t.Fatalf("failed to write store: err=%+v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("failed to close block file: err=%+v", err)
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("failed to read block file: err=%+v", err)
+	}
+
+	// Corrupt the first block.
+	corrupted := append([]byte(nil), data...)
+	corrupted[10] ^= 0xff
+	err = os.WriteFile(name, corrupted, 0644)
+	if err != nil {
+		t.Fatalf("failed to write block file: err=%+v", err)
+	}
+	r, err := blockfile.Open(name)
+	if err != nil {
+		t.Fatalf("failed to open block file: err=%+v", err)
+	}
+	_, err = r.BlockData(r.Blocks("cpu")[0])
+	if err != blockfile.ErrChecksum {
+		t.Errorf("got err=%v, want %v", err, blockfile.ErrChecksum)
+	}
+	r.Close()
+
+	// Corrupt the index.
+	corrupted = append([]byte(nil), data...)
+	corrupted[len(corrupted)-25] ^= 0xff
+	err = os.WriteFile(name, corrupted, 0644)
+	if err != nil {
+		t.Fatalf("failed to write block file: err=%+v", err)
+	}
+	_, err = blockfile.Open(name)
+	if err != blockfile.ErrChecksum {
+		t.Errorf("got err=%v, want %v", err, blockfile.ErrChecksum)
+	}
+
+	// Overflowing offsets with valid checksums.
+	indexOff := binary.BigEndian.Uint64(data[len(data)-20:])
+	for _, tc := range []struct {
+		name   string
+		modify func(b []byte)
+	}{
+		{name: "block offset", modify: func(b []byte) {
+			// The offset and the length of the first block of "cpu".
+			meta := b[indexOff+5:]
+			binary.BigEndian.PutUint64(meta[8:16], math.MaxUint64-0xf)
+			binary.BigEndian.PutUint32(meta[16:20], 0x20)
+		}},
+		{name: "index offset", modify: func(b []byte) {
+			size := uint64(len(b) - 20)
+			binary.BigEndian.PutUint64(b[len(b)-20:], math.MaxUint64-5)
+			binary.BigEndian.PutUint32(b[len(b)-12:], uint32(size+6))
+		}},
+	} {
+		corrupted = append([]byte(nil), data...)
+		tc.modify(corrupted)
+		footer := corrupted[len(corrupted)-20:]
+		off, n := binary.BigEndian.Uint64(footer), uint64(binary.BigEndian.Uint32(footer[8:]))
+		if off <= uint64(len(corrupted)) && n <= uint64(len(corrupted))-off {
+			index := corrupted[off : off+n]
+			binary.BigEndian.PutUint32(footer[12:], crc32.Checksum(index, crc32.MakeTable(crc32.Castagnoli)))
+		}
+		err = os.WriteFile(name, corrupted, 0644)
+		if err != nil {
+			t.Fatalf("failed to write block file: err=%+v", err)
+		}
+		_, err = blockfile.Open(name)
+		if err != blockfile.ErrFormat {
+			t.Errorf("%s: got err=%v, want %v", tc.name, err, blockfile.ErrFormat)
+		}
+	}
+
+	err = os.WriteFile(name, data[:len(data)-1], 0644)
+	if err != nil {
+		t.Fatalf("failed to write block file: err=%+v", err)
+	}
+	_, err = blockfile.Open(name)
+	if err != blockfile.ErrFormat {
+		t.Errorf("got err=%v, want %v", err, blockfile.ErrFormat)
+	}
+}
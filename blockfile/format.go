@@ -0,0 +1,77 @@
+// Package blockfile implements a persistent file format which holds many
+// sealed blocks of many series.
+//
+// A block file consists of a header, a data section, an index section and a
+// footer. All integers are big endian.
+//
+//	header:  magic "TSBF" (4 bytes), version (1 byte), reserved (3 bytes)
+//	data:    encoded blocks concatenated
+//	index:   for each series in key order:
+//	           key length (uvarint), key, number of blocks (uvarint),
+//	           for each block in timestamp order:
+//	             t0 (4 bytes), last timestamp (4 bytes), offset (8 bytes),
+//	             length (4 bytes), CRC32C of the block (4 bytes)
+//	footer:  index offset (8 bytes), index length (4 bytes),
+//	         CRC32C of the index (4 bytes), magic "TSBF" (4 bytes)
+//
+// The reader memory-maps the file and returns decoders over slices of the
+// mapped data without copying.
+package blockfile
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+const (
+	magic      = "TSBF"
+	version    = 1
+	headerSize = 8
+	footerSize = 20
+	metaSize   = 24
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrChecksum is returned when a block or the index has a checksum mismatch.
+var ErrChecksum = errors.New("block file checksum mismatch")
+
+// ErrFormat is returned when a file is not a valid block file.
+var ErrFormat = errors.New("invalid block file format")
+
+// BlockMeta is the metadata of a block in a block file.
+type BlockMeta struct {
+	// T0 is the block timestamp.
+	T0 uint32
+
+	// Last is the timestamp of the last data point in the block.
+	Last uint32
+
+	// Offset is the offset of the block from the start of the file.
+	Offset uint64
+
+	// Length is the length of the block in bytes.
+	Length uint32
+
+	// Checksum is the CRC32C checksum of the block.
+	Checksum uint32
+}
+
+func putMeta(b []byte, m BlockMeta) {
+	binary.BigEndian.PutUint32(b[0:4], m.T0)
+	binary.BigEndian.PutUint32(b[4:8], m.Last)
+	binary.BigEndian.PutUint64(b[8:16], m.Offset)
+	binary.BigEndian.PutUint32(b[16:20], m.Length)
+	binary.BigEndian.PutUint32(b[20:24], m.Checksum)
+}
+
+func getMeta(b []byte) BlockMeta {
+	return BlockMeta{
+		T0:       binary.BigEndian.Uint32(b[0:4]),
+		Last:     binary.BigEndian.Uint32(b[4:8]),
+		Offset:   binary.BigEndian.Uint64(b[8:16]),
+		Length:   binary.BigEndian.Uint32(b[16:20]),
+		Checksum: binary.BigEndian.Uint32(b[20:24]),
+	}
+}
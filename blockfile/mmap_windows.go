@@ -0,0 +1,21 @@
+//go:build windows
+// +build windows
+
+package blockfile
+
+import (
+	"io"
+	"os"
+)
+
+// mmap reads the whole file into memory on Windows.
+func mmap(f *os.File, size int) ([]byte, func() error, error) {
+	data := make([]byte, size)
+	_, err := io.ReadFull(f, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error {
+		return nil
+	}, nil
+}
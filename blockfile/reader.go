@@ -0,0 +1,211 @@
+package blockfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+
+	"github.com/hnakamur/timeseries"
+)
+
+// Reader reads blocks from a memory-mapped block file.
+// Slices returned by a reader are valid until the reader is closed.
+type Reader struct {
+	data   []byte
+	unmap  func() error
+	keys   []string
+	series map[string][]BlockMeta
+}
+
+// Open opens the block file of the name and verifies its index.
+func Open(name string) (*Reader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() < headerSize+footerSize {
+		return nil, ErrFormat
+	}
+
+	data, unmap, err := mmap(f, int(fi.Size()))
+	if err != nil {
+		return nil, err
+	}
+	r := &Reader{data: data, unmap: unmap}
+	err = r.readIndex()
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reader) readIndex() error {
+	data := r.data
+	if string(data[:4]) != magic || data[4] != version {
+		return ErrFormat
+	}
+	footer := data[len(data)-footerSize:]
+	if string(footer[16:]) != magic {
+		return ErrFormat
+	}
+	size := uint64(len(data) - footerSize)
+	off := binary.BigEndian.Uint64(footer[0:8])
+	n := uint64(binary.BigEndian.Uint32(footer[8:12]))
+	if off < headerSize || off > size || n != size-off {
+		return ErrFormat
+	}
+	index := data[off : off+n]
+	if crc32.Checksum(index, castagnoli) != binary.BigEndian.Uint32(footer[12:16]) {
+		return ErrChecksum
+	}
+
+	r.series = make(map[string][]BlockMeta)
+	for len(index) > 0 {
+		l, m := binary.Uvarint(index)
+		if m <= 0 || uint64(len(index)-m) < l {
+			return ErrFormat
+		}
+		key := string(index[m : m+int(l)])
+		index = index[m+int(l):]
+
+		cnt, m := binary.Uvarint(index)
+		if m <= 0 || cnt > uint64(len(index)-m)/metaSize {
+			return ErrFormat
+		}
+		index = index[m:]
+		metas := make([]BlockMeta, cnt)
+		for i := range metas {
+			metas[i] = getMeta(index[i*metaSize:])
+			if metas[i].Offset < headerSize || metas[i].Offset > off || uint64(metas[i].Length) > off-metas[i].Offset {
+				return ErrFormat
+			}
+		}
+		index = index[int(cnt)*metaSize:]
+
+		r.keys = append(r.keys, key)
+		r.series[key] = metas
+	}
+	return nil
+}
+
+// Close unmaps the file.
+func (r *Reader) Close() error {
+	if r.unmap == nil {
+		return nil
+	}
+	err := r.unmap()
+	r.unmap = nil
+	r.data = nil
+	return err
+}
+
+// Keys returns the sorted keys of the series in the file.
+func (r *Reader) Keys() []string {
+	return r.keys
+}
+
+// Blocks returns the metadata of the blocks of the series for the key in
+// timestamp order.
+func (r *Reader) Blocks(key string) []BlockMeta {
+	return r.series[key]
+}
+
+// BlockData returns the encoded block for the metadata as a slice of the
+// mapped file after verifying its checksum.
+func (r *Reader) BlockData(m BlockMeta) ([]byte, error) {
+	b := r.data[m.Offset : m.Offset+uint64(m.Length)]
+	if crc32.Checksum(b, castagnoli) != m.Checksum {
+		return nil, ErrChecksum
+	}
+	return b, nil
+}
+
+// Decoder returns a decoder over the encoded block for the metadata.
+func (r *Reader) Decoder(m BlockMeta) (*timeseries.Decoder, error) {
+	b, err := r.BlockData(m)
+	if err != nil {
+		return nil, err
+	}
+	return timeseries.NewDecoder(bytes.NewReader(b)), nil
+}
+
+// Query returns the data points of the series for the key whose timestamps
+// are between from and to inclusive.
+func (r *Reader) Query(key string, from, to uint32) ([]timeseries.Point, error) {
+	var points []timeseries.Point
+	for _, m := range r.series[key] {
+		if m.T0 > to || m.Last < from {
+			continue
+		}
+		b, err := r.BlockData(m)
+		if err != nil {
+			return nil, err
+		}
+		_, blockPoints, err := timeseries.Unmarshal(b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode block: key=%s, t0=%d, err=%+v", key, m.T0, err)
+		}
+		for _, p := range blockPoints {
+			if from <= p.Timestamp && p.Timestamp <= to {
+				points = append(points, p)
+			}
+		}
+	}
+	return points, nil
+}
+
+type seriesIterator struct {
+	r      *Reader
+	key    string
+	blocks []BlockMeta
+	it     timeseries.Iterator
+	err    error
+}
+
+// Iterator returns an iterator over the data points of the series for the
+// key. Blocks are decoded one at a time as the iteration proceeds.
+func (r *Reader) Iterator(key string) timeseries.Iterator {
+	return &seriesIterator{r: r, key: key, blocks: r.series[key]}
+}
+
+func (it *seriesIterator) Next() bool {
+	for it.err == nil {
+		if it.it != nil {
+			if it.it.Next() {
+				return true
+			}
+			if err := it.it.Err(); err != nil {
+				it.err = fmt.Errorf("failed to decode block: key=%s, err=%+v", it.key, err)
+				return false
+			}
+		}
+		if len(it.blocks) == 0 {
+			return false
+		}
+		b, err := it.r.BlockData(it.blocks[0])
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.blocks = it.blocks[1:]
+		it.it = timeseries.NewBlockIterator(b)
+	}
+	return false
+}
+
+func (it *seriesIterator) At() timeseries.Point {
+	return it.it.At()
+}
+
+func (it *seriesIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,192 @@
+package blockfile
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+
+	"github.com/hnakamur/timeseries/store"
+)
+
+// Writer writes sealed blocks to a block file.
+// The file is written to a temporary file and renamed to the final name on
+// Close, so a block file is either complete or absent.
+type Writer struct {
+	name   string
+	f      *os.File
+	bw     *bufio.Writer
+	off    uint64
+	series map[string][]BlockMeta
+	closed bool
+}
+
+// Create creates a writer for the block file of the name.
+func Create(name string) (*Writer, error) {
+	f, err := os.OpenFile(name+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{
+		name:   name,
+		f:      f,
+		bw:     bufio.NewWriter(f),
+		series: make(map[string][]BlockMeta),
+	}
+
+	var hdr [headerSize]byte
+	copy(hdr[:], magic)
+	hdr[4] = version
+	err = w.write(hdr[:])
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return w, nil
+}
+
+// WriteBlock writes a sealed block of the series for the key.
+func (w *Writer) WriteBlock(key string, b store.BlockInfo) error {
+	if w.closed {
+		return errors.New("block file writer is closed")
+	}
+	meta := BlockMeta{
+		T0:       b.T0,
+		Last:     b.Last,
+		Offset:   w.off,
+		Length:   uint32(len(b.Block.Data)),
+		Checksum: crc32.Checksum(b.Block.Data, castagnoli),
+	}
+	err := w.write(b.Block.Data)
+	if err != nil {
+		return err
+	}
+	w.series[key] = append(w.series[key], meta)
+	return nil
+}
+
+// WriteStore writes the sealed blocks of all series in the store.
+func (w *Writer) WriteStore(s *store.Store) error {
+	for _, key := range s.Keys() {
+		for _, b := range s.SealedBlocks(key) {
+			err := w.WriteBlock(key, b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close writes the index and the footer, syncs the file and renames it to the
+// final name.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	err := w.finish()
+	if err != nil {
+		w.f.Close()
+		os.Remove(w.f.Name())
+		return err
+	}
+	err = w.f.Close()
+	if err != nil {
+		os.Remove(w.f.Name())
+		return err
+	}
+	err = os.Rename(w.f.Name(), w.name)
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(w.name))
+}
+
+// syncDir syncs the directory so that a rename in it is durable.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		// Directories cannot be synced on Windows.
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// Abort discards the file being written.
+func (w *Writer) Abort() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.f.Close()
+	return os.Remove(w.f.Name())
+}
+
+func (w *Writer) finish() error {
+	keys := make([]string, 0, len(w.series))
+	for key := range w.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var index []byte
+	var b [binary.MaxVarintLen64]byte
+	var mb [metaSize]byte
+	for _, key := range keys {
+		metas := w.series[key]
+		sort.SliceStable(metas, func(i, j int) bool {
+			return metas[i].T0 < metas[j].T0
+		})
+
+		n := binary.PutUvarint(b[:], uint64(len(key)))
+		index = append(index, b[:n]...)
+		index = append(index, key...)
+		n = binary.PutUvarint(b[:], uint64(len(metas)))
+		index = append(index, b[:n]...)
+		for _, m := range metas {
+			putMeta(mb[:], m)
+			index = append(index, mb[:]...)
+		}
+	}
+
+	var footer [footerSize]byte
+	binary.BigEndian.PutUint64(footer[0:8], w.off)
+	binary.BigEndian.PutUint32(footer[8:12], uint32(len(index)))
+	binary.BigEndian.PutUint32(footer[12:16], crc32.Checksum(index, castagnoli))
+	copy(footer[16:], magic)
+
+	err := w.write(index)
+	if err != nil {
+		return err
+	}
+	err = w.write(footer[:])
+	if err != nil {
+		return err
+	}
+	err = w.bw.Flush()
+	if err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+func (w *Writer) write(b []byte) error {
+	n, err := w.bw.Write(b)
+	w.off += uint64(n)
+	return err
+}
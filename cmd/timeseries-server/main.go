@@ -0,0 +1,198 @@
+// Command timeseries-server serves the HTTP API of package server backed by
+// an in-memory store, and optionally receives metrics with the Graphite,
+// StatsD and OpenTSDB telnet protocols.
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/graphite"
+	"github.com/hnakamur/timeseries/labels"
+	"github.com/hnakamur/timeseries/opentsdb"
+	"github.com/hnakamur/timeseries/server"
+	"github.com/hnakamur/timeseries/statsd"
+	"github.com/hnakamur/timeseries/store"
+)
+
+type config struct {
+	addr               string
+	blockDuration      time.Duration
+	shutdownTimeout    time.Duration
+	graphiteAddr       string
+	graphitePickleAddr string
+	statsdAddr         string
+	statsdInterval     time.Duration
+	opentsdbAddr       string
+}
+
+func main() {
+	var c config
+	flag.StringVar(&c.addr, "addr", ":8080", "listen address of the HTTP API")
+	flag.DurationVar(&c.blockDuration, "block-duration", 2*time.Hour, "duration of a block")
+	flag.DurationVar(&c.shutdownTimeout, "shutdown-timeout", 10*time.Second, "timeout for graceful shutdown")
+	flag.StringVar(&c.graphiteAddr, "graphite-addr", "", "TCP and UDP listen address of the Graphite plaintext protocol, e.g. :2003")
+	flag.StringVar(&c.graphitePickleAddr, "graphite-pickle-addr", "", "TCP listen address of the Graphite pickle protocol, e.g. :2004")
+	flag.StringVar(&c.statsdAddr, "statsd-addr", "", "TCP and UDP listen address of the StatsD protocol, e.g. :8125")
+	flag.DurationVar(&c.statsdInterval, "statsd-flush-interval", statsd.DefaultFlushInterval, "flush interval of StatsD metrics")
+	flag.StringVar(&c.opentsdbAddr, "opentsdb-addr", "", "TCP listen address of the OpenTSDB telnet protocol, e.g. :4242")
+	flag.Parse()
+
+	err := run(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(c config) error {
+	s, err := store.New(store.Options{BlockDuration: c.blockDuration})
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Addr: c.addr, Handler: server.New(s)}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errC := make(chan error, 8)
+	go func() {
+		log.Printf("listening on %s", c.addr)
+		errC <- srv.ListenAndServe()
+	}()
+
+	var closers []io.Closer
+	if c.graphiteAddr != "" || c.graphitePickleAddr != "" {
+		g, err := startGraphite(c, s, errC)
+		if err != nil {
+			return err
+		}
+		closers = append(closers, g)
+	}
+	if c.statsdAddr != "" {
+		sd, err := startStatsD(c, s, errC)
+		if err != nil {
+			return err
+		}
+		closers = append(closers, sd)
+	}
+	if c.opentsdbAddr != "" {
+		ln, err := net.Listen("tcp", c.opentsdbAddr)
+		if err != nil {
+			return err
+		}
+		o := opentsdb.NewServer(s)
+		o.ErrorHandler = func(err error) {
+			log.Print(err)
+		}
+		log.Printf("listening OpenTSDB telnet protocol on %s", c.opentsdbAddr)
+		go func() {
+			err := o.Serve(ln)
+			if err != opentsdb.ErrServerClosed {
+				errC <- err
+			}
+		}()
+		closers = append(closers, o)
+	}
+
+	select {
+	case err = <-errC:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("shutting down")
+	for _, cl := range closers {
+		cl.Close()
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), c.shutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+// startGraphite starts the Graphite listeners which append metrics to the
+// series whose metric names are the metric paths.
+func startGraphite(c config, s *store.Store, errC chan<- error) (*graphite.Server, error) {
+	g := graphite.NewServer(store.AppenderFunc(func(path string, p timeseries.Point) error {
+		return s.AppendLabels(labels.FromStrings(labels.MetricName, path), p)
+	}))
+	g.ErrorHandler = func(err error) {
+		log.Print(err)
+	}
+	serve := func(f func() error) {
+		go func() {
+			err := f()
+			if err != graphite.ErrServerClosed {
+				errC <- err
+			}
+		}()
+	}
+
+	if c.graphiteAddr != "" {
+		ln, err := net.Listen("tcp", c.graphiteAddr)
+		if err != nil {
+			return nil, err
+		}
+		pc, err := net.ListenPacket("udp", c.graphiteAddr)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+		log.Printf("listening Graphite plaintext protocol on %s", c.graphiteAddr)
+		serve(func() error { return g.ServePlaintext(ln) })
+		serve(func() error { return g.ServeUDP(pc) })
+	}
+	if c.graphitePickleAddr != "" {
+		ln, err := net.Listen("tcp", c.graphitePickleAddr)
+		if err != nil {
+			g.Close()
+			return nil, err
+		}
+		log.Printf("listening Graphite pickle protocol on %s", c.graphitePickleAddr)
+		serve(func() error { return g.ServePickle(ln) })
+	}
+	return g, nil
+}
+
+// startStatsD starts the StatsD listeners which append aggregated values to
+// the store every flush interval.
+func startStatsD(c config, s *store.Store, errC chan<- error) (*statsd.Server, error) {
+	ln, err := net.Listen("tcp", c.statsdAddr)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenPacket("udp", c.statsdAddr)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	sd, err := statsd.NewServer(s, statsd.Options{FlushInterval: c.statsdInterval})
+	if err != nil {
+		ln.Close()
+		pc.Close()
+		return nil, err
+	}
+	sd.ErrorHandler = func(err error) {
+		log.Print(err)
+	}
+	serve := func(f func() error) {
+		go func() {
+			err := f()
+			if err != statsd.ErrServerClosed {
+				errC <- err
+			}
+		}()
+	}
+	log.Printf("listening StatsD protocol on %s", c.statsdAddr)
+	serve(func() error { return sd.ServeTCP(ln) })
+	serve(func() error { return sd.ServeUDP(pc) })
+	return sd, nil
+}
@@ -0,0 +1,295 @@
+// Command timeseries converts data points between CSV or TSV files and block
+// files, and exports block files as Arrow or Parquet files.
+//
+// Usage:
+//
+//	timeseries import-csv [flags] -o output.blocks [input.csv]
+//	timeseries export-csv [flags] [-o output.csv] input.blocks
+//	timeseries export-arrow [flags] -o output.arrow input.blocks
+//	timeseries export-parquet [flags] -o output.parquet input.blocks
+//
+// The input of import-csv defaults to the standard input, and the output of
+// export-csv defaults to the standard output. Each value column is stored as
+// a series whose key is the column header.
+//
+// export-arrow and export-parquet write rows of the timestamp, value and
+// series columns, where the series column has the key of the series.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/blockfile"
+	"github.com/hnakamur/timeseries/columnar"
+	"github.com/hnakamur/timeseries/csvblock"
+	"github.com/hnakamur/timeseries/labels"
+)
+
+const usage = `Usage:
+  timeseries import-csv [flags] -o output.blocks [input.csv]
+  timeseries export-csv [flags] [-o output.csv] input.blocks
+  timeseries export-arrow [flags] -o output.arrow input.blocks
+  timeseries export-parquet [flags] -o output.parquet input.blocks
+
+Run "timeseries <command> -h" for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "import-csv":
+		err = importCSV(os.Args[2:])
+	case "export-csv":
+		err = exportCSV(os.Args[2:])
+	case "export-arrow":
+		err = exportColumnar("export-arrow", os.Args[2:])
+	case "export-parquet":
+		err = exportColumnar("export-parquet", os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "timeseries %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// csvFlags is the flags for the CSV options common to the commands.
+type csvFlags struct {
+	tsv        bool
+	timeColumn string
+	timeFormat string
+	timeUnit   string
+	columns    string
+}
+
+func (f *csvFlags) register(fs *flag.FlagSet) {
+	fs.BoolVar(&f.tsv, "tsv", false, "use tab-separated values")
+	fs.StringVar(&f.timeColumn, "time-column", "time", "header of the timestamp column")
+	fs.StringVar(&f.timeFormat, "time-format", csvblock.UnixFormat, `"unix", "rfc3339" or a Go time layout`)
+	fs.StringVar(&f.timeUnit, "time-unit", "s", "unit of unix timestamps: s, ms, us or ns")
+	fs.StringVar(&f.columns, "columns", "", "comma-separated headers of the value columns (default all)")
+}
+
+func (f *csvFlags) options() (csvblock.Options, []string, error) {
+	unit, err := csvblock.ParseTimeUnit(f.timeUnit)
+	if err != nil {
+		return csvblock.Options{}, nil, err
+	}
+	opts := csvblock.Options{
+		TimeColumn: f.timeColumn,
+		TimeFormat: f.timeFormat,
+		TimeUnit:   unit,
+	}
+	switch strings.ToLower(f.timeFormat) {
+	case "rfc3339":
+		opts.TimeFormat = time.RFC3339
+	case "rfc3339nano":
+		opts.TimeFormat = time.RFC3339Nano
+	}
+	if f.tsv {
+		opts.Comma = '\t'
+	}
+	var columns []string
+	if f.columns != "" {
+		columns = strings.Split(f.columns, ",")
+	}
+	return opts, columns, nil
+}
+
+func importCSV(args []string) error {
+	fs := flag.NewFlagSet("import-csv", flag.ExitOnError)
+	var cf csvFlags
+	cf.register(fs)
+	output := fs.String("o", "", "output block file (required)")
+	blockDuration := fs.Duration("block-duration", 2*time.Hour, "duration of a block")
+	fs.Parse(args)
+	if *output == "" || fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	opts, columns, err := cf.options()
+	if err != nil {
+		return err
+	}
+
+	var in io.Reader = os.Stdin
+	if fs.NArg() == 1 && fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	r, err := csvblock.NewReader(bufio.NewReader(in), opts, columns...)
+	if err != nil {
+		return err
+	}
+	w, err := blockfile.Create(*output)
+	if err != nil {
+		return err
+	}
+	err = csvblock.Import(r, *blockDuration, w)
+	if err != nil {
+		w.Abort()
+		return err
+	}
+	return w.Close()
+}
+
+func exportCSV(args []string) error {
+	fs := flag.NewFlagSet("export-csv", flag.ExitOnError)
+	var cf csvFlags
+	cf.register(fs)
+	output := fs.String("o", "", "output CSV file (default standard output)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	opts, columns, err := cf.options()
+	if err != nil {
+		return err
+	}
+
+	r, err := blockfile.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if columns == nil {
+		columns = r.Keys()
+	}
+	if len(columns) == 0 {
+		return errors.New("no series in block file")
+	}
+	its := make([]timeseries.Iterator, len(columns))
+	for i, c := range columns {
+		if r.Blocks(c) == nil {
+			return fmt.Errorf("series %q not found in block file", c)
+		}
+		its[i] = r.Iterator(c)
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" && *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	bw := bufio.NewWriter(out)
+	err = csvblock.Export(bw, opts, columns, its)
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// seriesColumn is the name of the label column for the keys of series in
+// the output of export-arrow and export-parquet.
+const seriesColumn = "series"
+
+func exportColumnar(cmd string, args []string) error {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	output := fs.String("o", "", "output file (required)")
+	batchSize := fs.Int("batch-size", 64*1024, "number of rows at which a record batch or row group is written")
+	var stream *bool
+	if cmd == "export-arrow" {
+		stream = fs.Bool("stream", false, "use the IPC stream format instead of the file format")
+	}
+	fs.Parse(args)
+	if *output == "" || fs.NArg() != 1 || *batchSize <= 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	r, err := blockfile.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	bw := bufio.NewWriter(f)
+
+	schema := columnar.Schema{LabelNames: []string{seriesColumn}}
+	var w columnar.RecordWriter
+	switch {
+	case cmd == "export-parquet":
+		w, err = columnar.NewParquetWriter(bw, schema)
+	case *stream:
+		w, err = columnar.NewArrowStreamWriter(bw, schema)
+	default:
+		w, err = columnar.NewArrowFileWriter(bw, schema)
+	}
+	if err != nil {
+		return err
+	}
+
+	b := columnar.NewBuilder(schema)
+	flush := func() error {
+		if b.Len() == 0 {
+			return nil
+		}
+		err := w.Write(b.Record())
+		b.Reset()
+		return err
+	}
+	for _, key := range r.Keys() {
+		ls := labels.FromStrings(seriesColumn, key)
+		for _, m := range r.Blocks(key) {
+			dec, err := r.Decoder(m)
+			if err != nil {
+				return err
+			}
+			_, err = dec.DecodeHeader()
+			if err != nil {
+				return fmt.Errorf("failed to decode header of series %q: err=%+v", key, err)
+			}
+			err = b.AppendDecoder(ls, dec)
+			if err != nil {
+				return fmt.Errorf("failed to decode series %q: err=%+v", key, err)
+			}
+			if b.Len() >= *batchSize {
+				err = flush()
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	err = flush()
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	err = bw.Flush()
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,236 @@
+package columnar
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// The constants below are from Schema.fbs and Message.fbs of Arrow.
+const (
+	arrowMetadataV5 = 4
+
+	arrowHeaderSchema      = 1
+	arrowHeaderRecordBatch = 3
+
+	arrowTypeFloatingPoint = 3
+	arrowTypeUtf8          = 5
+	arrowTypeTimestamp     = 10
+
+	arrowPrecisionDouble = 2
+	arrowTimeUnitSecond  = 0
+)
+
+// arrowMagic is the magic string of the Arrow file format.
+const arrowMagic = "ARROW1"
+
+// ArrowWriter writes records as Arrow record batches in the IPC stream
+// format or the IPC file format, which is also known as Feather version 2.
+//
+// The timestamp column has the timestamp type in seconds with the UTC time
+// zone, the value column has the double type and the label columns have the
+// UTF-8 string type. The columns are not nullable.
+type ArrowWriter struct {
+	w      io.Writer
+	schema Schema
+	file   bool
+	off    int64
+	blocks []byte
+	closed bool
+}
+
+// NewArrowStreamWriter creates a writer of the IPC stream format and writes
+// the schema.
+func NewArrowStreamWriter(w io.Writer, schema Schema) (*ArrowWriter, error) {
+	aw := &ArrowWriter{w: w, schema: schema}
+	err := aw.writeMessage(aw.schemaMessage(), nil, false)
+	if err != nil {
+		return nil, err
+	}
+	return aw, nil
+}
+
+// NewArrowFileWriter creates a writer of the IPC file format and writes the
+// schema. The file is complete after Close.
+func NewArrowFileWriter(w io.Writer, schema Schema) (*ArrowWriter, error) {
+	aw := &ArrowWriter{w: w, schema: schema, file: true}
+	err := aw.write([]byte(arrowMagic + "\x00\x00"))
+	if err != nil {
+		return nil, err
+	}
+	err = aw.writeMessage(aw.schemaMessage(), nil, false)
+	if err != nil {
+		return nil, err
+	}
+	return aw, nil
+}
+
+// Write writes the record as a record batch.
+func (w *ArrowWriter) Write(rec *Record) error {
+	if w.closed {
+		return errors.New("arrow writer is closed")
+	}
+	if len(rec.Labels) != len(w.schema.LabelNames) {
+		return fmt.Errorf("record has %d label columns, want %d", len(rec.Labels), len(w.schema.LabelNames))
+	}
+	n := rec.Len()
+
+	var (
+		body    []byte
+		nodes   []byte
+		buffers []byte
+	)
+	addBuffer := func(b []byte) {
+		buffers = appendUint64(buffers, uint64(len(body)))
+		buffers = appendUint64(buffers, uint64(len(b)))
+		body = append(body, b...)
+		for len(body)%8 != 0 {
+			body = append(body, 0)
+		}
+	}
+	addNode := func() {
+		nodes = appendUint64(nodes, uint64(n))
+		nodes = appendUint64(nodes, 0)
+		// The validity bitmap is omitted since there are no nulls.
+		addBuffer(nil)
+	}
+
+	data := make([]byte, 0, 8*n)
+	addNode()
+	for _, t := range rec.Timestamps {
+		data = appendUint64(data, uint64(t))
+	}
+	addBuffer(data)
+
+	addNode()
+	data = data[:0]
+	for _, v := range rec.Values {
+		data = appendUint64(data, math.Float64bits(v))
+	}
+	addBuffer(data)
+
+	for i, col := range rec.Labels {
+		if len(col) != n {
+			return fmt.Errorf("label column %q has %d values, want %d", w.schema.LabelNames[i], len(col), n)
+		}
+		addNode()
+		offsets := make([]byte, 0, 4*(n+1))
+		var chars []byte
+		offsets = appendUint32(offsets, 0)
+		for _, v := range col {
+			chars = append(chars, v...)
+			if len(chars) > math.MaxInt32 {
+				return fmt.Errorf("label column %q is too large", w.schema.LabelNames[i])
+			}
+			offsets = appendUint32(offsets, uint32(len(chars)))
+		}
+		addBuffer(offsets)
+		addBuffer(chars)
+	}
+
+	meta := fbTable{
+		fbInt16(arrowMetadataV5),
+		fbUint8(arrowHeaderRecordBatch),
+		fbTable{
+			fbInt64(int64(n)),
+			fbStructs{size: 16, data: nodes},
+			fbStructs{size: 16, data: buffers},
+		},
+		fbInt64(int64(len(body))),
+	}
+	return w.writeMessage(meta, body, true)
+}
+
+// Close writes the end of the stream, and the footer for the file format.
+// It does not close the underlying writer.
+func (w *ArrowWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	err := w.write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})
+	if err != nil || !w.file {
+		return err
+	}
+
+	var b fbBuilder
+	footer := b.finish(fbTable{
+		fbInt16(arrowMetadataV5),
+		w.schemaTable(),
+		fbStructs{size: 24, data: nil},
+		fbStructs{size: 24, data: w.blocks},
+	})
+	footer = appendUint32(footer, uint32(len(footer)))
+	footer = append(footer, arrowMagic...)
+	return w.write(footer)
+}
+
+func (w *ArrowWriter) schemaTable() fbTable {
+	fields := fbTables{
+		arrowField(TimestampColumn, arrowTypeTimestamp, fbTable{fbInt16(arrowTimeUnitSecond), fbString("UTC")}),
+		arrowField(ValueColumn, arrowTypeFloatingPoint, fbTable{fbInt16(arrowPrecisionDouble)}),
+	}
+	for _, name := range w.schema.LabelNames {
+		fields = append(fields, arrowField(name, arrowTypeUtf8, fbTable{}))
+	}
+	return fbTable{fbInt16(0), fields}
+}
+
+func arrowField(name string, typ uint8, typeTable fbTable) fbTable {
+	return fbTable{
+		fbString(name),
+		fbBool(false),
+		fbUint8(typ),
+		typeTable,
+		nil,
+		fbTables{},
+	}
+}
+
+func (w *ArrowWriter) schemaMessage() fbTable {
+	return fbTable{
+		fbInt16(arrowMetadataV5),
+		fbUint8(arrowHeaderSchema),
+		w.schemaTable(),
+		fbInt64(0),
+	}
+}
+
+// writeMessage writes an encapsulated message, which is the continuation
+// marker, the length of the metadata, the metadata padded to 8 bytes and the
+// body. The blocks of record batches are kept for the footer of the file
+// format.
+func (w *ArrowWriter) writeMessage(meta fbTable, body []byte, batch bool) error {
+	var b fbBuilder
+	m := b.finish(meta)
+	for len(m)%8 != 0 {
+		m = append(m, 0)
+	}
+	start := w.off
+	hdr := make([]byte, 8, 8+len(m))
+	binary.LittleEndian.PutUint32(hdr, 0xffffffff)
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(len(m)))
+	err := w.write(append(hdr, m...))
+	if err != nil {
+		return err
+	}
+	err = w.write(body)
+	if err != nil {
+		return err
+	}
+	if w.file && batch {
+		w.blocks = appendUint64(w.blocks, uint64(start))
+		w.blocks = appendUint32(w.blocks, uint32(8+len(m)))
+		w.blocks = appendUint32(w.blocks, 0)
+		w.blocks = appendUint64(w.blocks, uint64(len(body)))
+	}
+	return nil
+}
+
+func (w *ArrowWriter) write(b []byte) error {
+	n, err := w.w.Write(b)
+	w.off += int64(n)
+	return err
+}
@@ -0,0 +1,570 @@
+package columnar_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/columnar"
+	"github.com/hnakamur/timeseries/labels"
+)
+
+func TestBuilder(t *testing.T) {
+	var buf bytes.Buffer
+	enc := timeseries.NewEncoder(&buf)
+	if err := enc.EncodeHeader(1000); err != nil {
+		t.Fatalf("failed to encode header: err=%+v", err)
+	}
+	for _, p := range []timeseries.Point{{Timestamp: 1010, Value: 1.5}, {Timestamp: 1020, Value: -2}} {
+		if err := enc.EncodePoint(p); err != nil {
+			t.Fatalf("failed to encode point: err=%+v", err)
+		}
+	}
+	if err := enc.Finish(); err != nil {
+		t.Fatalf("failed to finish encoding: err=%+v", err)
+	}
+
+	b := columnar.NewBuilder(columnar.Schema{LabelNames: []string{"host", "dc"}})
+	b.AppendPoints(labels.FromStrings("__name__", "cpu", "host", "a"), []timeseries.Point{{Timestamp: 1000, Value: 3}})
+	dec := timeseries.NewDecoder(&buf)
+	if _, err := dec.DecodeHeader(); err != nil {
+		t.Fatalf("failed to decode header: err=%+v", err)
+	}
+	if err := b.AppendDecoder(labels.FromStrings("host", "b", "dc", "x"), dec); err != nil {
+		t.Fatalf("failed to append decoder: err=%+v", err)
+	}
+	if got, want := b.Len(), 3; got != want {
+		t.Errorf("len mismatch, got=%d, want=%d", got, want)
+	}
+	want := &columnar.Record{
+		Columns: timeseries.Columns{
+			Timestamps: []uint32{1000, 1010, 1020},
+			Values:     []float64{3, 1.5, -2},
+		},
+		Labels: [][]string{{"a", "b", "b"}, {"", "x", "x"}},
+	}
+	if got := b.Record(); !reflect.DeepEqual(got, want) {
+		t.Errorf("record mismatch, got=%+v, want=%+v", got, want)
+	}
+
+	b.Reset()
+	if got := b.Len(); got != 0 {
+		t.Errorf("len mismatch after reset, got=%d, want=0", got)
+	}
+}
+
+func testRecord() *columnar.Record {
+	return &columnar.Record{
+		Columns: timeseries.Columns{
+			Timestamps: []uint32{1600000000, 1600000010, 1600000020},
+			Values:     []float64{0.25, 42, math.Inf(-1)},
+		},
+		Labels: [][]string{{"host-a", "host-b", ""}, {"x", "", "日本"}},
+	}
+}
+
+var testSchema = columnar.Schema{LabelNames: []string{"host", "dc"}}
+
+// columns is the decoded columns of a record batch or a row group. The
+// timestamps are in the unit of the format.
+type columns struct {
+	Timestamps []int64
+	Values     []float64
+	Labels     [][]string
+}
+
+func wantColumns(rec *columnar.Record, scale int64) columns {
+	c := columns{Timestamps: []int64{}, Values: []float64{}, Labels: [][]string{}}
+	for _, ts := range rec.Timestamps {
+		c.Timestamps = append(c.Timestamps, int64(ts)*scale)
+	}
+	c.Values = append(c.Values, rec.Values...)
+	for _, l := range rec.Labels {
+		c.Labels = append(c.Labels, append([]string{}, l...))
+	}
+	return c
+}
+
+// fbTable reads a table of a flatbuffer.
+type fbTable struct {
+	b   []byte
+	pos int
+}
+
+func fbRoot(b []byte) fbTable {
+	return fbTable{b: b, pos: int(binary.LittleEndian.Uint32(b))}
+}
+
+// field returns the position of the field, or 0 if it is absent.
+func (t fbTable) field(i int) int {
+	vt := t.pos - int(int32(binary.LittleEndian.Uint32(t.b[t.pos:])))
+	o := 4 + 2*i
+	if o >= int(binary.LittleEndian.Uint16(t.b[vt:])) {
+		return 0
+	}
+	if off := int(binary.LittleEndian.Uint16(t.b[vt+o:])); off != 0 {
+		return t.pos + off
+	}
+	return 0
+}
+
+func (t fbTable) uint8(i int) uint8 {
+	if p := t.field(i); p != 0 {
+		return t.b[p]
+	}
+	return 0
+}
+
+func (t fbTable) int16(i int) int16 {
+	if p := t.field(i); p != 0 {
+		return int16(binary.LittleEndian.Uint16(t.b[p:]))
+	}
+	return 0
+}
+
+func (t fbTable) int64(i int) int64 {
+	if p := t.field(i); p != 0 {
+		return int64(binary.LittleEndian.Uint64(t.b[p:]))
+	}
+	return 0
+}
+
+func (t fbTable) ref(i int) int {
+	p := t.field(i)
+	return p + int(binary.LittleEndian.Uint32(t.b[p:]))
+}
+
+func (t fbTable) table(i int) fbTable {
+	return fbTable{b: t.b, pos: t.ref(i)}
+}
+
+func (t fbTable) string(i int) string {
+	p := t.ref(i)
+	n := int(binary.LittleEndian.Uint32(t.b[p:]))
+	return string(t.b[p+4 : p+4+n])
+}
+
+// vector returns the position of the elements and the length of a vector.
+func (t fbTable) vector(i int) (int, int) {
+	p := t.ref(i)
+	return p + 4, int(binary.LittleEndian.Uint32(t.b[p:]))
+}
+
+func (t fbTable) tables(i int) []fbTable {
+	p, n := t.vector(i)
+	tables := make([]fbTable, n)
+	for j := range tables {
+		q := p + 4*j
+		tables[j] = fbTable{b: t.b, pos: q + int(binary.LittleEndian.Uint32(t.b[q:]))}
+	}
+	return tables
+}
+
+// arrowSchema formats the fields of an Arrow schema table.
+func arrowSchema(t *testing.T, schema fbTable) []string {
+	var fields []string
+	for _, f := range schema.tables(1) {
+		typ := fmt.Sprintf("type=%d", f.uint8(2))
+		switch f.uint8(2) {
+		case 3:
+			typ = fmt.Sprintf("float(precision=%d)", f.table(3).int16(0))
+		case 5:
+			typ = "utf8"
+		case 10:
+			typ = fmt.Sprintf("timestamp(unit=%d, tz=%s)", f.table(3).int16(0), f.table(3).string(1))
+		}
+		if _, n := f.vector(5); n != 0 {
+			t.Errorf("field %s has %d children, want 0", f.string(0), n)
+		}
+		fields = append(fields, fmt.Sprintf("%s: %s nullable=%d", f.string(0), typ, f.uint8(1)))
+	}
+	return fields
+}
+
+var wantArrowSchema = []string{
+	"timestamp: timestamp(unit=0, tz=UTC) nullable=0",
+	"value: float(precision=2) nullable=0",
+	"host: utf8 nullable=0",
+	"dc: utf8 nullable=0",
+}
+
+// arrowMessage is a decoded encapsulated message.
+type arrowMessage struct {
+	offset  int
+	metaLen int
+	bodyLen int
+	schema  []string
+	batch   *columns
+}
+
+// readArrowStream reads messages from pos until the end of stream marker
+// and returns them with the position after the marker.
+func readArrowStream(t *testing.T, b []byte, pos int) ([]arrowMessage, int) {
+	var msgs []arrowMessage
+	for {
+		if pos%8 != 0 {
+			t.Fatalf("message at %d is not aligned to 8 bytes", pos)
+		}
+		if got := binary.LittleEndian.Uint32(b[pos:]); got != 0xffffffff {
+			t.Fatalf("continuation marker mismatch at %d, got=%x", pos, got)
+		}
+		n := int(binary.LittleEndian.Uint32(b[pos+4:]))
+		if n == 0 {
+			return msgs, pos + 8
+		}
+		if n%8 != 0 {
+			t.Errorf("metadata length %d is not a multiple of 8", n)
+		}
+		m := fbRoot(b[pos+8 : pos+8+n])
+		if got := m.int16(0); got != 4 {
+			t.Errorf("metadata version mismatch, got=%d, want=4", got)
+		}
+		msg := arrowMessage{offset: pos, metaLen: 8 + n, bodyLen: int(m.int64(3))}
+		body := b[pos+8+n : pos+8+n+msg.bodyLen]
+		switch m.uint8(1) {
+		case 1:
+			msg.schema = arrowSchema(t, m.table(2))
+		case 3:
+			msg.batch = arrowBatch(t, m.table(2), body)
+		default:
+			t.Fatalf("unexpected message header type %d", m.uint8(1))
+		}
+		msgs = append(msgs, msg)
+		pos += 8 + n + msg.bodyLen
+	}
+}
+
+// arrowBatch decodes a record batch of the columns of testSchema.
+func arrowBatch(t *testing.T, rb fbTable, body []byte) *columns {
+	length := int(rb.int64(0))
+	nodes, nn := rb.vector(1)
+	bufs, nb := rb.vector(2)
+	if nodes%8 != 0 || bufs%8 != 0 {
+		t.Errorf("struct vectors are not aligned to 8 bytes")
+	}
+	if nn != 2+len(testSchema.LabelNames) || nb != 2*nn+len(testSchema.LabelNames) {
+		t.Fatalf("got %d nodes and %d buffers", nn, nb)
+	}
+	for i := 0; i < nn; i++ {
+		p := nodes + 16*i
+		if l, nulls := binary.LittleEndian.Uint64(rb.b[p:]), binary.LittleEndian.Uint64(rb.b[p+8:]); int(l) != length || nulls != 0 {
+			t.Errorf("node %d mismatch, got length=%d, nulls=%d", i, l, nulls)
+		}
+	}
+	buffer := func(i int) []byte {
+		p := bufs + 16*i
+		off, n := binary.LittleEndian.Uint64(rb.b[p:]), binary.LittleEndian.Uint64(rb.b[p+8:])
+		if off%8 != 0 {
+			t.Errorf("buffer %d at %d is not aligned to 8 bytes", i, off)
+		}
+		return body[off : off+n]
+	}
+	c := columns{Timestamps: []int64{}, Values: []float64{}, Labels: [][]string{}}
+	ts, vs := buffer(1), buffer(3)
+	for i := 0; i < length; i++ {
+		c.Timestamps = append(c.Timestamps, int64(binary.LittleEndian.Uint64(ts[8*i:])))
+		c.Values = append(c.Values, math.Float64frombits(binary.LittleEndian.Uint64(vs[8*i:])))
+	}
+	for j := range testSchema.LabelNames {
+		offsets, chars := buffer(5+3*j), buffer(6+3*j)
+		col := []string{}
+		for i := 0; i < length; i++ {
+			start := binary.LittleEndian.Uint32(offsets[4*i:])
+			end := binary.LittleEndian.Uint32(offsets[4*i+4:])
+			col = append(col, string(chars[start:end]))
+		}
+		c.Labels = append(c.Labels, col)
+	}
+	return &c
+}
+
+func writeArrow(t *testing.T, file bool, recs ...*columnar.Record) []byte {
+	var buf bytes.Buffer
+	var (
+		w   *columnar.ArrowWriter
+		err error
+	)
+	if file {
+		w, err = columnar.NewArrowFileWriter(&buf, testSchema)
+	} else {
+		w, err = columnar.NewArrowStreamWriter(&buf, testSchema)
+	}
+	if err != nil {
+		t.Fatalf("failed to create writer: err=%+v", err)
+	}
+	for _, rec := range recs {
+		if err := w.Write(rec); err != nil {
+			t.Fatalf("failed to write record: err=%+v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close writer: err=%+v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestArrowStreamWriter(t *testing.T) {
+	empty := &columnar.Record{Labels: [][]string{nil, nil}}
+	data := writeArrow(t, false, testRecord(), empty)
+	msgs, end := readArrowStream(t, data, 0)
+	if end != len(data) {
+		t.Errorf("stream ends at %d, want %d", end, len(data))
+	}
+	if len(msgs) != 3 {
+		t.Fatalf("got %d messages, want 3", len(msgs))
+	}
+	if !reflect.DeepEqual(msgs[0].schema, wantArrowSchema) {
+		t.Errorf("schema mismatch, got=%q, want=%q", msgs[0].schema, wantArrowSchema)
+	}
+	for i, want := range []columns{wantColumns(testRecord(), 1), wantColumns(empty, 1)} {
+		if got := msgs[i+1].batch; got == nil || !reflect.DeepEqual(*got, want) {
+			t.Errorf("batch %d mismatch, got=%+v, want=%+v", i, got, want)
+		}
+	}
+}
+
+func TestArrowFileWriter(t *testing.T) {
+	data := writeArrow(t, true, testRecord(), testRecord())
+	if !bytes.HasPrefix(data, []byte("ARROW1\x00\x00")) || !bytes.HasSuffix(data, []byte("ARROW1")) {
+		t.Fatalf("magic mismatch, got=%q...%q", data[:8], data[len(data)-6:])
+	}
+	msgs, end := readArrowStream(t, data, 8)
+	n := int(binary.LittleEndian.Uint32(data[len(data)-10:]))
+	if end+n+10 != len(data) {
+		t.Fatalf("footer length %d does not match, stream ends at %d of %d", n, end, len(data))
+	}
+	footer := fbRoot(data[end : end+n])
+	if got := arrowSchema(t, footer.table(1)); !reflect.DeepEqual(got, wantArrowSchema) {
+		t.Errorf("footer schema mismatch, got=%q, want=%q", got, wantArrowSchema)
+	}
+	if _, n := footer.vector(2); n != 0 {
+		t.Errorf("got %d dictionaries, want 0", n)
+	}
+	blocks, nb := footer.vector(3)
+	if nb != 2 {
+		t.Fatalf("got %d record batch blocks, want 2", nb)
+	}
+	for i := 0; i < nb; i++ {
+		p := blocks + 24*i
+		got := arrowMessage{
+			offset:  int(binary.LittleEndian.Uint64(footer.b[p:])),
+			metaLen: int(binary.LittleEndian.Uint32(footer.b[p+8:])),
+			bodyLen: int(binary.LittleEndian.Uint64(footer.b[p+16:])),
+		}
+		want := msgs[i+1]
+		if got.offset != want.offset || got.metaLen != want.metaLen || got.bodyLen != want.bodyLen {
+			t.Errorf("block %d mismatch, got=%+v, want offset=%d, metaLen=%d, bodyLen=%d",
+				i, got, want.offset, want.metaLen, want.bodyLen)
+		}
+		if want := wantColumns(testRecord(), 1); !reflect.DeepEqual(*msgs[i+1].batch, want) {
+			t.Errorf("batch %d mismatch, got=%+v, want=%+v", i, *msgs[i+1].batch, want)
+		}
+	}
+}
+
+func TestArrowWriterMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := columnar.NewArrowStreamWriter(&buf, testSchema)
+	if err != nil {
+		t.Fatalf("failed to create writer: err=%+v", err)
+	}
+	rec := testRecord()
+	rec.Labels[0] = rec.Labels[0][:1]
+	if err := w.Write(rec); err == nil {
+		t.Errorf("got nil error for short label column")
+	}
+	if err := w.Write(&columnar.Record{}); err == nil {
+		t.Errorf("got nil error for missing label column")
+	}
+}
+
+// thriftReader decodes structs of the Thrift compact protocol into maps from
+// field ids to values.
+type thriftReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b[r.pos:])
+	if n <= 0 {
+		panic("invalid varint")
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case 1, 2:
+		return typ == 1
+	case 5, 6:
+		v := r.uvarint()
+		return int64(v>>1) ^ -int64(v&1)
+	case 8:
+		n := int(r.uvarint())
+		r.pos += n
+		return string(r.b[r.pos-n : r.pos])
+	case 9:
+		h := r.b[r.pos]
+		r.pos++
+		n, elem := int(h>>4), h&0x0f
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		list := []interface{}{}
+		for i := 0; i < n; i++ {
+			list = append(list, r.value(elem))
+		}
+		return list
+	case 12:
+		m := map[int]interface{}{}
+		last := 0
+		for {
+			h := r.b[r.pos]
+			r.pos++
+			if h == 0 {
+				return m
+			}
+			if d := int(h >> 4); d != 0 {
+				last += d
+			} else {
+				v := r.uvarint()
+				last = int(int64(v>>1) ^ -int64(v&1))
+			}
+			m[last] = r.value(h & 0x0f)
+		}
+	}
+	panic(fmt.Sprintf("unsupported thrift type %d", typ))
+}
+
+type thriftStruct = map[int]interface{}
+
+func writeParquet(t *testing.T, recs ...*columnar.Record) []byte {
+	var buf bytes.Buffer
+	w, err := columnar.NewParquetWriter(&buf, testSchema)
+	if err != nil {
+		t.Fatalf("failed to create writer: err=%+v", err)
+	}
+	for _, rec := range recs {
+		if err := w.Write(rec); err != nil {
+			t.Fatalf("failed to write record: err=%+v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close writer: err=%+v", err)
+	}
+	return buf.Bytes()
+}
+
+// readParquet decodes the file metadata and the row groups of a file of
+// testSchema.
+func readParquet(t *testing.T, data []byte) (thriftStruct, []columns) {
+	if !bytes.HasPrefix(data, []byte("PAR1")) || !bytes.HasSuffix(data, []byte("PAR1")) {
+		t.Fatalf("magic mismatch, got=%q...%q", data[:4], data[len(data)-4:])
+	}
+	n := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	r := &thriftReader{b: data[:len(data)-8], pos: len(data) - 8 - n}
+	md := r.value(12).(thriftStruct)
+	if r.pos != len(data)-8 {
+		t.Fatalf("file metadata ends at %d, want %d", r.pos, len(data)-8)
+	}
+
+	var groups []columns
+	for _, rg := range md[4].([]interface{}) {
+		c := columns{Timestamps: []int64{}, Values: []float64{}, Labels: [][]string{}}
+		var total int64
+		for i, cc := range rg.(thriftStruct)[1].([]interface{}) {
+			meta := cc.(thriftStruct)[3].(thriftStruct)
+			pos := meta[9].(int64)
+			if off := cc.(thriftStruct)[2].(int64); off != pos {
+				t.Errorf("file offset %d does not match data page offset %d", off, pos)
+			}
+			var values []byte
+			var numValues int64
+			for pos < meta[9].(int64)+meta[7].(int64) {
+				pr := &thriftReader{b: data, pos: int(pos)}
+				ph := pr.value(12).(thriftStruct)
+				dph := ph[5].(thriftStruct)
+				if ph[1].(int64) != 0 || ph[2] != ph[3] || dph[2].(int64) != 0 {
+					t.Errorf("unexpected page header %v", ph)
+				}
+				numValues += dph[1].(int64)
+				values = append(values, data[pr.pos:pr.pos+int(ph[3].(int64))]...)
+				pos = int64(pr.pos) + ph[3].(int64)
+			}
+			if numValues != meta[5].(int64) {
+				t.Errorf("column %d has %d values, want %d", i, numValues, meta[5])
+			}
+			total += meta[7].(int64)
+			switch {
+			case i == 0:
+				for j := 0; j < len(values); j += 8 {
+					c.Timestamps = append(c.Timestamps, int64(binary.LittleEndian.Uint64(values[j:])))
+				}
+			case i == 1:
+				for j := 0; j < len(values); j += 8 {
+					c.Values = append(c.Values, math.Float64frombits(binary.LittleEndian.Uint64(values[j:])))
+				}
+			default:
+				col := []string{}
+				for j := 0; j < len(values); {
+					n := int(binary.LittleEndian.Uint32(values[j:]))
+					col = append(col, string(values[j+4:j+4+n]))
+					j += 4 + n
+				}
+				c.Labels = append(c.Labels, col)
+			}
+		}
+		if got := rg.(thriftStruct)[2].(int64); got != total {
+			t.Errorf("total byte size mismatch, got=%d, want=%d", got, total)
+		}
+		if got, want := rg.(thriftStruct)[3].(int64), int64(len(c.Timestamps)); got != want {
+			t.Errorf("row group rows mismatch, got=%d, want=%d", got, want)
+		}
+		groups = append(groups, c)
+	}
+	return md, groups
+}
+
+func TestParquetWriter(t *testing.T) {
+	// The second record is split into two pages.
+	big := &columnar.Record{Labels: [][]string{nil, nil}}
+	for i := 0; i < 70000; i++ {
+		big.Append(timeseries.Point{Timestamp: uint32(i), Value: float64(i)})
+		big.Labels[0] = append(big.Labels[0], "h")
+		big.Labels[1] = append(big.Labels[1], "")
+	}
+	data := writeParquet(t, testRecord(), big, &columnar.Record{Labels: [][]string{nil, nil}})
+	md, groups := readParquet(t, data)
+
+	var schema []string
+	for _, e := range md[2].([]interface{}) {
+		schema = append(schema, fmt.Sprint(e))
+	}
+	wantSchema := []string{
+		"map[4:schema 5:4]",
+		"map[1:2 3:0 4:timestamp 6:9 10:map[8:map[1:true 2:map[1:map[]]]]]",
+		"map[1:5 3:0 4:value]",
+		"map[1:6 3:0 4:host 6:0 10:map[1:map[]]]",
+		"map[1:6 3:0 4:dc 6:0 10:map[1:map[]]]",
+	}
+	if !reflect.DeepEqual(schema, wantSchema) {
+		t.Errorf("schema mismatch, got=%q, want=%q", schema, wantSchema)
+	}
+	if got, want := md[3].(int64), int64(3+70000); got != want {
+		t.Errorf("num rows mismatch, got=%d, want=%d", got, want)
+	}
+
+	want := []columns{wantColumns(testRecord(), 1000), wantColumns(big, 1000)}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("row groups mismatch, got %d row groups, want %d", len(groups), len(want))
+		if len(groups) > 0 {
+			t.Errorf("first row group got=%+v, want=%+v", groups[0], want[0])
+		}
+	}
+}
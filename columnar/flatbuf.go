@@ -0,0 +1,160 @@
+package columnar
+
+import (
+	"encoding/binary"
+)
+
+// The types below build flatbuffers for the Arrow metadata. Unlike the
+// official builder, which writes back to front, objects are laid out front
+// to back with children after their parents so that all offsets are
+// positive. See https://flatbuffers.dev/internals/ for the format.
+
+// fbTable is a table whose fields are indexed by the field IDs. A nil field
+// is absent.
+type fbTable []interface{}
+
+// fbScalar is a little-endian scalar field of the size in bytes.
+type fbScalar struct {
+	size int
+	v    uint64
+}
+
+func fbBool(v bool) fbScalar {
+	if v {
+		return fbScalar{1, 1}
+	}
+	return fbScalar{1, 0}
+}
+func fbUint8(v uint8) fbScalar { return fbScalar{1, uint64(v)} }
+func fbInt16(v int16) fbScalar { return fbScalar{2, uint64(uint16(v))} }
+func fbInt32(v int32) fbScalar { return fbScalar{4, uint64(uint32(v))} }
+func fbInt64(v int64) fbScalar { return fbScalar{8, uint64(v)} }
+
+// fbString is a string.
+type fbString string
+
+// fbTables is a vector of tables.
+type fbTables []fbTable
+
+// fbStructs is a vector of encoded structs of the size whose alignment is
+// 8 bytes.
+type fbStructs struct {
+	size int
+	data []byte
+}
+
+type fbBuilder struct {
+	buf []byte
+}
+
+// finish returns the buffer with the root table.
+func (b *fbBuilder) finish(root fbTable) []byte {
+	b.buf = append(b.buf[:0], 0, 0, 0, 0)
+	pos := b.table(root)
+	binary.LittleEndian.PutUint32(b.buf, uint32(pos))
+	return b.buf
+}
+
+func (b *fbBuilder) pad(align int) {
+	for len(b.buf)%align != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+func (b *fbBuilder) uint16(v uint16) {
+	b.buf = appendUint16(b.buf, v)
+}
+
+func (b *fbBuilder) uint32(v uint32) {
+	b.buf = appendUint32(b.buf, v)
+}
+
+// patch sets the offset at pos to the object at target.
+func (b *fbBuilder) patch(pos, target int) {
+	binary.LittleEndian.PutUint32(b.buf[pos:], uint32(target-pos))
+}
+
+func (b *fbBuilder) table(t fbTable) int {
+	// The table starts with the offset to the vtable followed by the
+	// fields, each aligned to its size. Offsets to objects are 4 bytes.
+	offsets := make([]uint16, len(t))
+	size := 4
+	for i, f := range t {
+		if f == nil {
+			continue
+		}
+		n := 4
+		if s, ok := f.(fbScalar); ok {
+			n = s.size
+		}
+		for size%n != 0 {
+			size++
+		}
+		offsets[i] = uint16(size)
+		size += n
+	}
+
+	b.pad(2)
+	vtable := len(b.buf)
+	b.uint16(uint16(4 + 2*len(t)))
+	b.uint16(uint16(size))
+	for _, off := range offsets {
+		b.uint16(off)
+	}
+
+	// Align the table to 8 bytes so that the fields are aligned in the
+	// buffer too.
+	b.pad(8)
+	pos := len(b.buf)
+	b.buf = append(b.buf, make([]byte, size)...)
+	binary.LittleEndian.PutUint32(b.buf[pos:], uint32(int32(pos-vtable)))
+	for i, f := range t {
+		if s, ok := f.(fbScalar); ok {
+			for j := 0; j < s.size; j++ {
+				b.buf[pos+int(offsets[i])+j] = byte(s.v >> (8 * j))
+			}
+		}
+	}
+	for i, f := range t {
+		if f == nil {
+			continue
+		}
+		if _, ok := f.(fbScalar); ok {
+			continue
+		}
+		b.patch(pos+int(offsets[i]), b.object(f))
+	}
+	return pos
+}
+
+func (b *fbBuilder) object(o interface{}) int {
+	switch o := o.(type) {
+	case fbTable:
+		return b.table(o)
+	case fbString:
+		b.pad(4)
+		pos := len(b.buf)
+		b.uint32(uint32(len(o)))
+		b.buf = append(b.buf, o...)
+		b.buf = append(b.buf, 0)
+		return pos
+	case fbTables:
+		b.pad(4)
+		pos := len(b.buf)
+		b.uint32(uint32(len(o)))
+		b.buf = append(b.buf, make([]byte, 4*len(o))...)
+		for i, t := range o {
+			b.patch(pos+4+4*i, b.table(t))
+		}
+		return pos
+	case fbStructs:
+		// The structs must be aligned to 8 bytes after the length.
+		b.pad(8)
+		b.buf = append(b.buf, 0, 0, 0, 0)
+		pos := len(b.buf)
+		b.uint32(uint32(len(o.data) / o.size))
+		b.buf = append(b.buf, o.data...)
+		return pos
+	}
+	panic("columnar: unsupported flatbuffers object")
+}
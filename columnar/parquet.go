@@ -0,0 +1,263 @@
+package columnar
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// The constants below are from parquet.thrift of Parquet.
+const (
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetRequired = 0
+
+	parquetConvertedUTF8            = 0
+	parquetConvertedTimestampMillis = 9
+
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+
+	parquetDataPage = 0
+)
+
+// parquetMagic is the magic string of the Parquet file format.
+const parquetMagic = "PAR1"
+
+// maxPageRows is the maximum number of rows in a data page.
+const maxPageRows = 64 * 1024
+
+// ParquetWriter writes records as row groups of a Parquet file.
+//
+// The timestamp column has the timestamp type in milliseconds adjusted to
+// UTC since Parquet has no timestamps in seconds, the value column has the
+// double type and the label columns have the string type. The columns are
+// required and written uncompressed with the plain encoding.
+type ParquetWriter struct {
+	w         io.Writer
+	schema    Schema
+	off       int64
+	numRows   int64
+	rowGroups []parquetRowGroup
+	closed    bool
+}
+
+type parquetRowGroup struct {
+	numRows int64
+	columns []parquetColumnChunk
+}
+
+type parquetColumnChunk struct {
+	numValues  int64
+	size       int64
+	pageOffset int64
+}
+
+// NewParquetWriter creates a writer and writes the magic string. The file is
+// complete after Close.
+func NewParquetWriter(w io.Writer, schema Schema) (*ParquetWriter, error) {
+	pw := &ParquetWriter{w: w, schema: schema}
+	err := pw.write([]byte(parquetMagic))
+	if err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// Write writes the record as a row group. Empty records are skipped.
+func (w *ParquetWriter) Write(rec *Record) error {
+	if w.closed {
+		return errors.New("parquet writer is closed")
+	}
+	if len(rec.Labels) != len(w.schema.LabelNames) {
+		return fmt.Errorf("record has %d label columns, want %d", len(rec.Labels), len(w.schema.LabelNames))
+	}
+	n := rec.Len()
+	for i, col := range rec.Labels {
+		if len(col) != n {
+			return fmt.Errorf("label column %q has %d values, want %d", w.schema.LabelNames[i], len(col), n)
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+
+	rg := parquetRowGroup{numRows: int64(n)}
+	writeColumn := func(encode func(data []byte, i int) []byte) error {
+		cc := parquetColumnChunk{numValues: int64(n), pageOffset: w.off}
+		var data []byte
+		for start := 0; start < n; start += maxPageRows {
+			end := start + maxPageRows
+			if end > n {
+				end = n
+			}
+			data = data[:0]
+			for i := start; i < end; i++ {
+				data = encode(data, i)
+			}
+			if len(data) > math.MaxInt32 {
+				return errors.New("parquet data page is too large")
+			}
+			hdr := parquetPageHeader(end-start, len(data))
+			err := w.write(hdr)
+			if err != nil {
+				return err
+			}
+			err = w.write(data)
+			if err != nil {
+				return err
+			}
+			cc.size += int64(len(hdr) + len(data))
+		}
+		rg.columns = append(rg.columns, cc)
+		return nil
+	}
+
+	err := writeColumn(func(data []byte, i int) []byte {
+		return appendUint64(data, uint64(rec.Timestamps[i])*1000)
+	})
+	if err != nil {
+		return err
+	}
+	err = writeColumn(func(data []byte, i int) []byte {
+		return appendUint64(data, math.Float64bits(rec.Values[i]))
+	})
+	if err != nil {
+		return err
+	}
+	for _, col := range rec.Labels {
+		col := col
+		err = writeColumn(func(data []byte, i int) []byte {
+			data = appendUint32(data, uint32(len(col[i])))
+			return append(data, col[i]...)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	w.rowGroups = append(w.rowGroups, rg)
+	w.numRows += int64(n)
+	return nil
+}
+
+// parquetPageHeader returns the header of a data page of PLAIN encoded
+// values of required columns, which have no repetition and definition
+// levels.
+func parquetPageHeader(numValues, size int) []byte {
+	var t thriftWriter
+	t.beginStruct()
+	t.i32Field(1, parquetDataPage)
+	t.i32Field(2, int32(size))
+	t.i32Field(3, int32(size))
+	t.structField(5)
+	t.i32Field(1, int32(numValues))
+	t.i32Field(2, parquetEncodingPlain)
+	t.i32Field(3, parquetEncodingRLE)
+	t.i32Field(4, parquetEncodingRLE)
+	t.endStruct()
+	t.endStruct()
+	return t.buf
+}
+
+// Close writes the file metadata. It does not close the underlying writer.
+func (w *ParquetWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	type column struct {
+		name      string
+		typ       int32
+		converted int32
+	}
+	columns := []column{
+		{TimestampColumn, parquetInt64, parquetConvertedTimestampMillis},
+		{ValueColumn, parquetDouble, -1},
+	}
+	for _, name := range w.schema.LabelNames {
+		columns = append(columns, column{name, parquetByteArray, parquetConvertedUTF8})
+	}
+
+	var t thriftWriter
+	t.beginStruct()
+	t.i32Field(1, 1)
+	t.listField(2, thriftStruct, len(columns)+1)
+	t.beginStruct()
+	t.stringField(4, "schema")
+	t.i32Field(5, int32(len(columns)))
+	t.endStruct()
+	for _, c := range columns {
+		t.beginStruct()
+		t.i32Field(1, c.typ)
+		t.i32Field(3, parquetRequired)
+		t.stringField(4, c.name)
+		if c.converted >= 0 {
+			t.i32Field(6, c.converted)
+		}
+		switch c.converted {
+		case parquetConvertedUTF8:
+			// LogicalType.STRING
+			t.structField(10)
+			t.structField(1)
+			t.endStruct()
+			t.endStruct()
+		case parquetConvertedTimestampMillis:
+			// LogicalType.TIMESTAMP with isAdjustedToUTC and MILLIS.
+			t.structField(10)
+			t.structField(8)
+			t.boolField(1, true)
+			t.structField(2)
+			t.structField(1)
+			t.endStruct()
+			t.endStruct()
+			t.endStruct()
+			t.endStruct()
+		}
+		t.endStruct()
+	}
+	t.i64Field(3, w.numRows)
+	t.listField(4, thriftStruct, len(w.rowGroups))
+	for _, rg := range w.rowGroups {
+		t.beginStruct()
+		t.listField(1, thriftStruct, len(rg.columns))
+		var total int64
+		for i, cc := range rg.columns {
+			t.beginStruct()
+			t.i64Field(2, cc.pageOffset)
+			t.structField(3)
+			t.i32Field(1, columns[i].typ)
+			t.listField(2, thriftI32, 2)
+			t.zigzag(parquetEncodingPlain)
+			t.zigzag(parquetEncodingRLE)
+			t.listField(3, thriftBinary, 1)
+			t.string(columns[i].name)
+			t.i32Field(4, 0)
+			t.i64Field(5, cc.numValues)
+			t.i64Field(6, cc.size)
+			t.i64Field(7, cc.size)
+			t.i64Field(9, cc.pageOffset)
+			t.endStruct()
+			t.endStruct()
+			total += cc.size
+		}
+		t.i64Field(2, total)
+		t.i64Field(3, rg.numRows)
+		t.endStruct()
+	}
+	t.stringField(6, "github.com/hnakamur/timeseries")
+	t.endStruct()
+
+	footer := appendUint32(t.buf, uint32(len(t.buf)))
+	footer = append(footer, parquetMagic...)
+	return w.write(footer)
+}
+
+func (w *ParquetWriter) write(b []byte) error {
+	n, err := w.w.Write(b)
+	w.off += int64(n)
+	return err
+}
@@ -0,0 +1,110 @@
+// Package columnar exports decoded data points in columnar formats for
+// offline analysis: Apache Arrow record batches in the IPC stream and file
+// formats, and Apache Parquet files.
+//
+// A record has a timestamp column, a value column and optional label
+// columns. The formats are written by minimal in-tree implementations which
+// support only the column types used here.
+package columnar
+
+import (
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/labels"
+)
+
+const (
+	// TimestampColumn is the name of the timestamp column.
+	TimestampColumn = "timestamp"
+	// ValueColumn is the name of the value column.
+	ValueColumn = "value"
+)
+
+// Schema is the schema of records.
+type Schema struct {
+	// LabelNames is the names of the label columns, which follow the
+	// timestamp and value columns.
+	LabelNames []string
+}
+
+// Record is a batch of rows.
+type Record struct {
+	timeseries.Columns
+
+	// Labels is the values of the label columns in the order of the label
+	// names of the schema. Each column has a value for each row.
+	Labels [][]string
+}
+
+// Reset removes the rows keeping the allocated memory.
+func (r *Record) Reset() {
+	r.Columns.Reset()
+	for i := range r.Labels {
+		r.Labels[i] = r.Labels[i][:0]
+	}
+}
+
+// RecordWriter writes records. ArrowWriter and ParquetWriter implement it.
+type RecordWriter interface {
+	Write(rec *Record) error
+	Close() error
+}
+
+// Builder builds a record from the data points of series.
+type Builder struct {
+	schema Schema
+	rec    Record
+}
+
+// NewBuilder creates a builder for the schema.
+func NewBuilder(schema Schema) *Builder {
+	return &Builder{
+		schema: schema,
+		rec:    Record{Labels: make([][]string, len(schema.LabelNames))},
+	}
+}
+
+// Len returns the number of rows built so far.
+func (b *Builder) Len() int {
+	return b.rec.Len()
+}
+
+// AppendDecoder decodes the data points with the decoder and appends them
+// as rows with the values of the labels. The header must be decoded before.
+func (b *Builder) AppendDecoder(ls labels.Labels, d *timeseries.Decoder) error {
+	n := b.rec.Len()
+	err := d.DecodeColumns(&b.rec.Columns)
+	if err != nil {
+		return err
+	}
+	b.appendLabels(ls, b.rec.Len()-n)
+	return nil
+}
+
+// AppendPoints appends the data points as rows with the values of the
+// labels.
+func (b *Builder) AppendPoints(ls labels.Labels, points []timeseries.Point) {
+	for _, p := range points {
+		b.rec.Append(p)
+	}
+	b.appendLabels(ls, len(points))
+}
+
+func (b *Builder) appendLabels(ls labels.Labels, n int) {
+	for i, name := range b.schema.LabelNames {
+		v := ls.Get(name)
+		for j := 0; j < n; j++ {
+			b.rec.Labels[i] = append(b.rec.Labels[i], v)
+		}
+	}
+}
+
+// Record returns the record built so far. The record is valid until the
+// following call of Reset.
+func (b *Builder) Record() *Record {
+	return &b.rec
+}
+
+// Reset removes the rows built so far.
+func (b *Builder) Reset() {
+	b.rec.Reset()
+}
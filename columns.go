@@ -0,0 +1,44 @@
+package timeseries
+
+import (
+	"fmt"
+	"io"
+)
+
+// Columns is data points in columnar form, where the i-th data point has
+// Timestamps[i] and Values[i].
+type Columns struct {
+	Timestamps []uint32
+	Values     []float64
+}
+
+// Len returns the number of data points.
+func (c *Columns) Len() int {
+	return len(c.Timestamps)
+}
+
+// Append appends a data point.
+func (c *Columns) Append(p Point) {
+	c.Timestamps = append(c.Timestamps, p.Timestamp)
+	c.Values = append(c.Values, p.Value)
+}
+
+// Reset removes the data points keeping the allocated memory.
+func (c *Columns) Reset() {
+	c.Timestamps = c.Timestamps[:0]
+	c.Values = c.Values[:0]
+}
+
+// DecodeColumns decodes the remaining data points and appends them to c.
+// The header must be decoded before.
+func (d *Decoder) DecodeColumns(c *Columns) error {
+	for {
+		p, err := d.DecodePoint()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to decode time series point: err=%+v", err)
+		}
+		c.Append(p)
+	}
+}
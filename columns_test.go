@@ -0,0 +1,45 @@
+package timeseries_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+)
+
+func TestDecodeColumns(t *testing.T) {
+	data, err := hex.DecodeString("5510c52000f900a0000000000002fdbc1b0010022666666666667ffffffffe")
+	if err != nil {
+		t.Fatalf("failed to decode hex string: err=%+v", err)
+	}
+	dec := timeseries.NewDecoder(bytes.NewReader(data))
+	_, err = dec.DecodeHeader()
+	if err != nil {
+		t.Fatalf("failed to decode header: err=%+v", err)
+	}
+
+	c := timeseries.Columns{Timestamps: []uint32{1}, Values: []float64{2}}
+	err = dec.DecodeColumns(&c)
+	if err != nil {
+		t.Fatalf("failed to decode columns: err=%+v", err)
+	}
+	t0 := uint32(time.Date(2015, 3, 24, 2, 0, 0, 0, time.UTC).Unix())
+	want := timeseries.Columns{
+		Timestamps: []uint32{1, t0 + 62, t0 + 122, t0 + 182},
+		Values:     []float64{2, 12, 12.5, -24.2},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("got=%+v, want=%+v", c, want)
+	}
+	if c.Len() != 4 {
+		t.Errorf("got len=%d, want 4", c.Len())
+	}
+
+	c.Reset()
+	if c.Len() != 0 || len(c.Values) != 0 {
+		t.Errorf("got len=%d after reset, want 0", c.Len())
+	}
+}
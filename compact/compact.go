@@ -0,0 +1,167 @@
+// Package compact implements compaction which merges small adjacent or
+// overlapping blocks of a series into larger blocks.
+//
+// Every block pays the 32 bits header, the 14 bits first delta and the full
+// 64 bits first value, so many tiny blocks made by early flushes or restarts
+// waste space. Compaction decodes the blocks, merges and deduplicates the
+// data points in timestamp order and re-encodes them into blocks of a target
+// duration. Values are preserved exactly.
+package compact
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/store"
+)
+
+// Options is options for compaction.
+type Options struct {
+	// BlockDuration is the duration of the compacted blocks.
+	// It must be between a second and timeseries.MaxBlockDuration.
+	BlockDuration time.Duration
+
+	// Alignment is the offset of block timestamps from multiples of
+	// BlockDuration. See timeseries.SeriesWriter.
+	Alignment time.Duration
+}
+
+// Result is the result of compaction.
+type Result struct {
+	// Blocks is the compacted blocks in timestamp order.
+	Blocks []store.BlockInfo
+
+	// InputBlocks is the number of the input blocks.
+	InputBlocks int
+
+	// OutputBlocks is the number of the compacted blocks.
+	OutputBlocks int
+
+	// InputBytes is the total size in bytes of the input blocks.
+	InputBytes int64
+
+	// OutputBytes is the total size in bytes of the compacted blocks.
+	OutputBytes int64
+
+	// Duplicates is the number of data points dropped since other data points
+	// had the same timestamps.
+	Duplicates int
+}
+
+// BytesSaved returns the number of bytes saved by compaction.
+func (r Result) BytesSaved() int64 {
+	return r.InputBytes - r.OutputBytes
+}
+
+func (r *Result) add(o Result) {
+	r.InputBlocks += o.InputBlocks
+	r.OutputBlocks += o.OutputBlocks
+	r.InputBytes += o.InputBytes
+	r.OutputBytes += o.OutputBytes
+	r.Duplicates += o.Duplicates
+}
+
+// Blocks compacts the blocks of a series. When data points in different
+// blocks have the same timestamp, the one in the later block in blocks wins.
+func Blocks(blocks []store.BlockInfo, opts Options) (Result, error) {
+	var res Result
+	var points []timeseries.Point
+	for _, b := range blocks {
+		_, blockPoints, err := b.Block.Points()
+		if err != nil {
+			return Result{}, fmt.Errorf("failed to decode block: t0=%d, err=%+v", b.T0, err)
+		}
+		points = append(points, blockPoints...)
+		res.InputBlocks++
+		res.InputBytes += int64(len(b.Block.Data))
+	}
+
+	// The sort is stable, so the last one of points with the same timestamp
+	// is from the latest block.
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Timestamp < points[j].Timestamp
+	})
+	merged := points[:0]
+	for i, p := range points {
+		if i+1 < len(points) && points[i+1].Timestamp == p.Timestamp {
+			res.Duplicates++
+			continue
+		}
+		merged = append(merged, p)
+	}
+
+	var last uint32
+	sink := timeseries.BlockSinkFunc(func(t0 uint32, block timeseries.Block) error {
+		// The sink is called before the writer writes the point after
+		// the sealed block, so last is the last point of the block.
+		res.Blocks = append(res.Blocks, store.BlockInfo{T0: t0, Last: last, Block: block})
+		res.OutputBlocks++
+		res.OutputBytes += int64(len(block.Data))
+		return nil
+	})
+	w, err := timeseries.NewSeriesWriter(opts.BlockDuration, opts.Alignment, sink)
+	if err != nil {
+		return Result{}, err
+	}
+	for _, p := range merged {
+		err = w.Write(p)
+		if err != nil {
+			return Result{}, err
+		}
+		last = p.Timestamp
+	}
+	err = w.Flush()
+	if err != nil {
+		return Result{}, err
+	}
+	return res, nil
+}
+
+// Store compacts the sealed blocks of all series in the store which have two
+// or more sealed blocks. It returns the sum of the results of the series
+// without the compacted blocks. Series whose sealed blocks are already
+// compacted, that is each of them fills its own block window of the options,
+// and series whose sealed blocks were changed concurrently are skipped.
+func Store(s *store.Store, opts Options) (Result, error) {
+	err := timeseries.ValidateBlockDuration(opts.BlockDuration, opts.Alignment)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var total Result
+	for _, key := range s.Keys() {
+		old := s.SealedBlocks(key)
+		if len(old) < 2 || compacted(old, opts) {
+			continue
+		}
+		res, err := Blocks(old, opts)
+		if err != nil {
+			return total, fmt.Errorf("failed to compact series: key=%s, err=%+v", key, err)
+		}
+		err = s.ReplaceSealedBlocks(key, old, res.Blocks)
+		if err == store.ErrConflict {
+			continue
+		} else if err != nil {
+			return total, err
+		}
+		total.add(res)
+	}
+	return total, nil
+}
+
+// compacted reports whether each of the blocks starts at the start of a block
+// window of the options, ends in the window, and is the only block in the
+// window, so that compaction would produce the same blocks.
+func compacted(blocks []store.BlockInfo, opts Options) bool {
+	windows := make(map[uint32]bool, len(blocks))
+	for _, b := range blocks {
+		start := timeseries.BlockStart(b.T0, opts.BlockDuration, opts.Alignment)
+		if start != b.T0 || timeseries.BlockStart(b.Last, opts.BlockDuration, opts.Alignment) != start || windows[start] {
+			return false
+		}
+		windows[start] = true
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+package compact_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/compact"
+	"github.com/hnakamur/timeseries/store"
+)
+
+func ts(hour, min int) uint32 {
+	return uint32(time.Date(2015, 3, 24, hour, min, 0, 0, time.UTC).Unix())
+}
+
+func newBlock(t *testing.T, t0 uint32, points []timeseries.Point) store.BlockInfo {
+	b, err := timeseries.NewBlock(t0, points)
+	if err != nil {
+		t.Fatalf("failed to create block: err=%+v", err)
+	}
+	return store.BlockInfo{T0: t0, Last: points[len(points)-1].Timestamp, Block: b}
+}
+
+func TestBlocks(t *testing.T) {
+	blocks := []store.BlockInfo{
+		newBlock(t, ts(2, 0), []timeseries.Point{
+			{Timestamp: ts(2, 0), Value: 1},
+			{Timestamp: ts(2, 10), Value: 2},
+		}),
+		newBlock(t, ts(2, 5), []timeseries.Point{
+			{Timestamp: ts(2, 5), Value: 1.5},
+			{Timestamp: ts(2, 10), Value: 2.5},
+		}),
+		newBlock(t, ts(2, 20), []timeseries.Point{
+			{Timestamp: ts(2, 20), Value: -24.2},
+			{Timestamp: ts(4, 30), Value: 0.1},
+		}),
+	}
+
+	res, err := compact.Blocks(blocks, compact.Options{BlockDuration: 2 * time.Hour})
+	if err != nil {
+		t.Fatalf("failed to compact blocks: err=%+v", err)
+	}
+	if res.InputBlocks != 3 || res.OutputBlocks != 2 || res.Duplicates != 1 {
+		t.Errorf("got result=%+v, want 3 input blocks, 2 output blocks and 1 duplicate", res)
+	}
+	if res.BytesSaved() <= 0 {
+		t.Errorf("got bytes saved=%d, want positive", res.BytesSaved())
+	}
+
+	var got []timeseries.Point
+	for _, b := range res.Blocks {
+		t0, points, err := b.Block.Points()
+		if err != nil {
+			t.Fatalf("failed to decode compacted block: err=%+v", err)
+		}
+		if t0 != b.T0 || points[len(points)-1].Timestamp != b.Last {
+			t.Errorf("got block info T0=%d, Last=%d, want T0=%d, Last=%d", b.T0, b.Last, t0, points[len(points)-1].Timestamp)
+		}
+		got = append(got, points...)
+	}
+	want := []timeseries.Point{
+		{Timestamp: ts(2, 0), Value: 1},
+		{Timestamp: ts(2, 5), Value: 1.5},
+		{Timestamp: ts(2, 10), Value: 2.5},
+		{Timestamp: ts(2, 20), Value: -24.2},
+		{Timestamp: ts(4, 30), Value: 0.1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got=%+v, want=%+v", got, want)
+	}
+}
+
+func TestStore(t *testing.T) {
+	s, err := store.New(store.Options{BlockDuration: 10 * time.Minute})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+	var want []timeseries.Point
+	for i := 0; i < 120; i++ {
+		p := timeseries.Point{Timestamp: ts(2, 0) + uint32(i*60), Value: float64(i) * 0.1}
+		err = s.Append("cpu", p)
+		if err != nil {
+			t.Fatalf("failed to append point: err=%+v", err)
+		}
+		want = append(want, p)
+	}
+	bytesBefore := s.Bytes()
+
+	res, err := compact.Store(s, compact.Options{BlockDuration: 2 * time.Hour})
+	if err != nil {
+		t.Fatalf("failed to compact store: err=%+v", err)
+	}
+	if res.InputBlocks != 11 || res.OutputBlocks != 1 {
+		t.Errorf("got result=%+v, want 11 input blocks and 1 output block", res)
+	}
+	if s.Bytes() != bytesBefore-res.BytesSaved() {
+		t.Errorf("got bytes=%d, want %d", s.Bytes(), bytesBefore-res.BytesSaved())
+	}
+
+	got, err := s.Query("cpu", 0, ts(23, 0))
+	if err != nil {
+		t.Fatalf("failed to query: err=%+v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got=%+v, want=%+v", got, want)
+	}
+}
+
+func TestStoreCompacted(t *testing.T) {
+	s, err := store.New(store.Options{BlockDuration: 10 * time.Minute})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+	for i := 0; i < 240; i++ {
+		err = s.Append("cpu", timeseries.Point{Timestamp: ts(2, 0) + uint32(i*60), Value: float64(i)})
+		if err != nil {
+			t.Fatalf("failed to append point: err=%+v", err)
+		}
+	}
+	opts := compact.Options{BlockDuration: 2 * time.Hour}
+	res, err := compact.Store(s, opts)
+	if err != nil {
+		t.Fatalf("failed to compact store: err=%+v", err)
+	}
+	if res.OutputBlocks != 2 {
+		t.Errorf("got result=%+v, want 2 output blocks", res)
+	}
+	blocks := s.SealedBlocks("cpu")
+
+	res, err = compact.Store(s, opts)
+	if err != nil {
+		t.Fatalf("failed to compact store again: err=%+v", err)
+	}
+	if !reflect.DeepEqual(res, compact.Result{}) {
+		t.Errorf("got result=%+v for compacted store, want zero result", res)
+	}
+	if got := s.SealedBlocks("cpu"); !reflect.DeepEqual(got, blocks) {
+		t.Errorf("got blocks=%+v, want unchanged %+v", got, blocks)
+	}
+
+	res, err = compact.Store(s, compact.Options{BlockDuration: time.Hour})
+	if err != nil {
+		t.Fatalf("failed to compact store to shorter blocks: err=%+v", err)
+	}
+	if res.InputBlocks != 2 || res.OutputBlocks != 4 {
+		t.Errorf("got result=%+v, want 2 input blocks and 4 output blocks", res)
+	}
+}
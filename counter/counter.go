@@ -0,0 +1,148 @@
+// Package counter implements functions for monotonic counters which may reset
+// on process restarts, with the same extrapolation semantics as Prometheus
+// rate, irate, increase, delta and resets functions.
+//
+// The functions consume data points from a timeseries.Iterator in one pass
+// and use the data points whose timestamps are between start and end
+// inclusive. ok is false when there are not enough data points in the range.
+package counter
+
+import (
+	"github.com/hnakamur/timeseries"
+)
+
+// rangeStats is what the functions need from the data points in a range.
+type rangeStats struct {
+	n          int
+	first      timeseries.Point
+	prev       timeseries.Point
+	last       timeseries.Point
+	correction float64
+	resets     int
+}
+
+func scan(it timeseries.Iterator, start, end uint32) (rangeStats, error) {
+	var s rangeStats
+	for it.Next() {
+		p := it.At()
+		if p.Timestamp < start {
+			continue
+		}
+		if p.Timestamp > end {
+			break
+		}
+		if s.n == 0 {
+			s.first = p
+		} else if p.Value < s.last.Value {
+			s.correction += s.last.Value
+			s.resets++
+		}
+		s.prev = s.last
+		s.last = p
+		s.n++
+	}
+	return s, it.Err()
+}
+
+// Rate returns the per-second average rate of increase of the counter in the
+// range, extrapolated to the range boundaries and adjusted for resets.
+func Rate(it timeseries.Iterator, start, end uint32) (v float64, ok bool, err error) {
+	return extrapolatedRate(it, start, end, true, true)
+}
+
+// Increase returns the increase of the counter in the range, extrapolated to
+// the range boundaries and adjusted for resets.
+func Increase(it timeseries.Iterator, start, end uint32) (v float64, ok bool, err error) {
+	return extrapolatedRate(it, start, end, true, false)
+}
+
+// Delta returns the difference between the first and last values of a gauge
+// in the range, extrapolated to the range boundaries.
+func Delta(it timeseries.Iterator, start, end uint32) (v float64, ok bool, err error) {
+	return extrapolatedRate(it, start, end, false, false)
+}
+
+// IRate returns the per-second instant rate of increase of the counter
+// calculated from the last two data points in the range.
+func IRate(it timeseries.Iterator, start, end uint32) (v float64, ok bool, err error) {
+	s, err := scan(it, start, end)
+	if err != nil {
+		return 0, false, err
+	}
+	if s.n < 2 || s.last.Timestamp == s.prev.Timestamp {
+		return 0, false, nil
+	}
+
+	v = s.last.Value
+	if s.last.Value >= s.prev.Value {
+		v -= s.prev.Value
+	}
+	return v / float64(s.last.Timestamp-s.prev.Timestamp), true, nil
+}
+
+// Resets returns the number of counter resets in the range. Any decrease in
+// the value between two consecutive data points is regarded as a reset.
+func Resets(it timeseries.Iterator, start, end uint32) (n int, ok bool, err error) {
+	s, err := scan(it, start, end)
+	if err != nil {
+		return 0, false, err
+	}
+	if s.n == 0 {
+		return 0, false, nil
+	}
+	return s.resets, true, nil
+}
+
+func extrapolatedRate(it timeseries.Iterator, start, end uint32, isCounter, isRate bool) (float64, bool, error) {
+	s, err := scan(it, start, end)
+	if err != nil {
+		return 0, false, err
+	}
+	if s.n < 2 {
+		return 0, false, nil
+	}
+
+	result := s.last.Value - s.first.Value
+	if isCounter {
+		result += s.correction
+	}
+
+	durationToStart := float64(s.first.Timestamp - start)
+	durationToEnd := float64(end - s.last.Timestamp)
+	sampledInterval := float64(s.last.Timestamp - s.first.Timestamp)
+	if sampledInterval == 0 {
+		return 0, false, nil
+	}
+	averageDurationBetweenSamples := sampledInterval / float64(s.n-1)
+
+	// A counter cannot be negative, so do not extrapolate the start beyond
+	// the time when the counter would have been zero.
+	if isCounter && result > 0 && s.first.Value >= 0 {
+		durationToZero := sampledInterval * (s.first.Value / result)
+		if durationToZero < durationToStart {
+			durationToStart = durationToZero
+		}
+	}
+
+	// Extrapolate to the range boundary if the first or last data point is
+	// close enough to it, otherwise extrapolate by half the average interval
+	// since the series probably starts or ends inside the range.
+	extrapolationThreshold := averageDurationBetweenSamples * 1.1
+	extrapolateToInterval := sampledInterval
+	if durationToStart < extrapolationThreshold {
+		extrapolateToInterval += durationToStart
+	} else {
+		extrapolateToInterval += averageDurationBetweenSamples / 2
+	}
+	if durationToEnd < extrapolationThreshold {
+		extrapolateToInterval += durationToEnd
+	} else {
+		extrapolateToInterval += averageDurationBetweenSamples / 2
+	}
+
+	result *= extrapolateToInterval / sampledInterval
+	if isRate {
+		result /= float64(end - start)
+	}
+	return result, true, nil
+}
@@ -0,0 +1,199 @@
+package counter_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/counter"
+)
+
+type fn func(it timeseries.Iterator, start, end uint32) (float64, bool, error)
+
+func points(tvs ...float64) []timeseries.Point {
+	var ps []timeseries.Point
+	for i := 0; i < len(tvs); i += 2 {
+		ps = append(ps, timeseries.Point{Timestamp: uint32(tvs[i]), Value: tvs[i+1]})
+	}
+	return ps
+}
+
+func TestFunctions(t *testing.T) {
+	resets := func(it timeseries.Iterator, start, end uint32) (float64, bool, error) {
+		n, ok, err := counter.Resets(it, start, end)
+		return float64(n), ok, err
+	}
+
+	testCases := []struct {
+		name   string
+		fn     fn
+		points []timeseries.Point
+		start  uint32
+		end    uint32
+		want   float64
+		wantOK bool
+	}{
+		{
+			name:   "increase without extrapolation",
+			fn:     counter.Increase,
+			points: points(0, 0, 300, 10, 600, 20, 900, 30),
+			start:  0,
+			end:    900,
+			want:   30,
+			wantOK: true,
+		},
+		{
+			name:   "rate without extrapolation",
+			fn:     counter.Rate,
+			points: points(0, 0, 300, 10, 600, 20, 900, 30),
+			start:  0,
+			end:    900,
+			want:   30.0 / 900,
+			wantOK: true,
+		},
+		{
+			name:   "increase extrapolated to both boundaries",
+			fn:     counter.Increase,
+			points: points(10, 1, 20, 2, 30, 3),
+			start:  0,
+			end:    40,
+			want:   4,
+			wantOK: true,
+		},
+		{
+			name:   "rate extrapolated to both boundaries",
+			fn:     counter.Rate,
+			points: points(10, 1, 20, 2, 30, 3),
+			start:  0,
+			end:    40,
+			want:   0.1,
+			wantOK: true,
+		},
+		{
+			name:   "increase extrapolated to zero at start",
+			fn:     counter.Increase,
+			points: points(10, 1, 20, 4, 30, 7),
+			start:  0,
+			end:    30,
+			// durationToZero = 20 * 1/6 = 3.33..., so the extrapolated
+			// interval is 23.33... and the result is 6 * 23.33... / 20.
+			want:   7,
+			wantOK: true,
+		},
+		{
+			name:   "increase extrapolated by half the average interval",
+			fn:     counter.Increase,
+			points: points(0, 1, 100, 3),
+			start:  0,
+			end:    300,
+			want:   3,
+			wantOK: true,
+		},
+		{
+			name:   "increase with a reset",
+			fn:     counter.Increase,
+			points: points(0, 5, 10, 10, 20, 2, 30, 4),
+			start:  0,
+			end:    30,
+			want:   9,
+			wantOK: true,
+		},
+		{
+			name:   "delta of gauge",
+			fn:     counter.Delta,
+			points: points(10, 3, 20, 2, 30, 1),
+			start:  0,
+			end:    40,
+			want:   -4,
+			wantOK: true,
+		},
+		{
+			name:   "irate with a reset",
+			fn:     counter.IRate,
+			points: points(0, 5, 10, 10, 20, 2, 30, 4),
+			start:  0,
+			end:    30,
+			want:   0.2,
+			wantOK: true,
+		},
+		{
+			name:   "irate after a reset",
+			fn:     counter.IRate,
+			points: points(0, 5, 10, 10, 20, 2),
+			start:  0,
+			end:    30,
+			want:   0.2,
+			wantOK: true,
+		},
+		{
+			name:   "resets",
+			fn:     resets,
+			points: points(0, 5, 10, 10, 20, 2, 30, 4, 40, 1, 50, 1),
+			start:  0,
+			end:    50,
+			want:   2,
+			wantOK: true,
+		},
+		{
+			name:   "points outside range are ignored",
+			fn:     counter.Increase,
+			points: points(0, 100, 10, 1, 20, 2, 30, 3, 50, 0),
+			start:  5,
+			end:    35,
+			// durationToStart = 5, durationToEnd = 5, so the result is
+			// 2 * 30 / 20.
+			want:   3,
+			wantOK: true,
+		},
+		{
+			name:   "one point",
+			fn:     counter.Rate,
+			points: points(10, 1),
+			start:  0,
+			end:    40,
+			wantOK: false,
+		},
+		{
+			name:   "no point for resets",
+			fn:     resets,
+			points: nil,
+			start:  0,
+			end:    40,
+			wantOK: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		got, ok, err := tc.fn(timeseries.NewSliceIterator(tc.points), tc.start, tc.end)
+		if err != nil {
+			t.Fatalf("%s: got error %+v", tc.name, err)
+		}
+		if ok != tc.wantOK {
+			t.Errorf("%s: got ok=%v, want %v", tc.name, ok, tc.wantOK)
+			continue
+		}
+		if ok && math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%s: got=%g, want=%g", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRateOnBlock(t *testing.T) {
+	t0 := uint32(1427162400)
+	var ps []timeseries.Point
+	for i := 0; i <= 10; i++ {
+		ps = append(ps, timeseries.Point{Timestamp: t0 + uint32(i*60), Value: float64(i * 6)})
+	}
+	data, err := timeseries.Marshal(t0, ps)
+	if err != nil {
+		t.Fatalf("failed to marshal points: err=%+v", err)
+	}
+
+	got, ok, err := counter.Rate(timeseries.NewBlockIterator(data), t0, t0+600)
+	if err != nil || !ok {
+		t.Fatalf("failed to calculate rate: ok=%v, err=%+v", ok, err)
+	}
+	if got != 0.1 {
+		t.Errorf("got=%g, want=0.1", got)
+	}
+}
@@ -0,0 +1,153 @@
+// Package csvblock converts CSV and TSV files with a timestamp column and
+// value columns into encoded blocks and back, one row at a time so that files
+// larger than memory can be converted.
+package csvblock
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UnixFormat is the time format for numeric timestamps in Options.TimeUnit
+// since 1970-01-01 00:00:00 +0000 UTC.
+const UnixFormat = "unix"
+
+// Options is options for reading and writing CSV.
+type Options struct {
+	// Comma is the field delimiter. It defaults to ','. Use '\t' for TSV.
+	Comma rune
+
+	// TimeColumn is the header of the timestamp column. It defaults to
+	// "time".
+	TimeColumn string
+
+	// TimeFormat is UnixFormat or a layout for time.Parse and
+	// time.Time.Format. It defaults to UnixFormat.
+	TimeFormat string
+
+	// TimeUnit is the unit of numeric timestamps. It must be a divisor or a
+	// multiple of a second and defaults to a second.
+	TimeUnit time.Duration
+
+	// Location is the location for layouts without time zones. It defaults
+	// to UTC.
+	Location *time.Location
+}
+
+func (o Options) withDefaults() (Options, error) {
+	if o.Comma == 0 {
+		o.Comma = ','
+	}
+	if o.TimeColumn == "" {
+		o.TimeColumn = "time"
+	}
+	if o.TimeFormat == "" {
+		o.TimeFormat = UnixFormat
+	}
+	if o.TimeUnit == 0 {
+		o.TimeUnit = time.Second
+	}
+	if o.TimeUnit < 0 || (o.TimeUnit < time.Second && time.Second%o.TimeUnit != 0) ||
+		(o.TimeUnit > time.Second && o.TimeUnit%time.Second != 0) {
+		return Options{}, fmt.Errorf("invalid time unit: unit=%s, must be a divisor or a multiple of second", o.TimeUnit)
+	}
+	if o.Location == nil {
+		o.Location = time.UTC
+	}
+	return o, nil
+}
+
+// ParseTimeUnit parses a time unit of numeric timestamps: s, ms, us or ns.
+// An empty string is a second.
+func ParseTimeUnit(s string) (time.Duration, error) {
+	switch s {
+	case "", "s":
+		return time.Second, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "us":
+		return time.Microsecond, nil
+	case "ns":
+		return time.Nanosecond, nil
+	}
+	return 0, fmt.Errorf("invalid time unit %q, must be one of s, ms, us and ns", s)
+}
+
+// parseTime parses a timestamp and truncates it to seconds.
+func (o *Options) parseTime(s string) (uint32, error) {
+	if o.TimeFormat != UnixFormat {
+		t, err := time.ParseInLocation(o.TimeFormat, s, o.Location)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp %q: %v", s, err)
+		}
+		if t.Unix() < 0 || t.Unix() > math.MaxUint32 {
+			return 0, fmt.Errorf("timestamp out of range %q", s)
+		}
+		return uint32(t.Unix()), nil
+	}
+
+	var sec float64
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		// Use integer arithmetic to avoid rounding large timestamps in
+		// fine units.
+		if o.TimeUnit < time.Second {
+			n /= int64(time.Second / o.TimeUnit)
+		} else if n > math.MaxUint32 {
+			return 0, fmt.Errorf("timestamp out of range %q", s)
+		} else {
+			n *= int64(o.TimeUnit / time.Second)
+		}
+		sec = float64(n)
+	} else {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return 0, fmt.Errorf("invalid timestamp %q", s)
+		}
+		sec = math.Floor(f * o.TimeUnit.Seconds())
+	}
+	if sec < 0 || sec > math.MaxUint32 {
+		return 0, fmt.Errorf("timestamp out of range %q", s)
+	}
+	return uint32(sec), nil
+}
+
+// formatTime formats a timestamp.
+func (o *Options) formatTime(t uint32) string {
+	if o.TimeFormat != UnixFormat {
+		return time.Unix(int64(t), 0).In(o.Location).Format(o.TimeFormat)
+	}
+	if o.TimeUnit < time.Second {
+		return strconv.FormatUint(uint64(t)*uint64(time.Second/o.TimeUnit), 10)
+	}
+	unit := uint64(o.TimeUnit / time.Second)
+	if uint64(t)%unit == 0 {
+		return strconv.FormatUint(uint64(t)/unit, 10)
+	}
+	return strconv.FormatFloat(float64(t)/float64(unit), 'f', -1, 64)
+}
+
+func parseValue(s string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return v, nil
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+var errNoColumns = errors.New("no value columns")
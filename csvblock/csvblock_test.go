@@ -0,0 +1,206 @@
+package csvblock_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/csvblock"
+	"github.com/hnakamur/timeseries/store"
+)
+
+type memSink map[string][]store.BlockInfo
+
+func (s memSink) WriteBlock(key string, b store.BlockInfo) error {
+	s[key] = append(s[key], b)
+	return nil
+}
+
+func (s memSink) points(t *testing.T, key string) []timeseries.Point {
+	t.Helper()
+	var points []timeseries.Point
+	for _, b := range s[key] {
+		t0, ps, err := b.Block.Points()
+		if err != nil {
+			t.Fatalf("failed to decode block: key=%s, err=%+v", key, err)
+		}
+		if t0 != b.T0 || ps[len(ps)-1].Timestamp != b.Last {
+			t.Errorf("key=%s, got T0=%d, Last=%d, want T0=%d, Last=%d", key, b.T0, b.Last, t0, ps[len(ps)-1].Timestamp)
+		}
+		points = append(points, ps...)
+	}
+	return points
+}
+
+func TestReader(t *testing.T) {
+	testCases := []struct {
+		name    string
+		opts    csvblock.Options
+		columns []string
+		input   string
+		want    []int64
+	}{
+		{
+			name:  "unix seconds",
+			input: "time,a\n1700000000,1\n1700000001.9,2\n",
+			want:  []int64{1700000000, 1700000001},
+		},
+		{
+			name:  "unix milliseconds",
+			opts:  csvblock.Options{TimeUnit: time.Millisecond},
+			input: "time,a\n1700000000999,1\n",
+			want:  []int64{1700000000},
+		},
+		{
+			name:  "unix hours",
+			opts:  csvblock.Options{TimeUnit: time.Hour},
+			input: "time,a\n472222,1\n",
+			want:  []int64{472222 * 3600},
+		},
+		{
+			name:  "layout",
+			opts:  csvblock.Options{TimeColumn: "ts", TimeFormat: "2006-01-02 15:04:05", Location: time.FixedZone("JST", 9*3600)},
+			input: "ts,a\n2023-11-15 07:13:20,1\n",
+			want:  []int64{1700000000},
+		},
+		{
+			name:  "tsv",
+			opts:  csvblock.Options{Comma: '\t', TimeFormat: time.RFC3339},
+			input: "a\ttime\n1\t2023-11-14T22:13:20Z\n",
+			want:  []int64{1700000000},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := csvblock.NewReader(strings.NewReader(tc.input), tc.opts, tc.columns...)
+			if err != nil {
+				t.Fatalf("failed to create reader: err=%+v", err)
+			}
+			var got []int64
+			for r.Next() {
+				got = append(got, int64(r.Row().Timestamp))
+			}
+			if err := r.Err(); err != nil {
+				t.Fatalf("failed to read: err=%+v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got=%v, want=%v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestImportError(t *testing.T) {
+	testCases := []struct {
+		name    string
+		opts    csvblock.Options
+		columns []string
+		input   string
+	}{
+		{name: "empty", input: ""},
+		{name: "no time column", input: "t,a\n1,2\n"},
+		{name: "duplicate column", input: "time,a,a\n1,2,3\n"},
+		{name: "no value columns", input: "time\n1\n"},
+		{name: "unknown column", columns: []string{"b"}, input: "time,a\n1,2\n"},
+		{name: "time column as value", columns: []string{"time"}, input: "time,a\n1,2\n"},
+		{name: "invalid time unit", opts: csvblock.Options{TimeUnit: 7 * time.Millisecond}, input: "time,a\n1,2\n"},
+		{name: "invalid timestamp", input: "time,a\nabc,2\n"},
+		{name: "negative timestamp", input: "time,a\n-1,2\n"},
+		{name: "timestamp out of range", input: "time,a\n4294967296,2\n"},
+		{name: "invalid layout timestamp", opts: csvblock.Options{TimeFormat: time.RFC3339}, input: "time,a\n1700000000,2\n"},
+		{name: "invalid value", input: "time,a\n1,abc\n"},
+		{name: "wrong number of fields", input: "time,a\n1,2,3\n"},
+		{name: "decreasing timestamps", input: "time,a\n2,1\n1,2\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := csvblock.NewReader(strings.NewReader(tc.input), tc.opts, tc.columns...)
+			if err == nil {
+				err = csvblock.Import(r, time.Hour, memSink{})
+			}
+			if err == nil {
+				t.Errorf("got no error, want error")
+			}
+		})
+	}
+}
+
+func TestImportExport(t *testing.T) {
+	input := "time,cpu,mem,disk\n" +
+		"1700000000,1.5,100,\n" +
+		"1700000060,2,,7\n" +
+		"1700003600,-3,101,8\n" +
+		"1700003600,4,102,9\n" +
+		"1700010800,NaN,103,\n"
+	r, err := csvblock.NewReader(strings.NewReader(input), csvblock.Options{}, "mem", "cpu")
+	if err != nil {
+		t.Fatalf("failed to create reader: err=%+v", err)
+	}
+	if got, want := r.Columns(), []string{"mem", "cpu"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("columns got=%v, want=%v", got, want)
+	}
+	sink := memSink{}
+	err = csvblock.Import(r, time.Hour, sink)
+	if err != nil {
+		t.Fatalf("failed to import: err=%+v", err)
+	}
+	if _, ok := sink["disk"]; ok {
+		t.Errorf("got unselected column disk")
+	}
+	if got := len(sink["cpu"]); got != 3 {
+		t.Errorf("got %d blocks of cpu, want 3", got)
+	}
+	mem := sink.points(t, "mem")
+	wantMem := []timeseries.Point{
+		{Timestamp: 1700000000, Value: 100},
+		{Timestamp: 1700003600, Value: 101},
+		{Timestamp: 1700003600, Value: 102},
+		{Timestamp: 1700010800, Value: 103},
+	}
+	if !reflect.DeepEqual(mem, wantMem) {
+		t.Errorf("mem got=%+v, want=%+v", mem, wantMem)
+	}
+	cpu := sink.points(t, "cpu")
+
+	var buf bytes.Buffer
+	err = csvblock.Export(&buf, csvblock.Options{Comma: '\t', TimeFormat: time.RFC3339},
+		[]string{"cpu", "mem"}, []timeseries.Iterator{timeseries.NewSliceIterator(cpu), timeseries.NewSliceIterator(mem)})
+	if err != nil {
+		t.Fatalf("failed to export: err=%+v", err)
+	}
+	want := "time\tcpu\tmem\n" +
+		"2023-11-14T22:13:20Z\t1.5\t100\n" +
+		"2023-11-14T22:14:20Z\t2\t\n" +
+		"2023-11-14T23:13:20Z\t-3\t101\n" +
+		"2023-11-14T23:13:20Z\t4\t102\n" +
+		"2023-11-15T01:13:20Z\tNaN\t103\n"
+	if buf.String() != want {
+		t.Errorf("got=%q, want=%q", buf.String(), want)
+	}
+}
+
+func TestWriterTimeFormat(t *testing.T) {
+	testCases := []struct {
+		opts csvblock.Options
+		want string
+	}{
+		{opts: csvblock.Options{}, want: "1700000000"},
+		{opts: csvblock.Options{TimeUnit: time.Millisecond}, want: "1700000000000"},
+		{opts: csvblock.Options{TimeUnit: time.Minute}, want: "28333333.333333332"},
+		{opts: csvblock.Options{TimeFormat: time.RFC3339, Location: time.FixedZone("JST", 9*3600)}, want: "2023-11-15T07:13:20+09:00"},
+	}
+	for _, tc := range testCases {
+		var buf bytes.Buffer
+		err := csvblock.Export(&buf, tc.opts, []string{"v"},
+			[]timeseries.Iterator{timeseries.NewSliceIterator([]timeseries.Point{{Timestamp: 1700000000, Value: 1}})})
+		if err != nil {
+			t.Fatalf("failed to export: err=%+v", err)
+		}
+		if want := "time,v\n" + tc.want + ",1\n"; buf.String() != want {
+			t.Errorf("opts=%+v, got=%q, want=%q", tc.opts, buf.String(), want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package csvblock
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/join"
+	"github.com/hnakamur/timeseries/store"
+)
+
+// Reader reads rows of a CSV file with a header.
+type Reader struct {
+	cr        *csv.Reader
+	opts      Options
+	timeIndex int
+	indexes   []int
+	columns   []string
+	row       join.Row
+	line      int
+	err       error
+}
+
+// NewReader creates a reader and reads the header. The columns are the
+// headers of the value columns to read, and default to all columns other
+// than the timestamp column.
+func NewReader(r io.Reader, opts Options, columns ...string) (*Reader, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	cr := csv.NewReader(r)
+	cr.Comma = opts.Comma
+	cr.ReuseRecord = true
+	// TSV files usually have no quoting.
+	cr.LazyQuotes = opts.Comma == '\t'
+
+	header, err := cr.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("missing header")
+		}
+		return nil, fmt.Errorf("failed to read header: err=%+v", err)
+	}
+	index := make(map[string]int, len(header))
+	for i, h := range header {
+		if _, ok := index[h]; ok {
+			return nil, fmt.Errorf("duplicate column %q in header", h)
+		}
+		index[h] = i
+	}
+	timeIndex, ok := index[opts.TimeColumn]
+	if !ok {
+		return nil, fmt.Errorf("time column %q not found in header", opts.TimeColumn)
+	}
+
+	rd := &Reader{cr: cr, opts: opts, timeIndex: timeIndex}
+	if len(columns) == 0 {
+		for i, h := range header {
+			if i != timeIndex {
+				rd.indexes = append(rd.indexes, i)
+				rd.columns = append(rd.columns, h)
+			}
+		}
+	} else {
+		for _, c := range columns {
+			i, ok := index[c]
+			if !ok || i == timeIndex {
+				return nil, fmt.Errorf("value column %q not found in header", c)
+			}
+			rd.indexes = append(rd.indexes, i)
+			rd.columns = append(rd.columns, c)
+		}
+	}
+	if len(rd.columns) == 0 {
+		return nil, errNoColumns
+	}
+	rd.row = join.Row{
+		Values:  make([]float64, len(rd.columns)),
+		Present: make([]bool, len(rd.columns)),
+	}
+	return rd, nil
+}
+
+// Columns returns the headers of the value columns.
+func (r *Reader) Columns() []string {
+	return r.columns
+}
+
+// Next reads the next row. It returns false at the end of the file or when
+// an error occurred.
+func (r *Reader) Next() bool {
+	if r.err != nil {
+		return false
+	}
+	record, err := r.cr.Read()
+	if err == io.EOF {
+		return false
+	} else if err != nil {
+		r.err = fmt.Errorf("failed to read CSV: err=%+v", err)
+		return false
+	}
+	r.line, _ = r.cr.FieldPos(0)
+
+	r.row.Timestamp, err = r.opts.parseTime(record[r.timeIndex])
+	if err != nil {
+		r.err = fmt.Errorf("line %d: %v", r.line, err)
+		return false
+	}
+	for i, j := range r.indexes {
+		// Empty cells are missing values.
+		if record[j] == "" {
+			r.row.Values[i], r.row.Present[i] = 0, false
+			continue
+		}
+		r.row.Values[i], err = parseValue(record[j])
+		if err != nil {
+			r.err = fmt.Errorf("line %d, column %q: %v", r.line, r.columns[i], err)
+			return false
+		}
+		r.row.Present[i] = true
+	}
+	return true
+}
+
+// Row returns the current row with the values in the order of Columns. The
+// slices in the row are reused by the following call of Next.
+func (r *Reader) Row() join.Row {
+	return r.row
+}
+
+// Line returns the line number of the current row.
+func (r *Reader) Line() int {
+	return r.line
+}
+
+// Err returns the error which stopped reading, if any.
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Sink receives sealed blocks of the series for the keys.
+// blockfile.Writer implements it.
+type Sink interface {
+	WriteBlock(key string, b store.BlockInfo) error
+}
+
+// Import reads the rows and writes the values of each column into blocks of
+// the duration, which are written to the sink with the column header as the
+// key. Rows must be in timestamp order, and only one open block per column
+// is kept in memory.
+func Import(r *Reader, blockDuration time.Duration, sink Sink) error {
+	columns := r.Columns()
+	writers := make([]*timeseries.SeriesWriter, len(columns))
+	last := make([]uint32, len(columns))
+	for i := range columns {
+		i := i
+		w, err := timeseries.NewSeriesWriter(blockDuration, 0, timeseries.BlockSinkFunc(func(t0 uint32, block timeseries.Block) error {
+			// A block is sealed before the point of the next block is
+			// written, so last is still the last point of the block.
+			return sink.WriteBlock(columns[i], store.BlockInfo{T0: t0, Last: last[i], Block: block})
+		}))
+		if err != nil {
+			return err
+		}
+		writers[i] = w
+	}
+
+	for r.Next() {
+		row := r.Row()
+		for i, ok := range row.Present {
+			if !ok {
+				continue
+			}
+			err := writers[i].Write(timeseries.Point{Timestamp: row.Timestamp, Value: row.Values[i]})
+			if err != nil {
+				return fmt.Errorf("line %d, column %q: %v", r.Line(), columns[i], err)
+			}
+			last[i] = row.Timestamp
+		}
+	}
+	if err := r.Err(); err != nil {
+		return err
+	}
+	for i, w := range writers {
+		err := w.Flush()
+		if err != nil {
+			return fmt.Errorf("failed to flush column %q: err=%+v", columns[i], err)
+		}
+	}
+	return nil
+}
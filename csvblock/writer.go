@@ -0,0 +1,85 @@
+package csvblock
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/join"
+)
+
+// Writer writes rows of a CSV file with a header.
+type Writer struct {
+	cw     *csv.Writer
+	opts   Options
+	record []string
+}
+
+// NewWriter creates a writer and writes the header with the timestamp column
+// and the value columns.
+func NewWriter(w io.Writer, opts Options, columns []string) (*Writer, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, errNoColumns
+	}
+	cw := csv.NewWriter(w)
+	cw.Comma = opts.Comma
+	record := make([]string, 0, len(columns)+1)
+	record = append(record, opts.TimeColumn)
+	record = append(record, columns...)
+	err = cw.Write(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write header: err=%+v", err)
+	}
+	return &Writer{cw: cw, opts: opts, record: record}, nil
+}
+
+// Write writes a row. Values which are not present are written as empty
+// cells.
+func (w *Writer) Write(row join.Row) error {
+	if len(row.Values) != len(w.record)-1 {
+		return fmt.Errorf("row has %d values, want %d", len(row.Values), len(w.record)-1)
+	}
+	w.record[0] = w.opts.formatTime(row.Timestamp)
+	for i, v := range row.Values {
+		if row.Present[i] {
+			w.record[i+1] = formatValue(v)
+		} else {
+			w.record[i+1] = ""
+		}
+	}
+	return w.cw.Write(w.record)
+}
+
+// Flush writes buffered rows to the underlying writer.
+func (w *Writer) Flush() error {
+	w.cw.Flush()
+	return w.cw.Error()
+}
+
+// Export joins the series read from the iterators by timestamp and writes
+// them as rows with the columns as the headers of the value columns.
+func Export(w io.Writer, opts Options, columns []string, its []timeseries.Iterator) error {
+	if len(columns) != len(its) {
+		return fmt.Errorf("got %d columns and %d iterators, must be the same", len(columns), len(its))
+	}
+	cw, err := NewWriter(w, opts, columns)
+	if err != nil {
+		return err
+	}
+	j := join.NewJoin(0, its...)
+	for j.Next() {
+		err = cw.Write(j.At())
+		if err != nil {
+			return err
+		}
+	}
+	if err := j.Err(); err != nil {
+		return err
+	}
+	return cw.Flush()
+}
@@ -27,6 +27,15 @@ func NewDecoder(r io.Reader) *Decoder {
 	}
 }
 
+// Reset discards the state of the decoder and makes it read from r.
+// This permits reusing a Decoder rather than allocating a new one.
+func (d *Decoder) Reset(r io.Reader) {
+	d.rd.Reset(r)
+	*d = Decoder{
+		rd: d.rd,
+	}
+}
+
 // DecodeHeader decodes header to the block timestamp.
 func (d *Decoder) DecodeHeader() (t0 uint32, err error) {
 	timestamp, err := d.rd.ReadBits(32)
@@ -0,0 +1,136 @@
+// Package downsample implements downsampling of raw blocks into rollup blocks
+// which hold aggregates of data points per interval, and a query path which
+// picks the coarsest resolution adequate for a requested step.
+//
+// A rollup block has a column for each aggregate. Each column is a block
+// encoded with timeseries.Encoder whose data points are at the start of the
+// intervals, so rollups are compressed in the same way as raw blocks.
+package downsample
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/hnakamur/timeseries"
+)
+
+// Aggregate is an aggregate function of data points in an interval.
+type Aggregate int
+
+const (
+	// Min is the minimum value.
+	Min Aggregate = iota
+
+	// Max is the maximum value.
+	Max
+
+	// Sum is the sum of values.
+	Sum
+
+	// Count is the number of data points.
+	Count
+
+	// Last is the value of the last data point.
+	Last
+
+	numAggregates
+)
+
+func (a Aggregate) String() string {
+	switch a {
+	case Min:
+		return "min"
+	case Max:
+		return "max"
+	case Sum:
+		return "sum"
+	case Count:
+		return "count"
+	case Last:
+		return "last"
+	default:
+		return fmt.Sprintf("Aggregate(%d)", int(a))
+	}
+}
+
+// Bucket is the aggregates of data points in an interval.
+type Bucket struct {
+	// Timestamp is the start of the interval.
+	Timestamp uint32
+
+	Min   float64
+	Max   float64
+	Sum   float64
+	Count float64
+	Last  float64
+}
+
+// Value returns the value of the aggregate.
+func (b Bucket) Value(a Aggregate) float64 {
+	switch a {
+	case Min:
+		return b.Min
+	case Max:
+		return b.Max
+	case Sum:
+		return b.Sum
+	case Count:
+		return b.Count
+	case Last:
+		return b.Last
+	default:
+		return math.NaN()
+	}
+}
+
+func (b *Bucket) set(a Aggregate, v float64) {
+	switch a {
+	case Min:
+		b.Min = v
+	case Max:
+		b.Max = v
+	case Sum:
+		b.Sum = v
+	case Count:
+		b.Count = v
+	case Last:
+		b.Last = v
+	}
+}
+
+func (b *Bucket) add(v float64) {
+	if b.Count == 0 {
+		b.Min = v
+		b.Max = v
+	} else {
+		b.Min = math.Min(b.Min, v)
+		b.Max = math.Max(b.Max, v)
+	}
+	b.Sum += v
+	b.Count++
+	b.Last = v
+}
+
+// Buckets aggregates data points in timestamp order into buckets of the
+// interval in seconds. Intervals start at multiples of the interval since
+// 1970-01-01 00:00:00 +0000 UTC. Intervals without data points are omitted.
+func Buckets(points []timeseries.Point, interval uint32) []Bucket {
+	var buckets []Bucket
+	for _, p := range points {
+		start := p.Timestamp - p.Timestamp%interval
+		if len(buckets) == 0 || buckets[len(buckets)-1].Timestamp != start {
+			buckets = append(buckets, Bucket{Timestamp: start})
+		}
+		buckets[len(buckets)-1].add(p.Value)
+	}
+	return buckets
+}
+
+// Points returns the values of the aggregate of buckets as data points.
+func Points(buckets []Bucket, a Aggregate) []timeseries.Point {
+	points := make([]timeseries.Point, len(buckets))
+	for i, b := range buckets {
+		points[i] = timeseries.Point{Timestamp: b.Timestamp, Value: b.Value(a)}
+	}
+	return points
+}
@@ -0,0 +1,108 @@
+package downsample_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/downsample"
+	"github.com/hnakamur/timeseries/store"
+)
+
+func ts(hour, min, sec int) uint32 {
+	return uint32(time.Date(2015, 3, 24, hour, min, sec, 0, time.UTC).Unix())
+}
+
+func TestBuckets(t *testing.T) {
+	points := []timeseries.Point{
+		{Timestamp: ts(2, 0, 10), Value: 3},
+		{Timestamp: ts(2, 0, 20), Value: 1},
+		{Timestamp: ts(2, 0, 50), Value: 2},
+		{Timestamp: ts(2, 2, 0), Value: -1},
+	}
+	got := downsample.Buckets(points, 60)
+	want := []downsample.Bucket{
+		{Timestamp: ts(2, 0, 0), Min: 1, Max: 3, Sum: 6, Count: 3, Last: 2},
+		{Timestamp: ts(2, 2, 0), Min: -1, Max: -1, Sum: -1, Count: 1, Last: -1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got=%+v, want=%+v", got, want)
+	}
+
+	rb, err := downsample.NewRollupBlock(ts(2, 0, 0), got)
+	if err != nil {
+		t.Fatalf("failed to create rollup block: err=%+v", err)
+	}
+	decoded, err := rb.Buckets()
+	if err != nil {
+		t.Fatalf("failed to decode rollup block: err=%+v", err)
+	}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("decoded=%+v, want=%+v", decoded, want)
+	}
+}
+
+func TestDownsamplerQuery(t *testing.T) {
+	s, err := store.New(store.Options{BlockDuration: time.Hour})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+	// A data point every 10 seconds from 00:00 to 05:59:50 with values
+	// 0, 1, ..., 5 repeatedly.
+	for i := 0; i < 6*360; i++ {
+		err = s.Append("cpu", timeseries.Point{Timestamp: ts(0, 0, 0) + uint32(i*10), Value: float64(i % 6)})
+		if err != nil {
+			t.Fatalf("failed to append point: err=%+v", err)
+		}
+	}
+
+	d, err := downsample.New(s, time.Hour, time.Minute)
+	if err != nil {
+		t.Fatalf("failed to create downsampler: err=%+v", err)
+	}
+	err = d.Update()
+	if err != nil {
+		t.Fatalf("failed to downsample: err=%+v", err)
+	}
+	if got := len(d.Rollups("cpu", time.Minute)); got != 1 {
+		t.Errorf("got %d rollup blocks of 1m, want 1", got)
+	}
+
+	testCases := []struct {
+		step           time.Duration
+		agg            downsample.Aggregate
+		wantResolution time.Duration
+		wantLen        int
+		wantValue      float64
+	}{
+		{step: 10 * time.Second, agg: downsample.Max, wantResolution: 0, wantLen: 6 * 360, wantValue: 0},
+		{step: 5 * time.Minute, agg: downsample.Max, wantResolution: time.Minute, wantLen: 6 * 60, wantValue: 5},
+		{step: time.Hour, agg: downsample.Count, wantResolution: time.Hour, wantLen: 6, wantValue: 360},
+		{step: 24 * time.Hour, agg: downsample.Sum, wantResolution: time.Hour, wantLen: 6, wantValue: 900},
+	}
+	for _, tc := range testCases {
+		points, resolution, err := d.Query("cpu", ts(0, 0, 0), ts(5, 59, 59), tc.step, tc.agg)
+		if err != nil {
+			t.Fatalf("failed to query: err=%+v", err)
+		}
+		if resolution != tc.wantResolution {
+			t.Errorf("step=%s, got resolution=%s, want %s", tc.step, resolution, tc.wantResolution)
+		}
+		if len(points) != tc.wantLen {
+			t.Fatalf("step=%s, got %d points, want %d", tc.step, len(points), tc.wantLen)
+		}
+		// The last hour is in the open block and aggregated on the fly.
+		for _, p := range points {
+			if p.Value != tc.wantValue && resolution != 0 {
+				t.Errorf("step=%s, agg=%s, got point=%+v, want value=%g", tc.step, tc.agg, p, tc.wantValue)
+				break
+			}
+		}
+	}
+
+	_, err = downsample.New(s, 7*time.Minute)
+	if err == nil {
+		t.Error("interval not dividing block duration: got no error, want an error")
+	}
+}
@@ -0,0 +1,272 @@
+package downsample
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/store"
+)
+
+// rollupWindow is the duration of rollup blocks. It is rounded down to a
+// multiple of the interval of a resolution. Four hours divide a day and the
+// first bucket of a block of four hours fits in the first delta.
+const rollupWindow = 4 * time.Hour
+
+// Downsampler keeps rollups of the sealed blocks in a store for each
+// resolution.
+//
+// The intervals of the resolutions must divide the block duration and the
+// alignment of the store, so that raw blocks end at interval boundaries.
+type Downsampler struct {
+	src    *store.Store
+	levels []level
+
+	mu     sync.Mutex
+	series map[string]*rollupSeries
+}
+
+type level struct {
+	interval uint32
+	window   uint32
+}
+
+type rollupSeries struct {
+	// done is the timestamp after the last downsampled data point.
+	done   uint32
+	levels []levelState
+}
+
+type levelState struct {
+	sealed []RollupBlock
+	openT0 uint32
+	open   []Bucket
+}
+
+// New creates a downsampler for the store with the intervals of resolutions.
+// Each interval must be a multiple of a second and at most
+// timeseries.MaxBlockDuration.
+func New(src *store.Store, intervals ...time.Duration) (*Downsampler, error) {
+	if len(intervals) == 0 {
+		return nil, errors.New("no downsampling interval")
+	}
+	opts := src.Options()
+	d := &Downsampler{src: src, series: make(map[string]*rollupSeries)}
+	for _, iv := range intervals {
+		if iv < time.Second || iv > timeseries.MaxBlockDuration || iv%time.Second != 0 {
+			return nil, fmt.Errorf("invalid downsampling interval: interval=%s, must be multiple of second and between 1s and %s",
+				iv, timeseries.MaxBlockDuration)
+		}
+		if opts.BlockDuration%iv != 0 || opts.Alignment%iv != 0 {
+			return nil, fmt.Errorf("downsampling interval must divide block duration and alignment of store: interval=%s, blockDuration=%s, alignment=%s",
+				iv, opts.BlockDuration, opts.Alignment)
+		}
+		interval := uint32(iv / time.Second)
+		window := uint32(rollupWindow/time.Second) / interval * interval
+		if window == 0 {
+			window = interval
+		}
+		d.levels = append(d.levels, level{interval: interval, window: window})
+	}
+	sort.Slice(d.levels, func(i, j int) bool {
+		return d.levels[i].interval < d.levels[j].interval
+	})
+	return d, nil
+}
+
+// Update downsamples the data points in the sealed blocks of the store which
+// are not downsampled yet.
+func (d *Downsampler) Update() error {
+	for _, key := range d.src.Keys() {
+		err := d.updateSeries(key)
+		if err != nil {
+			return fmt.Errorf("failed to downsample series: key=%s, err=%+v", key, err)
+		}
+	}
+	return nil
+}
+
+func (d *Downsampler) updateSeries(key string) error {
+	blocks := d.src.SealedBlocks(key)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	rs := d.series[key]
+	if rs == nil {
+		rs = &rollupSeries{levels: make([]levelState, len(d.levels))}
+		d.series[key] = rs
+	}
+
+	for _, b := range blocks {
+		if b.Last < rs.done {
+			continue
+		}
+		_, points, err := b.Block.Points()
+		if err != nil {
+			return err
+		}
+		i := sort.Search(len(points), func(i int) bool {
+			return points[i].Timestamp >= rs.done
+		})
+		points = points[i:]
+		if len(points) == 0 {
+			continue
+		}
+
+		for j, lv := range d.levels {
+			err = rs.levels[j].add(lv, Buckets(points, lv.interval))
+			if err != nil {
+				return err
+			}
+		}
+		rs.done = points[len(points)-1].Timestamp + 1
+	}
+	return nil
+}
+
+// add adds buckets in timestamp order. A bucket with the same timestamp as the
+// last bucket is merged into it, since a raw block may be sealed early in the
+// middle of an interval.
+func (ls *levelState) add(lv level, buckets []Bucket) error {
+	for _, b := range buckets {
+		t0 := b.Timestamp - b.Timestamp%lv.window
+		if len(ls.open) > 0 && ls.openT0 != t0 {
+			rb, err := NewRollupBlock(ls.openT0, ls.open)
+			if err != nil {
+				return err
+			}
+			ls.sealed = append(ls.sealed, rb)
+			ls.open = nil
+		}
+		ls.openT0 = t0
+		ls.open = appendBucket(ls.open, b)
+	}
+	return nil
+}
+
+func appendBucket(buckets []Bucket, b Bucket) []Bucket {
+	if len(buckets) == 0 || buckets[len(buckets)-1].Timestamp != b.Timestamp {
+		return append(buckets, b)
+	}
+	last := &buckets[len(buckets)-1]
+	last.Min = math.Min(last.Min, b.Min)
+	last.Max = math.Max(last.Max, b.Max)
+	last.Sum += b.Sum
+	last.Count += b.Count
+	last.Last = b.Last
+	return buckets
+}
+
+// Rollups returns the sealed rollup blocks of the series for the key at the
+// resolution of the interval.
+func (d *Downsampler) Rollups(key string, interval time.Duration) []RollupBlock {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	rs := d.series[key]
+	if rs == nil {
+		return nil
+	}
+	for i, lv := range d.levels {
+		if time.Duration(lv.interval)*time.Second == interval {
+			return append([]RollupBlock(nil), rs.levels[i].sealed...)
+		}
+	}
+	return nil
+}
+
+// Resolution returns the coarsest interval of resolutions which is not longer
+// than the step. It returns zero if the step is shorter than all intervals,
+// which means raw data points are adequate.
+func (d *Downsampler) Resolution(step time.Duration) time.Duration {
+	var res time.Duration
+	for _, lv := range d.levels {
+		iv := time.Duration(lv.interval) * time.Second
+		if iv > step {
+			break
+		}
+		res = iv
+	}
+	return res
+}
+
+// Query returns the values of the aggregate for the series for the key between
+// from and to inclusive at the coarsest resolution adequate for the step.
+// It also returns the interval of the resolution, or zero when raw data points
+// are returned as they are.
+//
+// Data points not downsampled yet are aggregated from raw data points on the
+// fly, so the result covers the open block too.
+func (d *Downsampler) Query(key string, from, to uint32, step time.Duration, a Aggregate) (points []timeseries.Point, resolution time.Duration, err error) {
+	if a < 0 || a >= numAggregates {
+		return nil, 0, fmt.Errorf("invalid aggregate: %d", int(a))
+	}
+	resolution = d.Resolution(step)
+	if resolution == 0 {
+		points, err = d.src.Query(key, from, to)
+		return points, 0, err
+	}
+
+	interval := uint32(resolution / time.Second)
+	start := from - from%interval
+	buckets, done, err := d.rollupBuckets(key, resolution, start, to)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if done <= to {
+		raw, err := d.src.Query(key, done, to)
+		if err != nil && !(err == store.ErrSeriesNotFound && len(buckets) > 0) {
+			return nil, 0, err
+		}
+		for _, b := range Buckets(raw, interval) {
+			buckets = appendBucket(buckets, b)
+		}
+	}
+
+	for _, b := range buckets {
+		if start <= b.Timestamp && b.Timestamp <= to {
+			points = append(points, timeseries.Point{Timestamp: b.Timestamp, Value: b.Value(a)})
+		}
+	}
+	return points, resolution, nil
+}
+
+// rollupBuckets returns the downsampled buckets of the resolution between
+// start and to, and the timestamp after the last downsampled data point.
+func (d *Downsampler) rollupBuckets(key string, resolution time.Duration, start, to uint32) ([]Bucket, uint32, error) {
+	d.mu.Lock()
+	rs := d.series[key]
+	if rs == nil {
+		d.mu.Unlock()
+		return nil, 0, nil
+	}
+	var ls levelState
+	for i, lv := range d.levels {
+		if time.Duration(lv.interval)*time.Second == resolution {
+			ls = levelState{
+				sealed: append([]RollupBlock(nil), rs.levels[i].sealed...),
+				open:   append([]Bucket(nil), rs.levels[i].open...),
+			}
+		}
+	}
+	done := rs.done
+	d.mu.Unlock()
+
+	var buckets []Bucket
+	for _, rb := range ls.sealed {
+		if rb.T0 > to || rb.Last < start {
+			continue
+		}
+		bs, err := rb.Buckets()
+		if err != nil {
+			return nil, 0, err
+		}
+		buckets = append(buckets, bs...)
+	}
+	buckets = append(buckets, ls.open...)
+	return buckets, done, nil
+}
@@ -0,0 +1,77 @@
+package downsample
+
+import (
+	"fmt"
+
+	"github.com/hnakamur/timeseries"
+)
+
+// RollupBlock is a block of buckets with a column block for each aggregate.
+type RollupBlock struct {
+	// T0 is the block timestamp.
+	T0 uint32
+
+	// Last is the timestamp of the last bucket in the block.
+	Last uint32
+
+	// Columns is the encoded blocks of the aggregates indexed by Aggregate.
+	Columns [numAggregates]timeseries.Block
+}
+
+// NewRollupBlock encodes the buckets in timestamp order to a rollup block.
+func NewRollupBlock(t0 uint32, buckets []Bucket) (RollupBlock, error) {
+	rb := RollupBlock{T0: t0}
+	if len(buckets) > 0 {
+		rb.Last = buckets[len(buckets)-1].Timestamp
+	}
+	for a := Aggregate(0); a < numAggregates; a++ {
+		b, err := timeseries.NewBlock(t0, Points(buckets, a))
+		if err != nil {
+			return RollupBlock{}, fmt.Errorf("failed to encode rollup column: aggregate=%s, err=%+v", a, err)
+		}
+		rb.Columns[a] = b
+	}
+	return rb, nil
+}
+
+// Size returns the total size in bytes of the encoded columns.
+func (rb RollupBlock) Size() int {
+	n := 0
+	for _, b := range rb.Columns {
+		n += len(b.Data)
+	}
+	return n
+}
+
+// Points decodes the column of the aggregate.
+func (rb RollupBlock) Points(a Aggregate) ([]timeseries.Point, error) {
+	if a < 0 || a >= numAggregates {
+		return nil, fmt.Errorf("invalid aggregate: %d", int(a))
+	}
+	_, points, err := rb.Columns[a].Points()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode rollup column: aggregate=%s, err=%+v", a, err)
+	}
+	return points, nil
+}
+
+// Buckets decodes all columns to buckets.
+func (rb RollupBlock) Buckets() ([]Bucket, error) {
+	var buckets []Bucket
+	for a := Aggregate(0); a < numAggregates; a++ {
+		points, err := rb.Points(a)
+		if err != nil {
+			return nil, err
+		}
+		if a == 0 {
+			buckets = make([]Bucket, len(points))
+		} else if len(points) != len(buckets) {
+			return nil, fmt.Errorf("rollup column length mismatch: aggregate=%s, got=%d, want=%d", a, len(points), len(buckets))
+		}
+		for i, p := range points {
+			buckets[i].Timestamp = p.Timestamp
+			buckets[i].set(a, p.Value)
+		}
+	}
+	return buckets, nil
+}
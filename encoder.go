@@ -21,6 +21,9 @@ type Encoder struct {
 	storedLeadingZeros  uint8
 	storedTrailingZeros uint8
 	storedValueBits     uint64
+
+	withStats bool
+	stats     Stats
 }
 
 // NewEncoder creates a new encoder.
@@ -31,6 +34,16 @@ func NewEncoder(w io.Writer) *Encoder {
 	}
 }
 
+// Reset discards the state of the encoder and makes it write to w.
+// This permits reusing an Encoder rather than allocating a new one.
+func (e *Encoder) Reset(w io.Writer) {
+	e.wr.Reset(w)
+	*e = Encoder{
+		wr:                 e.wr,
+		storedLeadingZeros: math.MaxInt8,
+	}
+}
+
 // EncodeHeader encodes the block timestamp to the header bits.
 func (e *Encoder) EncodeHeader(t0 uint32) error {
 	err := e.wr.WriteBits(uint64(t0), 32)
@@ -43,6 +56,9 @@ func (e *Encoder) EncodeHeader(t0 uint32) error {
 
 // EncodePoint encodes a data point.
 func (e *Encoder) EncodePoint(p Point) error {
+	if e.withStats {
+		e.stats.Add(p)
+	}
 	if e.storedTimestamp == 0 {
 		return e.writeFirst(p)
 	}
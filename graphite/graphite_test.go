@@ -0,0 +1,299 @@
+package graphite_test
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/graphite"
+	"github.com/hnakamur/timeseries/store"
+)
+
+var now = time.Date(2015, 3, 24, 2, 10, 0, 0, time.UTC)
+
+func TestParseLine(t *testing.T) {
+	testCases := []struct {
+		line    string
+		want    graphite.Metric
+		wantErr bool
+	}{
+		{line: "servers.a.cpu 1.5 1427162400", want: graphite.Metric{Path: "servers.a.cpu", Point: timeseries.Point{Timestamp: 1427162400, Value: 1.5}}},
+		{line: "  servers.a.cpu\t-2  1427162400.75 ", want: graphite.Metric{Path: "servers.a.cpu", Point: timeseries.Point{Timestamp: 1427162400, Value: -2}}},
+		{line: "servers.a.cpu 3 -1", want: graphite.Metric{Path: "servers.a.cpu", Point: timeseries.Point{Timestamp: 1427163000, Value: 3}}},
+		{line: "servers.a.cpu 3", wantErr: true},
+		{line: "servers.a.cpu abc 1427162400", wantErr: true},
+		{line: "servers.a.cpu 1 abc", wantErr: true},
+		{line: "servers.a.cpu 1 1e10", wantErr: true},
+		{line: "servers.a.cpu 1 2 3", wantErr: true},
+	}
+	for _, tc := range testCases {
+		got, err := graphite.ParseLine(tc.line, now)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("line=%q, got no error, want error", tc.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("failed to parse line: line=%q, err=%+v", tc.line, err)
+		}
+		if got != tc.want {
+			t.Errorf("line=%q, got=%+v, want=%+v", tc.line, got, tc.want)
+		}
+	}
+}
+
+// The payloads were generated by pickle.dumps of Python 3 for
+// [('servers.a.cpu', (1427162400, 1.5)), ('servers.b.cpu', (1427162400.0, 2)),
+// ('servers.a.cpu', (1427162460, -3))].
+var pickleTestCases = []struct {
+	name    string
+	payload string
+}{
+	{name: "protocol 0", payload: "(lp0\n(Vservers.a.cpu\np1\n(I1427162400\nF1.5\ntp2\ntp3\na(Vservers.b.cpu\np4\n(F1427162400.0\nI2\ntp5\ntp6\na(g1\n(I1427162460\nI-3\ntp7\ntp8\na."},
+	{name: "protocol 1", payload: "]q\x00((X\x0d\x00\x00\x00servers.a.cpuq\x01(J \xc5\x10UG?\xf8\x00\x00\x00\x00\x00\x00tq\x02tq\x03(X\x0d\x00\x00\x00servers.b.cpuq\x04(GA\xd5D1H\x00\x00\x00K\x02tq\x05tq\x06(h\x01(J\x5c\xc5\x10UJ\xfd\xff\xff\xfftq\x07tq\x08e."},
+	{name: "protocol 2", payload: "\x80\x02]q\x00(X\x0d\x00\x00\x00servers.a.cpuq\x01J \xc5\x10UG?\xf8\x00\x00\x00\x00\x00\x00\x86q\x02\x86q\x03X\x0d\x00\x00\x00servers.b.cpuq\x04GA\xd5D1H\x00\x00\x00K\x02\x86q\x05\x86q\x06h\x01J\x5c\xc5\x10UJ\xfd\xff\xff\xff\x86q\x07\x86q\x08e."},
+	{name: "protocol 4", payload: "\x80\x04\x95V\x00\x00\x00\x00\x00\x00\x00]\x94(\x8c\x0dservers.a.cpu\x94J \xc5\x10UG?\xf8\x00\x00\x00\x00\x00\x00\x86\x94\x86\x94\x8c\x0dservers.b.cpu\x94GA\xd5D1H\x00\x00\x00K\x02\x86\x94\x86\x94h\x01J\x5c\xc5\x10UJ\xfd\xff\xff\xff\x86\x94\x86\x94e."},
+}
+
+var pickleWant = []graphite.Metric{
+	{Path: "servers.a.cpu", Point: timeseries.Point{Timestamp: 1427162400, Value: 1.5}},
+	{Path: "servers.b.cpu", Point: timeseries.Point{Timestamp: 1427162400, Value: 2}},
+	{Path: "servers.a.cpu", Point: timeseries.Point{Timestamp: 1427162460, Value: -3}},
+}
+
+func TestParsePickle(t *testing.T) {
+	for _, tc := range pickleTestCases {
+		got, err := graphite.ParsePickle([]byte(tc.payload), now)
+		if err != nil {
+			t.Fatalf("%s: failed to parse pickle: err=%+v", tc.name, err)
+		}
+		if !reflect.DeepEqual(got, pickleWant) {
+			t.Errorf("%s: got=%+v, want=%+v", tc.name, got, pickleWant)
+		}
+	}
+
+	// Long integers, negative integers and strings.
+	got, err := graphite.ParsePickle([]byte("\x80\x02]q\x00(X\x03\x00\x00\x00bigq\x01J \xc5\x10U\x8a\x06\xf2/\xces:\x0b\x86q\x02\x86q\x03X\x03\x00\x00\x00negq\x04J \xc5\x10UJ\x90\xee\xfe\xff\x86q\x05\x86q\x06e."), now)
+	if err != nil {
+		t.Fatalf("failed to parse pickle: err=%+v", err)
+	}
+	want := []graphite.Metric{
+		{Path: "big", Point: timeseries.Point{Timestamp: 1427162400, Value: 12345678901234}},
+		{Path: "neg", Point: timeseries.Point{Timestamp: 1427162400, Value: -70000}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got=%+v, want=%+v", got, want)
+	}
+	got, err = graphite.ParsePickle([]byte("\x80\x02]q\x00X\x03\x00\x00\x00strq\x01X\n\x00\x00\x001427162400q\x02X\x03\x00\x00\x002.5q\x03\x86q\x04\x86q\x05a."), now)
+	if err != nil {
+		t.Fatalf("failed to parse pickle: err=%+v", err)
+	}
+	want = []graphite.Metric{{Path: "str", Point: timeseries.Point{Timestamp: 1427162400, Value: 2.5}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got=%+v, want=%+v", got, want)
+	}
+}
+
+func TestParsePickleError(t *testing.T) {
+	testCases := []struct {
+		name    string
+		payload string
+	}{
+		{name: "global", payload: "cos\nsystem\n(S'echo'\ntR."},
+		{name: "no stop", payload: "\x80\x02]q\x00"},
+		{name: "not list", payload: "K\x01."},
+		{name: "bad item", payload: "]K\x01a."},
+		{name: "truncated string", payload: "]X\xff\x00\x00\x00abc"},
+		{name: "stack underflow", payload: "a."},
+		{name: "recursive list", payload: "]q\x00h\x00a."},
+		{name: "recursive tuple", payload: "]q\x00h\x00\x85a."},
+		{name: "deep nesting", payload: "]" + strings.Repeat("\x85", 100) + "."},
+		{name: "billion laughs", payload: billionLaughs()},
+	}
+	for _, tc := range testCases {
+		_, err := graphite.ParsePickle([]byte(tc.payload), now)
+		if err == nil {
+			t.Errorf("%s: got no error, want error", tc.name)
+		}
+	}
+}
+
+// billionLaughs returns a payload of nested tuples which reference the
+// memoized tuple of the previous level 8 times, which has 8^10 items in
+// total.
+func billionLaughs() string {
+	var b strings.Builder
+	b.WriteString("]q\x00")
+	for i := 0; i < 10; i++ {
+		b.WriteString("(")
+		for j := 0; j < 8; j++ {
+			b.WriteString("h" + string(rune(i)))
+		}
+		b.WriteString("tq" + string(rune(i+1)))
+	}
+	b.WriteString(".")
+	return b.String()
+}
+
+type recorder struct {
+	mu      sync.Mutex
+	metrics []graphite.Metric
+}
+
+func (r *recorder) Append(key string, p timeseries.Point) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, graphite.Metric{Path: key, Point: p})
+	return nil
+}
+
+func (r *recorder) get() []graphite.Metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]graphite.Metric(nil), r.metrics...)
+}
+
+// waitReceived waits until the server receives n metrics and reports m
+// parse errors.
+func waitReceived(t *testing.T, srv *graphite.Server, n, m int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		st := srv.Stats()
+		if st.Received >= n && st.ParseErrors >= m {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out, stats=%+v, want received=%d, parseErrors=%d", srv.Stats(), n, m)
+}
+
+func TestServePlaintext(t *testing.T) {
+	rec := &recorder{}
+	srv := graphite.NewServer(rec)
+	var errs []error
+	var mu sync.Mutex
+	srv.ErrorHandler = func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: err=%+v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- srv.ServePlaintext(ln) }()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: err=%+v", err)
+	}
+	_, err = conn.Write([]byte("servers.a.cpu 1 1427162400\nbad line\nservers.a.cpu 2 1427162460\n"))
+	if err != nil {
+		t.Fatalf("failed to write: err=%+v", err)
+	}
+	conn.Close()
+	waitReceived(t, srv, 2, 1)
+
+	srv.Close()
+	if err := <-done; err != graphite.ErrServerClosed {
+		t.Errorf("got=%v, want=%v", err, graphite.ErrServerClosed)
+	}
+
+	want := []graphite.Metric{
+		{Path: "servers.a.cpu", Point: timeseries.Point{Timestamp: 1427162400, Value: 1}},
+		{Path: "servers.a.cpu", Point: timeseries.Point{Timestamp: 1427162460, Value: 2}},
+	}
+	if got := rec.get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got=%+v, want=%+v", got, want)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(errs) != 1 {
+		t.Fatalf("got errors=%v, want one parse error", errs)
+	}
+	if _, ok := errs[0].(*graphite.ParseError); !ok {
+		t.Errorf("got error type %T, want *graphite.ParseError", errs[0])
+	}
+}
+
+func TestServeUDP(t *testing.T) {
+	s, err := store.New(store.Options{})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+	srv := graphite.NewServer(s)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: err=%+v", err)
+	}
+	go srv.ServeUDP(pc)
+	defer srv.Close()
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: err=%+v", err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("servers.a.cpu 1 1427162400\nservers.a.cpu 2 1427162460"))
+	if err != nil {
+		t.Fatalf("failed to write: err=%+v", err)
+	}
+	waitReceived(t, srv, 2, 0)
+
+	got, err := s.Query("servers.a.cpu", 0, 1427163000)
+	if err != nil {
+		t.Fatalf("failed to query: err=%+v", err)
+	}
+	want := []timeseries.Point{{Timestamp: 1427162400, Value: 1}, {Timestamp: 1427162460, Value: 2}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got=%+v, want=%+v", got, want)
+	}
+}
+
+func TestServePickle(t *testing.T) {
+	rec := &recorder{}
+	srv := graphite.NewServer(rec)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: err=%+v", err)
+	}
+	go srv.ServePickle(ln)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: err=%+v", err)
+	}
+	defer conn.Close()
+	for _, payload := range []string{pickleTestCases[2].payload, "not a pickle", pickleTestCases[3].payload} {
+		var header [4]byte
+		binary.BigEndian.PutUint32(header[:], uint32(len(payload)))
+		_, err = conn.Write(append(header[:], payload...))
+		if err != nil {
+			t.Fatalf("failed to write: err=%+v", err)
+		}
+	}
+	waitReceived(t, srv, 6, 1)
+
+	want := append(append([]graphite.Metric(nil), pickleWant...), pickleWant...)
+	if got := rec.get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got=%+v, want=%+v", got, want)
+	}
+}
+
+func FuzzParsePickle(f *testing.F) {
+	f.Add([]byte("\x80\x02]q\x00(X\x03\x00\x00\x00bigq\x01J \xc5\x10U\x8a\x06\xf2/\xces:\x0b\x86q\x02\x86q\x03e."))
+	f.Add([]byte("(lp0\n(S'a.b'\np1\n(I1427162400\nF1.5\ntp2\ntp3\na."))
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		graphite.ParsePickle(payload, now)
+	})
+}
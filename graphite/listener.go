@@ -0,0 +1,184 @@
+package graphite
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hnakamur/timeseries/internal/listener"
+	"github.com/hnakamur/timeseries/store"
+)
+
+// maxLineSize is the maximum size of a line of the plaintext protocol.
+const maxLineSize = 64 * 1024
+
+// Stats is statistics of a server.
+type Stats struct {
+	// Received is the number of received metrics.
+	Received int64
+	// ParseErrors is the number of lines or payloads which failed to parse.
+	ParseErrors int64
+	// AppendErrors is the number of metrics which failed to be appended.
+	AppendErrors int64
+}
+
+// Server receives metrics with the Graphite protocols and appends them to an
+// appender with the metric paths as the keys.
+type Server struct {
+	app store.Appender
+
+	// ErrorHandler is called for parse errors and append errors if not nil.
+	// It may be called concurrently.
+	ErrorHandler func(err error)
+
+	// Now returns the current time, which is used for negative timestamps.
+	Now func() time.Time
+
+	received     int64
+	parseErrors  int64
+	appendErrors int64
+
+	group *listener.Group
+}
+
+// ErrServerClosed is returned by the Serve methods after Close.
+var ErrServerClosed = errors.New("graphite: server closed")
+
+// NewServer creates a server which appends metrics to the appender.
+func NewServer(app store.Appender) *Server {
+	return &Server{
+		app:   app,
+		Now:   time.Now,
+		group: listener.NewGroup(ErrServerClosed),
+	}
+}
+
+// Stats returns the statistics of the server.
+func (s *Server) Stats() Stats {
+	return Stats{
+		Received:     atomic.LoadInt64(&s.received),
+		ParseErrors:  atomic.LoadInt64(&s.parseErrors),
+		AppendErrors: atomic.LoadInt64(&s.appendErrors),
+	}
+}
+
+// ServePlaintext accepts connections on the listener and reads lines of the
+// plaintext protocol from them. It blocks until the listener fails or the
+// server is closed.
+func (s *Server) ServePlaintext(ln net.Listener) error {
+	return s.group.Serve(ln, s.handlePlaintext)
+}
+
+// ServePickle accepts connections on the listener and reads payloads of the
+// pickle protocol from them. It blocks until the listener fails or the
+// server is closed.
+func (s *Server) ServePickle(ln net.Listener) error {
+	return s.group.Serve(ln, s.handlePickle)
+}
+
+// ServeUDP reads datagrams with lines of the plaintext protocol from the
+// connection. It blocks until the connection fails or the server is closed.
+func (s *Server) ServeUDP(conn net.PacketConn) error {
+	return s.group.ServePacket(conn, func(data []byte, addr net.Addr) {
+		for _, line := range strings.Split(string(data), "\n") {
+			s.handleLine(line, addr.String())
+		}
+	})
+}
+
+// Close closes the listeners and connections and waits for the connection
+// handlers to finish.
+func (s *Server) Close() error {
+	s.group.Close()
+	return nil
+}
+
+func (s *Server) handlePlaintext(conn net.Conn) {
+	addr := conn.RemoteAddr().String()
+	sc := bufio.NewScanner(conn)
+	sc.Buffer(make([]byte, 4096), maxLineSize)
+	for sc.Scan() {
+		s.handleLine(sc.Text(), addr)
+	}
+	if err := sc.Err(); err != nil && !s.group.IsClosed() {
+		s.parseError(&ParseError{Addr: addr, Input: "connection", Err: err})
+	}
+}
+
+func (s *Server) handleLine(line, addr string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	m, err := ParseLine(line, s.Now())
+	if err != nil {
+		s.parseError(&ParseError{Addr: addr, Input: line, Err: err})
+		return
+	}
+	s.append(m)
+}
+
+func (s *Server) handlePickle(conn net.Conn) {
+	addr := conn.RemoteAddr().String()
+	r := bufio.NewReader(conn)
+	var header [4]byte
+	for {
+		_, err := io.ReadFull(r, header[:])
+		if err != nil {
+			if err != io.EOF && !s.group.IsClosed() {
+				s.parseError(&ParseError{Addr: addr, Input: "pickle header", Err: err})
+			}
+			return
+		}
+		n := binary.BigEndian.Uint32(header[:])
+		if n > maxPickleSize {
+			s.parseError(&ParseError{Addr: addr, Input: "pickle header",
+				Err: fmt.Errorf("payload too large: size=%d, max=%d", n, maxPickleSize)})
+			return
+		}
+		payload := make([]byte, n)
+		_, err = io.ReadFull(r, payload)
+		if err != nil {
+			if !s.group.IsClosed() {
+				s.parseError(&ParseError{Addr: addr, Input: "pickle payload", Err: err})
+			}
+			return
+		}
+
+		metrics, err := ParsePickle(payload, s.Now())
+		if err != nil {
+			// The stream is still in sync thanks to the length header.
+			s.parseError(&ParseError{Addr: addr, Input: "pickle payload", Err: err})
+			continue
+		}
+		for _, m := range metrics {
+			s.append(m)
+		}
+	}
+}
+
+func (s *Server) append(m Metric) {
+	atomic.AddInt64(&s.received, 1)
+	err := s.app.Append(m.Path, m.Point)
+	if err != nil {
+		atomic.AddInt64(&s.appendErrors, 1)
+		s.handleError(fmt.Errorf("failed to append metric: path=%s, point=%+v, err=%+v", m.Path, m.Point, err))
+	}
+}
+
+func (s *Server) parseError(err error) {
+	atomic.AddInt64(&s.parseErrors, 1)
+	s.handleError(err)
+}
+
+func (s *Server) handleError(err error) {
+	if s.ErrorHandler != nil {
+		s.ErrorHandler(err)
+	}
+}
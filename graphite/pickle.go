@@ -0,0 +1,429 @@
+package graphite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPickleSize is the maximum size of a pickle payload.
+const maxPickleSize = 16 << 20
+
+// ParsePickle parses a payload of the pickle protocol, which is a pickled
+// list of (path, (timestamp, value)) tuples. The 4-byte length header is not
+// included in the payload. Only the opcodes for lists, tuples, numbers and
+// strings are supported, so that no code is executed.
+func ParsePickle(payload []byte, now time.Time) ([]Metric, error) {
+	v, err := unpickle(payload)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected list, got %T", v)
+	}
+
+	metrics := make([]Metric, 0, len(list))
+	for _, item := range list {
+		m, err := pickledMetric(item, now)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
+
+func pickledMetric(item interface{}, now time.Time) (Metric, error) {
+	tuple, ok := item.([]interface{})
+	if !ok || len(tuple) != 2 {
+		return Metric{}, errors.New("expected (path, (timestamp, value)) tuple")
+	}
+	path, ok := tuple[0].(string)
+	if !ok {
+		return Metric{}, fmt.Errorf("expected string path, got %T", tuple[0])
+	}
+	datapoint, ok := tuple[1].([]interface{})
+	if !ok || len(datapoint) != 2 {
+		return Metric{}, fmt.Errorf("expected (timestamp, value) tuple for %s", path)
+	}
+	ts, err := pickledNumber(datapoint[0])
+	if err != nil {
+		return Metric{}, fmt.Errorf("invalid timestamp for %s: %v", path, err)
+	}
+	v, err := pickledNumber(datapoint[1])
+	if err != nil {
+		return Metric{}, fmt.Errorf("invalid value for %s: %v", path, err)
+	}
+	return newMetric(path, ts, v, now)
+}
+
+func pickledNumber(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0, fmt.Errorf("expected number, got %T", v)
+}
+
+type mark struct{}
+
+// list is a list being built. It is a pointer so that a memoized list sees
+// the items appended later.
+type list struct {
+	items []interface{}
+}
+
+const (
+	// maxPickleDepth is the maximum nesting depth of lists and tuples.
+	maxPickleDepth = 16
+	// maxPickleItems is the maximum total number of items in lists and
+	// tuples. Memoized objects are counted each time they are referenced.
+	maxPickleItems = 4 << 20
+)
+
+// resolver converts lists into []interface{} recursively. It rejects
+// cycles, deep nesting and too many items, which a payload can create by
+// referencing memoized lists.
+type resolver struct {
+	active map[*list]bool
+	items  int
+}
+
+func (r *resolver) resolve(v interface{}, depth int) (interface{}, error) {
+	var src []interface{}
+	switch v := v.(type) {
+	case *list:
+		if r.active[v] {
+			return nil, errors.New("pickle: recursive list")
+		}
+		r.active[v] = true
+		defer delete(r.active, v)
+		src = v.items
+	case []interface{}:
+		src = v
+	default:
+		return v, nil
+	}
+	if depth >= maxPickleDepth {
+		return nil, errors.New("pickle: nesting too deep")
+	}
+	r.items += len(src)
+	if r.items > maxPickleItems {
+		return nil, errors.New("pickle: too many items")
+	}
+	items := make([]interface{}, len(src))
+	for i, item := range src {
+		var err error
+		items[i], err = r.resolve(item, depth+1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// unpickle decodes a pickle of protocols 0 to 4 into nil, bool, int64,
+// float64, string and []interface{} for lists and tuples.
+func unpickle(data []byte) (interface{}, error) {
+	var (
+		stack []interface{}
+		memo  = make(map[int]interface{})
+		r     = bytes.NewReader(data)
+	)
+	pop := func() (interface{}, error) {
+		if len(stack) == 0 {
+			return nil, errors.New("pickle: stack underflow")
+		}
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v, nil
+	}
+	popMark := func() ([]interface{}, error) {
+		for i := len(stack) - 1; i >= 0; i-- {
+			if _, ok := stack[i].(mark); ok {
+				items := append([]interface{}{}, stack[i+1:]...)
+				stack = stack[:i]
+				return items, nil
+			}
+		}
+		return nil, errors.New("pickle: mark not found")
+	}
+	read := func(n int) ([]byte, error) {
+		if n < 0 || n > r.Len() {
+			return nil, errors.New("pickle: unexpected end of data")
+		}
+		b := make([]byte, n)
+		r.Read(b)
+		return b, nil
+	}
+	readLine := func() (string, error) {
+		var b []byte
+		for {
+			c, err := r.ReadByte()
+			if err != nil {
+				return "", errors.New("pickle: unexpected end of data")
+			}
+			if c == '\n' {
+				return string(b), nil
+			}
+			b = append(b, c)
+		}
+	}
+	readUint := func(n int) (uint64, error) {
+		b, err := read(n)
+		if err != nil {
+			return 0, err
+		}
+		var v uint64
+		for i := n - 1; i >= 0; i-- {
+			v = v<<8 | uint64(b[i])
+		}
+		return v, nil
+	}
+	appendTo := func(items ...interface{}) error {
+		if len(stack) == 0 {
+			return errors.New("pickle: stack underflow")
+		}
+		l, ok := stack[len(stack)-1].(*list)
+		if !ok {
+			return errors.New("pickle: append to non-list")
+		}
+		l.items = append(l.items, items...)
+		return nil
+	}
+
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return nil, errors.New("pickle: missing STOP opcode")
+		}
+		switch op {
+		case 0x80: // PROTO
+			_, err = read(1)
+		case 0x95: // FRAME
+			_, err = read(8)
+		case '.': // STOP
+			v, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			r := resolver{active: make(map[*list]bool)}
+			return r.resolve(v, 0)
+		case '(': // MARK
+			stack = append(stack, mark{})
+		case ']': // EMPTY_LIST
+			stack = append(stack, &list{})
+		case ')': // EMPTY_TUPLE
+			stack = append(stack, []interface{}{})
+		case 'l': // LIST
+			var items []interface{}
+			items, err = popMark()
+			stack = append(stack, &list{items: items})
+		case 't': // TUPLE
+			var items []interface{}
+			items, err = popMark()
+			stack = append(stack, items)
+		case 0x85, 0x86, 0x87: // TUPLE1, TUPLE2, TUPLE3
+			n := int(op - 0x84)
+			if len(stack) < n {
+				return nil, errors.New("pickle: stack underflow")
+			}
+			items := append([]interface{}{}, stack[len(stack)-n:]...)
+			stack = append(stack[:len(stack)-n], items)
+		case 'a': // APPEND
+			var v interface{}
+			v, err = pop()
+			if err == nil {
+				err = appendTo(v)
+			}
+		case 'e': // APPENDS
+			var items []interface{}
+			items, err = popMark()
+			if err == nil {
+				err = appendTo(items...)
+			}
+		case 'N': // NONE
+			stack = append(stack, nil)
+		case 0x88, 0x89: // NEWTRUE, NEWFALSE
+			stack = append(stack, op == 0x88)
+		case 'I', 'L': // INT, LONG
+			var line string
+			line, err = readLine()
+			if err != nil {
+				break
+			}
+			switch line {
+			case "00":
+				stack = append(stack, false)
+			case "01":
+				stack = append(stack, true)
+			default:
+				var v int64
+				v, err = strconv.ParseInt(strings.TrimSuffix(line, "L"), 10, 64)
+				stack = append(stack, v)
+			}
+		case 'J': // BININT
+			var v uint64
+			v, err = readUint(4)
+			stack = append(stack, int64(int32(v)))
+		case 'K': // BININT1
+			var v uint64
+			v, err = readUint(1)
+			stack = append(stack, int64(v))
+		case 'M': // BININT2
+			var v uint64
+			v, err = readUint(2)
+			stack = append(stack, int64(v))
+		case 0x8a: // LONG1
+			var n uint64
+			n, err = readUint(1)
+			if err != nil {
+				break
+			}
+			if n > 8 {
+				return nil, errors.New("pickle: long integer too large")
+			}
+			var v uint64
+			v, err = readUint(int(n))
+			if n > 0 && n < 8 && v&(1<<(8*n-1)) != 0 {
+				// Sign-extend a negative number.
+				v |= math.MaxUint64 << (8 * n)
+			}
+			stack = append(stack, int64(v))
+		case 'F': // FLOAT
+			var line string
+			line, err = readLine()
+			if err != nil {
+				break
+			}
+			var v float64
+			v, err = strconv.ParseFloat(line, 64)
+			stack = append(stack, v)
+		case 'G': // BINFLOAT
+			var b []byte
+			b, err = read(8)
+			if err != nil {
+				break
+			}
+			stack = append(stack, math.Float64frombits(binary.BigEndian.Uint64(b)))
+		case 'S': // STRING
+			var line string
+			line, err = readLine()
+			if err != nil {
+				break
+			}
+			var v string
+			v, err = unquotePython(line)
+			stack = append(stack, v)
+		case 'V': // UNICODE
+			var line string
+			line, err = readLine()
+			stack = append(stack, line)
+		case 'T', 'X', 'B': // BINSTRING, BINUNICODE, BINBYTES
+			var n uint64
+			n, err = readUint(4)
+			if err != nil {
+				break
+			}
+			var b []byte
+			b, err = read(int(n))
+			stack = append(stack, string(b))
+		case 'U', 0x8c, 'C': // SHORT_BINSTRING, SHORT_BINUNICODE, SHORT_BINBYTES
+			var n uint64
+			n, err = readUint(1)
+			if err != nil {
+				break
+			}
+			var b []byte
+			b, err = read(int(n))
+			stack = append(stack, string(b))
+		case 'p': // PUT
+			var line string
+			line, err = readLine()
+			if err != nil {
+				break
+			}
+			var i int
+			i, err = strconv.Atoi(line)
+			if err == nil && len(stack) > 0 {
+				memo[i] = stack[len(stack)-1]
+			}
+		case 'q', 'r': // BINPUT, LONG_BINPUT
+			n := 1
+			if op == 'r' {
+				n = 4
+			}
+			var i uint64
+			i, err = readUint(n)
+			if err == nil && len(stack) > 0 {
+				memo[int(i)] = stack[len(stack)-1]
+			}
+		case 0x94: // MEMOIZE
+			if len(stack) > 0 {
+				memo[len(memo)] = stack[len(stack)-1]
+			}
+		case 'g': // GET
+			var line string
+			line, err = readLine()
+			if err != nil {
+				break
+			}
+			var i int
+			i, err = strconv.Atoi(line)
+			if err == nil {
+				err = pushMemo(&stack, memo, i)
+			}
+		case 'h', 'j': // BINGET, LONG_BINGET
+			n := 1
+			if op == 'j' {
+				n = 4
+			}
+			var i uint64
+			i, err = readUint(n)
+			if err == nil {
+				err = pushMemo(&stack, memo, int(i))
+			}
+		default:
+			return nil, fmt.Errorf("pickle: unsupported opcode 0x%02x", op)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func pushMemo(stack *[]interface{}, memo map[int]interface{}, i int) error {
+	v, ok := memo[i]
+	if !ok {
+		return fmt.Errorf("pickle: memo %d not found", i)
+	}
+	*stack = append(*stack, v)
+	return nil
+}
+
+// unquotePython unquotes a string literal of Python with single or double
+// quotes.
+func unquotePython(s string) (string, error) {
+	if len(s) < 2 || s[0] != s[len(s)-1] || (s[0] != '\'' && s[0] != '"') {
+		return "", fmt.Errorf("pickle: invalid string %q", s)
+	}
+	if s[0] == '\'' {
+		s = `"` + strings.Replace(strings.Replace(s[1:len(s)-1], `\'`, `'`, -1), `"`, `\"`, -1) + `"`
+	}
+	return strconv.Unquote(s)
+}
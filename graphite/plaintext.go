@@ -0,0 +1,77 @@
+// Package graphite implements listeners for the Graphite plaintext and
+// pickle protocols which append received data points to an appender.
+package graphite
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+)
+
+// Metric is a data point of a metric path.
+type Metric struct {
+	Path  string
+	Point timeseries.Point
+}
+
+// ParseError is an error of parsing a line or a pickle payload.
+type ParseError struct {
+	// Addr is the remote address which sent the input.
+	Addr string
+	// Input is the line or a description of the payload.
+	Input string
+	Err   error
+}
+
+func (e *ParseError) Error() string {
+	if e.Addr == "" {
+		return fmt.Sprintf("failed to parse %q: %v", e.Input, e.Err)
+	}
+	return fmt.Sprintf("failed to parse %q from %s: %v", e.Input, e.Addr, e.Err)
+}
+
+// ParseLine parses a line of the plaintext protocol:
+//
+//	metric.path value timestamp
+//
+// The timestamp is in seconds and may be fractional. A negative timestamp
+// means now.
+func ParseLine(line string, now time.Time) (Metric, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return Metric{}, fmt.Errorf("expected 3 fields, got %d", len(fields))
+	}
+	v, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return Metric{}, fmt.Errorf("invalid value %q", fields[1])
+	}
+	ts, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return Metric{}, fmt.Errorf("invalid timestamp %q", fields[2])
+	}
+	return newMetric(fields[0], ts, v, now)
+}
+
+func newMetric(path string, ts, v float64, now time.Time) (Metric, error) {
+	if path == "" {
+		return Metric{}, fmt.Errorf("empty metric path")
+	}
+	var t uint32
+	if ts < 0 {
+		var err error
+		t, err = timeseries.Timestamp(now)
+		if err != nil {
+			return Metric{}, err
+		}
+	} else {
+		if math.IsNaN(ts) || ts > math.MaxUint32 {
+			return Metric{}, fmt.Errorf("timestamp out of range: %v", ts)
+		}
+		t = uint32(ts)
+	}
+	return Metric{Path: path, Point: timeseries.Point{Timestamp: t, Value: v}}, nil
+}
@@ -0,0 +1,144 @@
+// Package listener implements the accept loops of the receivers and tracks
+// their listeners and connections so that a server can close all of them.
+package listener
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Group is a group of listeners and connections of a server.
+type Group struct {
+	errClosed error
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[io.Closer]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// NewGroup creates a group. The serve methods return errClosed after Close.
+func NewGroup(errClosed error) *Group {
+	return &Group{
+		errClosed: errClosed,
+		listeners: make(map[io.Closer]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// Serve accepts connections on the listener and calls handle for each of
+// them in a new goroutine. The connection is closed after handle returns.
+// It blocks until the listener fails or the group is closed.
+func (g *Group) Serve(ln net.Listener, handle func(conn net.Conn)) error {
+	if !g.track(ln) {
+		ln.Close()
+		return g.errClosed
+	}
+	defer g.untrack(ln)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if g.IsClosed() {
+				return g.errClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		g.mu.Lock()
+		if g.closed {
+			g.mu.Unlock()
+			conn.Close()
+			return g.errClosed
+		}
+		g.conns[conn] = struct{}{}
+		g.wg.Add(1)
+		g.mu.Unlock()
+
+		go func() {
+			defer func() {
+				g.mu.Lock()
+				delete(g.conns, conn)
+				g.mu.Unlock()
+				conn.Close()
+				g.wg.Done()
+			}()
+			handle(conn)
+		}()
+	}
+}
+
+// ServePacket reads datagrams from the connection and calls handle for each
+// of them. The data is valid only until handle returns. It blocks until the
+// connection fails or the group is closed.
+func (g *Group) ServePacket(conn net.PacketConn, handle func(data []byte, addr net.Addr)) error {
+	if !g.track(conn) {
+		conn.Close()
+		return g.errClosed
+	}
+	defer g.untrack(conn)
+
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if g.IsClosed() {
+				return g.errClosed
+			}
+			return err
+		}
+		handle(buf[:n], addr)
+	}
+}
+
+// Close closes the listeners and connections and waits for the connection
+// handlers to finish. It reports whether the group was closed by this call.
+func (g *Group) Close() bool {
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return false
+	}
+	g.closed = true
+	for l := range g.listeners {
+		l.Close()
+	}
+	for c := range g.conns {
+		c.Close()
+	}
+	g.mu.Unlock()
+	g.wg.Wait()
+	return true
+}
+
+// IsClosed reports whether the group is closed.
+func (g *Group) IsClosed() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.closed
+}
+
+func (g *Group) track(l io.Closer) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return false
+	}
+	g.listeners[l] = struct{}{}
+	return true
+}
+
+func (g *Group) untrack(l io.Closer) {
+	g.mu.Lock()
+	delete(g.listeners, l)
+	g.mu.Unlock()
+}
@@ -0,0 +1,93 @@
+package listener_test
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/timeseries/internal/listener"
+)
+
+var errClosed = errors.New("test: closed")
+
+func TestServe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: err=%+v", err)
+	}
+	g := listener.NewGroup(errClosed)
+	lines := make(chan string, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- g.Serve(ln, func(conn net.Conn) {
+			sc := bufio.NewScanner(conn)
+			for sc.Scan() {
+				lines <- sc.Text()
+			}
+		})
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: err=%+v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("hello\n"))
+	if got := <-lines; got != "hello" {
+		t.Errorf("line mismatch, got=%q, want=%q", got, "hello")
+	}
+
+	if !g.Close() {
+		t.Errorf("got false for first close, want true")
+	}
+	if g.Close() {
+		t.Errorf("got true for second close, want false")
+	}
+	if err := <-served; err != errClosed {
+		t.Errorf("serve error mismatch, got=%v, want=%v", err, errClosed)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("got nil error for read from closed connection")
+	}
+
+	ln2, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: err=%+v", err)
+	}
+	if err := g.Serve(ln2, func(net.Conn) {}); err != errClosed {
+		t.Errorf("serve error after close mismatch, got=%v, want=%v", err, errClosed)
+	}
+}
+
+func TestServePacket(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: err=%+v", err)
+	}
+	g := listener.NewGroup(errClosed)
+	datagrams := make(chan string, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- g.ServePacket(pc, func(data []byte, addr net.Addr) {
+			datagrams <- string(data)
+		})
+	}()
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: err=%+v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("a\nb"))
+	if got := <-datagrams; got != "a\nb" {
+		t.Errorf("datagram mismatch, got=%q, want=%q", got, "a\nb")
+	}
+
+	g.Close()
+	if err := <-served; err != errClosed {
+		t.Errorf("serve error mismatch, got=%v, want=%v", err, errClosed)
+	}
+}
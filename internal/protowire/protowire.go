@@ -0,0 +1,232 @@
+// Package protowire implements the protocol buffers wire format which is
+// sufficient for hand-written messages of the protocols this module speaks.
+// See https://protobuf.dev/programming-guides/encoding/ for the format.
+//
+// The messages are hand-written rather than generated, since generated code
+// needs protoc and the generated packages of the protocols pull in gRPC,
+// while only a few fields of the messages are used.
+package protowire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Type is a wire type.
+type Type int
+
+const (
+	// VarintType is the wire type of int32, int64, uint32, uint64, sint32,
+	// sint64, bool and enum.
+	VarintType Type = 0
+	// Fixed64Type is the wire type of fixed64, sfixed64 and double.
+	Fixed64Type Type = 1
+	// BytesType is the wire type of string, bytes, embedded messages and
+	// packed repeated fields.
+	BytesType Type = 2
+	// Fixed32Type is the wire type of fixed32, sfixed32 and float.
+	Fixed32Type Type = 5
+)
+
+// ErrTruncated is returned when the input ends in the middle of a field.
+var ErrTruncated = errors.New("protowire: truncated input")
+
+// AppendTag appends the tag of the field.
+func AppendTag(b []byte, num int, typ Type) []byte {
+	return AppendVarint(b, uint64(num)<<3|uint64(typ))
+}
+
+// AppendVarint appends a varint.
+func AppendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// AppendFixed64 appends a little-endian 64-bit value.
+func AppendFixed64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// AppendBytes appends a length-delimited value.
+func AppendBytes(b []byte, v []byte) []byte {
+	b = AppendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// AppendString appends a length-delimited string.
+func AppendString(b []byte, v string) []byte {
+	b = AppendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// AppendDouble appends the field of a double value. Like proto3, zero values
+// are omitted.
+func AppendDouble(b []byte, num int, v float64) []byte {
+	bits := math.Float64bits(v)
+	if bits == 0 {
+		return b
+	}
+	b = AppendTag(b, num, Fixed64Type)
+	return AppendFixed64(b, bits)
+}
+
+// AppendInt64 appends the field of an int64 value. Like proto3, zero values
+// are omitted.
+func AppendInt64(b []byte, num int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = AppendTag(b, num, VarintType)
+	return AppendVarint(b, uint64(v))
+}
+
+// AppendStringField appends the field of a string value. Like proto3, empty
+// strings are omitted.
+func AppendStringField(b []byte, num int, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = AppendTag(b, num, BytesType)
+	return AppendString(b, v)
+}
+
+// AppendMessage appends the field of an embedded message encoded by f.
+func AppendMessage(b []byte, num int, f func(b []byte) []byte) []byte {
+	b = AppendTag(b, num, BytesType)
+	// Reserve a byte for the length and move the message if it needs more.
+	start := len(b)
+	b = append(b, 0)
+	b = f(b)
+	n := len(b) - start - 1
+	if n < 0x80 {
+		b[start] = byte(n)
+		return b
+	}
+	var buf [binary.MaxVarintLen64]byte
+	m := binary.PutUvarint(buf[:], uint64(n))
+	b = append(b, buf[:m-1]...)
+	copy(b[start+m:], b[start+1:start+1+n])
+	copy(b[start:], buf[:m])
+	return b
+}
+
+// Reader reads fields of a message.
+type Reader struct {
+	b []byte
+}
+
+// NewReader creates a reader for the encoded message.
+func NewReader(b []byte) *Reader {
+	return &Reader{b: b}
+}
+
+// Done returns whether all fields have been read.
+func (r *Reader) Done() bool {
+	return len(r.b) == 0
+}
+
+// Next reads the tag of the next field.
+func (r *Reader) Next() (num int, typ Type, err error) {
+	v, err := r.Varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	num = int(v >> 3)
+	typ = Type(v & 7)
+	if num <= 0 {
+		return 0, 0, fmt.Errorf("protowire: invalid field number %d", num)
+	}
+	return num, typ, nil
+}
+
+// Varint reads a varint.
+func (r *Reader) Varint() (uint64, error) {
+	v, n := binary.Uvarint(r.b)
+	if n == 0 {
+		return 0, ErrTruncated
+	} else if n < 0 {
+		return 0, errors.New("protowire: varint overflow")
+	}
+	r.b = r.b[n:]
+	return v, nil
+}
+
+// Fixed64 reads a little-endian 64-bit value.
+func (r *Reader) Fixed64() (uint64, error) {
+	if len(r.b) < 8 {
+		return 0, ErrTruncated
+	}
+	v := binary.LittleEndian.Uint64(r.b)
+	r.b = r.b[8:]
+	return v, nil
+}
+
+// Fixed32 reads a little-endian 32-bit value.
+func (r *Reader) Fixed32() (uint32, error) {
+	if len(r.b) < 4 {
+		return 0, ErrTruncated
+	}
+	v := binary.LittleEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v, nil
+}
+
+// Double reads a double value.
+func (r *Reader) Double() (float64, error) {
+	v, err := r.Fixed64()
+	return math.Float64frombits(v), err
+}
+
+// Bytes reads a length-delimited value. The returned slice refers to the
+// input.
+func (r *Reader) Bytes() ([]byte, error) {
+	n, err := r.Varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.b)) {
+		return nil, ErrTruncated
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v, nil
+}
+
+// String reads a length-delimited string.
+func (r *Reader) String() (string, error) {
+	v, err := r.Bytes()
+	return string(v), err
+}
+
+// Skip skips the value of the field of the wire type.
+func (r *Reader) Skip(typ Type) error {
+	var err error
+	switch typ {
+	case VarintType:
+		_, err = r.Varint()
+	case Fixed64Type:
+		_, err = r.Fixed64()
+	case BytesType:
+		_, err = r.Bytes()
+	case Fixed32Type:
+		_, err = r.Fixed32()
+	default:
+		err = fmt.Errorf("protowire: unsupported wire type %d", typ)
+	}
+	return err
+}
+
+// CheckType returns an error if the wire type of the field is not want.
+func CheckType(num int, typ, want Type) error {
+	if typ != want {
+		return fmt.Errorf("protowire: field %d has wire type %d, want %d", num, typ, want)
+	}
+	return nil
+}
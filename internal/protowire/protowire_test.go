@@ -0,0 +1,116 @@
+package protowire_test
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/hnakamur/timeseries/internal/protowire"
+)
+
+func TestAppendMessage(t *testing.T) {
+	for _, n := range []int{0, 1, 127, 128, 16383, 16384, 100000} {
+		payload := bytes.Repeat([]byte{'x'}, n)
+		got := protowire.AppendMessage([]byte{0xff}, 3, func(b []byte) []byte {
+			return append(b, payload...)
+		})
+		want := protowire.AppendBytes(protowire.AppendTag([]byte{0xff}, 3, protowire.BytesType), payload)
+		if !bytes.Equal(got, want) {
+			t.Errorf("n=%d, encoded message mismatch", n)
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+	var b []byte
+	b = protowire.AppendDouble(b, 1, 1.5)
+	b = protowire.AppendInt64(b, 2, -1)
+	b = protowire.AppendStringField(b, 3, "foo")
+	b = protowire.AppendTag(b, 4, protowire.Fixed32Type)
+	b = append(b, 1, 2, 3, 4)
+	b = protowire.AppendMessage(b, 5, func(b []byte) []byte {
+		return protowire.AppendInt64(b, 1, 42)
+	})
+	b = protowire.AppendDouble(b, 6, 0)
+
+	r := protowire.NewReader(b)
+	var (
+		d      float64
+		i      int64
+		s      string
+		nested uint64
+	)
+	for !r.Done() {
+		num, typ, err := r.Next()
+		if err != nil {
+			t.Fatalf("failed to read tag: err=%+v", err)
+		}
+		switch num {
+		case 1:
+			d, err = r.Double()
+		case 2:
+			var v uint64
+			v, err = r.Varint()
+			i = int64(v)
+		case 3:
+			s, err = r.String()
+		case 5:
+			var m []byte
+			m, err = r.Bytes()
+			if err == nil {
+				mr := protowire.NewReader(m)
+				_, _, err = mr.Next()
+				if err == nil {
+					nested, err = mr.Varint()
+				}
+			}
+		default:
+			err = r.Skip(typ)
+		}
+		if err != nil {
+			t.Fatalf("failed to read field %d: err=%+v", num, err)
+		}
+	}
+	if d != 1.5 || i != -1 || s != "foo" || nested != 42 {
+		t.Errorf("got d=%v, i=%d, s=%q, nested=%d, want 1.5, -1, foo, 42", d, i, s, nested)
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+	first := protowire.AppendDouble(nil, 1, math.Pi)
+	full := protowire.AppendStringField(first, 2, "foo")
+	for n := 1; n < len(full); n++ {
+		if n == len(first) {
+			// A valid message with only the first field.
+			continue
+		}
+		r := protowire.NewReader(full[:n])
+		var err error
+		for !r.Done() && err == nil {
+			var typ protowire.Type
+			_, typ, err = r.Next()
+			if err == nil {
+				err = r.Skip(typ)
+			}
+		}
+		if err == nil {
+			t.Errorf("n=%d, got no error, want error", n)
+		}
+	}
+}
+
+func FuzzReader(f *testing.F) {
+	f.Add(protowire.AppendStringField(protowire.AppendDouble(nil, 1, math.Pi), 2, "foo"))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		r := protowire.NewReader(b)
+		for !r.Done() {
+			_, typ, err := r.Next()
+			if err == nil {
+				err = r.Skip(typ)
+			}
+			if err != nil {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,236 @@
+// Package snappy implements encoding and decoding of the snappy block format
+// used by the Prometheus remote read and write protocols. See
+// https://github.com/google/snappy/blob/main/format_description.txt for the
+// format.
+//
+// It is implemented here rather than imported, like the formats of the
+// columnar package, so that the module builds without dependencies.
+package snappy
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrCorrupt is returned when the input is not in the snappy block format.
+var ErrCorrupt = errors.New("snappy: corrupt input")
+
+// ErrTooLarge is returned when the decoded length exceeds the limit.
+var ErrTooLarge = errors.New("snappy: decoded block is too large")
+
+const (
+	tagLiteral = 0x00
+	tagCopy1   = 0x01
+	tagCopy2   = 0x02
+	tagCopy4   = 0x03
+)
+
+// DecodedLen returns the length of the decoded block.
+func DecodedLen(src []byte) (int, error) {
+	n, _, err := decodedLen(src)
+	return n, err
+}
+
+func decodedLen(src []byte) (n, headerLen int, err error) {
+	v, headerLen := binary.Uvarint(src)
+	if headerLen <= 0 || v > 0xffffffff {
+		return 0, 0, ErrCorrupt
+	}
+	if uint64(int(v)) != v {
+		return 0, 0, ErrTooLarge
+	}
+	return int(v), headerLen, nil
+}
+
+// Decode returns the decoded form of src. The returned slice may be a
+// sub-slice of dst if dst is large enough. maxLen limits the decoded length
+// to protect against malicious input; zero means no limit.
+func Decode(dst, src []byte, maxLen int) ([]byte, error) {
+	n, d, err := decodedLen(src)
+	if err != nil {
+		return nil, err
+	}
+	if maxLen > 0 && n > maxLen {
+		return nil, ErrTooLarge
+	}
+	if cap(dst) < n {
+		dst = make([]byte, n)
+	}
+	dst = dst[:n]
+
+	s := d
+	o := 0
+	for s < len(src) {
+		tag := src[s]
+		var length, offset int
+		switch tag & 0x03 {
+		case tagLiteral:
+			x := int(tag >> 2)
+			s++
+			if x >= 60 {
+				b := x - 59
+				if s+b > len(src) {
+					return nil, ErrCorrupt
+				}
+				x = 0
+				for i := b - 1; i >= 0; i-- {
+					x = x<<8 | int(src[s+i])
+				}
+				s += b
+			}
+			length = x + 1
+			if length <= 0 || length > len(src)-s || length > len(dst)-o {
+				return nil, ErrCorrupt
+			}
+			copy(dst[o:], src[s:s+length])
+			o += length
+			s += length
+			continue
+		case tagCopy1:
+			if s+2 > len(src) {
+				return nil, ErrCorrupt
+			}
+			length = 4 + int(tag>>2)&0x7
+			offset = int(tag&0xe0)<<3 | int(src[s+1])
+			s += 2
+		case tagCopy2:
+			if s+3 > len(src) {
+				return nil, ErrCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[s+1:]))
+			s += 3
+		case tagCopy4:
+			if s+5 > len(src) {
+				return nil, ErrCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[s+1:]))
+			s += 5
+		}
+		if offset <= 0 || offset > o || length > len(dst)-o {
+			return nil, ErrCorrupt
+		}
+		// The source and destination may overlap, so copy byte by byte.
+		for end := o + length; o < end; o++ {
+			dst[o] = dst[o-offset]
+		}
+	}
+	if o != n {
+		return nil, ErrCorrupt
+	}
+	return dst, nil
+}
+
+const (
+	// maxBlockSize is the size of the chunks of the input which are encoded
+	// independently so that copy offsets fit in two bytes.
+	maxBlockSize = 65536
+
+	tableBits = 14
+	tableSize = 1 << tableBits
+
+	// minMatch is the minimum length of a match to be encoded as a copy.
+	minMatch = 4
+)
+
+// MaxEncodedLen returns the maximum length of the encoded block of srcLen
+// bytes.
+func MaxEncodedLen(srcLen int) int {
+	return 32 + srcLen + srcLen/6
+}
+
+// Encode returns the encoded form of src. The returned slice may be a
+// sub-slice of dst if dst is large enough.
+func Encode(dst, src []byte) []byte {
+	if n := MaxEncodedLen(len(src)); cap(dst) < n {
+		dst = make([]byte, 0, n)
+	}
+	dst = dst[:0]
+	dst = appendUvarint(dst, uint64(len(src)))
+
+	var table [tableSize]int32
+	for len(src) > 0 {
+		p := src
+		if len(p) > maxBlockSize {
+			p = p[:maxBlockSize]
+		}
+		src = src[len(p):]
+		dst = encodeBlock(dst, p, &table)
+	}
+	return dst
+}
+
+func encodeBlock(dst, src []byte, table *[tableSize]int32) []byte {
+	for i := range table {
+		table[i] = -1
+	}
+
+	lit := 0
+	s := 0
+	for s+minMatch <= len(src) {
+		h := hash(binary.LittleEndian.Uint32(src[s:]))
+		candidate := int(table[h])
+		table[h] = int32(s)
+		if candidate < 0 || binary.LittleEndian.Uint32(src[candidate:]) != binary.LittleEndian.Uint32(src[s:]) {
+			s++
+			continue
+		}
+
+		dst = emitLiteral(dst, src[lit:s])
+		length := minMatch
+		for s+length < len(src) && src[candidate+length] == src[s+length] {
+			length++
+		}
+		dst = emitCopy(dst, s-candidate, length)
+		s += length
+		lit = s
+	}
+	return emitLiteral(dst, src[lit:])
+}
+
+func hash(u uint32) uint32 {
+	return (u * 0x1e35a7bd) >> (32 - tableBits)
+}
+
+func emitLiteral(dst, lit []byte) []byte {
+	if len(lit) == 0 {
+		return dst
+	}
+	n := len(lit) - 1
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n)<<2|tagLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|tagLiteral, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2|tagLiteral, byte(n), byte(n>>8))
+	default:
+		dst = append(dst, 62<<2|tagLiteral, byte(n), byte(n>>8), byte(n>>16))
+	}
+	return append(dst, lit...)
+}
+
+// emitCopy appends copy elements for the match. offset must be less than
+// maxBlockSize.
+func emitCopy(dst []byte, offset, length int) []byte {
+	for length >= 68 {
+		dst = append(dst, 63<<2|tagCopy2, byte(offset), byte(offset>>8))
+		length -= 64
+	}
+	if length > 64 {
+		// Leave at least 4 bytes so that the rest can be a copy.
+		dst = append(dst, 59<<2|tagCopy2, byte(offset), byte(offset>>8))
+		length -= 60
+	}
+	if length >= 12 || offset >= 2048 {
+		return append(dst, byte(length-1)<<2|tagCopy2, byte(offset), byte(offset>>8))
+	}
+	return append(dst, byte(offset>>8)<<5|byte(length-4)<<2|tagCopy1, byte(offset))
+}
+
+func appendUvarint(dst []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(dst, buf[:n]...)
+}
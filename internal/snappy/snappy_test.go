@@ -0,0 +1,102 @@
+package snappy_test
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/hnakamur/timeseries/internal/snappy"
+)
+
+func TestRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	random := make([]byte, 100000)
+	rnd.Read(random)
+
+	testCases := []struct {
+		name string
+		src  []byte
+	}{
+		{name: "empty", src: nil},
+		{name: "short", src: []byte("abc")},
+		{name: "repeated", src: []byte(strings.Repeat("abcdefgh", 10000))},
+		{name: "long run", src: bytes.Repeat([]byte{'x'}, 200000)},
+		{name: "random", src: random},
+		{name: "text", src: []byte(strings.Repeat("the quick brown fox jumps over the lazy dog. ", 100) + "end")},
+	}
+	for _, tc := range testCases {
+		enc := snappy.Encode(nil, tc.src)
+		if len(enc) > snappy.MaxEncodedLen(len(tc.src)) {
+			t.Errorf("%s: encoded length %d exceeds max %d", tc.name, len(enc), snappy.MaxEncodedLen(len(tc.src)))
+		}
+		got, err := snappy.Decode(nil, enc, 0)
+		if err != nil {
+			t.Fatalf("%s: failed to decode: err=%+v", tc.name, err)
+		}
+		if !bytes.Equal(got, tc.src) {
+			t.Errorf("%s: decoded data mismatch", tc.name)
+		}
+	}
+}
+
+func TestDecodeKnown(t *testing.T) {
+	// Hand-encoded blocks with a literal and each kind of copy.
+	testCases := []struct {
+		enc  []byte
+		want string
+	}{
+		{enc: []byte{0x03, 0x08, 'a', 'b', 'c'}, want: "abc"},
+		// literal "ab" then copy1 of length 4 at offset 2.
+		{enc: []byte{0x06, 0x04, 'a', 'b', 0x01, 0x02}, want: "ababab"},
+		// literal "a" then copy2 of length 9 at offset 1.
+		{enc: []byte{0x0a, 0x00, 'a', 0x22, 0x01, 0x00}, want: "aaaaaaaaaa"},
+	}
+	for _, tc := range testCases {
+		got, err := snappy.Decode(nil, tc.enc, 0)
+		if err != nil {
+			t.Fatalf("failed to decode: enc=%x, err=%+v", tc.enc, err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("enc=%x, got=%q, want=%q", tc.enc, got, tc.want)
+		}
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	testCases := []struct {
+		name string
+		enc  []byte
+	}{
+		{name: "no header", enc: nil},
+		{name: "short literal", enc: []byte{0x05, 0x10, 'a'}},
+		{name: "offset before start", enc: []byte{0x05, 0x00, 'a', 0x01, 0x02}},
+		{name: "length mismatch", enc: []byte{0x05, 0x04, 'a', 'b'}},
+		{name: "truncated copy", enc: []byte{0x05, 0x00, 'a', 0x02, 0x01}},
+	}
+	for _, tc := range testCases {
+		_, err := snappy.Decode(nil, tc.enc, 0)
+		if err == nil {
+			t.Errorf("%s: got no error, want error", tc.name)
+		}
+	}
+	_, err := snappy.Decode(nil, snappy.Encode(nil, make([]byte, 1000)), 100)
+	if err != snappy.ErrTooLarge {
+		t.Errorf("got=%v, want=%v", err, snappy.ErrTooLarge)
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add(snappy.Encode(nil, []byte(strings.Repeat("abcdefgh", 100))))
+	f.Add([]byte{0x05, 0x00, 'a', 0x01, 0x02})
+	f.Fuzz(func(t *testing.T, enc []byte) {
+		dec, err := snappy.Decode(nil, enc, 1<<20)
+		if err != nil {
+			return
+		}
+		n, err := snappy.DecodedLen(enc)
+		if err != nil || n != len(dec) {
+			t.Errorf("decoded length mismatch, got=%d, want=%d, err=%v", n, len(dec), err)
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package timeseries
+
+import (
+	"bytes"
+	"io"
+)
+
+// Iterator iterates over data points in timestamp order.
+type Iterator interface {
+	// Next advances the iterator to the next data point. It returns false
+	// when there are no more data points or an error occurred.
+	Next() bool
+
+	// At returns the current data point.
+	At() Point
+
+	// Err returns the error which stopped the iteration, if any.
+	Err() error
+}
+
+type decoderIterator struct {
+	dec *Decoder
+	p   Point
+	err error
+}
+
+// NewDecoderIterator returns an iterator over the data points decoded by the
+// decoder. The header must be decoded before the iteration.
+func NewDecoderIterator(d *Decoder) Iterator {
+	return &decoderIterator{dec: d}
+}
+
+// NewBlockIterator returns an iterator over the data points in an encoded
+// block.
+func NewBlockIterator(data []byte) Iterator {
+	dec := NewDecoder(bytes.NewReader(data))
+	_, err := dec.DecodeHeader()
+	return &decoderIterator{dec: dec, err: err}
+}
+
+func (it *decoderIterator) Next() bool {
+	if it.err != nil || it.dec == nil {
+		return false
+	}
+	p, err := it.dec.DecodePoint()
+	if err == io.EOF {
+		it.dec = nil
+		return false
+	} else if err != nil {
+		it.err = err
+		return false
+	}
+	it.p = p
+	return true
+}
+
+func (it *decoderIterator) At() Point {
+	return it.p
+}
+
+func (it *decoderIterator) Err() error {
+	return it.err
+}
+
+type sliceIterator struct {
+	points []Point
+	i      int
+}
+
+// NewSliceIterator returns an iterator over the data points in the slice.
+func NewSliceIterator(points []Point) Iterator {
+	return &sliceIterator{points: points, i: -1}
+}
+
+func (it *sliceIterator) Next() bool {
+	if it.i+1 >= len(it.points) {
+		it.i = len(it.points)
+		return false
+	}
+	it.i++
+	return true
+}
+
+func (it *sliceIterator) At() Point {
+	return it.points[it.i]
+}
+
+func (it *sliceIterator) Err() error {
+	return nil
+}
+
+// Collect reads all data points from the iterator.
+func Collect(it Iterator) ([]Point, error) {
+	var points []Point
+	for it.Next() {
+		points = append(points, it.At())
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// EncodeIterator encodes all data points from the iterator with the encoder.
+// The header must be encoded before and the finish marker is not encoded.
+func EncodeIterator(e *Encoder, it Iterator) error {
+	for it.Next() {
+		err := e.EncodePoint(it.At())
+		if err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
@@ -0,0 +1,63 @@
+package timeseries_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+)
+
+func TestBlockIterator(t *testing.T) {
+	data, err := hex.DecodeString("5510c52000f900a0000000000002fdbc1b0010022666666666667ffffffffe")
+	if err != nil {
+		t.Fatalf("failed to decode hex string: err=%+v", err)
+	}
+	want := []timeseries.Point{
+		{
+			Timestamp: uint32(time.Date(2015, 3, 24, 2, 1, 2, 0, time.UTC).Unix()),
+			Value:     12.0,
+		},
+		{
+			Timestamp: uint32(time.Date(2015, 3, 24, 2, 2, 2, 0, time.UTC).Unix()),
+			Value:     12.5,
+		},
+		{
+			Timestamp: uint32(time.Date(2015, 3, 24, 2, 3, 2, 0, time.UTC).Unix()),
+			Value:     -24.2,
+		},
+	}
+
+	got, err := timeseries.Collect(timeseries.NewBlockIterator(data))
+	if err != nil {
+		t.Fatalf("failed to collect points: err=%+v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got=%+v, want=%+v", got, want)
+	}
+
+	_, err = timeseries.Collect(timeseries.NewBlockIterator(data[:2]))
+	if err == nil {
+		t.Error("truncated header: got no error, want an error")
+	}
+
+	var b bytes.Buffer
+	enc := timeseries.NewEncoder(&b)
+	err = enc.EncodeHeader(uint32(time.Date(2015, 3, 24, 2, 0, 0, 0, time.UTC).Unix()))
+	if err != nil {
+		t.Fatalf("failed to encode time series header: err=%+v", err)
+	}
+	err = timeseries.EncodeIterator(enc, timeseries.NewSliceIterator(want))
+	if err != nil {
+		t.Fatalf("failed to encode iterator: err=%+v", err)
+	}
+	err = enc.Finish()
+	if err != nil {
+		t.Fatalf("failed to encode time series finish marker: err=%+v", err)
+	}
+	if !bytes.Equal(b.Bytes(), data) {
+		t.Errorf("got=%x, want=%x", b.Bytes(), data)
+	}
+}
@@ -0,0 +1,137 @@
+package join
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+)
+
+// Op is a binary operator.
+type Op int
+
+const (
+	// Add is the addition.
+	Add Op = iota
+	// Sub is the subtraction.
+	Sub
+	// Mul is the multiplication.
+	Mul
+	// Div is the division. Division by zero results in infinity or NaN.
+	Div
+
+	// Eq keeps the left value where it is equal to the right value.
+	Eq
+	// Ne keeps the left value where it is not equal to the right value.
+	Ne
+	// Gt keeps the left value where it is greater than the right value.
+	Gt
+	// Lt keeps the left value where it is less than the right value.
+	Lt
+	// Ge keeps the left value where it is greater than or equal to the right
+	// value.
+	Ge
+	// Le keeps the left value where it is less than or equal to the right
+	// value.
+	Le
+)
+
+func (op Op) String() string {
+	switch op {
+	case Add:
+		return "+"
+	case Sub:
+		return "-"
+	case Mul:
+		return "*"
+	case Div:
+		return "/"
+	case Eq:
+		return "=="
+	case Ne:
+		return "!="
+	case Gt:
+		return ">"
+	case Lt:
+		return "<"
+	case Ge:
+		return ">="
+	case Le:
+		return "<="
+	default:
+		return fmt.Sprintf("Op(%d)", int(op))
+	}
+}
+
+// IsComparison returns whether the operator is a comparison filter.
+func (op Op) IsComparison() bool {
+	return op >= Eq && op <= Le
+}
+
+// Apply applies the operator to the values. keep is false if the operator is
+// a comparison filter and the comparison is false.
+func (op Op) Apply(a, b float64) (v float64, keep bool) {
+	switch op {
+	case Add:
+		return a + b, true
+	case Sub:
+		return a - b, true
+	case Mul:
+		return a * b, true
+	case Div:
+		return a / b, true
+	case Eq:
+		return a, a == b
+	case Ne:
+		return a, a != b
+	case Gt:
+		return a, a > b
+	case Lt:
+		return a, a < b
+	case Ge:
+		return a, a >= b
+	case Le:
+		return a, a <= b
+	default:
+		return 0, false
+	}
+}
+
+type binaryIterator struct {
+	op Op
+	j  *Join
+	p  timeseries.Point
+}
+
+// Binary returns an iterator which applies the operator to the data points of
+// two series matched with the tolerance. Rows where either series has no data
+// point are skipped, and so are rows where a comparison filter is false.
+// The timestamps of the results are the earliest ones of the matched data
+// points. It panics if the tolerance is negative.
+func Binary(op Op, a, b timeseries.Iterator, tolerance time.Duration) timeseries.Iterator {
+	return &binaryIterator{op: op, j: NewJoin(tolerance, a, b)}
+}
+
+func (it *binaryIterator) Next() bool {
+	for it.j.Next() {
+		row := it.j.At()
+		if !row.Present[0] || !row.Present[1] {
+			continue
+		}
+		v, keep := it.op.Apply(row.Values[0], row.Values[1])
+		if !keep {
+			continue
+		}
+		it.p = timeseries.Point{Timestamp: row.Timestamp, Value: v}
+		return true
+	}
+	return false
+}
+
+func (it *binaryIterator) At() timeseries.Point {
+	return it.p
+}
+
+func (it *binaryIterator) Err() error {
+	return it.j.Err()
+}
@@ -0,0 +1,116 @@
+package join
+
+import (
+	"time"
+
+	"github.com/hnakamur/timeseries"
+)
+
+// Row is the values of joined series at a timestamp.
+type Row struct {
+	// Timestamp is the earliest timestamp of the data points in the row.
+	Timestamp uint32
+
+	// Values is the values of the series in the order of the iterators.
+	Values []float64
+
+	// Present is whether each series has a data point in the row.
+	Present []bool
+}
+
+// Join joins series read from iterators by timestamp in one pass.
+type Join struct {
+	its       []timeseries.Iterator
+	tolerance uint32
+	heads     []timeseries.Point
+	hasHead   []bool
+	init      bool
+	row       Row
+	err       error
+}
+
+// NewJoin creates a join of the series read from the iterators. Data points
+// of different series whose timestamps are within the tolerance from the
+// earliest one are matched into the same row. The tolerance must be a multiple
+// of a second. It panics if the tolerance is negative.
+func NewJoin(tolerance time.Duration, its ...timeseries.Iterator) *Join {
+	if tolerance < 0 {
+		panic("join: negative tolerance")
+	}
+	return &Join{
+		its:       its,
+		tolerance: uint32(tolerance / time.Second),
+		heads:     make([]timeseries.Point, len(its)),
+		hasHead:   make([]bool, len(its)),
+		row: Row{
+			Values:  make([]float64, len(its)),
+			Present: make([]bool, len(its)),
+		},
+	}
+}
+
+// Next advances the join to the next row. It returns false when there are no
+// more rows or an error occurred.
+func (j *Join) Next() bool {
+	if j.err != nil {
+		return false
+	}
+	if !j.init {
+		j.init = true
+		for i := range j.its {
+			if !j.advance(i) {
+				return false
+			}
+		}
+	}
+
+	found := false
+	var t uint32
+	for i, ok := range j.hasHead {
+		if ok && (!found || j.heads[i].Timestamp < t) {
+			t = j.heads[i].Timestamp
+			found = true
+		}
+	}
+	if !found {
+		return false
+	}
+
+	j.row.Timestamp = t
+	for i, ok := range j.hasHead {
+		j.row.Present[i] = ok && uint64(j.heads[i].Timestamp) <= uint64(t)+uint64(j.tolerance)
+		if j.row.Present[i] {
+			j.row.Values[i] = j.heads[i].Value
+			if !j.advance(i) {
+				return false
+			}
+		} else {
+			j.row.Values[i] = 0
+		}
+	}
+	return true
+}
+
+func (j *Join) advance(i int) bool {
+	j.hasHead[i] = j.its[i].Next()
+	if j.hasHead[i] {
+		j.heads[i] = j.its[i].At()
+		return true
+	}
+	if err := j.its[i].Err(); err != nil {
+		j.err = err
+		return false
+	}
+	return true
+}
+
+// At returns the current row. The slices in the row are reused by the
+// following call of Next.
+func (j *Join) At() Row {
+	return j.row
+}
+
+// Err returns the error which stopped the join, if any.
+func (j *Join) Err() error {
+	return j.err
+}
@@ -0,0 +1,144 @@
+package join_test
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/join"
+)
+
+func points(tvs ...float64) []timeseries.Point {
+	var ps []timeseries.Point
+	for i := 0; i < len(tvs); i += 2 {
+		ps = append(ps, timeseries.Point{Timestamp: uint32(tvs[i]), Value: tvs[i+1]})
+	}
+	return ps
+}
+
+func TestMerge(t *testing.T) {
+	got, err := timeseries.Collect(join.Merge(
+		timeseries.NewSliceIterator(points(10, 1, 30, 3, 50, 5)),
+		timeseries.NewSliceIterator(points(20, 2, 30, 33)),
+		timeseries.NewSliceIterator(nil),
+		timeseries.NewSliceIterator(points(5, 0, 60, 6)),
+	))
+	if err != nil {
+		t.Fatalf("failed to merge: err=%+v", err)
+	}
+	want := points(5, 0, 10, 1, 20, 2, 30, 33, 50, 5, 60, 6)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got=%+v, want=%+v", got, want)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	j := join.NewJoin(2*time.Second,
+		timeseries.NewSliceIterator(points(10, 1, 20, 2, 30, 3)),
+		timeseries.NewSliceIterator(points(11, 10, 25, 20, 30, 30)),
+	)
+	var got []join.Row
+	for j.Next() {
+		row := j.At()
+		got = append(got, join.Row{
+			Timestamp: row.Timestamp,
+			Values:    append([]float64(nil), row.Values...),
+			Present:   append([]bool(nil), row.Present...),
+		})
+	}
+	if err := j.Err(); err != nil {
+		t.Fatalf("failed to join: err=%+v", err)
+	}
+	want := []join.Row{
+		{Timestamp: 10, Values: []float64{1, 10}, Present: []bool{true, true}},
+		{Timestamp: 20, Values: []float64{2, 0}, Present: []bool{true, false}},
+		{Timestamp: 25, Values: []float64{0, 20}, Present: []bool{false, true}},
+		{Timestamp: 30, Values: []float64{3, 30}, Present: []bool{true, true}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got=%+v, want=%+v", got, want)
+	}
+}
+
+func TestJoinNegativeTolerance(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("got no panic for negative tolerance, want a panic")
+		}
+	}()
+	join.NewJoin(-time.Second,
+		timeseries.NewSliceIterator(points(10, 1)),
+		timeseries.NewSliceIterator(points(10, 2)),
+	)
+}
+
+func TestBinary(t *testing.T) {
+	errors := points(0, 1, 60, 0, 120, 6)
+	requests := points(0, 10, 60, 20, 120, 0, 180, 40)
+
+	testCases := []struct {
+		op   join.Op
+		want []timeseries.Point
+	}{
+		{op: join.Add, want: points(0, 11, 60, 20, 120, 6)},
+		{op: join.Sub, want: points(0, -9, 60, -20, 120, 6)},
+		{op: join.Mul, want: points(0, 10, 60, 0, 120, 0)},
+		{op: join.Div, want: points(0, 0.1, 60, 0, 120, math.Inf(1))},
+		{op: join.Gt, want: points(120, 6)},
+		{op: join.Lt, want: points(0, 1, 60, 0)},
+		{op: join.Eq, want: nil},
+		{op: join.Ne, want: points(0, 1, 60, 0, 120, 6)},
+		{op: join.Ge, want: points(120, 6)},
+		{op: join.Le, want: points(0, 1, 60, 0)},
+	}
+	for _, tc := range testCases {
+		got, err := timeseries.Collect(join.Binary(tc.op,
+			timeseries.NewSliceIterator(errors), timeseries.NewSliceIterator(requests), 0))
+		if err != nil {
+			t.Fatalf("op=%s, failed to apply: err=%+v", tc.op, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("op=%s, got=%+v, want=%+v", tc.op, got, tc.want)
+		}
+	}
+}
+
+func TestBinaryToEncoder(t *testing.T) {
+	t0 := uint32(1427162400)
+	a, err := timeseries.Marshal(t0, points(float64(t0+60), 1, float64(t0+120), 3))
+	if err != nil {
+		t.Fatalf("failed to marshal points: err=%+v", err)
+	}
+	b, err := timeseries.Marshal(t0, points(float64(t0+61), 4, float64(t0+121), 6))
+	if err != nil {
+		t.Fatalf("failed to marshal points: err=%+v", err)
+	}
+
+	var buf bytes.Buffer
+	enc := timeseries.NewEncoder(&buf)
+	err = enc.EncodeHeader(t0)
+	if err != nil {
+		t.Fatalf("failed to encode time series header: err=%+v", err)
+	}
+	err = timeseries.EncodeIterator(enc, join.Binary(join.Div,
+		timeseries.NewBlockIterator(a), timeseries.NewBlockIterator(b), time.Second))
+	if err != nil {
+		t.Fatalf("failed to encode joined series: err=%+v", err)
+	}
+	err = enc.Finish()
+	if err != nil {
+		t.Fatalf("failed to encode time series finish marker: err=%+v", err)
+	}
+
+	_, got, err := timeseries.Unmarshal(buf.Bytes())
+	if err != nil {
+		t.Fatalf("failed to unmarshal: err=%+v", err)
+	}
+	want := points(float64(t0+60), 0.25, float64(t0+120), 0.5)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got=%+v, want=%+v", got, want)
+	}
+}
@@ -0,0 +1,106 @@
+// Package join implements streaming merges and joins of series read from
+// timeseries.Iterator, and binary operators across joined series which
+// produce a new iterator that can be encoded with timeseries.EncodeIterator.
+package join
+
+import (
+	"container/heap"
+
+	"github.com/hnakamur/timeseries"
+)
+
+type head struct {
+	p     timeseries.Point
+	index int
+	it    timeseries.Iterator
+}
+
+type headHeap []*head
+
+func (h headHeap) Len() int { return len(h) }
+func (h headHeap) Less(i, j int) bool {
+	if h[i].p.Timestamp != h[j].p.Timestamp {
+		return h[i].p.Timestamp < h[j].p.Timestamp
+	}
+	return h[i].index < h[j].index
+}
+func (h headHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *headHeap) Push(x interface{}) { *h = append(*h, x.(*head)) }
+func (h *headHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+type mergeIterator struct {
+	its  []timeseries.Iterator
+	h    headHeap
+	init bool
+	p    timeseries.Point
+	err  error
+}
+
+// Merge returns an iterator which merges data points of the iterators of the
+// same series in timestamp order with a k-way merge. When iterators have data
+// points with the same timestamp, only the one from the last iterator is
+// returned, so that newer blocks given later override older ones.
+func Merge(its ...timeseries.Iterator) timeseries.Iterator {
+	return &mergeIterator{its: its}
+}
+
+func (m *mergeIterator) Next() bool {
+	if m.err != nil {
+		return false
+	}
+	if !m.init {
+		m.init = true
+		for i, it := range m.its {
+			if !m.advance(&head{index: i, it: it}) {
+				return false
+			}
+		}
+	}
+	if len(m.h) == 0 {
+		return false
+	}
+
+	// Pop all heads with the smallest timestamp. They are popped in
+	// iterator order, so the last one wins.
+	top := heap.Pop(&m.h).(*head)
+	m.p = top.p
+	if !m.advance(top) {
+		return false
+	}
+	for len(m.h) > 0 && m.h[0].p.Timestamp == m.p.Timestamp {
+		top = heap.Pop(&m.h).(*head)
+		m.p = top.p
+		if !m.advance(top) {
+			return false
+		}
+	}
+	return true
+}
+
+// advance reads the next data point of the head and pushes it back to the
+// heap if any. It returns false if an error occurred.
+func (m *mergeIterator) advance(h *head) bool {
+	if h.it.Next() {
+		h.p = h.it.At()
+		heap.Push(&m.h, h)
+		return true
+	}
+	if err := h.it.Err(); err != nil {
+		m.err = err
+		return false
+	}
+	return true
+}
+
+func (m *mergeIterator) At() timeseries.Point {
+	return m.p
+}
+
+func (m *mergeIterator) Err() error {
+	return m.err
+}
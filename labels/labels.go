@@ -0,0 +1,171 @@
+// Package labels implements label sets which identify time series by a
+// metric name and labels such as host, region and status, and matchers which
+// select time series by their labels.
+package labels
+
+import (
+	"bytes"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MetricName is the label name for the metric name.
+const MetricName = "__name__"
+
+// Label is a pair of a label name and a label value.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Labels is a set of labels sorted by label names.
+// Create Labels with New, FromMap or FromStrings to keep the canonical order.
+type Labels []Label
+
+// New creates labels from ls. The labels are sorted by names, and labels with
+// empty values are removed. If there are labels with the same name, the last
+// one wins.
+func New(ls ...Label) Labels {
+	set := append(Labels(nil), ls...)
+	sort.SliceStable(set, func(i, j int) bool {
+		return set[i].Name < set[j].Name
+	})
+
+	res := set[:0]
+	for i, l := range set {
+		if i+1 < len(set) && set[i+1].Name == l.Name {
+			continue
+		}
+		if l.Value == "" {
+			continue
+		}
+		res = append(res, l)
+	}
+	return res
+}
+
+// FromMap creates labels from a map of label names to label values.
+func FromMap(m map[string]string) Labels {
+	ls := make([]Label, 0, len(m))
+	for name, value := range m {
+		ls = append(ls, Label{Name: name, Value: value})
+	}
+	return New(ls...)
+}
+
+// FromStrings creates labels from pairs of label names and label values.
+// It panics if the number of strings is odd.
+func FromStrings(ss ...string) Labels {
+	if len(ss)%2 != 0 {
+		panic("labels: odd number of strings")
+	}
+	ls := make([]Label, 0, len(ss)/2)
+	for i := 0; i < len(ss); i += 2 {
+		ls = append(ls, Label{Name: ss[i], Value: ss[i+1]})
+	}
+	return New(ls...)
+}
+
+// Get returns the value of the label for the name, or an empty string if
+// there is no such label.
+func (ls Labels) Get(name string) string {
+	i := sort.Search(len(ls), func(i int) bool {
+		return ls[i].Name >= name
+	})
+	if i < len(ls) && ls[i].Name == name {
+		return ls[i].Value
+	}
+	return ""
+}
+
+// Map returns the labels as a map of label names to label values.
+func (ls Labels) Map() map[string]string {
+	m := make(map[string]string, len(ls))
+	for _, l := range ls {
+		m[l.Name] = l.Value
+	}
+	return m
+}
+
+// Equal returns whether ls and o have the same labels.
+func (ls Labels) Equal(o Labels) bool {
+	if len(ls) != len(o) {
+		return false
+	}
+	for i := range ls {
+		if ls[i] != o[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Hash returns a hash value of the labels.
+// Equal labels have the same hash value.
+func (ls Labels) Hash() uint64 {
+	h := fnv.New64a()
+	sep := []byte{0xff}
+	for _, l := range ls {
+		h.Write([]byte(l.Name))
+		h.Write(sep)
+		h.Write([]byte(l.Value))
+		h.Write(sep)
+	}
+	return h.Sum64()
+}
+
+// String returns the canonical string representation of the labels, for
+// example cpu_usage{host="host1", region="tokyo"}. It can be used as a
+// series key since equal labels have the same string representation, and
+// different labels have different ones: label names which are not valid
+// identifiers are quoted, and a metric name containing '{' or '"' is quoted
+// and written as the first element in the braces, for example
+// {"a{b", "host.name"="host1"}.
+func (ls Labels) String() string {
+	var b bytes.Buffer
+	name := ls.Get(MetricName)
+	quoteName := strings.ContainsAny(name, `{"`)
+	if !quoteName {
+		b.WriteString(name)
+	}
+	b.WriteByte('{')
+	i := 0
+	if quoteName {
+		b.WriteString(strconv.Quote(name))
+		i++
+	}
+	for _, l := range ls {
+		if l.Name == MetricName {
+			continue
+		}
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if IsValidName(l.Name) {
+			b.WriteString(l.Name)
+		} else {
+			b.WriteString(strconv.Quote(l.Name))
+		}
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(l.Value))
+		i++
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// IsValidName reports whether the label name matches [a-zA-Z_][a-zA-Z0-9_]*.
+func IsValidName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c >= '0' && c <= '9' && i > 0) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+package labels_test
+
+import (
+	"testing"
+
+	"github.com/hnakamur/timeseries/labels"
+)
+
+func TestNew(t *testing.T) {
+	testCases := []struct {
+		ls   labels.Labels
+		want string
+	}{
+		{
+			ls:   labels.FromStrings("region", "tokyo", "host", "host1", labels.MetricName, "cpu"),
+			want: `cpu{host="host1", region="tokyo"}`,
+		},
+		{
+			ls:   labels.FromStrings("host", "host1", "host", "host2", "region", ""),
+			want: `{host="host2"}`,
+		},
+		{
+			ls:   labels.FromMap(map[string]string{"path": `/a"b`, labels.MetricName: "http"}),
+			want: `http{path="/a\"b"}`,
+		},
+		{
+			ls:   labels.New(),
+			want: `{}`,
+		},
+		{
+			ls:   labels.FromStrings(labels.MetricName, "cpu", `a="1", b`, "2"),
+			want: `cpu{"a=\"1\", b"="2"}`,
+		},
+		{
+			ls:   labels.FromStrings(labels.MetricName, "cpu", "a", "1", "b", "2"),
+			want: `cpu{a="1", b="2"}`,
+		},
+		{
+			ls:   labels.FromStrings(labels.MetricName, `a{b="1"}`, "host.name", "h"),
+			want: `{"a{b=\"1\"}", "host.name"="h"}`,
+		},
+		{
+			ls:   labels.FromStrings(labels.MetricName, "servers.host1.cpu"),
+			want: `servers.host1.cpu{}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		got := tc.ls.String()
+		if got != tc.want {
+			t.Errorf("got=%s, want=%s", got, tc.want)
+		}
+	}
+}
+
+func TestIsValidName(t *testing.T) {
+	testCases := []struct {
+		name string
+		want bool
+	}{
+		{name: "host", want: true},
+		{name: "_a1", want: true},
+		{name: "A_b", want: true},
+		{name: "", want: false},
+		{name: "1a", want: false},
+		{name: "host.name", want: false},
+		{name: `a="1", b`, want: false},
+	}
+	for _, tc := range testCases {
+		if got := labels.IsValidName(tc.name); got != tc.want {
+			t.Errorf("name=%q, got=%v, want=%v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	a := labels.FromStrings("host", "host1", "region", "tokyo")
+	b := labels.FromMap(map[string]string{"region": "tokyo", "host": "host1"})
+	c := labels.FromStrings("host", "host1region", "", "tokyo")
+	if !a.Equal(b) {
+		t.Errorf("a=%s, b=%s, got not equal, want equal", a, b)
+	}
+	if a.Hash() != b.Hash() {
+		t.Errorf("a=%s, b=%s, got different hashes, want same hash", a, b)
+	}
+	if a.Hash() == c.Hash() {
+		t.Errorf("a=%s, c=%s, got same hash, want different hashes", a, c)
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	testCases := []struct {
+		typ   labels.MatchType
+		value string
+		input string
+		want  bool
+	}{
+		{typ: labels.MatchEqual, value: "a", input: "a", want: true},
+		{typ: labels.MatchEqual, value: "a", input: "b", want: false},
+		{typ: labels.MatchNotEqual, value: "a", input: "b", want: true},
+		{typ: labels.MatchNotEqual, value: "a", input: "a", want: false},
+		{typ: labels.MatchRegexp, value: "a.*", input: "abc", want: true},
+		{typ: labels.MatchRegexp, value: "b", input: "abc", want: false},
+		{typ: labels.MatchRegexp, value: "a|b", input: "b", want: true},
+		{typ: labels.MatchNotRegexp, value: "a.*", input: "abc", want: false},
+		{typ: labels.MatchNotRegexp, value: "a.*", input: "", want: true},
+	}
+
+	for _, tc := range testCases {
+		m, err := labels.NewMatcher(tc.typ, "name", tc.value)
+		if err != nil {
+			t.Fatalf("failed to create matcher: err=%+v", err)
+		}
+		got := m.Matches(tc.input)
+		if got != tc.want {
+			t.Errorf("matcher=%s, input=%s, got=%v, want=%v", m, tc.input, got, tc.want)
+		}
+	}
+
+	_, err := labels.NewMatcher(labels.MatchRegexp, "name", "(")
+	if err == nil {
+		t.Error("invalid regular expression: got no error, want an error")
+	}
+}
@@ -0,0 +1,104 @@
+package labels
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// MatchType is the type of a label matcher.
+type MatchType int
+
+const (
+	// MatchEqual matches labels whose value is equal to the matcher value.
+	MatchEqual MatchType = iota
+
+	// MatchNotEqual matches labels whose value is not equal to the matcher value.
+	MatchNotEqual
+
+	// MatchRegexp matches labels whose value fully matches the regular
+	// expression of the matcher value.
+	MatchRegexp
+
+	// MatchNotRegexp matches labels whose value does not fully match the
+	// regular expression of the matcher value.
+	MatchNotRegexp
+)
+
+func (t MatchType) String() string {
+	switch t {
+	case MatchEqual:
+		return "="
+	case MatchNotEqual:
+		return "!="
+	case MatchRegexp:
+		return "=~"
+	case MatchNotRegexp:
+		return "!~"
+	default:
+		return fmt.Sprintf("MatchType(%d)", int(t))
+	}
+}
+
+// Matcher matches a label value of a label name.
+// A missing label is treated as a label with an empty value.
+type Matcher struct {
+	Type  MatchType
+	Name  string
+	Value string
+
+	re *regexp.Regexp
+}
+
+// NewMatcher creates a matcher. It returns an error if the type is
+// MatchRegexp or MatchNotRegexp and the value is not a valid regular
+// expression.
+func NewMatcher(t MatchType, name, value string) (*Matcher, error) {
+	m := &Matcher{Type: t, Name: name, Value: value}
+	switch t {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression for label matcher: value=%s, err=%+v", value, err)
+		}
+		m.re = re
+	default:
+		return nil, fmt.Errorf("invalid label match type: %d", int(t))
+	}
+	return m, nil
+}
+
+// MustNewMatcher is like NewMatcher but panics if an error occurs.
+func MustNewMatcher(t MatchType, name, value string) *Matcher {
+	m, err := NewMatcher(t, name, value)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Matches returns whether the label value matches the matcher.
+func (m *Matcher) Matches(v string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return v == m.Value
+	case MatchNotEqual:
+		return v != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(v)
+	case MatchNotRegexp:
+		return !m.re.MatchString(v)
+	default:
+		return false
+	}
+}
+
+// MatchesLabels returns whether the labels match the matcher.
+func (m *Matcher) MatchesLabels(ls Labels) bool {
+	return m.Matches(ls.Get(m.Name))
+}
+
+func (m *Matcher) String() string {
+	return m.Name + m.Type.String() + strconv.Quote(m.Value)
+}
@@ -0,0 +1,102 @@
+package lineprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/labels"
+)
+
+// MaxLineSize is the maximum size of a line read by Decoder.
+const MaxLineSize = 1 << 20
+
+// Decoder reads lines from a stream. The Line returned by Line refers to the
+// internal buffer and is valid until the next call to Next.
+type Decoder struct {
+	sc   *bufio.Scanner
+	line Line
+	n    int
+	err  error
+}
+
+// NewDecoder creates a decoder which reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), MaxLineSize)
+	return &Decoder{sc: sc}
+}
+
+// Next parses the next line, skipping empty lines and comments. It returns
+// false at the end of the input or on an error.
+func (d *Decoder) Next() bool {
+	if d.err != nil {
+		return false
+	}
+	for d.sc.Scan() {
+		d.n++
+		b := bytes.TrimLeft(d.sc.Bytes(), " \t")
+		b = bytes.TrimRight(b, "\r")
+		if len(b) == 0 || b[0] == '#' {
+			continue
+		}
+		err := ParseLine(b, &d.line)
+		if err != nil {
+			if se, ok := err.(*SyntaxError); ok {
+				se.Line = d.n
+			}
+			d.err = err
+			return false
+		}
+		return true
+	}
+	d.err = d.sc.Err()
+	return false
+}
+
+// Line returns the line parsed by Next.
+func (d *Decoder) Line() *Line {
+	return &d.line
+}
+
+// Err returns the error which stopped Next, or nil at the end of the input.
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+// Labels returns the labels of the series for the field of the line. The
+// metric name is the measurement for the field named value and
+// measurement_field otherwise, and the tags become the other labels. The
+// string representation of the labels is the key of the series in a store.
+func (l *Line) Labels(field int) labels.Labels {
+	ls := make([]labels.Label, 0, len(l.Tags)+1)
+	ls = append(ls, labels.Label{Name: labels.MetricName, Value: MetricName(l.Measurement, l.Fields[field].Key)})
+	for _, t := range l.Tags {
+		ls = append(ls, labels.Label{Name: string(t.Key), Value: string(t.Value)})
+	}
+	return labels.New(ls...)
+}
+
+// MetricName returns the metric name for the field of the measurement.
+func MetricName(measurement, field []byte) string {
+	if string(field) == DefaultField {
+		return string(measurement)
+	}
+	return string(measurement) + "_" + string(field)
+}
+
+// Time returns the timestamp of the line in seconds, converted from the
+// precision. now is used if the line has no timestamp.
+func (l *Line) Time(p Precision, now time.Time) (uint32, error) {
+	if !l.HasTimestamp {
+		return timeseries.Timestamp(now)
+	}
+	t, err := p.ToSeconds(l.Timestamp)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp of %s: %v", l.Measurement, err)
+	}
+	return t, nil
+}
@@ -0,0 +1,283 @@
+package lineprotocol_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/labels"
+	"github.com/hnakamur/timeseries/lineprotocol"
+)
+
+func TestParseLine(t *testing.T) {
+	testCases := []struct {
+		input string
+		want  lineprotocol.Line
+	}{
+		{
+			input: `cpu,host=server01,region=tokyo usage=0.64,count=3i,big=18446744073709551615u,up=t,msg="a \"b\" \\c" 1427162400000000000`,
+			want: lineprotocol.Line{
+				Measurement: []byte("cpu"),
+				Tags: []lineprotocol.Tag{
+					{Key: []byte("host"), Value: []byte("server01")},
+					{Key: []byte("region"), Value: []byte("tokyo")},
+				},
+				Fields: []lineprotocol.Field{
+					{Key: []byte("usage"), Type: lineprotocol.Float, Float: 0.64},
+					{Key: []byte("count"), Type: lineprotocol.Int, Int: 3},
+					{Key: []byte("big"), Type: lineprotocol.Uint, Uint: 18446744073709551615},
+					{Key: []byte("up"), Type: lineprotocol.Bool, Bool: true},
+					{Key: []byte("msg"), Type: lineprotocol.String, String: []byte(`a "b" \c`)},
+				},
+				Timestamp:    1427162400000000000,
+				HasTimestamp: true,
+			},
+		},
+		{
+			input: `my\ cpu,host\=name=a\,b\ c value=-1e3`,
+			want: lineprotocol.Line{
+				Measurement: []byte("my cpu"),
+				Tags:        []lineprotocol.Tag{{Key: []byte("host=name"), Value: []byte("a,b c")}},
+				Fields:      []lineprotocol.Field{{Key: []byte("value"), Type: lineprotocol.Float, Float: -1000}},
+			},
+		},
+		{
+			input: `mem  free=false,msg="x, y=z"   -5  `,
+			want: lineprotocol.Line{
+				Measurement:  []byte("mem"),
+				Fields:       []lineprotocol.Field{{Key: []byte("free"), Type: lineprotocol.Bool}, {Key: []byte("msg"), Type: lineprotocol.String, String: []byte("x, y=z")}},
+				Timestamp:    -5,
+				HasTimestamp: true,
+			},
+		},
+		{
+			input: `path\\ v\=1=2`,
+			want: lineprotocol.Line{
+				Measurement: []byte(`path\`),
+				Fields:      []lineprotocol.Field{{Key: []byte("v=1"), Type: lineprotocol.Float, Float: 2}},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		var got lineprotocol.Line
+		err := lineprotocol.ParseLine([]byte(tc.input), &got)
+		if err != nil {
+			t.Fatalf("failed to parse line: input=%s, err=%+v", tc.input, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("input=%s, got=%+v, want=%+v", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestParseLineError(t *testing.T) {
+	testCases := []string{
+		``,
+		`cpu`,
+		`cpu `,
+		`,host=a value=1`,
+		`cpu,host value=1`,
+		`cpu,host= value=1`,
+		`cpu,=a value=1`,
+		`cpu value`,
+		`cpu value=`,
+		`cpu value=abc`,
+		`cpu value=NaN`,
+		`cpu value=+Inf`,
+		`cpu value=0x10`,
+		`cpu value=1.5i`,
+		`cpu value=-1u`,
+		`cpu value="abc`,
+		`cpu value="abc"x`,
+		`cpu value=1 abc`,
+		`cpu value=1 1 2`,
+		`cpu value=1,`,
+	}
+	for _, input := range testCases {
+		var l lineprotocol.Line
+		err := lineprotocol.ParseLine([]byte(input), &l)
+		if err == nil {
+			t.Errorf("input=%q, got no error, want error", input)
+		}
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	input := strings.Join([]string{
+		"# comment",
+		"cpu,host=a value=1 1427162400000",
+		"",
+		"  cpu,host=a value=2,idle=50i 1427162460000\r",
+		"cpu,host=b value=3",
+	}, "\n")
+	d := lineprotocol.NewDecoder(strings.NewReader(input))
+	now := time.Date(2015, 3, 24, 2, 10, 0, 0, time.UTC)
+
+	type series struct {
+		labels string
+		point  timeseries.Point
+	}
+	var got []series
+	for d.Next() {
+		l := d.Line()
+		ts, err := l.Time(lineprotocol.Millisecond, now)
+		if err != nil {
+			t.Fatalf("failed to get time: err=%+v", err)
+		}
+		for i := range l.Fields {
+			v, _ := l.Fields[i].Number()
+			got = append(got, series{labels: l.Labels(i).String(), point: timeseries.Point{Timestamp: ts, Value: v}})
+		}
+	}
+	if err := d.Err(); err != nil {
+		t.Fatalf("failed to decode: err=%+v", err)
+	}
+	want := []series{
+		{labels: `cpu{host="a"}`, point: timeseries.Point{Timestamp: 1427162400, Value: 1}},
+		{labels: `cpu{host="a"}`, point: timeseries.Point{Timestamp: 1427162460, Value: 2}},
+		{labels: `cpu_idle{host="a"}`, point: timeseries.Point{Timestamp: 1427162460, Value: 50}},
+		{labels: `cpu{host="b"}`, point: timeseries.Point{Timestamp: 1427163000, Value: 3}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got=%+v, want=%+v", got, want)
+	}
+
+	d = lineprotocol.NewDecoder(strings.NewReader("cpu value=1\n\ncpu value=x\ncpu value=2\n"))
+	n := 0
+	for d.Next() {
+		n++
+	}
+	se, ok := d.Err().(*lineprotocol.SyntaxError)
+	if !ok || se.Line != 3 || n != 1 {
+		t.Errorf("got n=%d, err=%v, want n=1 and syntax error at line 3", n, d.Err())
+	}
+}
+
+func TestPrecision(t *testing.T) {
+	testCases := []struct {
+		precision string
+		ts        int64
+		want      uint32
+		wantErr   bool
+	}{
+		{precision: "", ts: 1427162400999999999, want: 1427162400},
+		{precision: "us", ts: 1427162400500000, want: 1427162400},
+		{precision: "ms", ts: 1427162400123, want: 1427162400},
+		{precision: "s", ts: 1427162400, want: 1427162400},
+		{precision: "m", ts: 23786040, want: 1427162400},
+		{precision: "h", ts: 396434, want: 1427162400},
+		{precision: "s", ts: -1, wantErr: true},
+		{precision: "s", ts: 1 << 32, wantErr: true},
+		{precision: "h", ts: 1 << 40, wantErr: true},
+		{precision: "x", wantErr: true},
+	}
+	for _, tc := range testCases {
+		p, err := lineprotocol.ParsePrecision(tc.precision)
+		var got uint32
+		if err == nil {
+			got, err = p.ToSeconds(tc.ts)
+		}
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("precision=%s, ts=%d, got no error, want error", tc.precision, tc.ts)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("precision=%s, ts=%d, err=%+v", tc.precision, tc.ts, err)
+		}
+		if got != tc.want {
+			t.Errorf("precision=%s, ts=%d, got=%d, want=%d", tc.precision, tc.ts, got, tc.want)
+		}
+		if p <= lineprotocol.Second {
+			if back, _ := p.ToSeconds(p.FromSeconds(got)); back != got {
+				t.Errorf("precision=%s, got round trip %d, want %d", tc.precision, back, got)
+			}
+		}
+	}
+}
+
+func TestWriteBlock(t *testing.T) {
+	points := []timeseries.Point{
+		{Timestamp: 1427162400, Value: 1.5},
+		{Timestamp: 1427162460, Value: -2},
+		{Timestamp: 1427162520, Value: 1e21},
+	}
+	data, err := timeseries.Marshal(1427162400, points)
+	if err != nil {
+		t.Fatalf("failed to marshal: err=%+v", err)
+	}
+
+	var b bytes.Buffer
+	w := lineprotocol.NewWriter(&b, lineprotocol.Second)
+	ls := labels.FromStrings(labels.MetricName, "cpu usage", "host", "a,b", "region", `x=\`)
+	err = w.WriteBlock(ls, data)
+	if err != nil {
+		t.Fatalf("failed to write block: err=%+v", err)
+	}
+	want := `cpu\ usage,host=a\,b,region=x\=\\ value=1.5 1427162400
+cpu\ usage,host=a\,b,region=x\=\\ value=-2 1427162460
+cpu\ usage,host=a\,b,region=x\=\\ value=1e+21 1427162520
+`
+	if b.String() != want {
+		t.Errorf("got=\n%s\nwant=\n%s", b.String(), want)
+	}
+
+	d := lineprotocol.NewDecoder(&b)
+	var got []timeseries.Point
+	for d.Next() {
+		l := d.Line()
+		if gotLabels := l.Labels(0); !gotLabels.Equal(ls) {
+			t.Errorf("got labels=%s, want=%s", gotLabels, ls)
+		}
+		ts, err := l.Time(lineprotocol.Second, time.Time{})
+		if err != nil {
+			t.Fatalf("failed to get time: err=%+v", err)
+		}
+		v, _ := l.Fields[0].Number()
+		got = append(got, timeseries.Point{Timestamp: ts, Value: v})
+	}
+	if err := d.Err(); err != nil {
+		t.Fatalf("failed to decode: err=%+v", err)
+	}
+	if !reflect.DeepEqual(got, points) {
+		t.Errorf("got=%+v, want=%+v", got, points)
+	}
+
+	err = w.WritePoint(labels.FromStrings("host", "a"), points[0])
+	if err == nil {
+		t.Errorf("got no error for series without metric name, want error")
+	}
+}
+
+func FuzzParseLine(f *testing.F) {
+	for _, seed := range []string{
+		`cpu,host=server01,region=tokyo usage=0.64,count=3i,big=1u,up=t,msg="a \"b\" \\c" 1427162400000000000`,
+		`my\ cpu,host\=name=a\,b\ c value=-1e3`,
+		`mem  free=false,msg="x, y=z"   -5  `,
+		`path\\ v\=1=2`,
+		`cpu value=abc`,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, input []byte) {
+		var l lineprotocol.Line
+		if lineprotocol.ParseLine(append([]byte(nil), input...), &l) != nil {
+			return
+		}
+		// A parsed line must be encoded into a line which is parsed into
+		// the same line.
+		encoded := lineprotocol.AppendLine(nil, &l)
+		var l2 lineprotocol.Line
+		err := lineprotocol.ParseLine(append([]byte(nil), encoded...), &l2)
+		if err != nil {
+			t.Fatalf("failed to parse encoded line: input=%q, encoded=%q, err=%+v", input, encoded, err)
+		}
+		if reencoded := lineprotocol.AppendLine(nil, &l2); !bytes.Equal(reencoded, encoded) {
+			t.Errorf("input=%q, got=%q, want=%q", input, reencoded, encoded)
+		}
+	})
+}
@@ -0,0 +1,308 @@
+// Package lineprotocol implements a parser and a writer of the InfluxDB line
+// protocol:
+//
+//	measurement[,tag=value...] field=value[,field=value...] [timestamp]
+//
+// See https://docs.influxdata.com/influxdb/v2/reference/syntax/line-protocol/
+// for the syntax.
+package lineprotocol
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FieldType is the type of a field value.
+type FieldType int
+
+const (
+	// Float is a 64-bit floating point number like 1.5.
+	Float FieldType = iota
+	// Int is a signed 64-bit integer like 1i.
+	Int
+	// Uint is an unsigned 64-bit integer like 1u.
+	Uint
+	// String is a string like "foo".
+	String
+	// Bool is a boolean like true or f.
+	Bool
+)
+
+func (t FieldType) String() string {
+	switch t {
+	case Float:
+		return "float"
+	case Int:
+		return "integer"
+	case Uint:
+		return "unsigned"
+	case String:
+		return "string"
+	case Bool:
+		return "boolean"
+	default:
+		return fmt.Sprintf("FieldType(%d)", int(t))
+	}
+}
+
+// Tag is a tag of a line.
+type Tag struct {
+	Key   []byte
+	Value []byte
+}
+
+// Field is a field of a line. Only the value for Type is set.
+type Field struct {
+	Key    []byte
+	Type   FieldType
+	Float  float64
+	Int    int64
+	Uint   uint64
+	String []byte
+	Bool   bool
+}
+
+// Number returns the value of a numeric or boolean field as float64, with
+// true as 1 and false as 0. ok is false for a string field.
+func (f *Field) Number() (v float64, ok bool) {
+	switch f.Type {
+	case Float:
+		return f.Float, true
+	case Int:
+		return float64(f.Int), true
+	case Uint:
+		return float64(f.Uint), true
+	case Bool:
+		if f.Bool {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// Line is a parsed line. The byte slices refer to the parsed input.
+type Line struct {
+	Measurement  []byte
+	Tags         []Tag
+	Fields       []Field
+	Timestamp    int64
+	HasTimestamp bool
+}
+
+// SyntaxError is an error of parsing a line.
+type SyntaxError struct {
+	// Line is the line number, or zero if unknown.
+	Line int
+	// Pos is the byte position in the line.
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("line protocol syntax error at position %d: %s", e.Pos, e.Msg)
+	}
+	return fmt.Sprintf("line protocol syntax error at line %d, position %d: %s", e.Line, e.Pos, e.Msg)
+}
+
+// The characters which terminate and which are escaped in measurements and
+// in tag and field keys and values. A backslash is escaped too, so that a
+// backslash at the end of a token is not read as escaping the terminator.
+const (
+	measurementStops   = ", "
+	measurementEscapes = ", \\"
+	keyStops           = ",= "
+	keyEscapes         = ",= \\"
+)
+
+// ParseLine parses a line without the trailing newline into l, reusing the
+// slices of l. Escaped characters are unescaped in place, so b is modified
+// and l refers to b without copying.
+func ParseLine(b []byte, l *Line) error {
+	p := parser{b: b}
+	l.Tags = l.Tags[:0]
+	l.Fields = l.Fields[:0]
+	l.Timestamp = 0
+	l.HasTimestamp = false
+
+	var stop byte
+	l.Measurement, stop = p.token(measurementStops, measurementEscapes)
+	if len(l.Measurement) == 0 {
+		return p.errorf("missing measurement")
+	}
+
+	for stop == ',' {
+		p.i++
+		var t Tag
+		t.Key, stop = p.token(keyStops, keyEscapes)
+		if len(t.Key) == 0 || stop != '=' {
+			return p.errorf("invalid tag key")
+		}
+		p.i++
+		t.Value, stop = p.token(keyStops, keyEscapes)
+		if len(t.Value) == 0 || stop == '=' {
+			return p.errorf("invalid tag value")
+		}
+		l.Tags = append(l.Tags, t)
+	}
+
+	if stop != ' ' {
+		return p.errorf("missing fields")
+	}
+	p.skipSpaces()
+	for {
+		var f Field
+		f.Key, stop = p.token(keyStops, keyEscapes)
+		if len(f.Key) == 0 || stop != '=' {
+			return p.errorf("invalid field key")
+		}
+		p.i++
+		err := p.fieldValue(&f)
+		if err != nil {
+			return err
+		}
+		l.Fields = append(l.Fields, f)
+		if p.i == len(p.b) || p.b[p.i] != ',' {
+			break
+		}
+		p.i++
+	}
+
+	if p.i == len(p.b) {
+		return nil
+	}
+	if p.b[p.i] != ' ' {
+		return p.errorf("unexpected character %q after field value", p.b[p.i])
+	}
+	p.skipSpaces()
+	end := len(p.b)
+	for end > p.i && p.b[end-1] == ' ' {
+		end--
+	}
+	if p.i == end {
+		return nil
+	}
+	ts, err := strconv.ParseInt(string(p.b[p.i:end]), 10, 64)
+	if err != nil {
+		return p.errorf("invalid timestamp %q", p.b[p.i:end])
+	}
+	l.Timestamp = ts
+	l.HasTimestamp = true
+	return nil
+}
+
+type parser struct {
+	b []byte
+	i int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Pos: p.i, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) skipSpaces() {
+	for p.i < len(p.b) && p.b[p.i] == ' ' {
+		p.i++
+	}
+}
+
+// token scans until one of the stop characters which is not escaped, and
+// unescapes the escaped characters in place. It returns the token and the
+// stop character, which is zero at the end of the input.
+func (p *parser) token(stops, escapes string) ([]byte, byte) {
+	start := p.i
+	w := p.i
+	for p.i < len(p.b) {
+		c := p.b[p.i]
+		if c == '\\' && p.i+1 < len(p.b) && strings.IndexByte(escapes, p.b[p.i+1]) >= 0 {
+			p.b[w] = p.b[p.i+1]
+			w++
+			p.i += 2
+			continue
+		}
+		if strings.IndexByte(stops, c) >= 0 {
+			return p.b[start:w], c
+		}
+		p.b[w] = c
+		w++
+		p.i++
+	}
+	return p.b[start:w], 0
+}
+
+func (p *parser) fieldValue(f *Field) error {
+	if p.i < len(p.b) && p.b[p.i] == '"' {
+		return p.stringValue(f)
+	}
+
+	start := p.i
+	for p.i < len(p.b) && p.b[p.i] != ',' && p.b[p.i] != ' ' {
+		p.i++
+	}
+	v := p.b[start:p.i]
+	if len(v) == 0 {
+		return p.errorf("missing field value")
+	}
+
+	var err error
+	switch last := v[len(v)-1]; {
+	case isBool(v):
+		f.Type = Bool
+		f.Bool = v[0] == 't' || v[0] == 'T'
+	case last == 'i':
+		f.Type = Int
+		f.Int, err = strconv.ParseInt(string(v[:len(v)-1]), 10, 64)
+	case last == 'u':
+		f.Type = Uint
+		f.Uint, err = strconv.ParseUint(string(v[:len(v)-1]), 10, 64)
+	default:
+		// strconv.ParseFloat accepts NaN and Inf, which line protocol
+		// does not.
+		if c := v[0]; !(c >= '0' && c <= '9' || c == '-' || c == '+' || c == '.') ||
+			bytes.ContainsAny(v, "nNxX") {
+			return p.errorf("invalid field value %q", v)
+		}
+		f.Type = Float
+		f.Float, err = strconv.ParseFloat(string(v), 64)
+	}
+	if err != nil {
+		return p.errorf("invalid field value %q", v)
+	}
+	return nil
+}
+
+func isBool(v []byte) bool {
+	switch string(v) {
+	case "t", "T", "true", "True", "TRUE", "f", "F", "false", "False", "FALSE":
+		return true
+	}
+	return false
+}
+
+func (p *parser) stringValue(f *Field) error {
+	p.i++
+	start := p.i
+	w := p.i
+	for p.i < len(p.b) {
+		c := p.b[p.i]
+		if c == '\\' && p.i+1 < len(p.b) && (p.b[p.i+1] == '"' || p.b[p.i+1] == '\\') {
+			p.b[w] = p.b[p.i+1]
+			w++
+			p.i += 2
+			continue
+		}
+		if c == '"' {
+			p.i++
+			f.Type = String
+			f.String = p.b[start:w]
+			return nil
+		}
+		p.b[w] = c
+		w++
+		p.i++
+	}
+	return p.errorf("unterminated string field value")
+}
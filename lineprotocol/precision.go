@@ -0,0 +1,69 @@
+package lineprotocol
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Precision is the unit of timestamps.
+type Precision time.Duration
+
+// Precisions of timestamps.
+const (
+	Nanosecond  = Precision(time.Nanosecond)
+	Microsecond = Precision(time.Microsecond)
+	Millisecond = Precision(time.Millisecond)
+	Second      = Precision(time.Second)
+	Minute      = Precision(time.Minute)
+	Hour        = Precision(time.Hour)
+)
+
+// ParsePrecision parses the precision parameter of the InfluxDB write API.
+// An empty string means nanoseconds.
+func ParsePrecision(s string) (Precision, error) {
+	switch s {
+	case "", "n", "ns":
+		return Nanosecond, nil
+	case "u", "us", "µs":
+		return Microsecond, nil
+	case "ms":
+		return Millisecond, nil
+	case "s":
+		return Second, nil
+	case "m":
+		return Minute, nil
+	case "h":
+		return Hour, nil
+	}
+	return 0, fmt.Errorf("invalid precision %q", s)
+}
+
+// ToSeconds converts a timestamp in the precision to a timestamp in seconds
+// as used in timeseries.Point, truncating fractions of seconds.
+func (p Precision) ToSeconds(ts int64) (uint32, error) {
+	if ts < 0 {
+		return 0, fmt.Errorf("timestamp out of range: timestamp=%d", ts)
+	}
+	if p >= Second {
+		m := int64(p / Second)
+		if ts > math.MaxUint32/m {
+			return 0, fmt.Errorf("timestamp out of range: timestamp=%d", ts)
+		}
+		return uint32(ts * m), nil
+	}
+	sec := ts / int64(Second/p)
+	if sec > math.MaxUint32 {
+		return 0, fmt.Errorf("timestamp out of range: timestamp=%d", ts)
+	}
+	return uint32(sec), nil
+}
+
+// FromSeconds converts a timestamp in seconds to a timestamp in the
+// precision, truncating it for precisions coarser than a second.
+func (p Precision) FromSeconds(sec uint32) int64 {
+	if p >= Second {
+		return int64(sec) / int64(p/Second)
+	}
+	return int64(sec) * int64(Second/p)
+}
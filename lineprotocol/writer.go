@@ -0,0 +1,154 @@
+package lineprotocol
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/labels"
+)
+
+// DefaultField is the name of the field written by Writer. A field with this
+// name is read as the series named by the measurement itself.
+const DefaultField = "value"
+
+// AppendLine appends the line in line protocol, escaping special
+// characters, without a trailing newline.
+func AppendLine(dst []byte, l *Line) []byte {
+	dst = appendEscaped(dst, l.Measurement, measurementEscapes)
+	for _, t := range l.Tags {
+		dst = append(dst, ',')
+		dst = appendEscaped(dst, t.Key, keyEscapes)
+		dst = append(dst, '=')
+		dst = appendEscaped(dst, t.Value, keyEscapes)
+	}
+	for i, f := range l.Fields {
+		if i == 0 {
+			dst = append(dst, ' ')
+		} else {
+			dst = append(dst, ',')
+		}
+		dst = appendEscaped(dst, f.Key, keyEscapes)
+		dst = append(dst, '=')
+		switch f.Type {
+		case Float:
+			dst = strconv.AppendFloat(dst, f.Float, 'g', -1, 64)
+		case Int:
+			dst = strconv.AppendInt(dst, f.Int, 10)
+			dst = append(dst, 'i')
+		case Uint:
+			dst = strconv.AppendUint(dst, f.Uint, 10)
+			dst = append(dst, 'u')
+		case String:
+			dst = append(dst, '"')
+			dst = appendEscaped(dst, f.String, `"\`)
+			dst = append(dst, '"')
+		case Bool:
+			dst = strconv.AppendBool(dst, f.Bool)
+		}
+	}
+	if l.HasTimestamp {
+		dst = append(dst, ' ')
+		dst = strconv.AppendInt(dst, l.Timestamp, 10)
+	}
+	return dst
+}
+
+func appendEscaped(dst, s []byte, escapes string) []byte {
+	for _, c := range s {
+		for i := 0; i < len(escapes); i++ {
+			if c == escapes[i] {
+				dst = append(dst, '\\')
+				break
+			}
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
+
+// Writer writes data points of series in line protocol. The metric name of
+// a series is written as the measurement, the other labels as tags and the
+// value as the field named DefaultField. Points with NaN or infinite values
+// are skipped since line protocol cannot represent them.
+type Writer struct {
+	w         io.Writer
+	precision Precision
+	buf       []byte
+	line      Line
+}
+
+// NewWriter creates a writer which writes timestamps in the precision.
+func NewWriter(w io.Writer, precision Precision) *Writer {
+	return &Writer{w: w, precision: precision}
+}
+
+// WritePoint writes a data point of the series.
+func (w *Writer) WritePoint(ls labels.Labels, p timeseries.Point) error {
+	err := w.setSeries(ls)
+	if err != nil {
+		return err
+	}
+	w.buf = w.appendPoint(w.buf[:0], p)
+	_, err = w.w.Write(w.buf)
+	return err
+}
+
+// WriteIterator writes the data points of the series from the iterator.
+func (w *Writer) WriteIterator(ls labels.Labels, it timeseries.Iterator) error {
+	err := w.setSeries(ls)
+	if err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+	for it.Next() {
+		w.buf = w.appendPoint(w.buf, it.At())
+		if len(w.buf) >= 32*1024 {
+			_, err := w.w.Write(w.buf)
+			if err != nil {
+				return err
+			}
+			w.buf = w.buf[:0]
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	_, err = w.w.Write(w.buf)
+	return err
+}
+
+// WriteBlock writes the data points of the series in the encoded block.
+func (w *Writer) WriteBlock(ls labels.Labels, data []byte) error {
+	return w.WriteIterator(ls, timeseries.NewBlockIterator(data))
+}
+
+func (w *Writer) setSeries(ls labels.Labels) error {
+	name := ls.Get(labels.MetricName)
+	if name == "" {
+		return fmt.Errorf("series without metric name: labels=%s", ls)
+	}
+	l := &w.line
+	l.Measurement = append(l.Measurement[:0], name...)
+	l.Tags = l.Tags[:0]
+	for _, lb := range ls {
+		if lb.Name != labels.MetricName {
+			l.Tags = append(l.Tags, Tag{Key: []byte(lb.Name), Value: []byte(lb.Value)})
+		}
+	}
+	l.Fields = append(l.Fields[:0], Field{Key: []byte(DefaultField), Type: Float})
+	l.HasTimestamp = true
+	return nil
+}
+
+func (w *Writer) appendPoint(dst []byte, p timeseries.Point) []byte {
+	if math.IsNaN(p.Value) || math.IsInf(p.Value, 0) {
+		return dst
+	}
+	w.line.Fields[0].Float = p.Value
+	w.line.Timestamp = w.precision.FromSeconds(p.Timestamp)
+	dst = AppendLine(dst, &w.line)
+	return append(dst, '\n')
+}
@@ -0,0 +1,151 @@
+package opentsdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/hnakamur/timeseries/store"
+)
+
+// maxPutBodySize is the maximum size of a put request body.
+const maxPutBodySize = 32 << 20
+
+// Summary is the response of a put request with the details query
+// parameter. The response with the summary query parameter does not have
+// Errors.
+type Summary struct {
+	Success int          `json:"success"`
+	Failed  int          `json:"failed"`
+	Errors  []PointError `json:"errors"`
+}
+
+// PointError is an error for a data point in the details response.
+type PointError struct {
+	DataPoint DataPoint `json:"datapoint"`
+	Error     string    `json:"error"`
+}
+
+// Put appends the data points to the appender and returns the summary
+// with the errors of the failed data points. It continues after failures.
+func Put(app store.LabelsAppender, dps []DataPoint) Summary {
+	var sum Summary
+	for _, dp := range dps {
+		err := put(app, dp)
+		if err != nil {
+			sum.Failed++
+			sum.Errors = append(sum.Errors, PointError{DataPoint: dp, Error: err.Error()})
+			continue
+		}
+		sum.Success++
+	}
+	return sum
+}
+
+func put(app store.LabelsAppender, dp DataPoint) error {
+	ls, err := dp.Labels()
+	if err != nil {
+		return err
+	}
+	p, err := dp.Point()
+	if err != nil {
+		return err
+	}
+	err = app.AppendLabels(ls, p)
+	if err != nil {
+		return fmt.Errorf("failed to append point: labels=%s, point=%+v, err=%+v", ls, p, err)
+	}
+	return nil
+}
+
+// NewPutHandler returns a handler of the /api/put endpoint, whose body is a
+// data point or an array of data points in JSON.
+//
+// It responds with 204 No Content if all data points are appended. With
+// the summary or details query parameter, it responds with a Summary, which
+// has the errors only for details.
+func NewPutHandler(app store.LabelsAppender) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed", "")
+			return
+		}
+		dps, err := decodeDataPoints(http.MaxBytesReader(w, r.Body, maxPutBodySize))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Unable to parse the given JSON", err.Error())
+			return
+		}
+
+		sum := Put(app, dps)
+		q := r.URL.Query()
+		_, details := q["details"]
+		_, summary := q["summary"]
+		code := http.StatusOK
+		if sum.Failed > 0 {
+			code = http.StatusBadRequest
+		}
+		switch {
+		case details:
+			if sum.Errors == nil {
+				// OpenTSDB clients expect an empty array.
+				sum.Errors = []PointError{}
+			}
+			writeJSON(w, code, sum)
+		case summary:
+			writeJSON(w, code, struct {
+				Success int `json:"success"`
+				Failed  int `json:"failed"`
+			}{sum.Success, sum.Failed})
+		case sum.Failed > 0:
+			writeError(w, code, "One or more data points had errors",
+				`Please see the TSD logs or append "details" to the put request`)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
+
+// decodeDataPoints decodes a data point or an array of data points.
+func decodeDataPoints(r io.Reader) ([]DataPoint, error) {
+	var raw json.RawMessage
+	err := json.NewDecoder(r).Decode(&raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode request body: err=%+v", err)
+	}
+	if raw[0] == '[' {
+		var dps []DataPoint
+		err = json.Unmarshal(raw, &dps)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode data points: err=%+v", err)
+		}
+		return dps, nil
+	}
+	var dp DataPoint
+	err = json.Unmarshal(raw, &dp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode data point: err=%+v", err)
+	}
+	return []DataPoint{dp}, nil
+}
+
+type errorResponse struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Details string `json:"details,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, code int, msg, details string) {
+	writeJSON(w, code, errorResponse{Error: errorDetail{Code: code, Message: msg, Details: details}})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
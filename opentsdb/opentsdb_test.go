@@ -0,0 +1,275 @@
+package opentsdb_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/labels"
+	"github.com/hnakamur/timeseries/opentsdb"
+	"github.com/hnakamur/timeseries/store"
+)
+
+func TestParsePut(t *testing.T) {
+	testCases := []struct {
+		line       string
+		wantLabels labels.Labels
+		wantPoint  timeseries.Point
+		wantErr    bool
+	}{
+		{
+			line:       "put sys.cpu.user 1356998400 42.5 host=web01 cpu=0",
+			wantLabels: labels.FromStrings(labels.MetricName, "sys.cpu.user", "cpu", "0", "host", "web01"),
+			wantPoint:  timeseries.Point{Timestamp: 1356998400, Value: 42.5},
+		},
+		{
+			line:       "put sys.cpu.user 1356998400500 1 host=web01",
+			wantLabels: labels.FromStrings(labels.MetricName, "sys.cpu.user", "host", "web01"),
+			wantPoint:  timeseries.Point{Timestamp: 1356998400, Value: 1},
+		},
+		{
+			line:       "put  sys.cpu.user\t1356998400.500  -3e2 host=web01 ",
+			wantLabels: labels.FromStrings(labels.MetricName, "sys.cpu.user", "host", "web01"),
+			wantPoint:  timeseries.Point{Timestamp: 1356998400, Value: -300},
+		},
+		{line: "put sys.cpu.user 1356998400 1", wantErr: true},
+		{line: "put sys.cpu.user 1356998400 1 host", wantErr: true},
+		{line: "put sys.cpu.user 1356998400 1 host=a host=b", wantErr: true},
+		{line: "put sys.cpu.user 1356998400 1 host=", wantErr: true},
+		{line: "put sys.cpu.user 1356998400 1 =a", wantErr: true},
+		{line: "put sys.cpu.user 1356998400 1 host=a,b", wantErr: true},
+		{line: "put sys cpu 1356998400 1 host=a", wantErr: true},
+		{line: "put sys{cpu} 1356998400 1 host=a", wantErr: true},
+		{line: "put sys.cpu.user abc 1 host=a", wantErr: true},
+		{line: "put sys.cpu.user 0 1 host=a", wantErr: true},
+		{line: "put sys.cpu.user 1356998400.5 1 host=a", wantErr: true},
+		{line: "put sys.cpu.user 99999999999999999 1 host=a", wantErr: true},
+		{line: "put sys.cpu.user 1356998400 abc host=a", wantErr: true},
+		{line: "put sys.cpu.user 1356998400 NaN host=a", wantErr: true},
+		{line: "get sys.cpu.user 1356998400 1 host=a", wantErr: true},
+	}
+	for _, tc := range testCases {
+		dp, err := opentsdb.ParsePut(tc.line)
+		var ls labels.Labels
+		var p timeseries.Point
+		if err == nil {
+			ls, err = dp.Labels()
+		}
+		if err == nil {
+			p, err = dp.Point()
+		}
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("line=%q, got no error, want error", tc.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("failed to parse line: line=%q, err=%+v", tc.line, err)
+		}
+		if !reflect.DeepEqual(ls, tc.wantLabels) {
+			t.Errorf("line=%q, labels got=%s, want=%s", tc.line, ls, tc.wantLabels)
+		}
+		if p != tc.wantPoint {
+			t.Errorf("line=%q, point got=%+v, want=%+v", tc.line, p, tc.wantPoint)
+		}
+	}
+}
+
+func TestPutHandler(t *testing.T) {
+	testCases := []struct {
+		name     string
+		query    string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "single",
+			body:     `{"metric":"sys.cpu","timestamp":1356998400,"value":1,"tags":{"host":"a"}}`,
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "array with string values",
+			body:     `[{"metric":"sys.cpu","timestamp":"1356998460","value":"2.5","tags":{"host":"a"}},{"metric":"sys.cpu","timestamp":1356998520000,"value":3,"tags":{"host":"a"}}]`,
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "summary",
+			query:    "?summary",
+			body:     `[{"metric":"sys.cpu","timestamp":1356998580,"value":4,"tags":{"host":"a"}},{"metric":"sys.cpu","timestamp":1356998580,"value":4}]`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"success":1,"failed":1}`,
+		},
+		{
+			name:     "details",
+			query:    "?details",
+			body:     `[{"metric":"sys.cpu","timestamp":1356998580,"value":1e400,"tags":{"host":"a"}}]`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"success":0,"failed":1,"errors":[{"datapoint":{"metric":"sys.cpu","timestamp":1356998580,"value":1e400,"tags":{"host":"a"}},"error":"invalid value \"1e400\""}]}`,
+		},
+		{
+			name:     "details without errors",
+			query:    "?details",
+			body:     `[]`,
+			wantCode: http.StatusOK,
+			wantBody: `{"success":0,"failed":0,"errors":[]}`,
+		},
+		{
+			name:     "failed without summary",
+			body:     `{"metric":"","timestamp":1356998580,"value":1,"tags":{"host":"a"}}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"error":{"code":400,"message":"One or more data points had errors","details":"Please see the TSD logs or append \"details\" to the put request"}}`,
+		},
+		{
+			name:     "invalid JSON",
+			body:     `{"metric":`,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	s, err := store.New(store.Options{})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+	h := opentsdb.NewPutHandler(s)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/put"+tc.query, strings.NewReader(tc.body))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tc.wantCode {
+				t.Errorf("code got=%d, want=%d, body=%s", rec.Code, tc.wantCode, rec.Body)
+			}
+			if tc.wantBody != "" {
+				if got := strings.TrimSpace(rec.Body.String()); got != tc.wantBody {
+					t.Errorf("body got=%s, want=%s", got, tc.wantBody)
+				}
+			}
+		})
+	}
+
+	series, err := s.Select(0, 1356999000, labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "sys.cpu"))
+	if err != nil {
+		t.Fatalf("failed to select: err=%+v", err)
+	}
+	want := []store.Series{{
+		Labels: labels.FromStrings(labels.MetricName, "sys.cpu", "host", "a"),
+		Points: []timeseries.Point{
+			{Timestamp: 1356998400, Value: 1},
+			{Timestamp: 1356998460, Value: 2.5},
+			{Timestamp: 1356998520, Value: 3},
+			{Timestamp: 1356998580, Value: 4},
+		},
+	}}
+	if !reflect.DeepEqual(series, want) {
+		t.Errorf("got=%+v, want=%+v", series, want)
+	}
+}
+
+func TestServe(t *testing.T) {
+	s, err := store.New(store.Options{})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+	srv := opentsdb.NewServer(s)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: err=%+v", err)
+	}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: err=%+v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("put sys.cpu 1356998400 1 host=a\nput sys.cpu 1356998460 x host=a\nstats\nput sys.cpu 1356998460 2 host=a\nexit\n"))
+	if err != nil {
+		t.Fatalf("failed to write: err=%+v", err)
+	}
+	var got []string
+	sc := bufio.NewScanner(conn)
+	for sc.Scan() {
+		got = append(got, sc.Text())
+	}
+	want := []string{`put: invalid value "x"`, "unknown command: stats."}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("responses got=%q, want=%q", got, want)
+	}
+	if got, want := srv.Stats(), (opentsdb.Stats{Received: 2, Errors: 1}); got != want {
+		t.Errorf("stats got=%+v, want=%+v", got, want)
+	}
+
+	pts, err := s.Query(labels.FromStrings(labels.MetricName, "sys.cpu", "host", "a").String(), 0, 1356999000)
+	if err != nil {
+		t.Fatalf("failed to query: err=%+v", err)
+	}
+	wantPts := []timeseries.Point{{Timestamp: 1356998400, Value: 1}, {Timestamp: 1356998460, Value: 2}}
+	if !reflect.DeepEqual(pts, wantPts) {
+		t.Errorf("points got=%+v, want=%+v", pts, wantPts)
+	}
+}
+
+func TestServeLineTooLong(t *testing.T) {
+	s, err := store.New(store.Options{})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+	srv := opentsdb.NewServer(s)
+	errC := make(chan error, 1)
+	srv.ErrorHandler = func(err error) {
+		errC <- err
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: err=%+v", err)
+	}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: err=%+v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	// The write may fail when the server closes the connection.
+	conn.Write([]byte("put sys.cpu 1356998400 1 host=" + strings.Repeat("a", 70000) + "\n"))
+
+	select {
+	case err := <-errC:
+		putErr, ok := err.(*opentsdb.PutError)
+		if !ok || putErr.Err != bufio.ErrTooLong {
+			t.Errorf("error got=%v, want a put error of %v", err, bufio.ErrTooLong)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an error")
+	}
+	if got, want := srv.Stats(), (opentsdb.Stats{Errors: 1}); got != want {
+		t.Errorf("stats got=%+v, want=%+v", got, want)
+	}
+}
+
+func TestDataPointJSON(t *testing.T) {
+	var dp opentsdb.DataPoint
+	err := json.Unmarshal([]byte(`{"metric":"m","timestamp":1356998400123,"value":"1e3","tags":{"k":"v"}}`), &dp)
+	if err != nil {
+		t.Fatalf("failed to unmarshal: err=%+v", err)
+	}
+	p, err := dp.Point()
+	if err != nil {
+		t.Fatalf("failed to get point: err=%+v", err)
+	}
+	if want := (timeseries.Point{Timestamp: 1356998400, Value: 1000}); p != want {
+		t.Errorf("got=%+v, want=%+v", p, want)
+	}
+}
@@ -0,0 +1,151 @@
+// Package opentsdb implements receivers of the OpenTSDB telnet put command
+// and the HTTP /api/put endpoint which append data points to a store.
+package opentsdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/labels"
+)
+
+// maxSeconds is the maximum timestamp in seconds. Larger timestamps are in
+// milliseconds as OpenTSDB does.
+const maxSeconds = math.MaxUint32
+
+// DataPoint is a data point in the OpenTSDB format.
+type DataPoint struct {
+	Metric    string            `json:"metric"`
+	Timestamp json.Number       `json:"timestamp"`
+	Value     json.Number       `json:"value"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// Labels returns the labels of the data point, which are the tags and the
+// metric name.
+func (dp *DataPoint) Labels() (labels.Labels, error) {
+	if dp.Metric == "" {
+		return nil, errors.New("missing metric name")
+	}
+	if err := checkName("metric name", dp.Metric); err != nil {
+		return nil, err
+	}
+	if len(dp.Tags) == 0 {
+		return nil, errors.New("missing tags")
+	}
+	ls := make([]labels.Label, 0, len(dp.Tags)+1)
+	ls = append(ls, labels.Label{Name: labels.MetricName, Value: dp.Metric})
+	for k, v := range dp.Tags {
+		if err := checkName("tag name", k); err != nil {
+			return nil, err
+		}
+		if err := checkName("tag value", v); err != nil {
+			return nil, err
+		}
+		ls = append(ls, labels.Label{Name: k, Value: v})
+	}
+	return labels.New(ls...), nil
+}
+
+// Point returns the data point with the timestamp in seconds. Timestamps
+// in milliseconds are truncated.
+func (dp *DataPoint) Point() (timeseries.Point, error) {
+	t, err := parseTimestamp(string(dp.Timestamp))
+	if err != nil {
+		return timeseries.Point{}, err
+	}
+	v, err := parseValue(string(dp.Value))
+	if err != nil {
+		return timeseries.Point{}, err
+	}
+	return timeseries.Point{Timestamp: t, Value: v}, nil
+}
+
+// parseTimestamp parses a timestamp in seconds, in milliseconds or in
+// seconds with 3 fractional digits.
+func parseTimestamp(s string) (uint32, error) {
+	if s == "" {
+		return 0, errors.New("missing timestamp")
+	}
+	ms := false
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		if len(s)-i-1 != 3 {
+			return 0, fmt.Errorf("invalid timestamp %q", s)
+		}
+		s = s[:i] + s[i+1:]
+		ms = true
+	}
+	t, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || t == 0 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	if ms || t > maxSeconds {
+		t /= 1000
+	}
+	if t > maxSeconds {
+		return 0, fmt.Errorf("timestamp out of range %q", s)
+	}
+	return uint32(t), nil
+}
+
+func parseValue(s string) (float64, error) {
+	if s == "" {
+		return 0, errors.New("missing value")
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return v, nil
+}
+
+// checkName checks that the name consists of the characters OpenTSDB allows,
+// which are letters, digits, '-', '_', '.' and '/'.
+func checkName(what, s string) error {
+	if s == "" {
+		return fmt.Errorf("empty %s", what)
+	}
+	for _, r := range s {
+		if !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '.' || r == '/') {
+			return fmt.Errorf("invalid character %q in %s %q", r, what, s)
+		}
+	}
+	return nil
+}
+
+// ParsePut parses a telnet put command:
+//
+//	put <metric> <timestamp> <value> <tagk1=tagv1 ...>
+func ParsePut(line string) (DataPoint, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != "put" {
+		return DataPoint{}, errors.New("not a put command")
+	}
+	if len(fields) < 5 {
+		return DataPoint{}, fmt.Errorf("not enough arguments: got=%d, want at least 4", len(fields)-1)
+	}
+	dp := DataPoint{
+		Metric:    fields[1],
+		Timestamp: json.Number(fields[2]),
+		Value:     json.Number(fields[3]),
+		Tags:      make(map[string]string, len(fields)-4),
+	}
+	for _, f := range fields[4:] {
+		i := strings.IndexByte(f, '=')
+		if i < 0 {
+			return DataPoint{}, fmt.Errorf("invalid tag %q", f)
+		}
+		k, v := f[:i], f[i+1:]
+		if _, ok := dp.Tags[k]; ok {
+			return DataPoint{}, fmt.Errorf("duplicate tag %q", k)
+		}
+		dp.Tags[k] = v
+	}
+	return dp, nil
+}
@@ -0,0 +1,143 @@
+package opentsdb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/hnakamur/timeseries/internal/listener"
+	"github.com/hnakamur/timeseries/store"
+)
+
+// maxLineSize is the maximum size of a line of the telnet protocol.
+const maxLineSize = 64 * 1024
+
+// Stats is statistics of a server.
+type Stats struct {
+	// Received is the number of appended data points.
+	Received int64
+	// Errors is the number of put commands which failed to parse or append.
+	Errors int64
+}
+
+// PutError is an error for a put command which failed.
+type PutError struct {
+	Addr string
+	Line string
+	Err  error
+}
+
+func (e *PutError) Error() string {
+	return fmt.Sprintf("opentsdb: put failed: addr=%s, line=%q, err=%v", e.Addr, e.Line, e.Err)
+}
+
+func (e *PutError) Unwrap() error { return e.Err }
+
+// Server receives data points with the OpenTSDB telnet protocol and appends
+// them to an appender.
+//
+// The supported commands are put, version and exit. Like OpenTSDB, errors
+// are written back to the clients as lines and the connections are kept.
+type Server struct {
+	app store.LabelsAppender
+
+	// ErrorHandler is called for failed put commands and for connections
+	// which failed to be read, for example by a line longer than 64KiB, if
+	// not nil. It may be called concurrently.
+	ErrorHandler func(err error)
+
+	received int64
+	errors   int64
+
+	group *listener.Group
+}
+
+// ErrServerClosed is returned by Serve after Close.
+var ErrServerClosed = errors.New("opentsdb: server closed")
+
+// NewServer creates a server which appends data points to the appender.
+func NewServer(app store.LabelsAppender) *Server {
+	return &Server{
+		app:   app,
+		group: listener.NewGroup(ErrServerClosed),
+	}
+}
+
+// Stats returns the statistics of the server.
+func (s *Server) Stats() Stats {
+	return Stats{
+		Received: atomic.LoadInt64(&s.received),
+		Errors:   atomic.LoadInt64(&s.errors),
+	}
+}
+
+// Serve accepts connections on the listener and reads commands from them.
+// It blocks until the listener fails or the server is closed.
+func (s *Server) Serve(ln net.Listener) error {
+	return s.group.Serve(ln, s.handleConn)
+}
+
+// Close closes the listeners and connections and waits for the connection
+// handlers to finish.
+func (s *Server) Close() error {
+	s.group.Close()
+	return nil
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	addr := conn.RemoteAddr().String()
+	sc := bufio.NewScanner(conn)
+	sc.Buffer(make([]byte, 4096), maxLineSize)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		cmd := line
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			cmd = line[:i]
+		}
+		switch cmd {
+		case "put":
+			err := s.put(line)
+			if err != nil {
+				atomic.AddInt64(&s.errors, 1)
+				s.handleError(&PutError{Addr: addr, Line: line, Err: err})
+				fmt.Fprintf(conn, "put: %v\n", err)
+			}
+		case "version":
+			fmt.Fprintf(conn, "timeseries OpenTSDB telnet receiver\n")
+		case "exit":
+			return
+		default:
+			fmt.Fprintf(conn, "unknown command: %s.\n", cmd)
+		}
+	}
+	if err := sc.Err(); err != nil && !s.group.IsClosed() {
+		atomic.AddInt64(&s.errors, 1)
+		s.handleError(&PutError{Addr: addr, Err: err})
+		fmt.Fprintf(conn, "error: %v\n", err)
+	}
+}
+
+func (s *Server) put(line string) error {
+	dp, err := ParsePut(line)
+	if err != nil {
+		return err
+	}
+	err = put(s.app, dp)
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&s.received, 1)
+	return nil
+}
+
+func (s *Server) handleError(err error) {
+	if s.ErrorHandler != nil {
+		s.ErrorHandler(err)
+	}
+}
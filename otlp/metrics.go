@@ -0,0 +1,897 @@
+package otlp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/hnakamur/timeseries/internal/protowire"
+)
+
+// maxValueDepth is the maximum nesting depth of array and key value list
+// values of attributes.
+const maxValueDepth = 64
+
+// The messages below are compatible with the ones in
+// opentelemetry/proto/collector/metrics/v1 and opentelemetry/proto/metrics/v1
+// with only the fields used by this package.
+
+// ExportMetricsServiceRequest is an export request.
+type ExportMetricsServiceRequest struct {
+	ResourceMetrics []ResourceMetrics
+}
+
+// ExportMetricsServiceResponse is an export response.
+type ExportMetricsServiceResponse struct {
+	// PartialSuccess is non-nil if some data points were rejected.
+	PartialSuccess *ExportMetricsPartialSuccess
+}
+
+// ExportMetricsPartialSuccess is the details of rejected data points.
+type ExportMetricsPartialSuccess struct {
+	RejectedDataPoints int64
+	ErrorMessage       string
+}
+
+// ResourceMetrics is metrics of a resource.
+type ResourceMetrics struct {
+	Resource     Resource
+	ScopeMetrics []ScopeMetrics
+}
+
+// Resource is a resource such as a service or a host.
+type Resource struct {
+	Attributes []KeyValue
+}
+
+// ScopeMetrics is metrics of an instrumentation scope.
+type ScopeMetrics struct {
+	Scope   InstrumentationScope
+	Metrics []Metric
+}
+
+// InstrumentationScope is an instrumentation scope such as a library.
+type InstrumentationScope struct {
+	Name       string
+	Version    string
+	Attributes []KeyValue
+}
+
+// Metric is a metric. At most one of Gauge, Sum, Histogram and Summary is
+// non-nil.
+type Metric struct {
+	Name        string
+	Description string
+	Unit        string
+	Gauge       *Gauge
+	Sum         *Sum
+	Histogram   *Histogram
+	Summary     *Summary
+
+	// unsupported is the number of data points of an unsupported type such
+	// as the exponential histogram.
+	unsupported int
+}
+
+// AggregationTemporality is the aggregation temporality of a sum or a
+// histogram.
+type AggregationTemporality int
+
+const (
+	// TemporalityUnspecified is the invalid default.
+	TemporalityUnspecified AggregationTemporality = iota
+	// TemporalityDelta is for values aggregated since the previous report.
+	TemporalityDelta
+	// TemporalityCumulative is for values aggregated since the start time.
+	TemporalityCumulative
+)
+
+// Gauge is a gauge.
+type Gauge struct {
+	DataPoints []NumberDataPoint
+}
+
+// Sum is a sum such as a counter.
+type Sum struct {
+	DataPoints             []NumberDataPoint
+	AggregationTemporality AggregationTemporality
+	IsMonotonic            bool
+}
+
+// Histogram is a histogram with explicit buckets.
+type Histogram struct {
+	DataPoints             []HistogramDataPoint
+	AggregationTemporality AggregationTemporality
+}
+
+// Summary is a summary with quantiles.
+type Summary struct {
+	DataPoints []SummaryDataPoint
+}
+
+// FlagNoRecordedValue is the flag of a data point which marks the series
+// stale.
+const FlagNoRecordedValue = 1
+
+// NumberDataPoint is a data point of a gauge or a sum. The value is AsInt if
+// IsInt is true, and AsDouble otherwise.
+type NumberDataPoint struct {
+	Attributes        []KeyValue
+	StartTimeUnixNano uint64
+	TimeUnixNano      uint64
+	AsDouble          float64
+	AsInt             int64
+	IsInt             bool
+	Flags             uint32
+}
+
+// Value returns the value of the data point.
+func (m *NumberDataPoint) Value() float64 {
+	if m.IsInt {
+		return float64(m.AsInt)
+	}
+	return m.AsDouble
+}
+
+// HistogramDataPoint is a data point of a histogram. BucketCounts has one
+// more element than ExplicitBounds, and the counts are not cumulative.
+type HistogramDataPoint struct {
+	Attributes        []KeyValue
+	StartTimeUnixNano uint64
+	TimeUnixNano      uint64
+	Count             uint64
+	Sum               float64
+	HasSum            bool
+	BucketCounts      []uint64
+	ExplicitBounds    []float64
+	Flags             uint32
+}
+
+// SummaryDataPoint is a data point of a summary.
+type SummaryDataPoint struct {
+	Attributes        []KeyValue
+	StartTimeUnixNano uint64
+	TimeUnixNano      uint64
+	Count             uint64
+	Sum               float64
+	QuantileValues    []ValueAtQuantile
+	Flags             uint32
+}
+
+// ValueAtQuantile is a value at a quantile of a summary.
+type ValueAtQuantile struct {
+	Quantile float64
+	Value    float64
+}
+
+// KeyValue is an attribute.
+type KeyValue struct {
+	Key   string
+	Value AnyValue
+}
+
+// ValueType is the type of an AnyValue.
+type ValueType int
+
+const (
+	// EmptyValue is an empty value.
+	EmptyValue ValueType = iota
+	// StringValue is a string value.
+	StringValue
+	// BoolValue is a bool value.
+	BoolValue
+	// IntValue is an int value.
+	IntValue
+	// DoubleValue is a double value.
+	DoubleValue
+	// ArrayValue is an array value.
+	ArrayValue
+	// KeyValueListValue is a list of key values.
+	KeyValueListValue
+	// BytesValue is a bytes value.
+	BytesValue
+)
+
+// AnyValue is a value of an attribute. The field for the type is used.
+type AnyValue struct {
+	Type        ValueType
+	StringValue string
+	BoolValue   bool
+	IntValue    int64
+	DoubleValue float64
+	ArrayValue  []AnyValue
+	KvlistValue []KeyValue
+	BytesValue  []byte
+}
+
+// Marshal encodes the request.
+func (m *ExportMetricsServiceRequest) Marshal() []byte {
+	var b []byte
+	for i := range m.ResourceMetrics {
+		b = protowire.AppendMessage(b, 1, m.ResourceMetrics[i].appendTo)
+	}
+	return b
+}
+
+// Unmarshal decodes the request.
+func (m *ExportMetricsServiceRequest) Unmarshal(b []byte) error {
+	*m = ExportMetricsServiceRequest{}
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		if num != 1 {
+			return r.Skip(typ)
+		}
+		var rm ResourceMetrics
+		err := unmarshalMessage(r, num, typ, rm.unmarshal)
+		m.ResourceMetrics = append(m.ResourceMetrics, rm)
+		return err
+	})
+}
+
+// Marshal encodes the response.
+func (m *ExportMetricsServiceResponse) Marshal() []byte {
+	var b []byte
+	if m.PartialSuccess != nil {
+		b = protowire.AppendMessage(b, 1, m.PartialSuccess.appendTo)
+	}
+	return b
+}
+
+// Unmarshal decodes the response.
+func (m *ExportMetricsServiceResponse) Unmarshal(b []byte) error {
+	*m = ExportMetricsServiceResponse{}
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		if num != 1 {
+			return r.Skip(typ)
+		}
+		m.PartialSuccess = &ExportMetricsPartialSuccess{}
+		return unmarshalMessage(r, num, typ, m.PartialSuccess.unmarshal)
+	})
+}
+
+func (m *ExportMetricsPartialSuccess) appendTo(b []byte) []byte {
+	b = protowire.AppendInt64(b, 1, m.RejectedDataPoints)
+	return protowire.AppendStringField(b, 2, m.ErrorMessage)
+}
+
+func (m *ExportMetricsPartialSuccess) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		switch num {
+		case 1:
+			v, err := unmarshalVarint(r, num, typ)
+			m.RejectedDataPoints = int64(v)
+			return err
+		case 2:
+			return unmarshalString(r, num, typ, &m.ErrorMessage)
+		}
+		return r.Skip(typ)
+	})
+}
+
+func (m *ResourceMetrics) appendTo(b []byte) []byte {
+	b = protowire.AppendMessage(b, 1, m.Resource.appendTo)
+	for i := range m.ScopeMetrics {
+		b = protowire.AppendMessage(b, 2, m.ScopeMetrics[i].appendTo)
+	}
+	return b
+}
+
+func (m *ResourceMetrics) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		switch num {
+		case 1:
+			return unmarshalMessage(r, num, typ, m.Resource.unmarshal)
+		case 2:
+			var sm ScopeMetrics
+			err := unmarshalMessage(r, num, typ, sm.unmarshal)
+			m.ScopeMetrics = append(m.ScopeMetrics, sm)
+			return err
+		}
+		return r.Skip(typ)
+	})
+}
+
+func (m *Resource) appendTo(b []byte) []byte {
+	return appendAttributes(b, 1, m.Attributes)
+}
+
+func (m *Resource) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		if num != 1 {
+			return r.Skip(typ)
+		}
+		return unmarshalAttribute(r, num, typ, &m.Attributes, 0)
+	})
+}
+
+func (m *ScopeMetrics) appendTo(b []byte) []byte {
+	b = protowire.AppendMessage(b, 1, m.Scope.appendTo)
+	for i := range m.Metrics {
+		b = protowire.AppendMessage(b, 2, m.Metrics[i].appendTo)
+	}
+	return b
+}
+
+func (m *ScopeMetrics) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		switch num {
+		case 1:
+			return unmarshalMessage(r, num, typ, m.Scope.unmarshal)
+		case 2:
+			var mt Metric
+			err := unmarshalMessage(r, num, typ, mt.unmarshal)
+			m.Metrics = append(m.Metrics, mt)
+			return err
+		}
+		return r.Skip(typ)
+	})
+}
+
+func (m *InstrumentationScope) appendTo(b []byte) []byte {
+	b = protowire.AppendStringField(b, 1, m.Name)
+	b = protowire.AppendStringField(b, 2, m.Version)
+	return appendAttributes(b, 3, m.Attributes)
+}
+
+func (m *InstrumentationScope) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		switch num {
+		case 1:
+			return unmarshalString(r, num, typ, &m.Name)
+		case 2:
+			return unmarshalString(r, num, typ, &m.Version)
+		case 3:
+			return unmarshalAttribute(r, num, typ, &m.Attributes, 0)
+		}
+		return r.Skip(typ)
+	})
+}
+
+func (m *Metric) appendTo(b []byte) []byte {
+	b = protowire.AppendStringField(b, 1, m.Name)
+	b = protowire.AppendStringField(b, 2, m.Description)
+	b = protowire.AppendStringField(b, 3, m.Unit)
+	switch {
+	case m.Gauge != nil:
+		b = protowire.AppendMessage(b, 5, m.Gauge.appendTo)
+	case m.Sum != nil:
+		b = protowire.AppendMessage(b, 7, m.Sum.appendTo)
+	case m.Histogram != nil:
+		b = protowire.AppendMessage(b, 9, m.Histogram.appendTo)
+	case m.Summary != nil:
+		b = protowire.AppendMessage(b, 11, m.Summary.appendTo)
+	}
+	return b
+}
+
+func (m *Metric) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		switch num {
+		case 1:
+			return unmarshalString(r, num, typ, &m.Name)
+		case 2:
+			return unmarshalString(r, num, typ, &m.Description)
+		case 3:
+			return unmarshalString(r, num, typ, &m.Unit)
+		case 5:
+			m.Gauge = &Gauge{}
+			return unmarshalMessage(r, num, typ, m.Gauge.unmarshal)
+		case 7:
+			m.Sum = &Sum{}
+			return unmarshalMessage(r, num, typ, m.Sum.unmarshal)
+		case 9:
+			m.Histogram = &Histogram{}
+			return unmarshalMessage(r, num, typ, m.Histogram.unmarshal)
+		case 10:
+			// The exponential histogram has the data points in field 1
+			// like the other types.
+			return unmarshalMessage(r, num, typ, func(b []byte) error {
+				return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+					if num == 1 {
+						m.unsupported++
+					}
+					return r.Skip(typ)
+				})
+			})
+		case 11:
+			m.Summary = &Summary{}
+			return unmarshalMessage(r, num, typ, m.Summary.unmarshal)
+		}
+		return r.Skip(typ)
+	})
+}
+
+func (m *Gauge) appendTo(b []byte) []byte {
+	for i := range m.DataPoints {
+		b = protowire.AppendMessage(b, 1, m.DataPoints[i].appendTo)
+	}
+	return b
+}
+
+func (m *Gauge) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		if num != 1 {
+			return r.Skip(typ)
+		}
+		var dp NumberDataPoint
+		err := unmarshalMessage(r, num, typ, dp.unmarshal)
+		m.DataPoints = append(m.DataPoints, dp)
+		return err
+	})
+}
+
+func (m *Sum) appendTo(b []byte) []byte {
+	for i := range m.DataPoints {
+		b = protowire.AppendMessage(b, 1, m.DataPoints[i].appendTo)
+	}
+	b = protowire.AppendInt64(b, 2, int64(m.AggregationTemporality))
+	if m.IsMonotonic {
+		b = protowire.AppendInt64(b, 3, 1)
+	}
+	return b
+}
+
+func (m *Sum) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		switch num {
+		case 1:
+			var dp NumberDataPoint
+			err := unmarshalMessage(r, num, typ, dp.unmarshal)
+			m.DataPoints = append(m.DataPoints, dp)
+			return err
+		case 2:
+			v, err := unmarshalVarint(r, num, typ)
+			m.AggregationTemporality = AggregationTemporality(v)
+			return err
+		case 3:
+			v, err := unmarshalVarint(r, num, typ)
+			m.IsMonotonic = v != 0
+			return err
+		}
+		return r.Skip(typ)
+	})
+}
+
+func (m *Histogram) appendTo(b []byte) []byte {
+	for i := range m.DataPoints {
+		b = protowire.AppendMessage(b, 1, m.DataPoints[i].appendTo)
+	}
+	return protowire.AppendInt64(b, 2, int64(m.AggregationTemporality))
+}
+
+func (m *Histogram) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		switch num {
+		case 1:
+			var dp HistogramDataPoint
+			err := unmarshalMessage(r, num, typ, dp.unmarshal)
+			m.DataPoints = append(m.DataPoints, dp)
+			return err
+		case 2:
+			v, err := unmarshalVarint(r, num, typ)
+			m.AggregationTemporality = AggregationTemporality(v)
+			return err
+		}
+		return r.Skip(typ)
+	})
+}
+
+func (m *Summary) appendTo(b []byte) []byte {
+	for i := range m.DataPoints {
+		b = protowire.AppendMessage(b, 1, m.DataPoints[i].appendTo)
+	}
+	return b
+}
+
+func (m *Summary) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		if num != 1 {
+			return r.Skip(typ)
+		}
+		var dp SummaryDataPoint
+		err := unmarshalMessage(r, num, typ, dp.unmarshal)
+		m.DataPoints = append(m.DataPoints, dp)
+		return err
+	})
+}
+
+func (m *NumberDataPoint) appendTo(b []byte) []byte {
+	b = appendFixed64Field(b, 2, m.StartTimeUnixNano)
+	b = appendFixed64Field(b, 3, m.TimeUnixNano)
+	// The value is in a oneof, so zero is encoded too.
+	if m.IsInt {
+		b = protowire.AppendTag(b, 6, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, uint64(m.AsInt))
+	} else {
+		b = protowire.AppendTag(b, 4, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(m.AsDouble))
+	}
+	b = appendAttributes(b, 7, m.Attributes)
+	return protowire.AppendInt64(b, 8, int64(m.Flags))
+}
+
+func (m *NumberDataPoint) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		switch num {
+		case 2:
+			return unmarshalFixed64(r, num, typ, &m.StartTimeUnixNano)
+		case 3:
+			return unmarshalFixed64(r, num, typ, &m.TimeUnixNano)
+		case 4:
+			m.IsInt = false
+			return unmarshalDouble(r, num, typ, &m.AsDouble)
+		case 6:
+			var v uint64
+			err := unmarshalFixed64(r, num, typ, &v)
+			m.AsInt = int64(v)
+			m.IsInt = true
+			return err
+		case 7:
+			return unmarshalAttribute(r, num, typ, &m.Attributes, 0)
+		case 8:
+			v, err := unmarshalVarint(r, num, typ)
+			m.Flags = uint32(v)
+			return err
+		}
+		return r.Skip(typ)
+	})
+}
+
+func (m *HistogramDataPoint) appendTo(b []byte) []byte {
+	b = appendFixed64Field(b, 2, m.StartTimeUnixNano)
+	b = appendFixed64Field(b, 3, m.TimeUnixNano)
+	b = appendFixed64Field(b, 4, m.Count)
+	if m.HasSum {
+		b = protowire.AppendTag(b, 5, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(m.Sum))
+	}
+	if len(m.BucketCounts) > 0 {
+		b = protowire.AppendMessage(b, 6, func(b []byte) []byte {
+			for _, v := range m.BucketCounts {
+				b = protowire.AppendFixed64(b, v)
+			}
+			return b
+		})
+	}
+	if len(m.ExplicitBounds) > 0 {
+		b = protowire.AppendMessage(b, 7, func(b []byte) []byte {
+			for _, v := range m.ExplicitBounds {
+				b = protowire.AppendFixed64(b, math.Float64bits(v))
+			}
+			return b
+		})
+	}
+	b = appendAttributes(b, 9, m.Attributes)
+	return protowire.AppendInt64(b, 10, int64(m.Flags))
+}
+
+func (m *HistogramDataPoint) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		switch num {
+		case 2:
+			return unmarshalFixed64(r, num, typ, &m.StartTimeUnixNano)
+		case 3:
+			return unmarshalFixed64(r, num, typ, &m.TimeUnixNano)
+		case 4:
+			return unmarshalFixed64(r, num, typ, &m.Count)
+		case 5:
+			m.HasSum = true
+			return unmarshalDouble(r, num, typ, &m.Sum)
+		case 6:
+			return unmarshalRepeatedFixed64(r, num, typ, func(v uint64) {
+				m.BucketCounts = append(m.BucketCounts, v)
+			})
+		case 7:
+			return unmarshalRepeatedFixed64(r, num, typ, func(v uint64) {
+				m.ExplicitBounds = append(m.ExplicitBounds, math.Float64frombits(v))
+			})
+		case 9:
+			return unmarshalAttribute(r, num, typ, &m.Attributes, 0)
+		case 10:
+			v, err := unmarshalVarint(r, num, typ)
+			m.Flags = uint32(v)
+			return err
+		}
+		return r.Skip(typ)
+	})
+}
+
+func (m *SummaryDataPoint) appendTo(b []byte) []byte {
+	b = appendFixed64Field(b, 2, m.StartTimeUnixNano)
+	b = appendFixed64Field(b, 3, m.TimeUnixNano)
+	b = appendFixed64Field(b, 4, m.Count)
+	b = protowire.AppendDouble(b, 5, m.Sum)
+	for i := range m.QuantileValues {
+		b = protowire.AppendMessage(b, 6, m.QuantileValues[i].appendTo)
+	}
+	b = appendAttributes(b, 7, m.Attributes)
+	return protowire.AppendInt64(b, 8, int64(m.Flags))
+}
+
+func (m *SummaryDataPoint) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		switch num {
+		case 2:
+			return unmarshalFixed64(r, num, typ, &m.StartTimeUnixNano)
+		case 3:
+			return unmarshalFixed64(r, num, typ, &m.TimeUnixNano)
+		case 4:
+			return unmarshalFixed64(r, num, typ, &m.Count)
+		case 5:
+			return unmarshalDouble(r, num, typ, &m.Sum)
+		case 6:
+			var q ValueAtQuantile
+			err := unmarshalMessage(r, num, typ, q.unmarshal)
+			m.QuantileValues = append(m.QuantileValues, q)
+			return err
+		case 7:
+			return unmarshalAttribute(r, num, typ, &m.Attributes, 0)
+		case 8:
+			v, err := unmarshalVarint(r, num, typ)
+			m.Flags = uint32(v)
+			return err
+		}
+		return r.Skip(typ)
+	})
+}
+
+func (m *ValueAtQuantile) appendTo(b []byte) []byte {
+	b = protowire.AppendDouble(b, 1, m.Quantile)
+	return protowire.AppendDouble(b, 2, m.Value)
+}
+
+func (m *ValueAtQuantile) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		switch num {
+		case 1:
+			return unmarshalDouble(r, num, typ, &m.Quantile)
+		case 2:
+			return unmarshalDouble(r, num, typ, &m.Value)
+		}
+		return r.Skip(typ)
+	})
+}
+
+func (m *KeyValue) appendTo(b []byte) []byte {
+	b = protowire.AppendStringField(b, 1, m.Key)
+	return protowire.AppendMessage(b, 2, m.Value.appendTo)
+}
+
+func (m *KeyValue) unmarshal(b []byte, depth int) error {
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		switch num {
+		case 1:
+			return unmarshalString(r, num, typ, &m.Key)
+		case 2:
+			return unmarshalMessage(r, num, typ, func(b []byte) error {
+				return m.Value.unmarshal(b, depth)
+			})
+		}
+		return r.Skip(typ)
+	})
+}
+
+func (m *AnyValue) appendTo(b []byte) []byte {
+	// The value is in a oneof, so zero values are encoded too.
+	switch m.Type {
+	case StringValue:
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, m.StringValue)
+	case BoolValue:
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		if m.BoolValue {
+			b = protowire.AppendVarint(b, 1)
+		} else {
+			b = protowire.AppendVarint(b, 0)
+		}
+	case IntValue:
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(m.IntValue))
+	case DoubleValue:
+		b = protowire.AppendTag(b, 4, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(m.DoubleValue))
+	case ArrayValue:
+		b = protowire.AppendMessage(b, 5, func(b []byte) []byte {
+			for i := range m.ArrayValue {
+				b = protowire.AppendMessage(b, 1, m.ArrayValue[i].appendTo)
+			}
+			return b
+		})
+	case KeyValueListValue:
+		b = protowire.AppendMessage(b, 6, func(b []byte) []byte {
+			return appendAttributes(b, 1, m.KvlistValue)
+		})
+	case BytesValue:
+		b = protowire.AppendTag(b, 7, protowire.BytesType)
+		b = protowire.AppendBytes(b, m.BytesValue)
+	}
+	return b
+}
+
+// unmarshal decodes the value at the nesting depth. It returns an error if
+// the nesting of array and key value list values is deeper than
+// maxValueDepth, since they are decoded recursively.
+func (m *AnyValue) unmarshal(b []byte, depth int) error {
+	if depth >= maxValueDepth {
+		return errors.New("attribute value nesting too deep")
+	}
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		switch num {
+		case 1:
+			m.Type = StringValue
+			return unmarshalString(r, num, typ, &m.StringValue)
+		case 2:
+			v, err := unmarshalVarint(r, num, typ)
+			m.Type = BoolValue
+			m.BoolValue = v != 0
+			return err
+		case 3:
+			v, err := unmarshalVarint(r, num, typ)
+			m.Type = IntValue
+			m.IntValue = int64(v)
+			return err
+		case 4:
+			m.Type = DoubleValue
+			return unmarshalDouble(r, num, typ, &m.DoubleValue)
+		case 5:
+			m.Type = ArrayValue
+			return unmarshalMessage(r, num, typ, func(b []byte) error {
+				return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+					if num != 1 {
+						return r.Skip(typ)
+					}
+					var v AnyValue
+					err := unmarshalMessage(r, num, typ, func(b []byte) error {
+						return v.unmarshal(b, depth+1)
+					})
+					m.ArrayValue = append(m.ArrayValue, v)
+					return err
+				})
+			})
+		case 6:
+			m.Type = KeyValueListValue
+			return unmarshalMessage(r, num, typ, func(b []byte) error {
+				return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+					if num != 1 {
+						return r.Skip(typ)
+					}
+					return unmarshalAttribute(r, num, typ, &m.KvlistValue, depth+1)
+				})
+			})
+		case 7:
+			m.Type = BytesValue
+			err := protowire.CheckType(num, typ, protowire.BytesType)
+			if err != nil {
+				return err
+			}
+			v, err := r.Bytes()
+			m.BytesValue = append([]byte(nil), v...)
+			return err
+		}
+		return r.Skip(typ)
+	})
+}
+
+func appendAttributes(b []byte, num int, kvs []KeyValue) []byte {
+	for i := range kvs {
+		b = protowire.AppendMessage(b, num, kvs[i].appendTo)
+	}
+	return b
+}
+
+// appendFixed64Field appends the field of a fixed64 value. Like proto3, zero
+// values are omitted.
+func appendFixed64Field(b []byte, num int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, v)
+}
+
+func unmarshalFields(b []byte, f func(r *protowire.Reader, num int, typ protowire.Type) error) error {
+	r := protowire.NewReader(b)
+	for !r.Done() {
+		num, typ, err := r.Next()
+		if err != nil {
+			return err
+		}
+		err = f(r, num, typ)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unmarshalMessage(r *protowire.Reader, num int, typ protowire.Type, f func([]byte) error) error {
+	err := protowire.CheckType(num, typ, protowire.BytesType)
+	if err != nil {
+		return err
+	}
+	b, err := r.Bytes()
+	if err != nil {
+		return err
+	}
+	err = f(b)
+	if err != nil {
+		return fmt.Errorf("field %d: %v", num, err)
+	}
+	return nil
+}
+
+func unmarshalAttribute(r *protowire.Reader, num int, typ protowire.Type, kvs *[]KeyValue, depth int) error {
+	var kv KeyValue
+	err := unmarshalMessage(r, num, typ, func(b []byte) error {
+		return kv.unmarshal(b, depth)
+	})
+	*kvs = append(*kvs, kv)
+	return err
+}
+
+func unmarshalString(r *protowire.Reader, num int, typ protowire.Type, v *string) error {
+	err := protowire.CheckType(num, typ, protowire.BytesType)
+	if err != nil {
+		return err
+	}
+	*v, err = r.String()
+	return err
+}
+
+func unmarshalVarint(r *protowire.Reader, num int, typ protowire.Type) (uint64, error) {
+	err := protowire.CheckType(num, typ, protowire.VarintType)
+	if err != nil {
+		return 0, err
+	}
+	return r.Varint()
+}
+
+func unmarshalFixed64(r *protowire.Reader, num int, typ protowire.Type, v *uint64) error {
+	err := protowire.CheckType(num, typ, protowire.Fixed64Type)
+	if err != nil {
+		return err
+	}
+	*v, err = r.Fixed64()
+	return err
+}
+
+func unmarshalDouble(r *protowire.Reader, num int, typ protowire.Type, v *float64) error {
+	err := protowire.CheckType(num, typ, protowire.Fixed64Type)
+	if err != nil {
+		return err
+	}
+	*v, err = r.Double()
+	return err
+}
+
+// unmarshalRepeatedFixed64 reads a packed or unpacked element of a repeated
+// fixed64 or double field.
+func unmarshalRepeatedFixed64(r *protowire.Reader, num int, typ protowire.Type, f func(v uint64)) error {
+	if typ == protowire.Fixed64Type {
+		v, err := r.Fixed64()
+		if err != nil {
+			return err
+		}
+		f(v)
+		return nil
+	}
+	err := protowire.CheckType(num, typ, protowire.BytesType)
+	if err != nil {
+		return err
+	}
+	b, err := r.Bytes()
+	if err != nil {
+		return err
+	}
+	if len(b)%8 != 0 {
+		return fmt.Errorf("field %d: packed fixed64 length %d is not a multiple of 8", num, len(b))
+	}
+	pr := protowire.NewReader(b)
+	for !pr.Done() {
+		v, err := pr.Fixed64()
+		if err != nil {
+			return err
+		}
+		f(v)
+	}
+	return nil
+}
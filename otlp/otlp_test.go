@@ -0,0 +1,337 @@
+package otlp_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/internal/protowire"
+	"github.com/hnakamur/timeseries/labels"
+	"github.com/hnakamur/timeseries/otlp"
+	"github.com/hnakamur/timeseries/store"
+)
+
+const t0 = 1700000000
+
+func ns(sec uint64) uint64 { return sec * 1e9 }
+
+func str(k, v string) otlp.KeyValue {
+	return otlp.KeyValue{Key: k, Value: otlp.AnyValue{Type: otlp.StringValue, StringValue: v}}
+}
+
+func request(metrics ...otlp.Metric) *otlp.ExportMetricsServiceRequest {
+	return &otlp.ExportMetricsServiceRequest{ResourceMetrics: []otlp.ResourceMetrics{{
+		Resource: otlp.Resource{Attributes: []otlp.KeyValue{str("service.name", "api"), str("host.name", "web01")}},
+		ScopeMetrics: []otlp.ScopeMetrics{{
+			Scope:   otlp.InstrumentationScope{Name: "lib", Version: "1.0", Attributes: []otlp.KeyValue{str("host.name", "web02")}},
+			Metrics: metrics,
+		}},
+	}}}
+}
+
+func TestMarshalUnmarshal(t *testing.T) {
+	req := request(
+		otlp.Metric{Name: "g", Unit: "1", Gauge: &otlp.Gauge{DataPoints: []otlp.NumberDataPoint{
+			{TimeUnixNano: ns(t0), AsDouble: 0, Attributes: []otlp.KeyValue{
+				{Key: "b", Value: otlp.AnyValue{Type: otlp.BoolValue, BoolValue: false}},
+				{Key: "i", Value: otlp.AnyValue{Type: otlp.IntValue, IntValue: -3}},
+				{Key: "d", Value: otlp.AnyValue{Type: otlp.DoubleValue, DoubleValue: 1.5}},
+				{Key: "a", Value: otlp.AnyValue{Type: otlp.ArrayValue, ArrayValue: []otlp.AnyValue{{Type: otlp.StringValue, StringValue: "x"}}}},
+				{Key: "k", Value: otlp.AnyValue{Type: otlp.KeyValueListValue, KvlistValue: []otlp.KeyValue{str("y", "z")}}},
+				{Key: "by", Value: otlp.AnyValue{Type: otlp.BytesValue, BytesValue: []byte{1, 2}}},
+			}},
+			{TimeUnixNano: ns(t0), AsInt: -7, IsInt: true, Flags: otlp.FlagNoRecordedValue},
+		}}},
+		otlp.Metric{Name: "s", Sum: &otlp.Sum{AggregationTemporality: otlp.TemporalityDelta, IsMonotonic: true,
+			DataPoints: []otlp.NumberDataPoint{{StartTimeUnixNano: ns(t0 - 10), TimeUnixNano: ns(t0), AsInt: 0, IsInt: true}}}},
+		otlp.Metric{Name: "h", Histogram: &otlp.Histogram{AggregationTemporality: otlp.TemporalityCumulative,
+			DataPoints: []otlp.HistogramDataPoint{{TimeUnixNano: ns(t0), Count: 3, Sum: 0, HasSum: true,
+				BucketCounts: []uint64{1, 2}, ExplicitBounds: []float64{0.5}}}}},
+		otlp.Metric{Name: "q", Summary: &otlp.Summary{DataPoints: []otlp.SummaryDataPoint{{TimeUnixNano: ns(t0), Count: 2, Sum: 3,
+			QuantileValues: []otlp.ValueAtQuantile{{Quantile: 0.5, Value: 1}}}}}},
+	)
+	var got otlp.ExportMetricsServiceRequest
+	err := got.Unmarshal(req.Marshal())
+	if err != nil {
+		t.Fatalf("failed to unmarshal: err=%+v", err)
+	}
+	if !reflect.DeepEqual(&got, req) {
+		t.Errorf("got=%+v, want=%+v", got, req)
+	}
+
+	resp := otlp.ExportMetricsServiceResponse{PartialSuccess: &otlp.ExportMetricsPartialSuccess{RejectedDataPoints: 2, ErrorMessage: "bad"}}
+	var gotResp otlp.ExportMetricsServiceResponse
+	err = gotResp.Unmarshal(resp.Marshal())
+	if err != nil {
+		t.Fatalf("failed to unmarshal response: err=%+v", err)
+	}
+	if !reflect.DeepEqual(gotResp, resp) {
+		t.Errorf("got=%+v, want=%+v", gotResp, resp)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	s, err := store.New(store.Options{})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+	rc := otlp.NewReceiver(s)
+
+	export := func(req *otlp.ExportMetricsServiceRequest) {
+		t.Helper()
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(req.Marshal())
+		zw.Close()
+		hr := httptest.NewRequest(http.MethodPost, "/v1/metrics", &buf)
+		hr.Header.Set("Content-Type", "application/x-protobuf")
+		hr.Header.Set("Content-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		rc.ServeHTTP(rec, hr)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("code got=%d, want=%d, body=%s", rec.Code, http.StatusOK, rec.Body)
+		}
+		var resp otlp.ExportMetricsServiceResponse
+		err := resp.Unmarshal(rec.Body.Bytes())
+		if err != nil {
+			t.Fatalf("failed to unmarshal response: err=%+v", err)
+		}
+		if resp.PartialSuccess != nil {
+			t.Fatalf("got partial success %+v", resp.PartialSuccess)
+		}
+	}
+	for i := uint64(0); i < 2; i++ {
+		export(request(
+			otlp.Metric{Name: "system.memory.usage", Gauge: &otlp.Gauge{DataPoints: []otlp.NumberDataPoint{
+				{TimeUnixNano: ns(t0+60*i) + 5e8, AsInt: int64(100 + i), IsInt: true, Attributes: []otlp.KeyValue{str("state", "used")}},
+			}}},
+			otlp.Metric{Name: "http.requests", Sum: &otlp.Sum{AggregationTemporality: otlp.TemporalityCumulative, IsMonotonic: true,
+				DataPoints: []otlp.NumberDataPoint{{TimeUnixNano: ns(t0 + 60*i), AsDouble: float64(10 * (i + 1))}}}},
+			otlp.Metric{Name: "jobs.done", Sum: &otlp.Sum{AggregationTemporality: otlp.TemporalityDelta, IsMonotonic: true,
+				DataPoints: []otlp.NumberDataPoint{{TimeUnixNano: ns(t0 + 60*i), AsInt: 3, IsInt: true}}}},
+			otlp.Metric{Name: "latency", Histogram: &otlp.Histogram{AggregationTemporality: otlp.TemporalityDelta,
+				DataPoints: []otlp.HistogramDataPoint{{TimeUnixNano: ns(t0 + 60*i), Count: 3, Sum: 1.5, HasSum: true,
+					BucketCounts: []uint64{1, 2, 0}, ExplicitBounds: []float64{0.1, 1}}}}},
+			otlp.Metric{Name: "rpc.duration", Summary: &otlp.Summary{DataPoints: []otlp.SummaryDataPoint{{TimeUnixNano: ns(t0 + 60*i),
+				Count: 4, Sum: 2, QuantileValues: []otlp.ValueAtQuantile{{Quantile: 0.99, Value: 0.9}}}}}},
+		))
+	}
+
+	common := []string{"host_name", "web02", "otel_scope_name", "lib", "otel_scope_version", "1.0", "service_name", "api"}
+	ls := func(ss ...string) string {
+		return labels.FromStrings(append(ss, common...)...).String()
+	}
+	testCases := []struct {
+		key  string
+		want []float64
+	}{
+		{key: ls(labels.MetricName, "system_memory_usage", "state", "used"), want: []float64{100, 101}},
+		{key: ls(labels.MetricName, "http_requests"), want: []float64{10, 20}},
+		{key: ls(labels.MetricName, "jobs_done"), want: []float64{3, 6}},
+		{key: ls(labels.MetricName, "latency_bucket", "le", "0.1"), want: []float64{1, 2}},
+		{key: ls(labels.MetricName, "latency_bucket", "le", "1"), want: []float64{3, 6}},
+		{key: ls(labels.MetricName, "latency_bucket", "le", "+Inf"), want: []float64{3, 6}},
+		{key: ls(labels.MetricName, "latency_count"), want: []float64{3, 6}},
+		{key: ls(labels.MetricName, "latency_sum"), want: []float64{1.5, 3}},
+		{key: ls(labels.MetricName, "rpc_duration", "quantile", "0.99"), want: []float64{0.9, 0.9}},
+		{key: ls(labels.MetricName, "rpc_duration_count"), want: []float64{4, 4}},
+		{key: ls(labels.MetricName, "rpc_duration_sum"), want: []float64{2, 2}},
+	}
+	for _, tc := range testCases {
+		got, err := s.Query(tc.key, 0, t0+3600)
+		if err != nil {
+			t.Errorf("failed to query: key=%s, err=%+v", tc.key, err)
+			continue
+		}
+		want := []timeseries.Point{{Timestamp: t0, Value: tc.want[0]}, {Timestamp: t0 + 60, Value: tc.want[1]}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("key=%s, got=%+v, want=%+v", tc.key, got, want)
+		}
+	}
+}
+
+func TestExportPartialSuccess(t *testing.T) {
+	s, err := store.New(store.Options{})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+	rc := otlp.NewReceiver(s)
+
+	// An exponential histogram with two data points, constructed by hand
+	// since the type is not supported.
+	expHist := protowire.AppendStringField(nil, 1, "exp")
+	expHist = protowire.AppendMessage(expHist, 10, func(b []byte) []byte {
+		b = protowire.AppendMessage(b, 1, func(b []byte) []byte { return b })
+		return protowire.AppendMessage(b, 1, func(b []byte) []byte { return b })
+	})
+	req := request(
+		otlp.Metric{Name: "ok", Gauge: &otlp.Gauge{DataPoints: []otlp.NumberDataPoint{{TimeUnixNano: ns(t0), AsDouble: 1}}}},
+		otlp.Metric{Name: "no_time", Gauge: &otlp.Gauge{DataPoints: []otlp.NumberDataPoint{{AsDouble: 1}}}},
+		otlp.Metric{Name: "unspecified", Sum: &otlp.Sum{DataPoints: []otlp.NumberDataPoint{{TimeUnixNano: ns(t0)}, {TimeUnixNano: ns(t0)}}}},
+		otlp.Metric{Name: "buckets", Histogram: &otlp.Histogram{AggregationTemporality: otlp.TemporalityCumulative,
+			DataPoints: []otlp.HistogramDataPoint{{TimeUnixNano: ns(t0), BucketCounts: []uint64{1}, ExplicitBounds: []float64{1}}}}},
+		otlp.Metric{Name: "no_buckets", Histogram: &otlp.Histogram{AggregationTemporality: otlp.TemporalityCumulative,
+			DataPoints: []otlp.HistogramDataPoint{{TimeUnixNano: ns(t0), ExplicitBounds: []float64{1, 2}}}}},
+		otlp.Metric{Gauge: &otlp.Gauge{DataPoints: []otlp.NumberDataPoint{{TimeUnixNano: ns(t0)}}}},
+		otlp.Metric{Name: "stale", Gauge: &otlp.Gauge{DataPoints: []otlp.NumberDataPoint{{TimeUnixNano: ns(t0), Flags: otlp.FlagNoRecordedValue}}}},
+	)
+	// Append a resource with the exponential histogram to the request.
+	scope := protowire.AppendMessage(nil, 2, func(b []byte) []byte { return append(b, expHist...) })
+	b := protowire.AppendMessage(req.Marshal(), 1, func(b []byte) []byte {
+		return protowire.AppendMessage(b, 2, func(b []byte) []byte { return append(b, scope...) })
+	})
+	var decoded otlp.ExportMetricsServiceRequest
+	err = decoded.Unmarshal(b)
+	if err != nil {
+		t.Fatalf("failed to unmarshal: err=%+v", err)
+	}
+
+	resp := rc.Export(&decoded)
+	want := &otlp.ExportMetricsPartialSuccess{
+		RejectedDataPoints: 8,
+		ErrorMessage:       `metric "no_time": missing timestamp (and 7 more rejected data points)`,
+	}
+	if !reflect.DeepEqual(resp.PartialSuccess, want) {
+		t.Errorf("got=%+v, want=%+v", resp.PartialSuccess, want)
+	}
+	if got := len(s.Keys()); got != 1 {
+		t.Errorf("got %d series, want 1", got)
+	}
+}
+
+func TestTotalsTTL(t *testing.T) {
+	s, err := store.New(store.Options{})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+	rc := otlp.NewReceiver(s)
+	now := time.Unix(t0, 0)
+	rc.Now = func() time.Time { return now }
+
+	for i, d := range []time.Duration{0, 30 * time.Minute, 2 * time.Hour} {
+		now = now.Add(d)
+		resp := rc.Export(request(otlp.Metric{Name: "jobs", Sum: &otlp.Sum{AggregationTemporality: otlp.TemporalityDelta,
+			DataPoints: []otlp.NumberDataPoint{{TimeUnixNano: ns(t0 + 60*uint64(i)), AsDouble: 3}}}}))
+		if resp.PartialSuccess != nil {
+			t.Fatalf("got partial success %+v", resp.PartialSuccess)
+		}
+	}
+	key := labels.FromStrings(labels.MetricName, "jobs", "host_name", "web02", "otel_scope_name", "lib",
+		"otel_scope_version", "1.0", "service_name", "api").String()
+	got, err := s.Query(key, 0, t0+3600)
+	if err != nil {
+		t.Fatalf("failed to query: err=%+v", err)
+	}
+	want := []timeseries.Point{{Timestamp: t0, Value: 3}, {Timestamp: t0 + 60, Value: 6}, {Timestamp: t0 + 120, Value: 3}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got=%+v, want=%+v", got, want)
+	}
+}
+
+// nested returns a request with a data point attribute whose value is an
+// array or a key value list nested depth times.
+func nested(depth int, kvlist bool) *otlp.ExportMetricsServiceRequest {
+	v := otlp.AnyValue{Type: otlp.StringValue, StringValue: "x"}
+	for i := 0; i < depth; i++ {
+		if kvlist {
+			v = otlp.AnyValue{Type: otlp.KeyValueListValue, KvlistValue: []otlp.KeyValue{{Key: "k", Value: v}}}
+		} else {
+			v = otlp.AnyValue{Type: otlp.ArrayValue, ArrayValue: []otlp.AnyValue{v}}
+		}
+	}
+	return request(otlp.Metric{Name: "g", Gauge: &otlp.Gauge{DataPoints: []otlp.NumberDataPoint{
+		{TimeUnixNano: ns(t0), AsDouble: 1, Attributes: []otlp.KeyValue{{Key: "a", Value: v}}},
+	}}})
+}
+
+func TestUnmarshalNestedValue(t *testing.T) {
+	testCases := []struct {
+		name    string
+		depth   int
+		kvlist  bool
+		wantErr bool
+	}{
+		{name: "array", depth: 63},
+		{name: "array too deep", depth: 64, wantErr: true},
+		{name: "kvlist", depth: 63, kvlist: true},
+		{name: "kvlist too deep", depth: 64, kvlist: true, wantErr: true},
+		{name: "array very deep", depth: 5000, wantErr: true},
+	}
+	for _, tc := range testCases {
+		var req otlp.ExportMetricsServiceRequest
+		err := req.Unmarshal(nested(tc.depth, tc.kvlist).Marshal())
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%s: got err=%v, wantErr=%v", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestServeHTTPError(t *testing.T) {
+	testCases := []struct {
+		name        string
+		method      string
+		contentType string
+		encoding    string
+		body        string
+		wantCode    int
+	}{
+		{name: "method", method: http.MethodGet, contentType: "application/x-protobuf", wantCode: http.StatusMethodNotAllowed},
+		{name: "json", method: http.MethodPost, contentType: "application/json", body: "{}", wantCode: http.StatusUnsupportedMediaType},
+		{name: "encoding", method: http.MethodPost, contentType: "application/x-protobuf", encoding: "br", wantCode: http.StatusBadRequest},
+		{name: "gzip", method: http.MethodPost, contentType: "application/x-protobuf", encoding: "gzip", body: "abc", wantCode: http.StatusBadRequest},
+		{name: "protobuf", method: http.MethodPost, contentType: "application/x-protobuf", body: "\x0a\x05", wantCode: http.StatusBadRequest},
+	}
+	s, err := store.New(store.Options{})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+	rc := otlp.NewReceiver(s)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/v1/metrics", bytes.NewReader([]byte(tc.body)))
+			req.Header.Set("Content-Type", tc.contentType)
+			if tc.encoding != "" {
+				req.Header.Set("Content-Encoding", tc.encoding)
+			}
+			rec := httptest.NewRecorder()
+			rc.ServeHTTP(rec, req)
+			if rec.Code != tc.wantCode {
+				t.Errorf("code got=%d, want=%d", rec.Code, tc.wantCode)
+			}
+		})
+	}
+}
+
+func FuzzExport(f *testing.F) {
+	f.Add(request(
+		otlp.Metric{Name: "g", Gauge: &otlp.Gauge{DataPoints: []otlp.NumberDataPoint{{TimeUnixNano: ns(t0), AsDouble: 1}}}},
+		otlp.Metric{Name: "s", Sum: &otlp.Sum{AggregationTemporality: otlp.TemporalityDelta,
+			DataPoints: []otlp.NumberDataPoint{{TimeUnixNano: ns(t0), AsInt: 3, IsInt: true}}}},
+		otlp.Metric{Name: "h", Histogram: &otlp.Histogram{AggregationTemporality: otlp.TemporalityCumulative,
+			DataPoints: []otlp.HistogramDataPoint{{TimeUnixNano: ns(t0), Count: 3, BucketCounts: []uint64{1, 2}, ExplicitBounds: []float64{0.5}}}}},
+		otlp.Metric{Name: "q", Summary: &otlp.Summary{DataPoints: []otlp.SummaryDataPoint{{TimeUnixNano: ns(t0),
+			Count: 1, QuantileValues: []otlp.ValueAtQuantile{{Quantile: 0.5, Value: 1}}}}}},
+	).Marshal())
+	f.Add(nested(100, false).Marshal())
+	f.Add(nested(100, true).Marshal())
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var req otlp.ExportMetricsServiceRequest
+		if req.Unmarshal(b) != nil {
+			return
+		}
+		s, err := store.New(store.Options{})
+		if err != nil {
+			t.Fatalf("failed to create store: err=%+v", err)
+		}
+		otlp.NewReceiver(s).Export(&req)
+		var again otlp.ExportMetricsServiceRequest
+		if err := again.Unmarshal(req.Marshal()); err != nil {
+			t.Errorf("failed to unmarshal marshaled request: err=%+v", err)
+		}
+	})
+}
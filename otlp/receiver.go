@@ -0,0 +1,476 @@
+// Package otlp implements a receiver of OpenTelemetry metrics over OTLP/HTTP
+// with protocol buffers which appends data points to a store.
+package otlp
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/labels"
+	"github.com/hnakamur/timeseries/store"
+)
+
+const (
+	// maxBodySize is the maximum size of a request body.
+	maxBodySize = 32 << 20
+	// maxDecodedSize is the maximum size of a decompressed request body.
+	maxDecodedSize = 256 << 20
+)
+
+// Receiver receives metrics and appends their data points to an appender.
+//
+// Metric names and attribute keys are converted to Prometheus-style names
+// by replacing unsupported characters with underscores. The labels of a
+// data point are the resource attributes, the scope attributes, the scope
+// name and version as otel_scope_name and otel_scope_version, and the data
+// point attributes, where later ones take precedence.
+//
+// The series of the metric types are:
+//
+//	gauge:     name
+//	sum:       name
+//	histogram: name_bucket{le="..."}, name_count and name_sum
+//	summary:   name{quantile="..."}, name_count and name_sum
+//
+// Values of delta sums and histograms are accumulated into cumulative ones
+// since the receiver was created. The total of a series which has not been
+// updated for TotalsTTL is discarded, so that the next delta starts from
+// zero. Timestamps are truncated to seconds.
+// Data points with the no recorded value flag are skipped, and exponential
+// histograms are rejected.
+type Receiver struct {
+	// TotalsTTL is the duration after which the total of a delta series
+	// which has not been updated is discarded.
+	TotalsTTL time.Duration
+	// Now returns the current time, which is used for TotalsTTL.
+	Now func() time.Time
+
+	app store.LabelsAppender
+
+	mu     sync.Mutex
+	totals map[string]*total
+	swept  time.Time
+}
+
+// DefaultTotalsTTL is the default of Receiver.TotalsTTL.
+const DefaultTotalsTTL = time.Hour
+
+type total struct {
+	value   float64
+	updated time.Time
+}
+
+// NewReceiver creates a receiver which appends data points to the appender.
+func NewReceiver(app store.LabelsAppender) *Receiver {
+	return &Receiver{
+		TotalsTTL: DefaultTotalsTTL,
+		Now:       time.Now,
+		app:       app,
+		totals:    make(map[string]*total),
+	}
+}
+
+// Export appends the data points of the request. Data points which failed
+// are reported in the partial success of the response.
+func (rc *Receiver) Export(req *ExportMetricsServiceRequest) *ExportMetricsServiceResponse {
+	var rej rejections
+	for i := range req.ResourceMetrics {
+		rm := &req.ResourceMetrics[i]
+		resource := make(map[string]string)
+		addAttributes(resource, rm.Resource.Attributes)
+		for j := range rm.ScopeMetrics {
+			sm := &rm.ScopeMetrics[j]
+			scope := make(map[string]string, len(resource))
+			for k, v := range resource {
+				scope[k] = v
+			}
+			addAttributes(scope, sm.Scope.Attributes)
+			scope["otel_scope_name"] = sm.Scope.Name
+			scope["otel_scope_version"] = sm.Scope.Version
+			for k := range sm.Metrics {
+				rc.exportMetric(scope, &sm.Metrics[k], &rej)
+			}
+		}
+	}
+
+	resp := &ExportMetricsServiceResponse{}
+	if rej.count > 0 {
+		resp.PartialSuccess = &ExportMetricsPartialSuccess{
+			RejectedDataPoints: rej.count,
+			ErrorMessage:       rej.message(),
+		}
+	}
+	return resp
+}
+
+// rejections is the rejected data points of a request.
+type rejections struct {
+	count int64
+	first string
+}
+
+func (r *rejections) add(n int, metric string, err error) {
+	if n == 0 {
+		return
+	}
+	if r.count == 0 {
+		r.first = fmt.Sprintf("metric %q: %v", metric, err)
+	}
+	r.count += int64(n)
+}
+
+// addErr adds a rejected data point if err is not nil.
+func (r *rejections) addErr(metric string, err error) {
+	if err != nil {
+		r.add(1, metric, err)
+	}
+}
+
+func (r *rejections) message() string {
+	if r.count == 1 {
+		return r.first
+	}
+	return fmt.Sprintf("%s (and %d more rejected data points)", r.first, r.count-1)
+}
+
+func (rc *Receiver) exportMetric(scope map[string]string, m *Metric, rej *rejections) {
+	name := sanitizeName(m.Name, true)
+	if name == "" {
+		rej.add(m.numDataPoints(), m.Name, errors.New("missing metric name"))
+		return
+	}
+
+	switch {
+	case m.Gauge != nil:
+		for i := range m.Gauge.DataPoints {
+			dp := &m.Gauge.DataPoints[i]
+			if dp.Flags&FlagNoRecordedValue != 0 {
+				continue
+			}
+			ls := pointLabels(scope, dp.Attributes, name)
+			rej.addErr(m.Name, rc.append(dp.TimeUnixNano, false, sample{ls, dp.Value()}))
+		}
+	case m.Sum != nil:
+		delta, err := isDelta(m.Sum.AggregationTemporality)
+		if err != nil {
+			rej.add(len(m.Sum.DataPoints), m.Name, err)
+			return
+		}
+		for i := range m.Sum.DataPoints {
+			dp := &m.Sum.DataPoints[i]
+			if dp.Flags&FlagNoRecordedValue != 0 {
+				continue
+			}
+			ls := pointLabels(scope, dp.Attributes, name)
+			rej.addErr(m.Name, rc.append(dp.TimeUnixNano, delta, sample{ls, dp.Value()}))
+		}
+	case m.Histogram != nil:
+		delta, err := isDelta(m.Histogram.AggregationTemporality)
+		if err != nil {
+			rej.add(len(m.Histogram.DataPoints), m.Name, err)
+			return
+		}
+		for i := range m.Histogram.DataPoints {
+			dp := &m.Histogram.DataPoints[i]
+			if dp.Flags&FlagNoRecordedValue != 0 {
+				continue
+			}
+			samples, err := histogramSamples(scope, name, dp)
+			if err == nil {
+				err = rc.append(dp.TimeUnixNano, delta, samples...)
+			}
+			rej.addErr(m.Name, err)
+		}
+	case m.Summary != nil:
+		for i := range m.Summary.DataPoints {
+			dp := &m.Summary.DataPoints[i]
+			if dp.Flags&FlagNoRecordedValue != 0 {
+				continue
+			}
+			rej.addErr(m.Name, rc.append(dp.TimeUnixNano, false, summarySamples(scope, name, dp)...))
+		}
+	case m.unsupported > 0:
+		rej.add(m.unsupported, m.Name, errors.New("unsupported metric type"))
+	}
+}
+
+func (m *Metric) numDataPoints() int {
+	switch {
+	case m.Gauge != nil:
+		return len(m.Gauge.DataPoints)
+	case m.Sum != nil:
+		return len(m.Sum.DataPoints)
+	case m.Histogram != nil:
+		return len(m.Histogram.DataPoints)
+	case m.Summary != nil:
+		return len(m.Summary.DataPoints)
+	}
+	return m.unsupported
+}
+
+func isDelta(t AggregationTemporality) (bool, error) {
+	switch t {
+	case TemporalityDelta:
+		return true, nil
+	case TemporalityCumulative:
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid aggregation temporality %d", t)
+}
+
+type sample struct {
+	labels labels.Labels
+	value  float64
+}
+
+func histogramSamples(scope map[string]string, name string, dp *HistogramDataPoint) ([]sample, error) {
+	if (len(dp.BucketCounts) > 0 || len(dp.ExplicitBounds) > 0) && len(dp.BucketCounts) != len(dp.ExplicitBounds)+1 {
+		return nil, fmt.Errorf("bucket counts length %d does not match explicit bounds length %d",
+			len(dp.BucketCounts), len(dp.ExplicitBounds))
+	}
+	samples := make([]sample, 0, len(dp.ExplicitBounds)+3)
+	bucket := func(le string, v float64) {
+		ls := pointLabels(scope, dp.Attributes, name+"_bucket", labels.Label{Name: "le", Value: le})
+		samples = append(samples, sample{ls, v})
+	}
+	var count uint64
+	for i, b := range dp.ExplicitBounds {
+		count += dp.BucketCounts[i]
+		bucket(formatFloat(b), float64(count))
+	}
+	bucket("+Inf", float64(dp.Count))
+	samples = append(samples, sample{pointLabels(scope, dp.Attributes, name+"_count"), float64(dp.Count)})
+	if dp.HasSum {
+		samples = append(samples, sample{pointLabels(scope, dp.Attributes, name+"_sum"), dp.Sum})
+	}
+	return samples, nil
+}
+
+func summarySamples(scope map[string]string, name string, dp *SummaryDataPoint) []sample {
+	samples := make([]sample, 0, len(dp.QuantileValues)+2)
+	for _, q := range dp.QuantileValues {
+		ls := pointLabels(scope, dp.Attributes, name, labels.Label{Name: "quantile", Value: formatFloat(q.Quantile)})
+		samples = append(samples, sample{ls, q.Value})
+	}
+	samples = append(samples,
+		sample{pointLabels(scope, dp.Attributes, name+"_count"), float64(dp.Count)},
+		sample{pointLabels(scope, dp.Attributes, name+"_sum"), dp.Sum})
+	return samples
+}
+
+// append appends the samples of a data point at the timestamp in
+// nanoseconds. If delta is true, the values are added to the totals of the
+// series.
+func (rc *Receiver) append(timeUnixNano uint64, delta bool, samples ...sample) error {
+	if timeUnixNano == 0 {
+		return errors.New("missing timestamp")
+	}
+	t := timeUnixNano / 1e9
+	if t > math.MaxUint32 {
+		return fmt.Errorf("timestamp out of range: %d", timeUnixNano)
+	}
+	if delta {
+		rc.accumulate(samples)
+	}
+	for _, s := range samples {
+		err := rc.app.AppendLabels(s.labels, timeseries.Point{Timestamp: uint32(t), Value: s.value})
+		if err != nil {
+			return fmt.Errorf("failed to append point: labels=%s, err=%+v", s.labels, err)
+		}
+	}
+	return nil
+}
+
+// accumulate adds the values of the samples to the totals of the series and
+// replaces the values with the totals.
+func (rc *Receiver) accumulate(samples []sample) {
+	now := rc.Now()
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if now.Sub(rc.swept) >= rc.TotalsTTL {
+		for key, t := range rc.totals {
+			if now.Sub(t.updated) >= rc.TotalsTTL {
+				delete(rc.totals, key)
+			}
+		}
+		rc.swept = now
+	}
+	for i := range samples {
+		key := samples[i].labels.String()
+		t, ok := rc.totals[key]
+		if !ok {
+			t = &total{}
+			rc.totals[key] = t
+		}
+		t.value += samples[i].value
+		t.updated = now
+		samples[i].value = t.value
+	}
+}
+
+func pointLabels(scope map[string]string, attrs []KeyValue, name string, extra ...labels.Label) labels.Labels {
+	ls := make([]labels.Label, 0, len(scope)+len(attrs)+len(extra)+1)
+	m := make(map[string]string, len(attrs))
+	addAttributes(m, attrs)
+	for k, v := range scope {
+		if _, ok := m[k]; !ok {
+			ls = append(ls, labels.Label{Name: k, Value: v})
+		}
+	}
+	for k, v := range m {
+		ls = append(ls, labels.Label{Name: k, Value: v})
+	}
+	ls = append(ls, extra...)
+	ls = append(ls, labels.Label{Name: labels.MetricName, Value: name})
+	return labels.New(ls...)
+}
+
+func addAttributes(m map[string]string, attrs []KeyValue) {
+	for i := range attrs {
+		name := sanitizeName(attrs[i].Key, false)
+		if name == "" || name == labels.MetricName {
+			continue
+		}
+		m[name] = attrs[i].Value.String()
+	}
+}
+
+// String returns the value as a string. Arrays and key value lists are
+// formatted in JSON.
+func (v *AnyValue) String() string {
+	switch v.Type {
+	case StringValue:
+		return v.StringValue
+	case ArrayValue, KeyValueListValue:
+		b, _ := json.Marshal(v.jsonValue())
+		return string(b)
+	}
+	return fmt.Sprint(v.jsonValue())
+}
+
+func (v *AnyValue) jsonValue() interface{} {
+	switch v.Type {
+	case StringValue:
+		return v.StringValue
+	case BoolValue:
+		return v.BoolValue
+	case IntValue:
+		return v.IntValue
+	case DoubleValue:
+		return formatFloat(v.DoubleValue)
+	case ArrayValue:
+		a := make([]interface{}, len(v.ArrayValue))
+		for i := range v.ArrayValue {
+			a[i] = v.ArrayValue[i].jsonValue()
+		}
+		return a
+	case KeyValueListValue:
+		m := make(map[string]interface{}, len(v.KvlistValue))
+		for i := range v.KvlistValue {
+			m[v.KvlistValue[i].Key] = v.KvlistValue[i].Value.jsonValue()
+		}
+		return m
+	case BytesValue:
+		return base64.StdEncoding.EncodeToString(v.BytesValue)
+	}
+	return ""
+}
+
+// sanitizeName replaces characters other than ASCII letters, digits and
+// underscores, and colons for metric names, with underscores, and prefixes
+// an underscore to a name starting with a digit.
+func sanitizeName(s string, metric bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':' && metric:
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// ServeHTTP receives an export request in the OTLP/HTTP binary protobuf
+// encoding, optionally compressed with gzip.
+func (rc *Receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/x-protobuf" {
+		http.Error(w, fmt.Sprintf("unsupported content type %q", mediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+	b, err := readBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req ExportMetricsServiceRequest
+	err = req.Unmarshal(b)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to unmarshal export request: %v", err), http.StatusBadRequest)
+		return
+	}
+	resp := rc.Export(&req)
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(resp.Marshal())
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	var body io.Reader = io.LimitReader(r.Body, maxBodySize+1)
+	limit := int64(maxBodySize)
+	switch enc := r.Header.Get("Content-Encoding"); enc {
+	case "", "identity":
+	case "gzip":
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress request body: %v", err)
+		}
+		defer zr.Close()
+		body = io.LimitReader(zr, maxDecodedSize+1)
+		limit = maxDecodedSize
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", enc)
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %v", err)
+	}
+	if int64(len(b)) > limit {
+		return nil, errors.New("request body too large")
+	}
+	return b, nil
+}
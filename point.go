@@ -20,7 +20,8 @@ type Point struct {
 // Marshal encodes a block timestamp and data points to bytes.
 func Marshal(t0 uint32, points []Point) ([]byte, error) {
 	var b bytes.Buffer
-	enc := NewEncoder(&b)
+	enc := GetEncoder(&b)
+	defer PutEncoder(enc)
 	err := enc.EncodeHeader(t0)
 	if err != nil {
 		return nil, fmt.Errorf("failed to encode time series header: err=%+v", err)
@@ -44,7 +45,8 @@ func Marshal(t0 uint32, points []Point) ([]byte, error) {
 // Unmarshal decodes bytes to a block timestamp and data points.
 func Unmarshal(data []byte) (t0 uint32, points []Point, err error) {
 	b := bytes.NewBuffer(data)
-	dec := NewDecoder(b)
+	dec := GetDecoder(b)
+	defer PutDecoder(dec)
 
 	t0, err = dec.DecodeHeader()
 	if err != nil {
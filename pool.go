@@ -0,0 +1,48 @@
+package timeseries
+
+import (
+	"io"
+	"sync"
+)
+
+var encoderPool = sync.Pool{
+	New: func() interface{} {
+		return NewEncoder(nil)
+	},
+}
+
+var decoderPool = sync.Pool{
+	New: func() interface{} {
+		return NewDecoder(nil)
+	},
+}
+
+// GetEncoder returns an encoder from the package-level pool which writes to w.
+// Call PutEncoder to return the encoder to the pool when you are done with it.
+func GetEncoder(w io.Writer) *Encoder {
+	e := encoderPool.Get().(*Encoder)
+	e.Reset(w)
+	return e
+}
+
+// PutEncoder returns an encoder to the package-level pool.
+// The encoder must not be used after calling PutEncoder.
+func PutEncoder(e *Encoder) {
+	e.Reset(nil)
+	encoderPool.Put(e)
+}
+
+// GetDecoder returns a decoder from the package-level pool which reads from r.
+// Call PutDecoder to return the decoder to the pool when you are done with it.
+func GetDecoder(r io.Reader) *Decoder {
+	d := decoderPool.Get().(*Decoder)
+	d.Reset(r)
+	return d
+}
+
+// PutDecoder returns a decoder to the package-level pool.
+// The decoder must not be used after calling PutDecoder.
+func PutDecoder(d *Decoder) {
+	d.Reset(nil)
+	decoderPool.Put(d)
+}
@@ -0,0 +1,69 @@
+package promql
+
+import (
+	"time"
+
+	"github.com/hnakamur/timeseries/join"
+	"github.com/hnakamur/timeseries/labels"
+)
+
+// Expr is a node of a parsed query expression.
+type Expr interface {
+	expr()
+}
+
+// NumberLiteral is a number literal.
+type NumberLiteral struct {
+	Val float64
+}
+
+// VectorSelector selects an instant vector of series by label matchers.
+type VectorSelector struct {
+	Matchers []*labels.Matcher
+}
+
+// MatrixSelector selects a range vector of series by label matchers.
+type MatrixSelector struct {
+	Vector *VectorSelector
+	Range  time.Duration
+}
+
+// Call is a function call.
+type Call struct {
+	Func string
+	Args []Expr
+}
+
+// AggregateExpr is an aggregation over series in an instant vector.
+type AggregateExpr struct {
+	Op       string
+	Expr     Expr
+	Grouping []string
+	Without  bool
+}
+
+// BinaryExpr is a binary operation.
+type BinaryExpr struct {
+	Op  join.Op
+	LHS Expr
+	RHS Expr
+}
+
+// UnaryExpr is a negation.
+type UnaryExpr struct {
+	Expr Expr
+}
+
+// ParenExpr is an expression in parentheses.
+type ParenExpr struct {
+	Expr Expr
+}
+
+func (*NumberLiteral) expr()  {}
+func (*VectorSelector) expr() {}
+func (*MatrixSelector) expr() {}
+func (*Call) expr()           {}
+func (*AggregateExpr) expr()  {}
+func (*BinaryExpr) expr()     {}
+func (*UnaryExpr) expr()      {}
+func (*ParenExpr) expr()      {}
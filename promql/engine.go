@@ -0,0 +1,442 @@
+// Package promql implements a query engine for a subset of PromQL over the
+// series in a store: selectors with label matchers, range vectors, functions
+// such as rate and histogram_quantile, aggregations and binary operations.
+package promql
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/join"
+	"github.com/hnakamur/timeseries/labels"
+	"github.com/hnakamur/timeseries/store"
+)
+
+// DefaultLookbackDelta is the default of Engine.LookbackDelta.
+const DefaultLookbackDelta = 5 * time.Minute
+
+// Engine evaluates queries over the series in a store appended with
+// Store.AppendLabels.
+type Engine struct {
+	store *store.Store
+
+	// LookbackDelta is the maximum age of the data point of a series
+	// selected by a vector selector.
+	LookbackDelta time.Duration
+}
+
+// NewEngine creates an engine for the store.
+func NewEngine(s *store.Store) *Engine {
+	return &Engine{store: s, LookbackDelta: DefaultLookbackDelta}
+}
+
+// Instant evaluates the query at the timestamp t. The result is a Scalar,
+// a Vector or a Matrix.
+func (e *Engine) Instant(query string, t uint32) (Value, error) {
+	expr, err := Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	ev, err := e.newEvaluator(expr, t, t)
+	if err != nil {
+		return nil, err
+	}
+	v, err := ev.eval(expr, t)
+	if err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case Vector:
+		sortVector(v)
+	case Matrix:
+		sortMatrix(v)
+	}
+	return v, nil
+}
+
+// Range evaluates the query at each step from start to end inclusive and
+// returns the results as series. The query must result in a Scalar or a
+// Vector, and step must be a multiple of one second.
+func (e *Engine) Range(query string, start, end uint32, step time.Duration) (Matrix, error) {
+	if step < time.Second {
+		return nil, errors.New("step must be one second or longer")
+	}
+	if step%time.Second != 0 {
+		return nil, errors.New("step must be a multiple of one second")
+	}
+	if end < start {
+		return nil, errors.New("end must not be before start")
+	}
+	expr, err := Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	if typ := argType(expr); typ != ValueTypeScalar && typ != ValueTypeVector {
+		return nil, fmt.Errorf("range query must result in scalar or vector, got %s", typ)
+	}
+	ev, err := e.newEvaluator(expr, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make(map[string]int)
+	var res Matrix
+	add := func(ls labels.Labels, p timeseries.Point) {
+		key := ls.String()
+		i, ok := indexes[key]
+		if !ok {
+			i = len(res)
+			res = append(res, Series{Labels: ls})
+			indexes[key] = i
+		}
+		res[i].Points = append(res[i].Points, p)
+	}
+
+	stepSecs := uint64(step / time.Second)
+	for t := uint64(start); t <= uint64(end); t += stepSecs {
+		v, err := ev.eval(expr, uint32(t))
+		if err != nil {
+			return nil, err
+		}
+		switch v := v.(type) {
+		case Scalar:
+			add(labels.Labels{}, timeseries.Point{Timestamp: v.T, Value: v.V})
+		case Vector:
+			for _, s := range v {
+				add(s.Labels, timeseries.Point{Timestamp: s.T, Value: s.V})
+			}
+		}
+	}
+	sortMatrix(res)
+	return res, nil
+}
+
+// evaluator evaluates an expression with the data of the selectors fetched
+// from the store in advance.
+type evaluator struct {
+	lookbackDelta uint32
+	data          map[*VectorSelector][]store.Series
+}
+
+func (e *Engine) newEvaluator(expr Expr, start, end uint32) (*evaluator, error) {
+	ev := &evaluator{
+		lookbackDelta: uint32(e.LookbackDelta / time.Second),
+		data:          make(map[*VectorSelector][]store.Series),
+	}
+	var err error
+	fetch := func(vs *VectorSelector, lookback uint32) {
+		if err != nil {
+			return
+		}
+		from := uint32(0)
+		if start > lookback {
+			from = start - lookback
+		}
+		ev.data[vs], err = e.store.Select(from, end, vs.Matchers...)
+	}
+	walk(expr, func(expr Expr) {
+		switch expr := expr.(type) {
+		case *MatrixSelector:
+			fetch(expr.Vector, uint32(expr.Range/time.Second))
+		case *VectorSelector:
+			if _, ok := ev.data[expr]; !ok {
+				fetch(expr, ev.lookbackDelta)
+			}
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to select series: err=%+v", err)
+	}
+	return ev, nil
+}
+
+// walk calls f for the expression and its descendants, with a parent before
+// its children.
+func walk(expr Expr, f func(Expr)) {
+	f(expr)
+	switch expr := expr.(type) {
+	case *MatrixSelector:
+		// The vector selector is fetched with the range of the matrix
+		// selector.
+	case *Call:
+		for _, arg := range expr.Args {
+			walk(arg, f)
+		}
+	case *AggregateExpr:
+		walk(expr.Expr, f)
+	case *BinaryExpr:
+		walk(expr.LHS, f)
+		walk(expr.RHS, f)
+	case *UnaryExpr:
+		walk(expr.Expr, f)
+	case *ParenExpr:
+		walk(expr.Expr, f)
+	}
+}
+
+func (ev *evaluator) eval(expr Expr, t uint32) (Value, error) {
+	switch expr := expr.(type) {
+	case *NumberLiteral:
+		return Scalar{T: t, V: expr.Val}, nil
+	case *VectorSelector:
+		return ev.vectorSelector(expr, t), nil
+	case *MatrixSelector:
+		return ev.matrixSelector(expr, t), nil
+	case *ParenExpr:
+		return ev.eval(expr.Expr, t)
+	case *UnaryExpr:
+		v, err := ev.eval(expr.Expr, t)
+		if err != nil {
+			return nil, err
+		}
+		switch v := v.(type) {
+		case Scalar:
+			return Scalar{T: t, V: -v.V}, nil
+		case Vector:
+			res := make(Vector, len(v))
+			for i, s := range v {
+				res[i] = Sample{Labels: dropMetricName(s.Labels), T: t, V: -s.V}
+			}
+			return res, nil
+		}
+	case *Call:
+		return ev.call(expr, t)
+	case *AggregateExpr:
+		v, err := ev.eval(expr.Expr, t)
+		if err != nil {
+			return nil, err
+		}
+		return aggregate(expr, v.(Vector), t), nil
+	case *BinaryExpr:
+		lhs, err := ev.eval(expr.LHS, t)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := ev.eval(expr.RHS, t)
+		if err != nil {
+			return nil, err
+		}
+		return binary(expr.Op, lhs, rhs, t)
+	}
+	return nil, fmt.Errorf("unexpected expression %T", expr)
+}
+
+// vectorSelector returns the latest data point of each series within the
+// lookback delta before t.
+func (ev *evaluator) vectorSelector(vs *VectorSelector, t uint32) Vector {
+	var res Vector
+	for _, s := range ev.data[vs] {
+		i := sort.Search(len(s.Points), func(i int) bool {
+			return s.Points[i].Timestamp > t
+		})
+		if i == 0 {
+			continue
+		}
+		p := s.Points[i-1]
+		if t-p.Timestamp >= ev.lookbackDelta {
+			continue
+		}
+		res = append(res, Sample{Labels: s.Labels, T: t, V: p.Value})
+	}
+	return res
+}
+
+// matrixSelector returns the data points of each series in the range
+// (t - range, t].
+func (ev *evaluator) matrixSelector(ms *MatrixSelector, t uint32) Matrix {
+	var res Matrix
+	r := uint32(ms.Range / time.Second)
+	for _, s := range ev.data[ms.Vector] {
+		i := 0
+		if t > r {
+			i = sort.Search(len(s.Points), func(i int) bool {
+				return s.Points[i].Timestamp > t-r
+			})
+		}
+		j := sort.Search(len(s.Points), func(i int) bool {
+			return s.Points[i].Timestamp > t
+		})
+		if i < j {
+			res = append(res, Series{Labels: s.Labels, Points: s.Points[i:j]})
+		}
+	}
+	return res
+}
+
+func (ev *evaluator) call(c *Call, t uint32) (Value, error) {
+	args := make([]Value, len(c.Args))
+	start := t
+	for i, arg := range c.Args {
+		v, err := ev.eval(arg, t)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+		if ms, ok := arg.(*MatrixSelector); ok {
+			r := uint32(ms.Range / time.Second)
+			start = 0
+			if t > r {
+				start = t - r
+			}
+		}
+	}
+	return functions[c.Func].call(args, start, t)
+}
+
+func aggregate(a *AggregateExpr, v Vector, t uint32) Vector {
+	type group struct {
+		labels labels.Labels
+		value  float64
+		count  int
+	}
+	groups := make(map[string]*group)
+	var keys []string
+	for _, s := range v {
+		var ls labels.Labels
+		if a.Without {
+			ls = dropLabels(s.Labels, append(a.Grouping, labels.MetricName)...)
+		} else {
+			ls = keepLabels(s.Labels, a.Grouping...)
+		}
+		key := ls.String()
+		g, ok := groups[key]
+		if !ok {
+			g = &group{labels: ls, value: s.V}
+			groups[key] = g
+			keys = append(keys, key)
+		} else {
+			switch a.Op {
+			case "sum", "avg":
+				g.value += s.V
+			case "min":
+				if s.V < g.value || math.IsNaN(g.value) {
+					g.value = s.V
+				}
+			case "max":
+				if s.V > g.value || math.IsNaN(g.value) {
+					g.value = s.V
+				}
+			}
+		}
+		g.count++
+	}
+
+	res := make(Vector, 0, len(keys))
+	for _, key := range keys {
+		g := groups[key]
+		switch a.Op {
+		case "avg":
+			g.value /= float64(g.count)
+		case "count":
+			g.value = float64(g.count)
+		}
+		res = append(res, Sample{Labels: g.labels, T: t, V: g.value})
+	}
+	return res
+}
+
+// binary applies the operator to the operands. Samples of vectors are
+// matched one-to-one by their labels except the metric name. Arithmetic
+// operators drop the metric name, and comparison operators filter samples
+// of the vector operand.
+func binary(op join.Op, lhs, rhs Value, t uint32) (Value, error) {
+	switch l := lhs.(type) {
+	case Scalar:
+		switch r := rhs.(type) {
+		case Scalar:
+			if op.IsComparison() {
+				return nil, fmt.Errorf("comparison %s between scalars is not supported", op)
+			}
+			v, _ := op.Apply(l.V, r.V)
+			return Scalar{T: t, V: v}, nil
+		case Vector:
+			var res Vector
+			for _, s := range r {
+				v, keep := op.Apply(l.V, s.V)
+				if !keep {
+					continue
+				}
+				if op.IsComparison() {
+					res = append(res, Sample{Labels: s.Labels, T: t, V: s.V})
+				} else {
+					res = append(res, Sample{Labels: dropMetricName(s.Labels), T: t, V: v})
+				}
+			}
+			return res, nil
+		}
+	case Vector:
+		switch r := rhs.(type) {
+		case Scalar:
+			var res Vector
+			for _, s := range l {
+				v, keep := op.Apply(s.V, r.V)
+				if !keep {
+					continue
+				}
+				ls := s.Labels
+				if !op.IsComparison() {
+					ls = dropMetricName(ls)
+				}
+				res = append(res, Sample{Labels: ls, T: t, V: v})
+			}
+			return res, nil
+		case Vector:
+			right := make(map[string]Sample, len(r))
+			for _, s := range r {
+				key := dropMetricName(s.Labels).String()
+				if _, ok := right[key]; ok {
+					return nil, fmt.Errorf("found duplicate series for the match group %s on the right hand-side of the operation", key)
+				}
+				right[key] = s
+			}
+			var res Vector
+			matched := make(map[string]bool, len(l))
+			for _, s := range l {
+				key := dropMetricName(s.Labels).String()
+				rs, ok := right[key]
+				if !ok {
+					continue
+				}
+				if matched[key] {
+					return nil, fmt.Errorf("found duplicate series for the match group %s on the left hand-side of the operation", key)
+				}
+				matched[key] = true
+				v, keep := op.Apply(s.V, rs.V)
+				if !keep {
+					continue
+				}
+				ls := s.Labels
+				if !op.IsComparison() {
+					ls = dropMetricName(ls)
+				}
+				res = append(res, Sample{Labels: ls, T: t, V: v})
+			}
+			return res, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported operand types %s and %s for %s", lhs.Type(), rhs.Type(), op)
+}
+
+// argType returns the type of the value which the expression evaluates to.
+func argType(expr Expr) ValueType {
+	switch expr := expr.(type) {
+	case *NumberLiteral:
+		return ValueTypeScalar
+	case *MatrixSelector:
+		return ValueTypeMatrix
+	case *BinaryExpr:
+		if argType(expr.LHS) == ValueTypeScalar && argType(expr.RHS) == ValueTypeScalar {
+			return ValueTypeScalar
+		}
+		return ValueTypeVector
+	case *UnaryExpr:
+		return argType(expr.Expr)
+	case *ParenExpr:
+		return argType(expr.Expr)
+	}
+	return ValueTypeVector
+}
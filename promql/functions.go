@@ -0,0 +1,203 @@
+package promql
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/counter"
+	"github.com/hnakamur/timeseries/labels"
+)
+
+// function is a query function. call is given the evaluated arguments and
+// the range [start, end] of the range vector argument if any.
+type function struct {
+	args []ValueType
+	call func(args []Value, start, end uint32) (Vector, error)
+}
+
+var functions = map[string]function{
+	"rate":     {args: []ValueType{ValueTypeMatrix}, call: counterFunc(counter.Rate)},
+	"irate":    {args: []ValueType{ValueTypeMatrix}, call: counterFunc(counter.IRate)},
+	"increase": {args: []ValueType{ValueTypeMatrix}, call: counterFunc(counter.Increase)},
+	"delta":    {args: []ValueType{ValueTypeMatrix}, call: counterFunc(counter.Delta)},
+	"resets": {args: []ValueType{ValueTypeMatrix}, call: counterFunc(
+		func(it timeseries.Iterator, start, end uint32) (float64, bool, error) {
+			n, ok, err := counter.Resets(it, start, end)
+			return float64(n), ok, err
+		})},
+	"avg_over_time":   {args: []ValueType{ValueTypeMatrix}, call: overTimeFunc(avgOverTime)},
+	"sum_over_time":   {args: []ValueType{ValueTypeMatrix}, call: overTimeFunc(sumOverTime)},
+	"min_over_time":   {args: []ValueType{ValueTypeMatrix}, call: overTimeFunc(minOverTime)},
+	"max_over_time":   {args: []ValueType{ValueTypeMatrix}, call: overTimeFunc(maxOverTime)},
+	"count_over_time": {args: []ValueType{ValueTypeMatrix}, call: overTimeFunc(countOverTime)},
+	"histogram_quantile": {
+		args: []ValueType{ValueTypeScalar, ValueTypeVector},
+		call: histogramQuantile,
+	},
+}
+
+func counterFunc(f func(it timeseries.Iterator, start, end uint32) (float64, bool, error)) func([]Value, uint32, uint32) (Vector, error) {
+	return func(args []Value, start, end uint32) (Vector, error) {
+		var res Vector
+		for _, s := range args[0].(Matrix) {
+			v, ok, err := f(timeseries.NewSliceIterator(s.Points), start, end)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				res = append(res, Sample{Labels: dropMetricName(s.Labels), T: end, V: v})
+			}
+		}
+		return res, nil
+	}
+}
+
+func overTimeFunc(f func(points []timeseries.Point) float64) func([]Value, uint32, uint32) (Vector, error) {
+	return func(args []Value, start, end uint32) (Vector, error) {
+		var res Vector
+		for _, s := range args[0].(Matrix) {
+			if len(s.Points) > 0 {
+				res = append(res, Sample{Labels: dropMetricName(s.Labels), T: end, V: f(s.Points)})
+			}
+		}
+		return res, nil
+	}
+}
+
+func avgOverTime(points []timeseries.Point) float64 {
+	return sumOverTime(points) / float64(len(points))
+}
+
+func sumOverTime(points []timeseries.Point) float64 {
+	var sum float64
+	for _, p := range points {
+		sum += p.Value
+	}
+	return sum
+}
+
+func minOverTime(points []timeseries.Point) float64 {
+	min := points[0].Value
+	for _, p := range points[1:] {
+		if p.Value < min || math.IsNaN(min) {
+			min = p.Value
+		}
+	}
+	return min
+}
+
+func maxOverTime(points []timeseries.Point) float64 {
+	max := points[0].Value
+	for _, p := range points[1:] {
+		if p.Value > max || math.IsNaN(max) {
+			max = p.Value
+		}
+	}
+	return max
+}
+
+func countOverTime(points []timeseries.Point) float64 {
+	return float64(len(points))
+}
+
+// bucketLabel is the label for the upper bound of a histogram bucket.
+const bucketLabel = "le"
+
+type bucket struct {
+	upperBound float64
+	count      float64
+}
+
+// histogramQuantile calculates the quantile from the buckets of histograms
+// in the same way as Prometheus. The buckets of a histogram are the samples
+// with the same labels except the le label and the metric name.
+func histogramQuantile(args []Value, start, end uint32) (Vector, error) {
+	q := args[0].(Scalar).V
+	type histogram struct {
+		labels  labels.Labels
+		buckets []bucket
+	}
+	histograms := make(map[string]*histogram)
+	var keys []string
+	for _, s := range args[1].(Vector) {
+		le := s.Labels.Get(bucketLabel)
+		if le == "" {
+			continue
+		}
+		upperBound, err := strconv.ParseFloat(le, 64)
+		if err != nil {
+			continue
+		}
+		ls := dropLabels(s.Labels, labels.MetricName, bucketLabel)
+		key := ls.String()
+		h, ok := histograms[key]
+		if !ok {
+			h = &histogram{labels: ls}
+			histograms[key] = h
+			keys = append(keys, key)
+		}
+		h.buckets = append(h.buckets, bucket{upperBound: upperBound, count: s.V})
+	}
+
+	res := make(Vector, 0, len(keys))
+	for _, key := range keys {
+		h := histograms[key]
+		res = append(res, Sample{Labels: h.labels, T: end, V: bucketQuantile(q, h.buckets)})
+	}
+	return res, nil
+}
+
+func bucketQuantile(q float64, buckets []bucket) float64 {
+	if math.IsNaN(q) {
+		return math.NaN()
+	}
+	if q < 0 {
+		return math.Inf(-1)
+	}
+	if q > 1 {
+		return math.Inf(1)
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].upperBound < buckets[j].upperBound
+	})
+	if len(buckets) < 2 || !math.IsInf(buckets[len(buckets)-1].upperBound, 1) {
+		return math.NaN()
+	}
+
+	// Buckets may be non-monotonic since they are scraped at different
+	// times. Treat decreasing counts as the previous count.
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i].count < buckets[i-1].count {
+			buckets[i].count = buckets[i-1].count
+		}
+	}
+
+	observations := buckets[len(buckets)-1].count
+	if observations == 0 {
+		return math.NaN()
+	}
+	rank := q * observations
+	b := sort.Search(len(buckets)-1, func(i int) bool {
+		return buckets[i].count >= rank
+	})
+
+	if b == len(buckets)-1 {
+		return buckets[len(buckets)-2].upperBound
+	}
+	if b == 0 && buckets[0].upperBound <= 0 {
+		return buckets[0].upperBound
+	}
+	var (
+		bucketStart float64
+		bucketEnd   = buckets[b].upperBound
+		count       = buckets[b].count
+	)
+	if b > 0 {
+		bucketStart = buckets[b-1].upperBound
+		count -= buckets[b-1].count
+		rank -= buckets[b-1].count
+	}
+	return bucketStart + (bucketEnd-bucketStart)*(rank/count)
+}
@@ -0,0 +1,170 @@
+package promql
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenType int
+
+const (
+	tokEOF tokenType = iota
+	tokIdent
+	tokNumber
+	tokDuration
+	tokString
+	tokLParen
+	tokRParen
+	tokLBrace
+	tokRBrace
+	tokLBracket
+	tokRBracket
+	tokComma
+	tokAssign
+	tokRegexMatch
+	tokRegexNotMatch
+	tokAdd
+	tokSub
+	tokMul
+	tokDiv
+	tokEql
+	tokNeq
+	tokGtr
+	tokLss
+	tokGte
+	tokLte
+)
+
+type token struct {
+	typ tokenType
+	val string
+	pos int
+}
+
+func (t token) String() string {
+	if t.typ == tokEOF {
+		return "end of input"
+	}
+	return fmt.Sprintf("%q", t.val)
+}
+
+// lex splits the input into tokens.
+func lex(input string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(input) {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case isIdentStart(c):
+			start := i
+			for i < len(input) && isIdentChar(input[i]) {
+				i++
+			}
+			tokens = append(tokens, token{typ: tokIdent, val: input[start:i], pos: start})
+			continue
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(input) && input[i+1] >= '0' && input[i+1] <= '9':
+			start := i
+			for i < len(input) && (input[i] >= '0' && input[i] <= '9' || input[i] == '.' ||
+				input[i] == 'e' || input[i] == 'E' ||
+				(input[i] == '+' || input[i] == '-') && (input[i-1] == 'e' || input[i-1] == 'E')) {
+				i++
+			}
+			if i < len(input) && strings.IndexByte("smhdwy", input[i]) >= 0 {
+				// A duration like 5m or 1h30m.
+				for i < len(input) && (input[i] >= '0' && input[i] <= '9' || strings.IndexByte("smhdwy", input[i]) >= 0) {
+					i++
+				}
+				tokens = append(tokens, token{typ: tokDuration, val: input[start:i], pos: start})
+				continue
+			}
+			tokens = append(tokens, token{typ: tokNumber, val: input[start:i], pos: start})
+			continue
+		case c == '"' || c == '\'':
+			start := i
+			i++
+			for i < len(input) && input[i] != c {
+				if input[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(input) {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			i++
+			tokens = append(tokens, token{typ: tokString, val: input[start:i], pos: start})
+			continue
+		}
+
+		typ, n := operator(input[i:])
+		if n == 0 {
+			return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+		}
+		tokens = append(tokens, token{typ: typ, val: input[i : i+n], pos: i})
+		i += n
+	}
+	tokens = append(tokens, token{typ: tokEOF, pos: len(input)})
+	return tokens, nil
+}
+
+func operator(s string) (tokenType, int) {
+	if len(s) >= 2 {
+		switch s[:2] {
+		case "=~":
+			return tokRegexMatch, 2
+		case "!~":
+			return tokRegexNotMatch, 2
+		case "==":
+			return tokEql, 2
+		case "!=":
+			return tokNeq, 2
+		case ">=":
+			return tokGte, 2
+		case "<=":
+			return tokLte, 2
+		}
+	}
+	switch s[0] {
+	case '(':
+		return tokLParen, 1
+	case ')':
+		return tokRParen, 1
+	case '{':
+		return tokLBrace, 1
+	case '}':
+		return tokRBrace, 1
+	case '[':
+		return tokLBracket, 1
+	case ']':
+		return tokRBracket, 1
+	case ',':
+		return tokComma, 1
+	case '=':
+		return tokAssign, 1
+	case '+':
+		return tokAdd, 1
+	case '-':
+		return tokSub, 1
+	case '*':
+		return tokMul, 1
+	case '/':
+		return tokDiv, 1
+	case '>':
+		return tokGtr, 1
+	case '<':
+		return tokLss, 1
+	}
+	return tokEOF, 0
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == ':' || unicode.IsLetter(rune(c)) && c < 0x80
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
@@ -0,0 +1,477 @@
+package promql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hnakamur/timeseries/join"
+	"github.com/hnakamur/timeseries/labels"
+)
+
+// ParseError is an error of parsing a query.
+type ParseError struct {
+	Pos int
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("parse error at position %d: %s", e.Pos, e.Msg)
+}
+
+var aggregateOps = map[string]bool{
+	"sum":   true,
+	"avg":   true,
+	"min":   true,
+	"max":   true,
+	"count": true,
+}
+
+type parser struct {
+	tokens []token
+	i      int
+}
+
+// Parse parses a query expression.
+func Parse(input string) (Expr, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.typ != tokEOF {
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+	return e, nil
+}
+
+// ParseMatchers parses a vector selector like foo{bar="baz"} and returns its
+// label matchers.
+func ParseMatchers(input string) ([]*labels.Matcher, error) {
+	e, err := Parse(input)
+	if err != nil {
+		return nil, err
+	}
+	vs, ok := e.(*VectorSelector)
+	if !ok {
+		return nil, &ParseError{Msg: "expected vector selector"}
+	}
+	return vs.Matchers, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.typ != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) expect(typ tokenType, what string) (token, error) {
+	t := p.next()
+	if t.typ != typ {
+		return t, p.errorf(t, "unexpected %s, expected %s", t, what)
+	}
+	return t, nil
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return &ParseError{Pos: t.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+var comparisonOps = map[tokenType]join.Op{
+	tokEql: join.Eq,
+	tokNeq: join.Ne,
+	tokGtr: join.Gt,
+	tokLss: join.Lt,
+	tokGte: join.Ge,
+	tokLte: join.Le,
+}
+
+func (p *parser) parseExpr() (Expr, error) {
+	lhs, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := comparisonOps[p.peek().typ]
+		if !ok {
+			return lhs, nil
+		}
+		t := p.next()
+		rhs, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		lhs, err = p.newBinary(t, op, lhs, rhs)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseAdditive() (Expr, error) {
+	lhs, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op join.Op
+		switch p.peek().typ {
+		case tokAdd:
+			op = join.Add
+		case tokSub:
+			op = join.Sub
+		default:
+			return lhs, nil
+		}
+		t := p.next()
+		rhs, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		lhs, err = p.newBinary(t, op, lhs, rhs)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseMultiplicative() (Expr, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op join.Op
+		switch p.peek().typ {
+		case tokMul:
+			op = join.Mul
+		case tokDiv:
+			op = join.Div
+		default:
+			return lhs, nil
+		}
+		t := p.next()
+		rhs, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		lhs, err = p.newBinary(t, op, lhs, rhs)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) newBinary(t token, op join.Op, lhs, rhs Expr) (Expr, error) {
+	if argType(lhs) == ValueTypeMatrix || argType(rhs) == ValueTypeMatrix {
+		return nil, p.errorf(t, "binary operation %s is not allowed on range vectors", op)
+	}
+	if op.IsComparison() && argType(lhs) == ValueTypeScalar && argType(rhs) == ValueTypeScalar {
+		return nil, p.errorf(t, "comparison %s between scalars is not supported", op)
+	}
+	return &BinaryExpr{Op: op, LHS: lhs, RHS: rhs}, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	switch t := p.peek(); t.typ {
+	case tokSub:
+		p.next()
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if argType(e) == ValueTypeMatrix {
+			return nil, p.errorf(t, "unary minus is not allowed on range vectors")
+		}
+		if n, ok := e.(*NumberLiteral); ok {
+			return &NumberLiteral{Val: -n.Val}, nil
+		}
+		return &UnaryExpr{Expr: e}, nil
+	case tokAdd:
+		p.next()
+		return p.parseUnary()
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (Expr, error) {
+	e, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().typ != tokLBracket {
+		return e, nil
+	}
+
+	t := p.next()
+	vs, ok := e.(*VectorSelector)
+	if !ok {
+		return nil, p.errorf(t, "range can only be applied to a vector selector")
+	}
+	d, err := p.expect(tokDuration, "duration")
+	if err != nil {
+		return nil, err
+	}
+	r, err := parseDuration(d.val)
+	if err != nil {
+		return nil, p.errorf(d, "%v", err)
+	}
+	_, err = p.expect(tokRBracket, `"]"`)
+	if err != nil {
+		return nil, err
+	}
+	return &MatrixSelector{Vector: vs, Range: r}, nil
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	t := p.peek()
+	switch t.typ {
+	case tokNumber:
+		p.next()
+		v, err := strconv.ParseFloat(t.val, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid number %s", t)
+		}
+		return &NumberLiteral{Val: v}, nil
+	case tokLParen:
+		p.next()
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		_, err = p.expect(tokRParen, `")"`)
+		if err != nil {
+			return nil, err
+		}
+		return &ParenExpr{Expr: e}, nil
+	case tokLBrace:
+		return p.parseSelector("")
+	case tokIdent:
+		p.next()
+		next := p.peek().typ
+		if aggregateOps[t.val] && (next == tokLParen || next == tokIdent) {
+			return p.parseAggregate(t)
+		}
+		if next == tokLParen {
+			return p.parseCall(t)
+		}
+		return p.parseSelector(t.val)
+	}
+	return nil, p.errorf(t, "unexpected %s", t)
+}
+
+func (p *parser) parseAggregate(op token) (Expr, error) {
+	agg := &AggregateExpr{Op: op.val}
+	grouped := false
+	if p.peek().typ == tokIdent {
+		err := p.parseGrouping(agg)
+		if err != nil {
+			return nil, err
+		}
+		grouped = true
+	}
+
+	_, err := p.expect(tokLParen, `"("`)
+	if err != nil {
+		return nil, err
+	}
+	agg.Expr, err = p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if argType(agg.Expr) != ValueTypeVector {
+		return nil, p.errorf(op, "aggregation %s expects instant vector", op.val)
+	}
+	_, err = p.expect(tokRParen, `")"`)
+	if err != nil {
+		return nil, err
+	}
+
+	if !grouped && p.peek().typ == tokIdent && (p.peek().val == "by" || p.peek().val == "without") {
+		err = p.parseGrouping(agg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return agg, nil
+}
+
+func (p *parser) parseGrouping(agg *AggregateExpr) error {
+	t := p.next()
+	switch t.val {
+	case "by":
+	case "without":
+		agg.Without = true
+	default:
+		return p.errorf(t, "unexpected %s, expected by or without", t)
+	}
+	_, err := p.expect(tokLParen, `"("`)
+	if err != nil {
+		return err
+	}
+	agg.Grouping = []string{}
+	for p.peek().typ != tokRParen {
+		name, err := p.expect(tokIdent, "label name")
+		if err != nil {
+			return err
+		}
+		agg.Grouping = append(agg.Grouping, name.val)
+		if p.peek().typ != tokComma {
+			break
+		}
+		p.next()
+	}
+	_, err = p.expect(tokRParen, `")"`)
+	return err
+}
+
+func (p *parser) parseCall(name token) (Expr, error) {
+	fn, ok := functions[name.val]
+	if !ok {
+		return nil, p.errorf(name, "unknown function %s", name)
+	}
+	p.next() // (
+	call := &Call{Func: name.val}
+	for p.peek().typ != tokRParen {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+		if p.peek().typ != tokComma {
+			break
+		}
+		p.next()
+	}
+	_, err := p.expect(tokRParen, `")"`)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(call.Args) != len(fn.args) {
+		return nil, p.errorf(name, "function %s expects %d arguments, got %d", name.val, len(fn.args), len(call.Args))
+	}
+	for i, arg := range call.Args {
+		if argType(arg) != fn.args[i] {
+			return nil, p.errorf(name, "function %s expects %s as argument %d", name.val, fn.args[i], i+1)
+		}
+	}
+	return call, nil
+}
+
+func (p *parser) parseSelector(name string) (Expr, error) {
+	var ms []*labels.Matcher
+	if name != "" {
+		ms = append(ms, labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, name))
+	}
+	if p.peek().typ != tokLBrace {
+		return &VectorSelector{Matchers: ms}, nil
+	}
+
+	brace := p.next()
+	for p.peek().typ != tokRBrace {
+		lname, err := p.expect(tokIdent, "label name")
+		if err != nil {
+			return nil, err
+		}
+		var typ labels.MatchType
+		op := p.next()
+		switch op.typ {
+		case tokAssign:
+			typ = labels.MatchEqual
+		case tokNeq:
+			typ = labels.MatchNotEqual
+		case tokRegexMatch:
+			typ = labels.MatchRegexp
+		case tokRegexNotMatch:
+			typ = labels.MatchNotRegexp
+		default:
+			return nil, p.errorf(op, "unexpected %s, expected label match operator", op)
+		}
+		s, err := p.expect(tokString, "string")
+		if err != nil {
+			return nil, err
+		}
+		val, err := unquote(s.val)
+		if err != nil {
+			return nil, p.errorf(s, "invalid string %s", s)
+		}
+		m, err := labels.NewMatcher(typ, lname.val, val)
+		if err != nil {
+			return nil, p.errorf(s, "%v", err)
+		}
+		ms = append(ms, m)
+		if p.peek().typ != tokComma {
+			break
+		}
+		p.next()
+	}
+	_, err := p.expect(tokRBrace, `"}"`)
+	if err != nil {
+		return nil, err
+	}
+
+	// Like Prometheus, a selector must have a matcher which does not match
+	// the empty string, so that it does not select all series.
+	for _, m := range ms {
+		if !m.Matches("") {
+			return &VectorSelector{Matchers: ms}, nil
+		}
+	}
+	return nil, p.errorf(brace, "vector selector must contain at least one non-empty matcher")
+}
+
+func unquote(s string) (string, error) {
+	if s[0] == '\'' {
+		s = `"` + strings.Replace(strings.Replace(s[1:len(s)-1], `\'`, `'`, -1), `"`, `\"`, -1) + `"`
+	}
+	return strconv.Unquote(s)
+}
+
+// parseDuration parses a duration like 5m or 1h30m with the units s, m, h,
+// d, w and y.
+func parseDuration(s string) (time.Duration, error) {
+	units := map[byte]time.Duration{
+		's': time.Second,
+		'm': time.Minute,
+		'h': time.Hour,
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+		'y': 365 * 24 * time.Hour,
+	}
+	var d time.Duration
+	for len(s) > 0 {
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == 0 || i == len(s) {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		n, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		unit, ok := units[s[i]]
+		if !ok {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		d += time.Duration(n) * unit
+		s = s[i+1:]
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("duration must be positive")
+	}
+	return d, nil
+}
@@ -0,0 +1,255 @@
+package promql_test
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/labels"
+	"github.com/hnakamur/timeseries/promql"
+	"github.com/hnakamur/timeseries/store"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// base is the timestamp which the times in testdata/queries.txt are relative
+// to.
+var base = uint32(time.Date(2015, 3, 24, 2, 0, 0, 0, time.UTC).Unix())
+
+func newTestStore(t *testing.T) *store.Store {
+	s, err := store.New(store.Options{})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+	appendPoints := func(ls labels.Labels, f func(i int) float64) {
+		for i := 0; i <= 40; i++ {
+			p := timeseries.Point{Timestamp: base + uint32(i*15), Value: f(i)}
+			err := s.AppendLabels(ls, p)
+			if err != nil {
+				t.Fatalf("failed to append point: labels=%s, point=%+v, err=%+v", ls, p, err)
+			}
+		}
+	}
+
+	// Counters increasing by 1/s and 2/s. The latter resets at i=20.
+	appendPoints(labels.FromStrings(labels.MetricName, "http_requests_total", "job", "api", "instance", "a", "status", "200"),
+		func(i int) float64 { return float64(15 * i) })
+	appendPoints(labels.FromStrings(labels.MetricName, "http_requests_total", "job", "api", "instance", "b", "status", "200"),
+		func(i int) float64 {
+			if i >= 20 {
+				return float64(30 * (i - 20))
+			}
+			return float64(30 * i)
+		})
+	appendPoints(labels.FromStrings(labels.MetricName, "http_requests_total", "job", "api", "instance", "a", "status", "500"),
+		func(i int) float64 { return float64(3 * i) })
+
+	// Gauges.
+	appendPoints(labels.FromStrings(labels.MetricName, "cpu_usage", "host", "host1", "region", "tokyo"),
+		func(i int) float64 { return float64(i % 10) })
+	appendPoints(labels.FromStrings(labels.MetricName, "cpu_usage", "host", "host2", "region", "osaka"),
+		func(i int) float64 { return 50 })
+
+	// Histogram buckets with 100 observations per 15 seconds.
+	buckets := []struct {
+		le    string
+		ratio float64
+	}{
+		{le: "0.1", ratio: 0.5},
+		{le: "0.5", ratio: 0.9},
+		{le: "1", ratio: 0.99},
+		{le: "+Inf", ratio: 1},
+	}
+	for _, b := range buckets {
+		ratio := b.ratio
+		appendPoints(labels.FromStrings(labels.MetricName, "request_duration_seconds_bucket", "job", "api", "le", b.le),
+			func(i int) float64 { return 100 * ratio * float64(i) })
+	}
+	return s
+}
+
+func TestGolden(t *testing.T) {
+	e := promql.NewEngine(newTestStore(t))
+
+	queries, err := os.Open("testdata/queries.txt")
+	if err != nil {
+		t.Fatalf("failed to open queries: err=%+v", err)
+	}
+	defer queries.Close()
+
+	var got bytes.Buffer
+	sc := bufio.NewScanner(queries)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fmt.Fprintf(&got, "== %s\n", line)
+		res, err := evalLine(e, line)
+		if err != nil {
+			fmt.Fprintf(&got, "error: %v\n\n", err)
+			continue
+		}
+		if res != "" {
+			got.WriteString(res)
+			got.WriteByte('\n')
+		}
+		got.WriteByte('\n')
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatalf("failed to read queries: err=%+v", err)
+	}
+
+	const golden = "testdata/queries.golden"
+	if *update {
+		err = ioutil.WriteFile(golden, got.Bytes(), 0644)
+		if err != nil {
+			t.Fatalf("failed to write golden file: err=%+v", err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("failed to read golden file: err=%+v", err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("result mismatch, run go test -update and check the diff of %s\ngot=\n%s", golden, got.String())
+	}
+}
+
+// evalLine evaluates a line of testdata/queries.txt, which is either
+// "instant <t> <query>" or "range <start> <end> <step> <query>" with times
+// in seconds relative to base.
+func evalLine(e *promql.Engine, line string) (string, error) {
+	fields := strings.SplitN(line, " ", 2)
+	switch fields[0] {
+	case "instant":
+		args := strings.SplitN(fields[1], " ", 2)
+		t, err := strconv.Atoi(args[0])
+		if err != nil {
+			return "", err
+		}
+		v, err := e.Instant(args[1], base+uint32(t))
+		if err != nil {
+			return "", err
+		}
+		return v.String(), nil
+	case "range":
+		args := strings.SplitN(fields[1], " ", 4)
+		start, err := strconv.Atoi(args[0])
+		if err != nil {
+			return "", err
+		}
+		end, err := strconv.Atoi(args[1])
+		if err != nil {
+			return "", err
+		}
+		step, err := time.ParseDuration(args[2])
+		if err != nil {
+			return "", err
+		}
+		m, err := e.Range(args[3], base+uint32(start), base+uint32(end), step)
+		if err != nil {
+			return "", err
+		}
+		return m.String(), nil
+	}
+	return "", fmt.Errorf("unknown command %q", fields[0])
+}
+
+func TestInstant(t *testing.T) {
+	e := promql.NewEngine(newTestStore(t))
+	testCases := []struct {
+		query string
+		t     uint32
+		want  float64
+	}{
+		// 1/s over the whole 5m range with data points at both ends of
+		// (t-5m, t] except the left boundary, extrapolated by 15s.
+		{query: `rate(http_requests_total{instance="a", status="200"}[5m])`, t: base + 600, want: 1},
+		{query: `increase(http_requests_total{instance="b"}[5m])`, t: base + 600, want: 600},
+		{query: `sum(cpu_usage) / count(cpu_usage)`, t: base + 600, want: 25},
+		{query: `max_over_time(cpu_usage{host="host1"}[5m])`, t: base + 600, want: 9},
+		{query: `histogram_quantile(0.5, rate(request_duration_seconds_bucket[5m]))`, t: base + 600, want: 0.1},
+		{query: `-(1 + 2) * 3`, t: base, want: -9},
+	}
+	for _, tc := range testCases {
+		v, err := e.Instant(tc.query, tc.t)
+		if err != nil {
+			t.Fatalf("failed to evaluate: query=%s, err=%+v", tc.query, err)
+		}
+		var got float64
+		switch v := v.(type) {
+		case promql.Scalar:
+			got = v.V
+		case promql.Vector:
+			if len(v) != 1 {
+				t.Fatalf("query=%s, got=%s, want a single sample", tc.query, v)
+			}
+			got = v[0].V
+		default:
+			t.Fatalf("query=%s, got=%s, want scalar or vector", tc.query, v)
+		}
+		if math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("query=%s, got=%v, want=%v", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	testCases := []string{
+		`sum(`,
+		`foo{`,
+		`{job=~".*"}`,
+		`foo[5m] + 1`,
+		`rate(foo)`,
+		`unknown(foo)`,
+		`sum(foo[5m])`,
+		`1 > 2`,
+		`foo[5x]`,
+		`foo bar`,
+	}
+	for _, query := range testCases {
+		_, err := promql.Parse(query)
+		if err == nil {
+			t.Errorf("query=%s, got no error, want error", query)
+		}
+	}
+}
+
+func TestParseMatchers(t *testing.T) {
+	testCases := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{input: `foo`, want: `[__name__="foo"]`},
+		{input: `foo{bar=~"b.*", baz!="qux"}`, want: `[__name__="foo" bar=~"b.*" baz!="qux"]`},
+		{input: `{bar='baz'}`, want: `[bar="baz"]`},
+		{input: `foo[5m]`, wantErr: true},
+		{input: `sum(foo)`, wantErr: true},
+	}
+	for _, tc := range testCases {
+		ms, err := promql.ParseMatchers(tc.input)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("input=%s, got no error, want error", tc.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("failed to parse matchers: input=%s, err=%+v", tc.input, err)
+		}
+		if got := fmt.Sprint(ms); got != tc.want {
+			t.Errorf("input=%s, got=%s, want=%s", tc.input, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package promql
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/labels"
+)
+
+// ValueType is the type of a value.
+type ValueType string
+
+const (
+	// ValueTypeScalar is the type of Scalar.
+	ValueTypeScalar ValueType = "scalar"
+	// ValueTypeVector is the type of Vector.
+	ValueTypeVector ValueType = "vector"
+	// ValueTypeMatrix is the type of Matrix.
+	ValueTypeMatrix ValueType = "matrix"
+)
+
+// Value is a result of evaluating an expression.
+type Value interface {
+	Type() ValueType
+	String() string
+}
+
+// Scalar is a single value at a timestamp.
+type Scalar struct {
+	T uint32
+	V float64
+}
+
+// Sample is a value of a series at a timestamp.
+type Sample struct {
+	Labels labels.Labels
+	T      uint32
+	V      float64
+}
+
+// Vector is a set of samples at the same timestamp.
+type Vector []Sample
+
+// Series is a series with data points.
+type Series struct {
+	Labels labels.Labels
+	Points []timeseries.Point
+}
+
+// Matrix is a set of series.
+type Matrix []Series
+
+// Type returns ValueTypeScalar.
+func (s Scalar) Type() ValueType { return ValueTypeScalar }
+
+// Type returns ValueTypeVector.
+func (v Vector) Type() ValueType { return ValueTypeVector }
+
+// Type returns ValueTypeMatrix.
+func (m Matrix) Type() ValueType { return ValueTypeMatrix }
+
+func (s Scalar) String() string {
+	return fmt.Sprintf("scalar: %s @[%d]", formatValue(s.V), s.T)
+}
+
+func (s Sample) String() string {
+	return fmt.Sprintf("%s => %s @[%d]", s.Labels, formatValue(s.V), s.T)
+}
+
+func (v Vector) String() string {
+	var b bytes.Buffer
+	for i, s := range v {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(s.String())
+	}
+	return b.String()
+}
+
+func (s Series) String() string {
+	var b bytes.Buffer
+	b.WriteString(s.Labels.String())
+	b.WriteString(" =>")
+	for _, p := range s.Points {
+		fmt.Fprintf(&b, "\n%s @[%d]", formatValue(p.Value), p.Timestamp)
+	}
+	return b.String()
+}
+
+func (m Matrix) String() string {
+	var b bytes.Buffer
+	for i, s := range m {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(s.String())
+	}
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortVector(v Vector) {
+	sort.SliceStable(v, func(i, j int) bool {
+		return v[i].Labels.String() < v[j].Labels.String()
+	})
+}
+
+func sortMatrix(m Matrix) {
+	sort.SliceStable(m, func(i, j int) bool {
+		return m[i].Labels.String() < m[j].Labels.String()
+	})
+}
+
+// dropMetricName returns the labels without the metric name.
+func dropMetricName(ls labels.Labels) labels.Labels {
+	return dropLabels(ls, labels.MetricName)
+}
+
+// dropLabels returns the labels without the labels of the names.
+func dropLabels(ls labels.Labels, names ...string) labels.Labels {
+	res := make(labels.Labels, 0, len(ls))
+loop:
+	for _, l := range ls {
+		for _, name := range names {
+			if l.Name == name {
+				continue loop
+			}
+		}
+		res = append(res, l)
+	}
+	return res
+}
+
+// keepLabels returns the labels only of the names.
+func keepLabels(ls labels.Labels, names ...string) labels.Labels {
+	res := make(labels.Labels, 0, len(names))
+	for _, l := range ls {
+		for _, name := range names {
+			if l.Name == name {
+				res = append(res, l)
+				break
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,331 @@
+package remote
+
+import (
+	"fmt"
+
+	"github.com/hnakamur/timeseries/internal/protowire"
+)
+
+// The messages below are compatible with the ones in prompb of Prometheus
+// with only the fields used by this package.
+
+// WriteRequest is a remote write request.
+type WriteRequest struct {
+	Timeseries []TimeSeries
+}
+
+// TimeSeries is a series with samples.
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// Label is a label pair.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is a value at a timestamp in milliseconds.
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// MatchType is the type of a label matcher.
+type MatchType int
+
+const (
+	// MatchEQ is an equality matcher.
+	MatchEQ MatchType = iota
+	// MatchNEQ is an inequality matcher.
+	MatchNEQ
+	// MatchRE is a regular expression matcher.
+	MatchRE
+	// MatchNRE is a negated regular expression matcher.
+	MatchNRE
+)
+
+// LabelMatcher is a label matcher of a query.
+type LabelMatcher struct {
+	Type  MatchType
+	Name  string
+	Value string
+}
+
+// Query is a query of a remote read request with timestamps in milliseconds.
+type Query struct {
+	StartTimestampMs int64
+	EndTimestampMs   int64
+	Matchers         []LabelMatcher
+}
+
+// ReadRequest is a remote read request.
+type ReadRequest struct {
+	Queries []Query
+}
+
+// QueryResult is the result of a query.
+type QueryResult struct {
+	Timeseries []TimeSeries
+}
+
+// ReadResponse is a remote read response with the results in the order of
+// the queries.
+type ReadResponse struct {
+	Results []QueryResult
+}
+
+// Marshal encodes the request.
+func (m *WriteRequest) Marshal() []byte {
+	var b []byte
+	for i := range m.Timeseries {
+		b = protowire.AppendMessage(b, 1, m.Timeseries[i].appendTo)
+	}
+	return b
+}
+
+// Unmarshal decodes the request.
+func (m *WriteRequest) Unmarshal(b []byte) error {
+	*m = WriteRequest{}
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		if num != 1 {
+			return r.Skip(typ)
+		}
+		var ts TimeSeries
+		err := unmarshalMessage(r, num, typ, ts.unmarshal)
+		m.Timeseries = append(m.Timeseries, ts)
+		return err
+	})
+}
+
+// Marshal encodes the request.
+func (m *ReadRequest) Marshal() []byte {
+	var b []byte
+	for i := range m.Queries {
+		b = protowire.AppendMessage(b, 1, m.Queries[i].appendTo)
+	}
+	return b
+}
+
+// Unmarshal decodes the request.
+func (m *ReadRequest) Unmarshal(b []byte) error {
+	*m = ReadRequest{}
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		if num != 1 {
+			return r.Skip(typ)
+		}
+		var q Query
+		err := unmarshalMessage(r, num, typ, q.unmarshal)
+		m.Queries = append(m.Queries, q)
+		return err
+	})
+}
+
+// Marshal encodes the response.
+func (m *ReadResponse) Marshal() []byte {
+	var b []byte
+	for i := range m.Results {
+		b = protowire.AppendMessage(b, 1, m.Results[i].appendTo)
+	}
+	return b
+}
+
+// Unmarshal decodes the response.
+func (m *ReadResponse) Unmarshal(b []byte) error {
+	*m = ReadResponse{}
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		if num != 1 {
+			return r.Skip(typ)
+		}
+		var res QueryResult
+		err := unmarshalMessage(r, num, typ, res.unmarshal)
+		m.Results = append(m.Results, res)
+		return err
+	})
+}
+
+func (m *TimeSeries) appendTo(b []byte) []byte {
+	for i := range m.Labels {
+		b = protowire.AppendMessage(b, 1, m.Labels[i].appendTo)
+	}
+	for i := range m.Samples {
+		b = protowire.AppendMessage(b, 2, m.Samples[i].appendTo)
+	}
+	return b
+}
+
+func (m *TimeSeries) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		switch num {
+		case 1:
+			var l Label
+			err := unmarshalMessage(r, num, typ, l.unmarshal)
+			m.Labels = append(m.Labels, l)
+			return err
+		case 2:
+			var s Sample
+			err := unmarshalMessage(r, num, typ, s.unmarshal)
+			m.Samples = append(m.Samples, s)
+			return err
+		}
+		return r.Skip(typ)
+	})
+}
+
+func (m *Label) appendTo(b []byte) []byte {
+	b = protowire.AppendStringField(b, 1, m.Name)
+	return protowire.AppendStringField(b, 2, m.Value)
+}
+
+func (m *Label) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		switch num {
+		case 1:
+			return unmarshalString(r, num, typ, &m.Name)
+		case 2:
+			return unmarshalString(r, num, typ, &m.Value)
+		}
+		return r.Skip(typ)
+	})
+}
+
+func (m *Sample) appendTo(b []byte) []byte {
+	b = protowire.AppendDouble(b, 1, m.Value)
+	return protowire.AppendInt64(b, 2, m.Timestamp)
+}
+
+func (m *Sample) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		switch num {
+		case 1:
+			err := protowire.CheckType(num, typ, protowire.Fixed64Type)
+			if err != nil {
+				return err
+			}
+			m.Value, err = r.Double()
+			return err
+		case 2:
+			return unmarshalInt64(r, num, typ, &m.Timestamp)
+		}
+		return r.Skip(typ)
+	})
+}
+
+func (m *LabelMatcher) appendTo(b []byte) []byte {
+	b = protowire.AppendInt64(b, 1, int64(m.Type))
+	b = protowire.AppendStringField(b, 2, m.Name)
+	return protowire.AppendStringField(b, 3, m.Value)
+}
+
+func (m *LabelMatcher) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		switch num {
+		case 1:
+			var v int64
+			err := unmarshalInt64(r, num, typ, &v)
+			m.Type = MatchType(v)
+			return err
+		case 2:
+			return unmarshalString(r, num, typ, &m.Name)
+		case 3:
+			return unmarshalString(r, num, typ, &m.Value)
+		}
+		return r.Skip(typ)
+	})
+}
+
+func (m *Query) appendTo(b []byte) []byte {
+	b = protowire.AppendInt64(b, 1, m.StartTimestampMs)
+	b = protowire.AppendInt64(b, 2, m.EndTimestampMs)
+	for i := range m.Matchers {
+		b = protowire.AppendMessage(b, 3, m.Matchers[i].appendTo)
+	}
+	return b
+}
+
+func (m *Query) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		switch num {
+		case 1:
+			return unmarshalInt64(r, num, typ, &m.StartTimestampMs)
+		case 2:
+			return unmarshalInt64(r, num, typ, &m.EndTimestampMs)
+		case 3:
+			var lm LabelMatcher
+			err := unmarshalMessage(r, num, typ, lm.unmarshal)
+			m.Matchers = append(m.Matchers, lm)
+			return err
+		}
+		return r.Skip(typ)
+	})
+}
+
+func (m *QueryResult) appendTo(b []byte) []byte {
+	for i := range m.Timeseries {
+		b = protowire.AppendMessage(b, 1, m.Timeseries[i].appendTo)
+	}
+	return b
+}
+
+func (m *QueryResult) unmarshal(b []byte) error {
+	return unmarshalFields(b, func(r *protowire.Reader, num int, typ protowire.Type) error {
+		if num != 1 {
+			return r.Skip(typ)
+		}
+		var ts TimeSeries
+		err := unmarshalMessage(r, num, typ, ts.unmarshal)
+		m.Timeseries = append(m.Timeseries, ts)
+		return err
+	})
+}
+
+func unmarshalFields(b []byte, f func(r *protowire.Reader, num int, typ protowire.Type) error) error {
+	r := protowire.NewReader(b)
+	for !r.Done() {
+		num, typ, err := r.Next()
+		if err != nil {
+			return err
+		}
+		err = f(r, num, typ)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unmarshalMessage(r *protowire.Reader, num int, typ protowire.Type, f func([]byte) error) error {
+	err := protowire.CheckType(num, typ, protowire.BytesType)
+	if err != nil {
+		return err
+	}
+	b, err := r.Bytes()
+	if err != nil {
+		return err
+	}
+	err = f(b)
+	if err != nil {
+		return fmt.Errorf("field %d: %v", num, err)
+	}
+	return nil
+}
+
+func unmarshalString(r *protowire.Reader, num int, typ protowire.Type, v *string) error {
+	err := protowire.CheckType(num, typ, protowire.BytesType)
+	if err != nil {
+		return err
+	}
+	*v, err = r.String()
+	return err
+}
+
+func unmarshalInt64(r *protowire.Reader, num int, typ protowire.Type, v *int64) error {
+	err := protowire.CheckType(num, typ, protowire.VarintType)
+	if err != nil {
+		return err
+	}
+	u, err := r.Varint()
+	*v = int64(u)
+	return err
+}
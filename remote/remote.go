@@ -0,0 +1,240 @@
+// Package remote implements the receiver of the Prometheus remote write
+// protocol (version 1) and the endpoint of the remote read protocol over a
+// store, using snappy-compressed protocol buffers messages.
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/internal/snappy"
+	"github.com/hnakamur/timeseries/labels"
+	"github.com/hnakamur/timeseries/store"
+)
+
+const (
+	// maxBodySize is the maximum size of a compressed request body.
+	maxBodySize = 32 << 20
+	// maxDecodedSize is the maximum size of a decompressed request body.
+	maxDecodedSize = 256 << 20
+)
+
+// staleNaN is the value Prometheus uses as a staleness marker.
+const staleNaN = 0x7ff0000000000002
+
+// NewWriteHandler returns a handler which receives remote write requests and
+// appends the samples to the store.
+//
+// Timestamps of samples are truncated to seconds, and only the last sample
+// in each second of a series in a request is appended. Samples of a series
+// in the same second sent in different requests are all appended with the
+// same timestamp. Staleness markers are ignored.
+func NewWriteHandler(s *store.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		b, err := readBody(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var req WriteRequest
+		err = req.Unmarshal(b)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to unmarshal write request: %v", err), http.StatusBadRequest)
+			return
+		}
+		err = Write(s, &req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// Write appends the samples of the request to the store.
+func Write(s *store.Store, req *WriteRequest) error {
+	for _, ts := range req.Timeseries {
+		ls := labelsFromProto(ts.Labels)
+		if ls.Get(labels.MetricName) == "" {
+			return fmt.Errorf("series without metric name: labels=%s", ls)
+		}
+
+		var (
+			p       timeseries.Point
+			pending bool
+		)
+		for _, sample := range ts.Samples {
+			if math.Float64bits(sample.Value) == staleNaN {
+				continue
+			}
+			if sample.Timestamp < 0 || sample.Timestamp/1000 > math.MaxUint32 {
+				return fmt.Errorf("sample timestamp out of range: labels=%s, timestamp=%d", ls, sample.Timestamp)
+			}
+			t := uint32(sample.Timestamp / 1000)
+			if pending && t != p.Timestamp {
+				err := s.AppendLabels(ls, p)
+				if err != nil {
+					return fmt.Errorf("failed to append sample: labels=%s, err=%+v", ls, err)
+				}
+			}
+			p = timeseries.Point{Timestamp: t, Value: sample.Value}
+			pending = true
+		}
+		if pending {
+			err := s.AppendLabels(ls, p)
+			if err != nil {
+				return fmt.Errorf("failed to append sample: labels=%s, err=%+v", ls, err)
+			}
+		}
+	}
+	return nil
+}
+
+// NewReadHandler returns a handler which serves remote read requests from
+// the store. Only the SAMPLES response type is supported.
+func NewReadHandler(s *store.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		b, err := readBody(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var req ReadRequest
+		err = req.Unmarshal(b)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to unmarshal read request: %v", err), http.StatusBadRequest)
+			return
+		}
+		resp, err := Read(s, &req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Header().Set("Content-Encoding", "snappy")
+		w.Write(snappy.Encode(nil, resp.Marshal()))
+	})
+}
+
+// Read returns the samples selected by the queries of the request.
+func Read(s *store.Store, req *ReadRequest) (*ReadResponse, error) {
+	resp := &ReadResponse{Results: make([]QueryResult, len(req.Queries))}
+	for i, q := range req.Queries {
+		ms, err := matchersFromProto(q.Matchers)
+		if err != nil {
+			return nil, err
+		}
+		from, to, ok := secondsRange(q.StartTimestampMs, q.EndTimestampMs)
+		if !ok {
+			continue
+		}
+		series, err := s.Select(from, to, ms...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to select series: err=%+v", err)
+		}
+
+		res := make([]TimeSeries, len(series))
+		for j, sr := range series {
+			ts := TimeSeries{
+				Labels:  make([]Label, len(sr.Labels)),
+				Samples: make([]Sample, len(sr.Points)),
+			}
+			for k, l := range sr.Labels {
+				ts.Labels[k] = Label{Name: l.Name, Value: l.Value}
+			}
+			for k, p := range sr.Points {
+				ts.Samples[k] = Sample{Value: p.Value, Timestamp: int64(p.Timestamp) * 1000}
+			}
+			res[j] = ts
+		}
+		resp.Results[i].Timeseries = res
+	}
+	return resp, nil
+}
+
+// secondsRange returns the range of timestamps in seconds whose milliseconds
+// are in [startMs, endMs]. ok is false if the range is empty.
+func secondsRange(startMs, endMs int64) (from, to uint32, ok bool) {
+	if endMs < 0 || endMs < startMs {
+		return 0, 0, false
+	}
+	if startMs < 0 {
+		startMs = 0
+	}
+	start := (startMs + 999) / 1000
+	end := endMs / 1000
+	if start > math.MaxUint32 || start > end {
+		return 0, 0, false
+	}
+	if end > math.MaxUint32 {
+		end = math.MaxUint32
+	}
+	return uint32(start), uint32(end), true
+}
+
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	if enc := r.Header.Get("Content-Encoding"); enc != "" && enc != "snappy" {
+		return nil, fmt.Errorf("unsupported content encoding %q", enc)
+	}
+	compressed, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %v", err)
+	}
+	if len(compressed) > maxBodySize {
+		return nil, errors.New("request body too large")
+	}
+	b, err := snappy.Decode(nil, compressed, maxDecodedSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress request body: %v", err)
+	}
+	return b, nil
+}
+
+func labelsFromProto(pls []Label) labels.Labels {
+	ls := make([]labels.Label, len(pls))
+	for i, l := range pls {
+		ls[i] = labels.Label{Name: l.Name, Value: l.Value}
+	}
+	return labels.New(ls...)
+}
+
+func matchersFromProto(pms []LabelMatcher) ([]*labels.Matcher, error) {
+	ms := make([]*labels.Matcher, len(pms))
+	for i, pm := range pms {
+		var typ labels.MatchType
+		switch pm.Type {
+		case MatchEQ:
+			typ = labels.MatchEqual
+		case MatchNEQ:
+			typ = labels.MatchNotEqual
+		case MatchRE:
+			typ = labels.MatchRegexp
+		case MatchNRE:
+			typ = labels.MatchNotRegexp
+		default:
+			return nil, fmt.Errorf("invalid matcher type %d", pm.Type)
+		}
+		m, err := labels.NewMatcher(typ, pm.Name, pm.Value)
+		if err != nil {
+			return nil, err
+		}
+		ms[i] = m
+	}
+	return ms, nil
+}
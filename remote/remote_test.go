@@ -0,0 +1,207 @@
+package remote_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/hnakamur/timeseries/internal/snappy"
+	"github.com/hnakamur/timeseries/remote"
+	"github.com/hnakamur/timeseries/store"
+)
+
+func TestMarshalRoundTrip(t *testing.T) {
+	wr := &remote.WriteRequest{Timeseries: []remote.TimeSeries{
+		{
+			Labels:  []remote.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "api"}},
+			Samples: []remote.Sample{{Value: 1, Timestamp: 1427162400000}, {Value: 0, Timestamp: 1427162415000}},
+		},
+	}}
+	var gotWR remote.WriteRequest
+	err := gotWR.Unmarshal(wr.Marshal())
+	if err != nil {
+		t.Fatalf("failed to unmarshal write request: err=%+v", err)
+	}
+	if !reflect.DeepEqual(&gotWR, wr) {
+		t.Errorf("write request: got=%+v, want=%+v", gotWR, *wr)
+	}
+
+	rr := &remote.ReadRequest{Queries: []remote.Query{{
+		StartTimestampMs: 1,
+		EndTimestampMs:   2,
+		Matchers:         []remote.LabelMatcher{{Type: remote.MatchRE, Name: "job", Value: "a.*"}},
+	}}}
+	var gotRR remote.ReadRequest
+	err = gotRR.Unmarshal(rr.Marshal())
+	if err != nil {
+		t.Fatalf("failed to unmarshal read request: err=%+v", err)
+	}
+	if !reflect.DeepEqual(&gotRR, rr) {
+		t.Errorf("read request: got=%+v, want=%+v", gotRR, *rr)
+	}
+}
+
+func post(t *testing.T, h http.Handler, body []byte, wantCode int) []byte {
+	t.Helper()
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL, bytes.NewReader(snappy.Encode(nil, body)))
+	if err != nil {
+		t.Fatalf("failed to create request: err=%+v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to send request: err=%+v", err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response: err=%+v", err)
+	}
+	if resp.StatusCode != wantCode {
+		t.Fatalf("got status=%d, want=%d, body=%s", resp.StatusCode, wantCode, b)
+	}
+	return b
+}
+
+func TestWriteAndRead(t *testing.T) {
+	s, err := store.New(store.Options{})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+
+	staleNaN := math.Float64frombits(0x7ff0000000000002)
+	wr := remote.WriteRequest{Timeseries: []remote.TimeSeries{
+		{
+			Labels: []remote.Label{{Name: "job", Value: "api"}, {Name: "__name__", Value: "http_requests_total"}},
+			Samples: []remote.Sample{
+				{Value: 1, Timestamp: 1427162400000},
+				// The last sample in the same second wins.
+				{Value: 2, Timestamp: 1427162415250},
+				{Value: 3, Timestamp: 1427162415999},
+				{Value: staleNaN, Timestamp: 1427162430000},
+				{Value: 4, Timestamp: 1427162445000},
+			},
+		},
+		{
+			Labels:  []remote.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "db"}},
+			Samples: []remote.Sample{{Value: 1, Timestamp: 1427162400000}},
+		},
+	}}
+	post(t, remote.NewWriteHandler(s), wr.Marshal(), http.StatusNoContent)
+
+	rr := remote.ReadRequest{Queries: []remote.Query{
+		{
+			StartTimestampMs: 1427162400001,
+			EndTimestampMs:   1427162445000,
+			Matchers:         []remote.LabelMatcher{{Type: remote.MatchEQ, Name: "__name__", Value: "http_requests_total"}},
+		},
+		{
+			StartTimestampMs: 0,
+			EndTimestampMs:   1427162500000,
+			Matchers:         []remote.LabelMatcher{{Type: remote.MatchNRE, Name: "job", Value: "api|web"}},
+		},
+	}}
+	b := post(t, remote.NewReadHandler(s), rr.Marshal(), http.StatusOK)
+	decoded, err := snappy.Decode(nil, b, 0)
+	if err != nil {
+		t.Fatalf("failed to decompress response: err=%+v", err)
+	}
+	var got remote.ReadResponse
+	err = got.Unmarshal(decoded)
+	if err != nil {
+		t.Fatalf("failed to unmarshal response: err=%+v", err)
+	}
+
+	want := remote.ReadResponse{Results: []remote.QueryResult{
+		{Timeseries: []remote.TimeSeries{{
+			Labels: []remote.Label{{Name: "__name__", Value: "http_requests_total"}, {Name: "job", Value: "api"}},
+			Samples: []remote.Sample{
+				{Value: 3, Timestamp: 1427162415000},
+				{Value: 4, Timestamp: 1427162445000},
+			},
+		}}},
+		{Timeseries: []remote.TimeSeries{{
+			Labels:  []remote.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "db"}},
+			Samples: []remote.Sample{{Value: 1, Timestamp: 1427162400000}},
+		}}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got=%+v, want=%+v", got, want)
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	s, err := store.New(store.Options{})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+	testCases := []struct {
+		name string
+		req  remote.WriteRequest
+	}{
+		{name: "no metric name", req: remote.WriteRequest{Timeseries: []remote.TimeSeries{{
+			Labels:  []remote.Label{{Name: "job", Value: "api"}},
+			Samples: []remote.Sample{{Value: 1, Timestamp: 1000}},
+		}}}},
+		{name: "negative timestamp", req: remote.WriteRequest{Timeseries: []remote.TimeSeries{{
+			Labels:  []remote.Label{{Name: "__name__", Value: "up"}},
+			Samples: []remote.Sample{{Value: 1, Timestamp: -1000}},
+		}}}},
+		{name: "out of order", req: remote.WriteRequest{Timeseries: []remote.TimeSeries{{
+			Labels:  []remote.Label{{Name: "__name__", Value: "up"}},
+			Samples: []remote.Sample{{Value: 1, Timestamp: 2000}, {Value: 1, Timestamp: 1000}},
+		}}}},
+	}
+	for _, tc := range testCases {
+		err := remote.Write(s, &tc.req)
+		if err == nil {
+			t.Errorf("%s: got no error, want error", tc.name)
+		}
+	}
+
+	// A body which is not snappy-compressed is rejected.
+	ts := httptest.NewServer(remote.NewWriteHandler(s))
+	defer ts.Close()
+	resp, err := http.Post(ts.URL, "application/x-protobuf", bytes.NewReader([]byte{0xff, 0xff, 0xff}))
+	if err != nil {
+		t.Fatalf("failed to send request: err=%+v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status=%d, want=%d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	f.Add((&remote.WriteRequest{Timeseries: []remote.TimeSeries{{
+		Labels:  []remote.Label{{Name: "__name__", Value: "up"}},
+		Samples: []remote.Sample{{Value: 1, Timestamp: 1427162400000}},
+	}}}).Marshal())
+	f.Add((&remote.ReadRequest{Queries: []remote.Query{{
+		StartTimestampMs: 1427162400000,
+		EndTimestampMs:   1427166000000,
+		Matchers:         []remote.LabelMatcher{{Type: remote.MatchRE, Name: "job", Value: "a.*"}},
+	}}}).Marshal())
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var wr remote.WriteRequest
+		if wr.Unmarshal(b) == nil {
+			s, err := store.New(store.Options{})
+			if err != nil {
+				t.Fatalf("failed to create store: err=%+v", err)
+			}
+			remote.Write(s, &wr)
+		}
+		var rr remote.ReadRequest
+		rr.Unmarshal(b)
+		var resp remote.ReadResponse
+		resp.Unmarshal(b)
+	})
+}
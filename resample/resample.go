@@ -0,0 +1,192 @@
+// Package resample implements resampling of data points with irregular
+// timestamps onto a grid of a fixed step, so that series can be plotted or
+// combined element-wise.
+package resample
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+)
+
+// Strategy is the strategy to fill a value at a grid timestamp.
+type Strategy int
+
+const (
+	// Previous uses the value of the last data point at or before the grid
+	// timestamp.
+	Previous Strategy = iota
+
+	// Linear interpolates linearly between the data points around the grid
+	// timestamp.
+	Linear
+
+	// Nearest uses the value of the data point closest to the grid timestamp.
+	// The earlier one wins a tie.
+	Nearest
+
+	// Null uses the value of the last data point in the step ending at the
+	// grid timestamp, that is after the previous grid timestamp and at or
+	// before the grid timestamp, and NaN if there is none.
+	Null
+)
+
+// Options is options for resampling.
+type Options struct {
+	// Start is the first grid timestamp.
+	Start uint32
+
+	// End is the last timestamp of the grid. The last grid timestamp is the
+	// largest one at or before End.
+	End uint32
+
+	// Step is the step of the grid. It must be a positive multiple of a second.
+	Step time.Duration
+
+	// Strategy is the strategy to fill values.
+	Strategy Strategy
+
+	// MaxGap is the maximum lookback. For Previous and Nearest, data points
+	// further than MaxGap from the grid timestamp are not used. For Linear,
+	// data points further than MaxGap apart are not interpolated.
+	// Zero means no limit.
+	MaxGap time.Duration
+}
+
+// Grid is values at timestamps of a fixed step. Missing values are NaN.
+type Grid struct {
+	Start  uint32
+	Step   uint32
+	Values []float64
+}
+
+// Timestamp returns the timestamp of the i-th value.
+func (g Grid) Timestamp(i int) uint32 {
+	return g.Start + uint32(i)*g.Step
+}
+
+// Points returns the values which are not NaN as data points.
+func (g Grid) Points() []timeseries.Point {
+	var points []timeseries.Point
+	for i, v := range g.Values {
+		if !math.IsNaN(v) {
+			points = append(points, timeseries.Point{Timestamp: g.Timestamp(i), Value: v})
+		}
+	}
+	return points
+}
+
+// Resample reads data points from the iterator and returns values on the grid.
+func Resample(it timeseries.Iterator, opts Options) (Grid, error) {
+	if opts.Step < time.Second || opts.Step%time.Second != 0 {
+		return Grid{}, fmt.Errorf("invalid resampling step: %s, must be positive multiple of second", opts.Step)
+	}
+	if opts.End < opts.Start {
+		return Grid{}, fmt.Errorf("resampling end is before start: start=%d, end=%d", opts.Start, opts.End)
+	}
+	if opts.MaxGap < 0 {
+		return Grid{}, fmt.Errorf("invalid resampling max gap: %s", opts.MaxGap)
+	}
+	switch opts.Strategy {
+	case Previous, Linear, Nearest, Null:
+	default:
+		return Grid{}, fmt.Errorf("invalid resampling strategy: %d", int(opts.Strategy))
+	}
+
+	step := uint64(opts.Step / time.Second)
+	maxGap := uint64(opts.MaxGap / time.Second)
+	if opts.MaxGap == 0 {
+		maxGap = math.MaxUint64
+	}
+	n := (uint64(opts.End)-uint64(opts.Start))/step + 1
+	g := Grid{Start: opts.Start, Step: uint32(step), Values: make([]float64, n)}
+
+	var prev, next timeseries.Point
+	hasPrev := false
+	hasNext := it.Next()
+	if hasNext {
+		next = it.At()
+	}
+	for i := range g.Values {
+		t := uint64(opts.Start) + uint64(i)*step
+		for hasNext && uint64(next.Timestamp) <= t {
+			prev, hasPrev = next, true
+			hasNext = it.Next()
+			if hasNext {
+				next = it.At()
+			}
+		}
+		g.Values[i] = fill(opts.Strategy, t, step, maxGap, prev, hasPrev, next, hasNext)
+	}
+	if err := it.Err(); err != nil {
+		return Grid{}, err
+	}
+	return g, nil
+}
+
+// fill returns the value at the grid timestamp t. prev is the last data point
+// at or before t and next is the first data point after t.
+func fill(s Strategy, t, step, maxGap uint64, prev timeseries.Point, hasPrev bool, next timeseries.Point, hasNext bool) float64 {
+	toPrev := t - uint64(prev.Timestamp)
+	toNext := uint64(next.Timestamp) - t
+	switch s {
+	case Previous:
+		if hasPrev && toPrev <= maxGap {
+			return prev.Value
+		}
+	case Linear:
+		if hasPrev && toPrev == 0 {
+			return prev.Value
+		}
+		if hasPrev && hasNext && toPrev+toNext <= maxGap {
+			return prev.Value + (next.Value-prev.Value)*float64(toPrev)/float64(toPrev+toNext)
+		}
+	case Nearest:
+		if hasPrev && toPrev <= maxGap && (!hasNext || toPrev <= toNext) {
+			return prev.Value
+		}
+		if hasNext && toNext <= maxGap {
+			return next.Value
+		}
+	case Null:
+		if hasPrev && toPrev < step {
+			return prev.Value
+		}
+	}
+	return math.NaN()
+}
+
+// Apply combines grids element-wise with the operator. The grids must have
+// the same start, step and length. The result is NaN where any of the values
+// is NaN.
+func Apply(op func(a, b float64) float64, a, b Grid) (Grid, error) {
+	if a.Start != b.Start || a.Step != b.Step || len(a.Values) != len(b.Values) {
+		return Grid{}, errors.New("resampled grids are not aligned")
+	}
+	g := Grid{Start: a.Start, Step: a.Step, Values: make([]float64, len(a.Values))}
+	for i := range g.Values {
+		if math.IsNaN(a.Values[i]) || math.IsNaN(b.Values[i]) {
+			g.Values[i] = math.NaN()
+			continue
+		}
+		g.Values[i] = op(a.Values[i], b.Values[i])
+	}
+	return g, nil
+}
+
+// ResampleAll resamples the series read from the iterators with the same
+// options, so that the results can be combined with Apply.
+func ResampleAll(its []timeseries.Iterator, opts Options) ([]Grid, error) {
+	grids := make([]Grid, len(its))
+	for i, it := range its {
+		g, err := Resample(it, opts)
+		if err != nil {
+			return nil, err
+		}
+		grids[i] = g
+	}
+	return grids, nil
+}
@@ -0,0 +1,103 @@
+package resample_test
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/resample"
+)
+
+var nan = math.NaN()
+
+func equalValues(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.IsNaN(a[i]) != math.IsNaN(b[i]) || !math.IsNaN(a[i]) && math.Abs(a[i]-b[i]) > 1e-12 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestResample(t *testing.T) {
+	points := []timeseries.Point{
+		{Timestamp: 105, Value: 1},
+		{Timestamp: 118, Value: 3},
+		{Timestamp: 160, Value: 7},
+	}
+
+	testCases := []struct {
+		strategy resample.Strategy
+		maxGap   time.Duration
+		want     []float64
+	}{
+		// Grid timestamps are 100, 110, 120, ..., 180.
+		{strategy: resample.Previous, want: []float64{nan, 1, 3, 3, 3, 3, 7, 7, 7}},
+		{strategy: resample.Previous, maxGap: 15 * time.Second, want: []float64{nan, 1, 3, 3, nan, nan, 7, 7, nan}},
+		{strategy: resample.Linear, want: []float64{nan, 1 + 2*5.0/13, 3 + 4*2.0/42, 3 + 4*12.0/42, 3 + 4*22.0/42, 3 + 4*32.0/42, 7, nan, nan}},
+		{strategy: resample.Linear, maxGap: 20 * time.Second, want: []float64{nan, 1 + 2*5.0/13, nan, nan, nan, nan, 7, nan, nan}},
+		{strategy: resample.Nearest, want: []float64{1, 1, 3, 3, 7, 7, 7, 7, 7}},
+		{strategy: resample.Nearest, maxGap: 10 * time.Second, want: []float64{1, 1, 3, nan, nan, 7, 7, 7, nan}},
+		{strategy: resample.Null, want: []float64{nan, 1, 3, nan, nan, nan, 7, nan, nan}},
+	}
+
+	for _, tc := range testCases {
+		g, err := resample.Resample(timeseries.NewSliceIterator(points), resample.Options{
+			Start:    100,
+			End:      185,
+			Step:     10 * time.Second,
+			Strategy: tc.strategy,
+			MaxGap:   tc.maxGap,
+		})
+		if err != nil {
+			t.Fatalf("failed to resample: err=%+v", err)
+		}
+		if g.Start != 100 || g.Step != 10 {
+			t.Errorf("got start=%d, step=%d, want start=100, step=10", g.Start, g.Step)
+		}
+		if !equalValues(g.Values, tc.want) {
+			t.Errorf("strategy=%d, maxGap=%s, got=%v, want=%v", tc.strategy, tc.maxGap, g.Values, tc.want)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	errors := []timeseries.Point{
+		{Timestamp: 0, Value: 1},
+		{Timestamp: 62, Value: 3},
+	}
+	requests := []timeseries.Point{
+		{Timestamp: 1, Value: 10},
+		{Timestamp: 59, Value: 20},
+		{Timestamp: 121, Value: 40},
+	}
+	grids, err := resample.ResampleAll([]timeseries.Iterator{
+		timeseries.NewSliceIterator(errors),
+		timeseries.NewSliceIterator(requests),
+	}, resample.Options{Start: 0, End: 120, Step: time.Minute, Strategy: resample.Previous})
+	if err != nil {
+		t.Fatalf("failed to resample: err=%+v", err)
+	}
+
+	ratio, err := resample.Apply(func(a, b float64) float64 { return a / b }, grids[0], grids[1])
+	if err != nil {
+		t.Fatalf("failed to apply operator: err=%+v", err)
+	}
+	want := []timeseries.Point{
+		{Timestamp: 60, Value: 0.05},
+		{Timestamp: 120, Value: 0.15},
+	}
+	if got := ratio.Points(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got=%+v, want=%+v", got, want)
+	}
+
+	_, err = resample.Apply(func(a, b float64) float64 { return a / b }, grids[0], resample.Grid{Start: 0, Step: 30})
+	if err == nil {
+		t.Error("unaligned grids: got no error, want an error")
+	}
+}
@@ -0,0 +1,145 @@
+package timeseries_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+)
+
+func TestEncoderReset(t *testing.T) {
+	t0 := uint32(time.Date(2015, 3, 24, 2, 0, 0, 0, time.UTC).Unix())
+	points := []timeseries.Point{
+		{
+			Timestamp: uint32(time.Date(2015, 3, 24, 2, 1, 2, 0, time.UTC).Unix()),
+			Value:     12.0,
+		},
+		{
+			Timestamp: uint32(time.Date(2015, 3, 24, 2, 2, 2, 0, time.UTC).Unix()),
+			Value:     12.5,
+		},
+		{
+			Timestamp: uint32(time.Date(2015, 3, 24, 2, 3, 2, 0, time.UTC).Unix()),
+			Value:     -24.2,
+		},
+	}
+	want := "5510c52000f900a0000000000002fdbc1b0010022666666666667ffffffffe"
+
+	var b bytes.Buffer
+	enc := timeseries.NewEncoder(&b)
+	for i := 0; i < 2; i++ {
+		b.Reset()
+		enc.Reset(&b)
+		err := enc.EncodeHeader(t0)
+		if err != nil {
+			t.Fatalf("failed to encode time series header: err=%+v", err)
+		}
+		for _, p := range points {
+			err = enc.EncodePoint(p)
+			if err != nil {
+				t.Fatalf("failed to encode time series point: err=%+v", err)
+			}
+		}
+		err = enc.Finish()
+		if err != nil {
+			t.Fatalf("failed to encode time series finish marker: err=%+v", err)
+		}
+
+		got := hex.EncodeToString(b.Bytes())
+		if got != want {
+			t.Errorf("i=%d, got=%s, want=%s", i, got, want)
+		}
+	}
+}
+
+func TestDecoderReset(t *testing.T) {
+	inputs := []string{
+		"5510c52000f900a0000000000002fdbc1b0010022666666666667ffffffffe",
+		"5510c52000f900a0000000000003ffffffffc0",
+	}
+	wantPoints := [][]timeseries.Point{
+		{
+			{
+				Timestamp: uint32(time.Date(2015, 3, 24, 2, 1, 2, 0, time.UTC).Unix()),
+				Value:     12.0,
+			},
+			{
+				Timestamp: uint32(time.Date(2015, 3, 24, 2, 2, 2, 0, time.UTC).Unix()),
+				Value:     12.5,
+			},
+			{
+				Timestamp: uint32(time.Date(2015, 3, 24, 2, 3, 2, 0, time.UTC).Unix()),
+				Value:     -24.2,
+			},
+		},
+		{
+			{
+				Timestamp: uint32(time.Date(2015, 3, 24, 2, 1, 2, 0, time.UTC).Unix()),
+				Value:     12.0,
+			},
+		},
+	}
+
+	dec := timeseries.NewDecoder(nil)
+	for i, input := range inputs {
+		data, err := hex.DecodeString(input)
+		if err != nil {
+			t.Fatalf("failed to decode input hex string: input=%s, err=%+v", input, err)
+		}
+
+		dec.Reset(bytes.NewReader(data))
+		_, err = dec.DecodeHeader()
+		if err != nil {
+			t.Fatalf("failed to decode time series header: input=%s, err=%+v", input, err)
+		}
+		var points []timeseries.Point
+		for {
+			p, err := dec.DecodePoint()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("failed to decode time series point: input=%s, err=%+v", input, err)
+			}
+			points = append(points, p)
+		}
+		if !reflect.DeepEqual(points, wantPoints[i]) {
+			t.Errorf("input=%s, gotPoints=%+v, wantPoints=%+v", input, points, wantPoints[i])
+		}
+	}
+}
+
+func TestEncoderPool(t *testing.T) {
+	t0 := uint32(time.Date(2015, 3, 24, 2, 0, 0, 0, time.UTC).Unix())
+	p := timeseries.Point{
+		Timestamp: uint32(time.Date(2015, 3, 24, 2, 1, 2, 0, time.UTC).Unix()),
+		Value:     12.0,
+	}
+	want := "5510c52000f900a0000000000003ffffffffc0"
+
+	for i := 0; i < 3; i++ {
+		var b bytes.Buffer
+		enc := timeseries.GetEncoder(&b)
+		err := enc.EncodeHeader(t0)
+		if err != nil {
+			t.Fatalf("failed to encode time series header: err=%+v", err)
+		}
+		err = enc.EncodePoint(p)
+		if err != nil {
+			t.Fatalf("failed to encode time series point: err=%+v", err)
+		}
+		err = enc.Finish()
+		if err != nil {
+			t.Fatalf("failed to encode time series finish marker: err=%+v", err)
+		}
+		timeseries.PutEncoder(enc)
+
+		got := hex.EncodeToString(b.Bytes())
+		if got != want {
+			t.Errorf("i=%d, got=%s, want=%s", i, got, want)
+		}
+	}
+}
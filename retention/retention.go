@@ -0,0 +1,233 @@
+// Package retention implements retention policies which delete sealed blocks
+// in a store older than a configured age, or evict the oldest sealed blocks
+// when the total encoded size exceeds a budget.
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/store"
+)
+
+// Rule is a retention rule for the series whose keys match the pattern.
+type Rule struct {
+	// Pattern is a regular expression which series keys must fully match.
+	// An empty pattern matches all series.
+	Pattern string
+
+	// MaxAge is the maximum age of sealed blocks. A sealed block is deleted
+	// when its last data point is older than MaxAge. Zero means no limit.
+	MaxAge time.Duration
+
+	// MaxBytes is the maximum total size in bytes of the sealed blocks of all
+	// series matching the rule. The oldest sealed blocks are deleted when the
+	// total size exceeds MaxBytes. Zero means no limit.
+	MaxBytes int64
+}
+
+// Policy is a retention policy.
+type Policy struct {
+	// Rules is the retention rules. A series is subject to the first rule
+	// whose pattern matches the series key.
+	Rules []Rule
+
+	// Interval is the interval for Run to apply the policy.
+	Interval time.Duration
+
+	// Now returns the current time. It defaults to time.Now if nil.
+	Now func() time.Time
+}
+
+// Metrics is the metrics of an enforcer.
+type Metrics struct {
+	// Runs is the number of times the policy was applied.
+	Runs int64
+
+	// Errors is the number of times applying the policy failed.
+	Errors int64
+
+	// DeletedBlocks is the total number of deleted blocks.
+	DeletedBlocks int64
+
+	// DeletedBytes is the total size in bytes of deleted blocks.
+	DeletedBytes int64
+
+	// LastRun is the time when the policy was applied last.
+	LastRun time.Time
+}
+
+// Result is the result of applying a policy once.
+type Result struct {
+	// DeletedBlocks is the number of deleted blocks.
+	DeletedBlocks int
+
+	// DeletedBytes is the total size in bytes of deleted blocks.
+	DeletedBytes int64
+}
+
+// Enforcer applies a retention policy to a store.
+type Enforcer struct {
+	store  *store.Store
+	policy Policy
+	res    []*regexp.Regexp
+
+	mu      sync.Mutex
+	metrics Metrics
+}
+
+// New creates an enforcer.
+func New(s *store.Store, p Policy) (*Enforcer, error) {
+	if p.Now == nil {
+		p.Now = time.Now
+	}
+	e := &Enforcer{store: s, policy: p}
+	for _, r := range p.Rules {
+		if r.MaxAge < 0 || r.MaxBytes < 0 {
+			return nil, fmt.Errorf("invalid retention rule: pattern=%s, maxAge=%s, maxBytes=%d",
+				r.Pattern, r.MaxAge, r.MaxBytes)
+		}
+		if r.Pattern == "" {
+			e.res = append(e.res, nil)
+			continue
+		}
+		re, err := regexp.Compile("^(?:" + r.Pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid retention rule pattern: pattern=%s, err=%+v", r.Pattern, err)
+		}
+		e.res = append(e.res, re)
+	}
+	return e, nil
+}
+
+// Metrics returns the metrics of the enforcer.
+func (e *Enforcer) Metrics() Metrics {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.metrics
+}
+
+// Run applies the policy every interval until the context is done.
+// It returns the error of the context.
+func (e *Enforcer) Run(ctx context.Context) error {
+	if e.policy.Interval <= 0 {
+		return errors.New("retention interval must be positive")
+	}
+	ticker := time.NewTicker(e.policy.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			// Errors are counted in the metrics and the policy is applied
+			// again at the next tick.
+			e.Apply()
+		}
+	}
+}
+
+// candidate is a sealed block which may be deleted.
+type candidate struct {
+	key   string
+	index int
+	last  uint32
+	bytes int64
+}
+
+// Apply applies the policy once.
+func (e *Enforcer) Apply() (Result, error) {
+	now := e.policy.Now()
+	res, err := e.apply(now)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.metrics.Runs++
+	if err != nil {
+		e.metrics.Errors++
+	}
+	e.metrics.DeletedBlocks += int64(res.DeletedBlocks)
+	e.metrics.DeletedBytes += res.DeletedBytes
+	e.metrics.LastRun = now
+	return res, err
+}
+
+func (e *Enforcer) apply(now time.Time) (Result, error) {
+	keys := make([][]string, len(e.policy.Rules))
+	for _, key := range e.store.Keys() {
+		for i, re := range e.res {
+			if re == nil || re.MatchString(key) {
+				keys[i] = append(keys[i], key)
+				break
+			}
+		}
+	}
+
+	var res Result
+	for i, r := range e.policy.Rules {
+		var minLast uint32
+		if r.MaxAge > 0 {
+			minLast, _ = timeseries.Timestamp(now.Add(-r.MaxAge))
+		}
+
+		blocks := make(map[string][]store.BlockInfo)
+		deletes := make(map[string]int)
+		var kept []candidate
+		var total int64
+		for _, key := range keys[i] {
+			bs := e.store.SealedBlocks(key)
+			blocks[key] = bs
+			for j, b := range bs {
+				if b.Last < minLast {
+					deletes[key] = j + 1
+					continue
+				}
+				c := candidate{key: key, index: j, last: b.Last, bytes: int64(len(b.Block.Data))}
+				kept = append(kept, c)
+				total += c.bytes
+			}
+		}
+
+		if r.MaxBytes > 0 && total > r.MaxBytes {
+			sort.SliceStable(kept, func(i, j int) bool {
+				return kept[i].last < kept[j].last
+			})
+			for _, c := range kept {
+				if total <= r.MaxBytes {
+					break
+				}
+				// Blocks of a series are deleted from the oldest, so delete
+				// the preceding blocks of the series too.
+				if c.index+1 > deletes[c.key] {
+					for _, b := range blocks[c.key][deletes[c.key] : c.index+1] {
+						total -= int64(len(b.Block.Data))
+					}
+					deletes[c.key] = c.index + 1
+				}
+			}
+		}
+
+		for key, n := range deletes {
+			old := blocks[key][:n]
+			err := e.store.ReplaceSealedBlocks(key, old, nil)
+			if err == store.ErrConflict {
+				// The blocks were changed concurrently. They will be
+				// deleted at the next run if still needed.
+				continue
+			} else if err != nil {
+				return res, err
+			}
+			res.DeletedBlocks += n
+			for _, b := range old {
+				res.DeletedBytes += int64(len(b.Block.Data))
+			}
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,145 @@
+package retention_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/retention"
+	"github.com/hnakamur/timeseries/store"
+)
+
+func ts(hour, min int) uint32 {
+	return uint32(time.Date(2015, 3, 24, hour, min, 0, 0, time.UTC).Unix())
+}
+
+// newTestStore creates a store with 6 sealed blocks of 10 minutes for each key.
+func newTestStore(t *testing.T, keys ...string) *store.Store {
+	s, err := store.New(store.Options{BlockDuration: 10 * time.Minute})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+	for _, key := range keys {
+		for i := 0; i < 60; i++ {
+			err = s.Append(key, timeseries.Point{Timestamp: ts(2, 0) + uint32(i*60), Value: float64(i)})
+			if err != nil {
+				t.Fatalf("failed to append point: err=%+v", err)
+			}
+		}
+	}
+	err = s.Flush()
+	if err != nil {
+		t.Fatalf("failed to flush store: err=%+v", err)
+	}
+	return s
+}
+
+func TestApplyMaxAge(t *testing.T) {
+	s := newTestStore(t, "cpu.host1", "mem.host1")
+	e, err := retention.New(s, retention.Policy{
+		Rules: []retention.Rule{
+			{Pattern: `cpu\..*`, MaxAge: 30 * time.Minute},
+		},
+		Now: func() time.Time {
+			return time.Unix(int64(ts(3, 0)), 0)
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create enforcer: err=%+v", err)
+	}
+
+	res, err := e.Apply()
+	if err != nil {
+		t.Fatalf("failed to apply policy: err=%+v", err)
+	}
+	// Blocks whose last points are 02:09, 02:19 and 02:29 are older than 02:30.
+	if res.DeletedBlocks != 3 {
+		t.Errorf("got deleted blocks=%d, want 3", res.DeletedBlocks)
+	}
+	if got := len(s.SealedBlocks("cpu.host1")); got != 3 {
+		t.Errorf("got %d blocks for cpu.host1, want 3", got)
+	}
+	if got := len(s.SealedBlocks("mem.host1")); got != 6 {
+		t.Errorf("got %d blocks for mem.host1, want 6", got)
+	}
+
+	m := e.Metrics()
+	if m.Runs != 1 || m.DeletedBlocks != 3 || m.DeletedBytes != res.DeletedBytes {
+		t.Errorf("got metrics=%+v, want 1 run and 3 deleted blocks", m)
+	}
+}
+
+func TestApplyMaxBytes(t *testing.T) {
+	s := newTestStore(t, "cpu.host1", "cpu.host2")
+	blocks := s.SealedBlocks("cpu.host1")
+	var blockBytes int64
+	for _, b := range blocks {
+		blockBytes += int64(len(b.Block.Data))
+	}
+
+	e, err := retention.New(s, retention.Policy{
+		Rules: []retention.Rule{
+			{MaxBytes: blockBytes},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create enforcer: err=%+v", err)
+	}
+	res, err := e.Apply()
+	if err != nil {
+		t.Fatalf("failed to apply policy: err=%+v", err)
+	}
+	if res.DeletedBytes < blockBytes {
+		t.Errorf("got deleted bytes=%d, want %d or more", res.DeletedBytes, blockBytes)
+	}
+	if s.Bytes() > blockBytes {
+		t.Errorf("got store bytes=%d, want %d or less", s.Bytes(), blockBytes)
+	}
+
+	// The oldest blocks of both series are deleted, so both series keep
+	// their newest blocks.
+	for _, key := range []string{"cpu.host1", "cpu.host2"} {
+		bs := s.SealedBlocks(key)
+		if len(bs) == 0 || bs[len(bs)-1].Last != ts(2, 59) {
+			t.Errorf("key=%s, got blocks=%d, want the newest block kept", key, len(bs))
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	s := newTestStore(t, "cpu")
+	e, err := retention.New(s, retention.Policy{
+		Rules: []retention.Rule{
+			{MaxAge: time.Hour},
+		},
+		Interval: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create enforcer: err=%+v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- e.Run(ctx)
+	}()
+	for e.Metrics().Runs == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	err = <-done
+	if err != context.Canceled {
+		t.Errorf("got err=%v, want %v", err, context.Canceled)
+	}
+	if got := len(s.SealedBlocks("cpu")); got != 0 {
+		t.Errorf("got %d blocks, want 0", got)
+	}
+
+	_, err = retention.New(s, retention.Policy{
+		Rules: []retention.Rule{{Pattern: "("}},
+	})
+	if err == nil {
+		t.Error("invalid pattern: got no error, want an error")
+	}
+}
@@ -0,0 +1,208 @@
+package timeseries
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgryski/go-bitstream"
+)
+
+// MaxBlockDuration is the maximum block duration for SeriesWriter.
+// Any point in a block of this duration can be encoded as the first point.
+const MaxBlockDuration = MaxFirstDelta + time.Second
+
+// ErrOutOfOrder is returned by SeriesWriter.Write when the point is before
+// the previous point.
+var ErrOutOfOrder = errors.New("point is before previous point")
+
+// BlockSink receives sealed blocks from a SeriesWriter.
+type BlockSink interface {
+	// WriteBlock is called with the block timestamp and the sealed block.
+	// The sink may retain the block.
+	WriteBlock(t0 uint32, block Block) error
+}
+
+// BlockSinkFunc is an adapter to allow the use of ordinary functions as
+// BlockSink.
+type BlockSinkFunc func(t0 uint32, block Block) error
+
+// WriteBlock calls f(t0, block).
+func (f BlockSinkFunc) WriteBlock(t0 uint32, block Block) error {
+	return f(t0, block)
+}
+
+// SeriesWriter writes data points of a time series into blocks of a fixed
+// duration. It chooses the block timestamp of each block, rolls over to a new
+// block when a point falls outside the current block window, and hands sealed
+// blocks to a BlockSink.
+//
+// Block timestamps are the alignment plus multiples of the block duration
+// since 1970-01-01 00:00:00 +0000 UTC. For example, with the block duration of
+// two hours and the alignment of zero, blocks start at 00:00, 02:00, 04:00 and
+// so on in UTC.
+type SeriesWriter struct {
+	duration  uint32
+	alignment uint32
+	sink      BlockSink
+
+	buf  *bytes.Buffer
+	enc  *Encoder
+	open bool
+	t0   uint32
+	end  uint64
+	last uint32
+}
+
+// NewSeriesWriter creates a series writer. blockDuration and alignment must
+// be multiples of a second, blockDuration must be between a second and
+// MaxBlockDuration, and alignment must be between zero and blockDuration.
+func NewSeriesWriter(blockDuration, alignment time.Duration, sink BlockSink) (*SeriesWriter, error) {
+	err := ValidateBlockDuration(blockDuration, alignment)
+	if err != nil {
+		return nil, err
+	}
+	if sink == nil {
+		return nil, errors.New("block sink must not be nil")
+	}
+	return &SeriesWriter{
+		duration:  uint32(blockDuration / time.Second),
+		alignment: uint32(alignment / time.Second),
+		sink:      sink,
+		enc:       NewEncoder(nil),
+	}, nil
+}
+
+// ValidateBlockDuration returns an error if the block duration or the
+// alignment is not accepted by NewSeriesWriter.
+func ValidateBlockDuration(blockDuration, alignment time.Duration) error {
+	if blockDuration < time.Second || blockDuration > MaxBlockDuration || blockDuration%time.Second != 0 {
+		return fmt.Errorf("invalid block duration: duration=%s, must be multiple of second and between 1s and %s",
+			blockDuration, MaxBlockDuration)
+	}
+	if alignment < 0 || alignment >= blockDuration || alignment%time.Second != 0 {
+		return fmt.Errorf("invalid block alignment: alignment=%s, must be multiple of second and between 0s and block duration %s",
+			alignment, blockDuration)
+	}
+	return nil
+}
+
+// BlockStart returns the block timestamp of the block which contains the
+// timestamp.
+func (w *SeriesWriter) BlockStart(timestamp uint32) uint32 {
+	return blockStart(timestamp, w.duration, w.alignment)
+}
+
+// BlockStart returns the block timestamp of the block which contains the
+// timestamp for a SeriesWriter with the block duration and the alignment.
+// They must be valid for ValidateBlockDuration.
+func BlockStart(timestamp uint32, blockDuration, alignment time.Duration) uint32 {
+	return blockStart(timestamp, uint32(blockDuration/time.Second), uint32(alignment/time.Second))
+}
+
+func blockStart(timestamp, duration, alignment uint32) uint32 {
+	if timestamp < alignment {
+		// The block containing the timestamp starts before the epoch, so
+		// the block is shortened to start at the epoch.
+		return 0
+	}
+	return timestamp - (timestamp-alignment)%duration
+}
+
+// Write writes a data point. It seals the current block and starts a new one
+// if the point falls after the current block window.
+// It returns an error wrapping ErrOutOfOrder if the point is before the
+// previous point.
+func (w *SeriesWriter) Write(p Point) error {
+	if p.Timestamp < w.last {
+		return fmt.Errorf("%w: timestamp=%d, previousTimestamp=%d",
+			ErrOutOfOrder, p.Timestamp, w.last)
+	}
+
+	if w.open && uint64(p.Timestamp) >= w.end {
+		err := w.seal()
+		if err != nil {
+			return err
+		}
+	}
+
+	if !w.open {
+		err := w.start(w.BlockStart(p.Timestamp))
+		if err != nil {
+			return err
+		}
+	}
+
+	err := w.enc.EncodePoint(p)
+	if err != nil {
+		return fmt.Errorf("failed to encode time series point: err=%+v", err)
+	}
+	w.last = p.Timestamp
+	return nil
+}
+
+// Len returns the number of bytes encoded so far in the current block.
+// It returns zero if there is no current block.
+func (w *SeriesWriter) Len() int {
+	if !w.open {
+		return 0
+	}
+	return w.buf.Len()
+}
+
+// OpenBlock returns a copy of the current block with the finish marker, so
+// that the points written so far can be decoded while the writer keeps
+// appending to the current block. ok is false if there is no current block.
+func (w *SeriesWriter) OpenBlock() (t0 uint32, block Block, ok bool, err error) {
+	if !w.open {
+		return 0, Block{}, false, nil
+	}
+
+	b := bytes.NewBuffer(make([]byte, 0, w.buf.Len()+6))
+	b.Write(w.buf.Bytes())
+	enc := *w.enc
+	enc.wr = bitstream.NewWriter(b)
+	enc.wr.Resume(w.enc.wr.Pending())
+	err = enc.Finish()
+	if err != nil {
+		return 0, Block{}, false, fmt.Errorf("failed to encode time series finish marker: err=%+v", err)
+	}
+	return w.t0, Block{Data: b.Bytes()}, true, nil
+}
+
+// Flush seals the current block if any and hands it to the sink.
+func (w *SeriesWriter) Flush() error {
+	if !w.open {
+		return nil
+	}
+	return w.seal()
+}
+
+func (w *SeriesWriter) start(t0 uint32) error {
+	w.buf = new(bytes.Buffer)
+	w.enc.Reset(w.buf)
+	err := w.enc.EncodeHeader(t0)
+	if err != nil {
+		return fmt.Errorf("failed to encode time series header: err=%+v", err)
+	}
+	w.open = true
+	w.t0 = t0
+	if t0 < w.alignment {
+		w.end = uint64(w.alignment)
+	} else {
+		w.end = uint64(t0) + uint64(w.duration)
+	}
+	return nil
+}
+
+func (w *SeriesWriter) seal() error {
+	w.open = false
+	err := w.enc.Finish()
+	if err != nil {
+		return fmt.Errorf("failed to encode time series finish marker: err=%+v", err)
+	}
+	block := Block{Data: w.buf.Bytes()}
+	w.buf = nil
+	return w.sink.WriteBlock(w.t0, block)
+}
@@ -0,0 +1,210 @@
+package timeseries_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+)
+
+type sealedBlock struct {
+	t0     uint32
+	points []timeseries.Point
+}
+
+func TestSeriesWriter(t *testing.T) {
+	ts := func(hour, min int) uint32 {
+		return uint32(time.Date(2015, 3, 24, hour, min, 0, 0, time.UTC).Unix())
+	}
+
+	testCases := []struct {
+		alignment time.Duration
+		points    []timeseries.Point
+		want      []sealedBlock
+	}{
+		{
+			alignment: 0,
+			points: []timeseries.Point{
+				{Timestamp: ts(2, 1), Value: 1},
+				{Timestamp: ts(3, 59), Value: 2},
+				{Timestamp: ts(4, 0), Value: 3},
+				{Timestamp: ts(9, 30), Value: 4},
+			},
+			want: []sealedBlock{
+				{
+					t0: ts(2, 0),
+					points: []timeseries.Point{
+						{Timestamp: ts(2, 1), Value: 1},
+						{Timestamp: ts(3, 59), Value: 2},
+					},
+				},
+				{
+					t0: ts(4, 0),
+					points: []timeseries.Point{
+						{Timestamp: ts(4, 0), Value: 3},
+					},
+				},
+				{
+					t0: ts(8, 0),
+					points: []timeseries.Point{
+						{Timestamp: ts(9, 30), Value: 4},
+					},
+				},
+			},
+		},
+		{
+			alignment: time.Hour,
+			points: []timeseries.Point{
+				{Timestamp: ts(2, 1), Value: 1},
+				{Timestamp: ts(3, 0), Value: 2},
+			},
+			want: []sealedBlock{
+				{
+					t0: ts(1, 0),
+					points: []timeseries.Point{
+						{Timestamp: ts(2, 1), Value: 1},
+					},
+				},
+				{
+					t0: ts(3, 0),
+					points: []timeseries.Point{
+						{Timestamp: ts(3, 0), Value: 2},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		var got []sealedBlock
+		sink := timeseries.BlockSinkFunc(func(t0 uint32, block timeseries.Block) error {
+			blockT0, points, err := block.Points()
+			if err != nil {
+				return err
+			}
+			if blockT0 != t0 {
+				t.Errorf("block header t0=%d, sink t0=%d", blockT0, t0)
+			}
+			got = append(got, sealedBlock{t0: t0, points: points})
+			return nil
+		})
+
+		w, err := timeseries.NewSeriesWriter(2*time.Hour, tc.alignment, sink)
+		if err != nil {
+			t.Fatalf("failed to create series writer: err=%+v", err)
+		}
+		for _, p := range tc.points {
+			err = w.Write(p)
+			if err != nil {
+				t.Fatalf("failed to write point: point=%+v, err=%+v", p, err)
+			}
+		}
+		err = w.Flush()
+		if err != nil {
+			t.Fatalf("failed to flush series writer: err=%+v", err)
+		}
+
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("alignment=%s, got=%+v, want=%+v", tc.alignment, got, tc.want)
+		}
+	}
+}
+
+func TestSeriesWriterError(t *testing.T) {
+	sink := timeseries.BlockSinkFunc(func(t0 uint32, block timeseries.Block) error {
+		return nil
+	})
+
+	testCases := []struct {
+		duration  time.Duration
+		alignment time.Duration
+	}{
+		{duration: 0, alignment: 0},
+		{duration: 1500 * time.Millisecond, alignment: 0},
+		{duration: timeseries.MaxBlockDuration + time.Second, alignment: 0},
+		{duration: time.Hour, alignment: time.Hour},
+		{duration: time.Hour, alignment: -time.Second},
+	}
+	for _, tc := range testCases {
+		_, err := timeseries.NewSeriesWriter(tc.duration, tc.alignment, sink)
+		if err == nil {
+			t.Errorf("duration=%s, alignment=%s, got no error, want an error", tc.duration, tc.alignment)
+		}
+		err = timeseries.ValidateBlockDuration(tc.duration, tc.alignment)
+		if err == nil {
+			t.Errorf("validate duration=%s, alignment=%s, got no error, want an error", tc.duration, tc.alignment)
+		}
+	}
+
+	w, err := timeseries.NewSeriesWriter(time.Hour, 0, sink)
+	if err != nil {
+		t.Fatalf("failed to create series writer: err=%+v", err)
+	}
+	err = w.Write(timeseries.Point{Timestamp: 7200, Value: 1})
+	if err != nil {
+		t.Fatalf("failed to write point: err=%+v", err)
+	}
+	err = w.Write(timeseries.Point{Timestamp: 7199, Value: 1})
+	if err == nil {
+		t.Error("point before previous point: got no error, want an error")
+	}
+}
+
+func TestSeriesWriterOpenBlock(t *testing.T) {
+	sink := timeseries.BlockSinkFunc(func(t0 uint32, block timeseries.Block) error {
+		return nil
+	})
+	w, err := timeseries.NewSeriesWriter(2*time.Hour, 0, sink)
+	if err != nil {
+		t.Fatalf("failed to create series writer: err=%+v", err)
+	}
+
+	_, _, ok, err := w.OpenBlock()
+	if err != nil {
+		t.Fatalf("failed to get open block: err=%+v", err)
+	}
+	if ok {
+		t.Error("got an open block before writing, want none")
+	}
+
+	t0 := uint32(time.Date(2015, 3, 24, 2, 0, 0, 0, time.UTC).Unix())
+	points := []timeseries.Point{
+		{
+			Timestamp: uint32(time.Date(2015, 3, 24, 2, 1, 2, 0, time.UTC).Unix()),
+			Value:     12.0,
+		},
+		{
+			Timestamp: uint32(time.Date(2015, 3, 24, 2, 2, 2, 0, time.UTC).Unix()),
+			Value:     12.5,
+		},
+		{
+			Timestamp: uint32(time.Date(2015, 3, 24, 2, 3, 2, 0, time.UTC).Unix()),
+			Value:     -24.2,
+		},
+	}
+	for i, p := range points {
+		err = w.Write(p)
+		if err != nil {
+			t.Fatalf("failed to write point: point=%+v, err=%+v", p, err)
+		}
+
+		gotT0, block, ok, err := w.OpenBlock()
+		if err != nil {
+			t.Fatalf("failed to get open block: err=%+v", err)
+		}
+		if !ok {
+			t.Fatal("got no open block, want one")
+		}
+		if gotT0 != t0 {
+			t.Errorf("gotT0=%d, wantT0=%d", gotT0, t0)
+		}
+		_, gotPoints, err := block.Points()
+		if err != nil {
+			t.Fatalf("failed to decode open block: err=%+v", err)
+		}
+		if !reflect.DeepEqual(gotPoints, points[:i+1]) {
+			t.Errorf("gotPoints=%+v, wantPoints=%+v", gotPoints, points[:i+1])
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/promql"
+)
+
+// maxPointsPerSeries is the maximum number of steps of a range query, which
+// is the same as the one of Prometheus.
+const maxPointsPerSeries = 11000
+
+// QueryData is the data of a query response.
+type QueryData struct {
+	ResultType promql.ValueType `json:"resultType"`
+	Result     interface{}      `json:"result"`
+}
+
+// VectorSample is a sample of a vector result. Value is a pair of the
+// timestamp and the value formatted as a string.
+type VectorSample struct {
+	Metric map[string]string `json:"metric"`
+	Value  [2]interface{}    `json:"value"`
+}
+
+// MatrixSeries is a series of a matrix result. Values are pairs of
+// timestamps and values formatted as strings.
+type MatrixSeries struct {
+	Metric map[string]string `json:"metric"`
+	Values [][2]interface{}  `json:"values"`
+}
+
+func (srv *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	t := srv.Now()
+	if s := r.FormValue("time"); s != "" {
+		var err error
+		t, err = parseTime(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid time: %v", err))
+			return
+		}
+	}
+	ts, err := timeseries.Timestamp(t)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	v, err := srv.engine.Instant(r.FormValue("query"), ts)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeData(w, queryData(v))
+}
+
+func (srv *Server) handleQueryRange(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	var start, end uint32
+	for _, p := range []struct {
+		name string
+		ts   *uint32
+	}{{name: "start", ts: &start}, {name: "end", ts: &end}} {
+		t, err := parseTime(r.FormValue(p.name))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s: %v", p.name, err))
+			return
+		}
+		*p.ts, err = timeseries.Timestamp(t)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	step, err := parseDuration(r.FormValue("step"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid step: %v", err))
+		return
+	}
+	if step > 0 && end > start && time.Duration(end-start)*time.Second/step > maxPointsPerSeries {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("exceeded maximum resolution of %d points per series, try increasing step", maxPointsPerSeries))
+		return
+	}
+
+	m, err := srv.engine.Range(r.FormValue("query"), start, end, step)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeData(w, queryData(m))
+}
+
+func (srv *Server) handleSeries(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	selectors := r.Form["match[]"]
+	if len(selectors) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("no match[] parameter"))
+		return
+	}
+
+	seen := make(map[string]bool)
+	res := []map[string]string{}
+	for _, sel := range selectors {
+		ms, err := promql.ParseMatchers(sel)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		for _, ls := range srv.store.SelectLabels(ms...) {
+			key := ls.String()
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			res = append(res, ls.Map())
+		}
+	}
+	writeData(w, res)
+}
+
+func queryData(v promql.Value) QueryData {
+	d := QueryData{ResultType: v.Type()}
+	switch v := v.(type) {
+	case promql.Scalar:
+		d.Result = [2]interface{}{v.T, formatValue(v.V)}
+	case promql.Vector:
+		res := make([]VectorSample, len(v))
+		for i, s := range v {
+			res[i] = VectorSample{Metric: s.Labels.Map(), Value: [2]interface{}{s.T, formatValue(s.V)}}
+		}
+		d.Result = res
+	case promql.Matrix:
+		res := make([]MatrixSeries, len(v))
+		for i, s := range v {
+			values := make([][2]interface{}, len(s.Points))
+			for j, p := range s.Points {
+				values[j] = [2]interface{}{p.Timestamp, formatValue(p.Value)}
+			}
+			res[i] = MatrixSeries{Metric: s.Labels.Map(), Values: values}
+		}
+		d.Result = res
+	}
+	return d
+}
+
+// parseTime parses a time in Unix seconds, which may be fractional, or in
+// RFC 3339 format.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, errors.New("missing time")
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// parseDuration parses a duration in seconds, which may be fractional, or in
+// the format of time.ParseDuration.
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, errors.New("missing duration")
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(f * float64(time.Second)), nil
+	}
+	return time.ParseDuration(s)
+}
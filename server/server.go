@@ -0,0 +1,117 @@
+// Package server implements an HTTP API for writing data points into a store
+// and querying them with PromQL.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hnakamur/timeseries/opentsdb"
+	"github.com/hnakamur/timeseries/otlp"
+	"github.com/hnakamur/timeseries/promql"
+	"github.com/hnakamur/timeseries/remote"
+	"github.com/hnakamur/timeseries/store"
+)
+
+// Server is an HTTP handler for the API.
+//
+// The endpoints are:
+//
+//	POST /api/v1/write        writes data points in JSON or line protocol
+//	GET  /api/v1/query        evaluates an instant query
+//	GET  /api/v1/query_range  evaluates a range query
+//	GET  /api/v1/series       lists series matching selectors
+//	POST /api/v1/prom/write   receives Prometheus remote write requests
+//	POST /api/v1/prom/read    serves Prometheus remote read requests
+//	POST /api/put             receives OpenTSDB data points in JSON
+//	POST /v1/metrics          receives OpenTelemetry metrics over OTLP/HTTP
+type Server struct {
+	store  *store.Store
+	engine *promql.Engine
+	mux    *http.ServeMux
+
+	// Now returns the current time. It is used for the default query time
+	// and data points without timestamps.
+	Now func() time.Time
+}
+
+// New creates a server for the store.
+func New(s *store.Store) *Server {
+	srv := &Server{
+		store:  s,
+		engine: promql.NewEngine(s),
+		mux:    http.NewServeMux(),
+		Now:    time.Now,
+	}
+	srv.mux.HandleFunc("/api/v1/write", srv.handleWrite)
+	srv.mux.HandleFunc("/api/v1/query", srv.handleQuery)
+	srv.mux.HandleFunc("/api/v1/query_range", srv.handleQueryRange)
+	srv.mux.HandleFunc("/api/v1/series", srv.handleSeries)
+	srv.mux.Handle("/api/v1/prom/write", remote.NewWriteHandler(s))
+	srv.mux.Handle("/api/v1/prom/read", remote.NewReadHandler(s))
+	srv.mux.Handle("/api/put", opentsdb.NewPutHandler(s))
+	srv.mux.Handle("/v1/metrics", otlp.NewReceiver(s))
+	return srv
+}
+
+// Handle registers an additional handler for the pattern.
+func (srv *Server) Handle(pattern string, h http.Handler) {
+	srv.mux.Handle(pattern, h)
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv.mux.ServeHTTP(w, r)
+}
+
+var errMethodNotAllowed = errors.New("method not allowed")
+
+type response struct {
+	Status string      `json:"status"`
+	Data   interface{} `json:"data,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeData(w http.ResponseWriter, data interface{}) {
+	writeJSON(w, http.StatusOK, response{Status: "success", Data: data})
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, response{Status: "error", Error: err.Error()})
+}
+
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+	return false
+}
+
+// formatValue formats a value in the same way as the Prometheus API, which
+// uses strings so that NaN and infinities can be represented.
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,204 @@
+package server_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/timeseries/server"
+	"github.com/hnakamur/timeseries/store"
+)
+
+var now = time.Date(2015, 3, 24, 2, 10, 0, 0, time.UTC)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	s, err := store.New(store.Options{})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+	srv := server.New(s)
+	srv.Now = func() time.Time { return now }
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+type response struct {
+	Status string          `json:"status"`
+	Data   json.RawMessage `json:"data"`
+	Error  string          `json:"error"`
+}
+
+func do(t *testing.T, req *http.Request, wantCode int) response {
+	t.Helper()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to send request: err=%+v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response: err=%+v", err)
+	}
+	if resp.StatusCode != wantCode {
+		t.Fatalf("%s %s: got status=%d, want=%d, body=%s", req.Method, req.URL, resp.StatusCode, wantCode, body)
+	}
+	var res response
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &res)
+		if err != nil {
+			t.Fatalf("failed to decode response: body=%s, err=%+v", body, err)
+		}
+	}
+	return res
+}
+
+func post(t *testing.T, u, contentType, body string, wantCode int) response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, u, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to create request: err=%+v", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	return do(t, req, wantCode)
+}
+
+func get(t *testing.T, u string, params url.Values, wantCode int) response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, u+"?"+params.Encode(), nil)
+	if err != nil {
+		t.Fatalf("failed to create request: err=%+v", err)
+	}
+	return do(t, req, wantCode)
+}
+
+func TestWriteAndQuery(t *testing.T) {
+	ts := newTestServer(t)
+
+	post(t, ts.URL+"/api/v1/write", "application/json", `[
+		{"labels": {"__name__": "cpu_usage", "host": "host1"},
+		 "points": [{"Timestamp": 1427162400, "Value": 10}, {"Timestamp": 1427162460, "Value": 20}]},
+		{"labels": {"__name__": "cpu_usage", "host": "host2"},
+		 "points": [{"Timestamp": 1427162400, "Value": 30}]}
+	]`, http.StatusNoContent)
+	post(t, ts.URL+"/api/v1/write?precision=s", "text/plain", strings.Join([]string{
+		"# comment",
+		"mem,host=host1 value=100,free=25i 1427162400",
+		"mem,host=host1 value=200 1427162460",
+		"mem,host=host2 value=300",
+	}, "\n"), http.StatusNoContent)
+
+	testCases := []struct {
+		path   string
+		params url.Values
+		want   string
+	}{
+		{
+			path:   "/api/v1/query",
+			params: url.Values{"query": {"sum(cpu_usage)"}, "time": {"1427162460"}},
+			want:   `{"resultType":"vector","result":[{"metric":{},"value":[1427162460,"50"]}]}`,
+		},
+		{
+			path:   "/api/v1/query",
+			params: url.Values{"query": {`mem{host="host2"}`}},
+			want:   `{"resultType":"vector","result":[{"metric":{"__name__":"mem","host":"host2"},"value":[1427163000,"300"]}]}`,
+		},
+		{
+			path:   "/api/v1/query",
+			params: url.Values{"query": {"1 + 1"}, "time": {"2015-03-24T02:00:00Z"}},
+			want:   `{"resultType":"scalar","result":[1427162400,"2"]}`,
+		},
+		{
+			path:   "/api/v1/query",
+			params: url.Values{"query": {"mem_free"}, "time": {"1427162400"}},
+			want:   `{"resultType":"vector","result":[{"metric":{"__name__":"mem_free","host":"host1"},"value":[1427162400,"25"]}]}`,
+		},
+		{
+			path: "/api/v1/query_range",
+			params: url.Values{"query": {`cpu_usage{host="host1"} * 2`},
+				"start": {"1427162400"}, "end": {"1427162460"}, "step": {"30s"}},
+			want: `{"resultType":"matrix","result":[{"metric":{"host":"host1"},"values":[[1427162400,"20"],[1427162430,"20"],[1427162460,"40"]]}]}`,
+		},
+		{
+			path:   "/api/v1/series",
+			params: url.Values{"match[]": {`cpu_usage{host="host1"}`, `{host="host1"}`}},
+			want:   `[{"__name__":"cpu_usage","host":"host1"},{"__name__":"mem","host":"host1"},{"__name__":"mem_free","host":"host1"}]`,
+		},
+	}
+	for _, tc := range testCases {
+		res := get(t, ts.URL+tc.path, tc.params, http.StatusOK)
+		if res.Status != "success" {
+			t.Errorf("path=%s, params=%v, got status=%s, want=success", tc.path, tc.params, res.Status)
+		}
+		if !jsonEqual(t, res.Data, tc.want) {
+			t.Errorf("path=%s, params=%v, got=%s, want=%s", tc.path, tc.params, res.Data, tc.want)
+		}
+	}
+}
+
+func jsonEqual(t *testing.T, got json.RawMessage, want string) bool {
+	var g, w interface{}
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("failed to decode json: err=%+v", err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatalf("failed to decode json: err=%+v", err)
+	}
+	return reflect.DeepEqual(g, w)
+}
+
+func TestErrors(t *testing.T) {
+	ts := newTestServer(t)
+
+	testCases := []struct {
+		name string
+		req  func() response
+	}{
+		{name: "invalid json", req: func() response {
+			return post(t, ts.URL+"/api/v1/write", "application/json", `{`, http.StatusBadRequest)
+		}},
+		{name: "missing metric name", req: func() response {
+			return post(t, ts.URL+"/api/v1/write", "application/json",
+				`[{"labels": {"host": "host1"}, "points": [{"Timestamp": 1, "Value": 1}]}]`, http.StatusBadRequest)
+		}},
+		{name: "invalid line", req: func() response {
+			return post(t, ts.URL+"/api/v1/write", "text/plain", "mem value=abc", http.StatusBadRequest)
+		}},
+		{name: "invalid precision", req: func() response {
+			return post(t, ts.URL+"/api/v1/write?precision=d", "text/plain", "mem value=1", http.StatusBadRequest)
+		}},
+		{name: "invalid query", req: func() response {
+			return get(t, ts.URL+"/api/v1/query", url.Values{"query": {"sum("}}, http.StatusBadRequest)
+		}},
+		{name: "missing step", req: func() response {
+			return get(t, ts.URL+"/api/v1/query_range",
+				url.Values{"query": {"1"}, "start": {"0"}, "end": {"1"}}, http.StatusBadRequest)
+		}},
+		{name: "too many steps", req: func() response {
+			return get(t, ts.URL+"/api/v1/query_range",
+				url.Values{"query": {"1"}, "start": {"0"}, "end": {"4000000000"}, "step": {"1"}}, http.StatusBadRequest)
+		}},
+		{name: "fractional step", req: func() response {
+			return get(t, ts.URL+"/api/v1/query_range",
+				url.Values{"query": {"1"}, "start": {"0"}, "end": {"3"}, "step": {"1.5"}}, http.StatusBadRequest)
+		}},
+		{name: "missing match", req: func() response {
+			return get(t, ts.URL+"/api/v1/series", nil, http.StatusBadRequest)
+		}},
+		{name: "method not allowed", req: func() response {
+			return get(t, ts.URL+"/api/v1/write", nil, http.StatusMethodNotAllowed)
+		}},
+	}
+	for _, tc := range testCases {
+		res := tc.req()
+		if res.Status != "error" || res.Error == "" {
+			t.Errorf("%s: got status=%s, error=%s, want error", tc.name, res.Status, res.Error)
+		}
+	}
+}
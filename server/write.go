@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/labels"
+	"github.com/hnakamur/timeseries/lineprotocol"
+)
+
+// maxWriteBodySize is the maximum size of a write request body.
+const maxWriteBodySize = 32 << 20
+
+// WriteSeries is a series in a JSON write request body.
+type WriteSeries struct {
+	Labels map[string]string  `json:"labels"`
+	Points []timeseries.Point `json:"points"`
+}
+
+// handleWrite writes data points in the request body. The body is a JSON
+// array of WriteSeries if the content type is application/json, and line
+// protocol otherwise.
+func (srv *Server) handleWrite(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+	body := http.MaxBytesReader(w, r.Body, maxWriteBodySize)
+
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		err = srv.writeJSON(body)
+	} else {
+		err = srv.writeLines(body, r.URL.Query().Get("precision"))
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (srv *Server) writeJSON(r io.Reader) error {
+	var series []WriteSeries
+	err := json.NewDecoder(r).Decode(&series)
+	if err != nil {
+		return fmt.Errorf("failed to decode request body: err=%+v", err)
+	}
+	for _, s := range series {
+		ls := labels.FromMap(s.Labels)
+		if ls.Get(labels.MetricName) == "" {
+			return fmt.Errorf("series without metric name: labels=%s", ls)
+		}
+		for _, p := range s.Points {
+			err = srv.store.AppendLabels(ls, p)
+			if err != nil {
+				return fmt.Errorf("failed to append point: labels=%s, point=%+v, err=%+v", ls, p, err)
+			}
+		}
+	}
+	return nil
+}
+
+// writeLines writes data points in line protocol. String fields are
+// skipped since they cannot be stored.
+func (srv *Server) writeLines(r io.Reader, precision string) error {
+	p, err := lineprotocol.ParsePrecision(precision)
+	if err != nil {
+		return err
+	}
+	now := srv.Now()
+	d := lineprotocol.NewDecoder(r)
+	for d.Next() {
+		l := d.Line()
+		t, err := l.Time(p, now)
+		if err != nil {
+			return err
+		}
+		for i := range l.Fields {
+			v, ok := l.Fields[i].Number()
+			if !ok {
+				continue
+			}
+			ls := l.Labels(i)
+			err = srv.store.AppendLabels(ls, timeseries.Point{Timestamp: t, Value: v})
+			if err != nil {
+				return fmt.Errorf("failed to append point: labels=%s, err=%+v", ls, err)
+			}
+		}
+	}
+	return d.Err()
+}
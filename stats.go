@@ -0,0 +1,234 @@
+package timeseries
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sync"
+)
+
+// The stats footer is appended after the finish marker of a block by
+// FinishWithStats. Decoders stop at the finish marker, so blocks with the
+// footer can be decoded as blocks without it.
+//
+// The footer is count, first timestamp and last timestamp as uint32, min, max,
+// sum and m2 as float64 bits, all in big endian, followed by the magic "TSST".
+// The last four bytes of a block without the footer have many one bits of the
+// finish marker or are all zero for an empty block, so they never match the
+// magic.
+const (
+	statsFooterMagic = "TSST"
+	statsFooterSize  = 48
+)
+
+// Stats is the statistics of data points.
+// The zero value is the statistics of no data points.
+type Stats struct {
+	// Count is the number of data points.
+	Count uint32
+
+	// First is the timestamp of the first data point.
+	First uint32
+
+	// Last is the timestamp of the last data point.
+	Last uint32
+
+	// Min is the minimum value.
+	Min float64
+
+	// Max is the maximum value.
+	Max float64
+
+	// Sum is the sum of values.
+	Sum float64
+
+	// M2 is the sum of squares of differences from the mean.
+	M2 float64
+}
+
+// Add adds a data point to the statistics.
+func (s *Stats) Add(p Point) {
+	if s.Count == 0 {
+		*s = Stats{Count: 1, First: p.Timestamp, Last: p.Timestamp, Min: p.Value, Max: p.Value, Sum: p.Value}
+		return
+	}
+
+	// Welford's online algorithm for M2.
+	oldMean := s.Mean()
+	s.Count++
+	s.Sum += p.Value
+	s.M2 += (p.Value - oldMean) * (p.Value - s.Mean())
+	s.Last = p.Timestamp
+	s.Min = math.Min(s.Min, p.Value)
+	s.Max = math.Max(s.Max, p.Value)
+}
+
+// Merge returns the statistics of data points of s followed by those of o.
+func (s Stats) Merge(o Stats) Stats {
+	if s.Count == 0 {
+		return o
+	}
+	if o.Count == 0 {
+		return s
+	}
+
+	n1, n2 := float64(s.Count), float64(o.Count)
+	delta := o.Mean() - s.Mean()
+	return Stats{
+		Count: s.Count + o.Count,
+		First: s.First,
+		Last:  o.Last,
+		Min:   math.Min(s.Min, o.Min),
+		Max:   math.Max(s.Max, o.Max),
+		Sum:   s.Sum + o.Sum,
+		M2:    s.M2 + o.M2 + delta*delta*n1*n2/(n1+n2),
+	}
+}
+
+// Mean returns the mean of values. It returns NaN if there is no data point.
+func (s Stats) Mean() float64 {
+	if s.Count == 0 {
+		return math.NaN()
+	}
+	return s.Sum / float64(s.Count)
+}
+
+// Variance returns the population variance of values.
+// It returns NaN if there is no data point.
+func (s Stats) Variance() float64 {
+	if s.Count == 0 {
+		return math.NaN()
+	}
+	return s.M2 / float64(s.Count)
+}
+
+// StdDev returns the population standard deviation of values.
+// It returns NaN if there is no data point.
+func (s Stats) StdDev() float64 {
+	return math.Sqrt(s.Variance())
+}
+
+// EnableStats makes the encoder collect the statistics of encoded data
+// points for FinishWithStats. It must be called before encoding data points.
+// Reset disables it.
+func (e *Encoder) EnableStats() {
+	e.withStats = true
+}
+
+// FinishWithStats encodes the finish marker like Finish, and then appends the
+// footer with the statistics of the encoded data points. EnableStats must be
+// called before encoding data points.
+func (e *Encoder) FinishWithStats() error {
+	if !e.withStats {
+		return errors.New("stats are not enabled")
+	}
+	err := e.Finish()
+	if err != nil {
+		return err
+	}
+
+	var footer [statsFooterSize]byte
+	putStats(footer[:], e.stats)
+	for _, b := range footer {
+		err = e.wr.WriteByte(b)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func putStats(b []byte, s Stats) {
+	binary.BigEndian.PutUint32(b[0:4], s.Count)
+	binary.BigEndian.PutUint32(b[4:8], s.First)
+	binary.BigEndian.PutUint32(b[8:12], s.Last)
+	binary.BigEndian.PutUint64(b[12:20], math.Float64bits(s.Min))
+	binary.BigEndian.PutUint64(b[20:28], math.Float64bits(s.Max))
+	binary.BigEndian.PutUint64(b[28:36], math.Float64bits(s.Sum))
+	binary.BigEndian.PutUint64(b[36:44], math.Float64bits(s.M2))
+	copy(b[44:48], statsFooterMagic)
+}
+
+// BlockStats returns the statistics in the footer of an encoded block.
+// ok is false if the block does not have the footer.
+func BlockStats(data []byte) (s Stats, ok bool) {
+	if len(data) < statsFooterSize+4 {
+		return Stats{}, false
+	}
+	b := data[len(data)-statsFooterSize:]
+	if string(b[44:48]) != statsFooterMagic {
+		return Stats{}, false
+	}
+	return Stats{
+		Count: binary.BigEndian.Uint32(b[0:4]),
+		First: binary.BigEndian.Uint32(b[4:8]),
+		Last:  binary.BigEndian.Uint32(b[8:12]),
+		Min:   math.Float64frombits(binary.BigEndian.Uint64(b[12:20])),
+		Max:   math.Float64frombits(binary.BigEndian.Uint64(b[20:28])),
+		Sum:   math.Float64frombits(binary.BigEndian.Uint64(b[28:36])),
+		M2:    math.Float64frombits(binary.BigEndian.Uint64(b[36:44])),
+	}, true
+}
+
+// Stats returns the statistics in the footer of the block.
+// ok is false if the block does not have the footer.
+func (b Block) Stats() (s Stats, ok bool) {
+	return BlockStats(b.Data)
+}
+
+// AggregateDecoder consumes data points from a decoder whose header is already
+// decoded, and returns the statistics of the data points whose timestamps are
+// between from and to inclusive. It stops at the first data point after to.
+func AggregateDecoder(d *Decoder, from, to uint32) (Stats, error) {
+	var s Stats
+	for {
+		p, err := d.DecodePoint()
+		if err == io.EOF {
+			return s, nil
+		} else if err != nil {
+			return Stats{}, err
+		}
+		if p.Timestamp > to {
+			return s, nil
+		}
+		if p.Timestamp >= from {
+			s.Add(p)
+		}
+	}
+}
+
+type blockDecoder struct {
+	r   bytes.Reader
+	dec *Decoder
+}
+
+var blockDecoderPool = sync.Pool{
+	New: func() interface{} {
+		return &blockDecoder{dec: NewDecoder(nil)}
+	},
+}
+
+// Aggregate returns the statistics of the data points in an encoded block
+// whose timestamps are between from and to inclusive, in one pass without
+// allocating memory. If the block has the stats footer and the range covers
+// all data points in the block, it returns the statistics in the footer
+// without decoding data points.
+func Aggregate(data []byte, from, to uint32) (Stats, error) {
+	if s, ok := BlockStats(data); ok && (s.Count == 0 || from <= s.First && s.Last <= to) {
+		return s, nil
+	}
+
+	bd := blockDecoderPool.Get().(*blockDecoder)
+	defer blockDecoderPool.Put(bd)
+	bd.r.Reset(data)
+	bd.dec.Reset(&bd.r)
+	defer bd.dec.Reset(nil)
+
+	_, err := bd.dec.DecodeHeader()
+	if err != nil {
+		return Stats{}, err
+	}
+	return AggregateDecoder(bd.dec, from, to)
+}
@@ -0,0 +1,125 @@
+package timeseries_test
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+)
+
+func encodeBlock(t *testing.T, t0 uint32, points []timeseries.Point, withStats bool) []byte {
+	var b bytes.Buffer
+	enc := timeseries.NewEncoder(&b)
+	if withStats {
+		enc.EnableStats()
+	}
+	err := enc.EncodeHeader(t0)
+	if err != nil {
+		t.Fatalf("failed to encode time series header: err=%+v", err)
+	}
+	for _, p := range points {
+		err = enc.EncodePoint(p)
+		if err != nil {
+			t.Fatalf("failed to encode time series point: err=%+v", err)
+		}
+	}
+	if withStats {
+		err = enc.FinishWithStats()
+	} else {
+		err = enc.Finish()
+	}
+	if err != nil {
+		t.Fatalf("failed to encode time series finish marker: err=%+v", err)
+	}
+	return b.Bytes()
+}
+
+func TestAggregate(t *testing.T) {
+	t0 := uint32(time.Date(2015, 3, 24, 2, 0, 0, 0, time.UTC).Unix())
+	var points []timeseries.Point
+	for i, v := range []float64{2, 4, 4, 4, 5, 5, 7, 9} {
+		points = append(points, timeseries.Point{Timestamp: t0 + uint32(i*60), Value: v})
+	}
+
+	for _, withStats := range []bool{false, true} {
+		data := encodeBlock(t, t0, points, withStats)
+
+		_, gotPoints, err := timeseries.Unmarshal(data)
+		if err != nil {
+			t.Fatalf("withStats=%v, failed to unmarshal block: err=%+v", withStats, err)
+		}
+		if len(gotPoints) != len(points) {
+			t.Errorf("withStats=%v, got %d points, want %d", withStats, len(gotPoints), len(points))
+		}
+		_, ok := timeseries.BlockStats(data)
+		if ok != withStats {
+			t.Errorf("withStats=%v, got footer=%v", withStats, ok)
+		}
+
+		s, err := timeseries.Aggregate(data, 0, math.MaxUint32)
+		if err != nil {
+			t.Fatalf("withStats=%v, failed to aggregate: err=%+v", withStats, err)
+		}
+		if s.Count != 8 || s.Min != 2 || s.Max != 9 || s.Sum != 40 || s.Mean() != 5 || s.StdDev() != 2 {
+			t.Errorf("withStats=%v, got stats=%+v, mean=%g, stddev=%g", withStats, s, s.Mean(), s.StdDev())
+		}
+		if s.First != t0 || s.Last != t0+7*60 {
+			t.Errorf("withStats=%v, got first=%d, last=%d, want first=%d, last=%d", withStats, s.First, s.Last, t0, t0+7*60)
+		}
+
+		s, err = timeseries.Aggregate(data, t0+60, t0+3*60)
+		if err != nil {
+			t.Fatalf("withStats=%v, failed to aggregate: err=%+v", withStats, err)
+		}
+		if s.Count != 3 || s.Sum != 12 || s.Min != 4 || s.Max != 4 || s.Variance() != 0 {
+			t.Errorf("withStats=%v, got stats=%+v", withStats, s)
+		}
+
+		allocs := testing.AllocsPerRun(100, func() {
+			timeseries.Aggregate(data, t0+60, t0+3*60)
+		})
+		if allocs != 0 {
+			t.Errorf("withStats=%v, got %g allocations, want 0", withStats, allocs)
+		}
+	}
+
+	empty := encodeBlock(t, t0, nil, true)
+	s, err := timeseries.Aggregate(empty, 0, math.MaxUint32)
+	if err != nil {
+		t.Fatalf("failed to aggregate empty block: err=%+v", err)
+	}
+	if s.Count != 0 || !math.IsNaN(s.Mean()) {
+		t.Errorf("got stats=%+v for empty block", s)
+	}
+}
+
+func TestStatsMerge(t *testing.T) {
+	var a, b, all timeseries.Stats
+	for i, v := range []float64{2, 4, 4, 4, 5, 5, 7, 9} {
+		p := timeseries.Point{Timestamp: uint32(i), Value: v}
+		if i < 3 {
+			a.Add(p)
+		} else {
+			b.Add(p)
+		}
+		all.Add(p)
+	}
+	got := a.Merge(b)
+	if got.Count != all.Count || got.Sum != all.Sum || math.Abs(got.M2-all.M2) > 1e-9 ||
+		got.First != all.First || got.Last != all.Last || got.Min != all.Min || got.Max != all.Max {
+		t.Errorf("got=%+v, want=%+v", got, all)
+	}
+}
+
+func TestFinishWithStatsNotEnabled(t *testing.T) {
+	var b bytes.Buffer
+	enc := timeseries.NewEncoder(&b)
+	if err := enc.EncodeHeader(0); err != nil {
+		t.Fatalf("failed to encode time series header: err=%+v", err)
+	}
+	if err := enc.FinishWithStats(); err == nil {
+		t.Errorf("got nil error, want error")
+	}
+}
@@ -0,0 +1,201 @@
+package statsd
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/labels"
+)
+
+// DefaultPercentiles is the default of Options.Percentiles.
+var DefaultPercentiles = []float64{90}
+
+// Sample is an aggregated value of a series.
+type Sample struct {
+	Labels labels.Labels
+	Point  timeseries.Point
+}
+
+type counter struct {
+	labels labels.Labels
+	value  float64
+}
+
+type gauge struct {
+	labels labels.Labels
+	value  float64
+}
+
+type timer struct {
+	labels labels.Labels
+	values []float64
+	count  float64
+}
+
+type set struct {
+	labels labels.Labels
+	values map[string]struct{}
+}
+
+// Aggregator aggregates metrics in a flush interval.
+//
+// The series of the aggregated values have the metric names below, with
+// the tags of the metrics as the other labels:
+//
+//	counter: name (the sum) and name.rate (per second)
+//	gauge:   name (the last value, kept across flush intervals)
+//	timer:   name.count, name.sum, name.mean, name.min, name.max,
+//	         name.median and name.p<percentile> such as name.p90
+//	set:     name (the number of unique values)
+type Aggregator struct {
+	percentiles []float64
+
+	mu       sync.Mutex
+	counters map[string]*counter
+	gauges   map[string]*gauge
+	timers   map[string]*timer
+	sets     map[string]*set
+}
+
+// NewAggregator creates an aggregator which calculates the percentiles of
+// timers. The percentiles default to DefaultPercentiles if nil.
+func NewAggregator(percentiles []float64) (*Aggregator, error) {
+	if percentiles == nil {
+		percentiles = DefaultPercentiles
+	}
+	for _, p := range percentiles {
+		if !(p > 0 && p <= 100) {
+			return nil, fmt.Errorf("percentile must be in (0, 100]: %v", p)
+		}
+	}
+	return &Aggregator{
+		percentiles: percentiles,
+		counters:    make(map[string]*counter),
+		gauges:      make(map[string]*gauge),
+		timers:      make(map[string]*timer),
+		sets:        make(map[string]*set),
+	}, nil
+}
+
+// Add adds the metric to the current flush interval.
+func (a *Aggregator) Add(m Metric) {
+	ls := labels.New(append(m.Tags[:len(m.Tags):len(m.Tags)], labels.Label{Name: labels.MetricName, Value: m.Name})...)
+	key := ls.String()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch m.Type {
+	case Counter:
+		c, ok := a.counters[key]
+		if !ok {
+			c = &counter{labels: ls}
+			a.counters[key] = c
+		}
+		c.value += m.Value / m.SampleRate
+	case Gauge:
+		g, ok := a.gauges[key]
+		if !ok {
+			g = &gauge{labels: ls}
+			a.gauges[key] = g
+		}
+		if m.Delta {
+			g.value += m.Value
+		} else {
+			g.value = m.Value
+		}
+	case Timer:
+		t, ok := a.timers[key]
+		if !ok {
+			t = &timer{labels: ls}
+			a.timers[key] = t
+		}
+		t.values = append(t.values, m.Value)
+		t.count += 1 / m.SampleRate
+	case Set:
+		s, ok := a.sets[key]
+		if !ok {
+			s = &set{labels: ls, values: make(map[string]struct{})}
+			a.sets[key] = s
+		}
+		s.values[m.SetValue] = struct{}{}
+	}
+}
+
+// Flush returns the aggregated values of the flush interval of the length
+// at the timestamp t, sorted by labels, and starts a new interval.
+func (a *Aggregator) Flush(t uint32, interval float64) []Sample {
+	a.mu.Lock()
+	counters, timers, sets := a.counters, a.timers, a.sets
+	a.counters = make(map[string]*counter)
+	a.timers = make(map[string]*timer)
+	a.sets = make(map[string]*set)
+	var res []Sample
+	for _, g := range a.gauges {
+		res = append(res, Sample{Labels: g.labels, Point: timeseries.Point{Timestamp: t, Value: g.value}})
+	}
+	a.mu.Unlock()
+
+	add := func(ls labels.Labels, suffix string, v float64) {
+		if suffix != "" {
+			ls = withName(ls, ls.Get(labels.MetricName)+"."+suffix)
+		}
+		res = append(res, Sample{Labels: ls, Point: timeseries.Point{Timestamp: t, Value: v}})
+	}
+	for _, c := range counters {
+		add(c.labels, "", c.value)
+		if interval > 0 {
+			add(c.labels, "rate", c.value/interval)
+		}
+	}
+	for _, tm := range timers {
+		sort.Float64s(tm.values)
+		var sum float64
+		for _, v := range tm.values {
+			sum += v
+		}
+		n := len(tm.values)
+		add(tm.labels, "count", tm.count)
+		add(tm.labels, "sum", sum)
+		add(tm.labels, "mean", sum/float64(n))
+		add(tm.labels, "min", tm.values[0])
+		add(tm.labels, "max", tm.values[n-1])
+		add(tm.labels, "median", percentile(tm.values, 50))
+		for _, p := range a.percentiles {
+			add(tm.labels, "p"+strings.Replace(strconv.FormatFloat(p, 'f', -1, 64), ".", "_", -1), percentile(tm.values, p))
+		}
+	}
+	for _, s := range sets {
+		add(s.labels, "", float64(len(s.values)))
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Labels.String() < res[j].Labels.String()
+	})
+	return res
+}
+
+// percentile returns the percentile of the sorted values with the nearest
+// rank method.
+func percentile(sorted []float64, p float64) float64 {
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func withName(ls labels.Labels, name string) labels.Labels {
+	res := make(labels.Labels, len(ls))
+	copy(res, ls)
+	for i := range res {
+		if res[i].Name == labels.MetricName {
+			res[i].Value = name
+		}
+	}
+	return res
+}
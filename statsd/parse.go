@@ -0,0 +1,146 @@
+// Package statsd implements a StatsD and DogStatsD front-end which
+// aggregates received metrics per flush interval and appends the aggregated
+// values as data points to a store.
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hnakamur/timeseries/labels"
+)
+
+// Type is the type of a metric.
+type Type int
+
+const (
+	// Counter is a counter, which is summed in a flush interval.
+	Counter Type = iota
+	// Gauge is a gauge, whose last value is kept. A value with an explicit
+	// sign changes the current value.
+	Gauge
+	// Timer is a timer, a DogStatsD histogram or a distribution, whose
+	// values are summarized into statistics and percentiles.
+	Timer
+	// Set is a set, whose number of unique values is counted.
+	Set
+)
+
+func (t Type) String() string {
+	switch t {
+	case Counter:
+		return "counter"
+	case Gauge:
+		return "gauge"
+	case Timer:
+		return "timer"
+	case Set:
+		return "set"
+	default:
+		return fmt.Sprintf("Type(%d)", int(t))
+	}
+}
+
+// Metric is a received metric.
+type Metric struct {
+	Name string
+	Type Type
+	// Value is the value of a counter, a gauge or a timer.
+	Value float64
+	// Delta is true if the gauge value has an explicit sign.
+	Delta bool
+	// SetValue is the value of a set.
+	SetValue string
+	// SampleRate is the sample rate in (0, 1].
+	SampleRate float64
+	// Tags is the DogStatsD tags. A tag without a value has the value
+	// true, like Telegraf does.
+	Tags labels.Labels
+}
+
+// errSkip is returned for DogStatsD events and service checks, which are
+// not metrics.
+var errSkip = errors.New("not a metric")
+
+// ParseLine parses a line of the StatsD protocol with the DogStatsD
+// extensions:
+//
+//	name:value|type[|@sample_rate][|#tag:value,tag...]
+func ParseLine(line string) (Metric, error) {
+	if strings.HasPrefix(line, "_e{") || strings.HasPrefix(line, "_sc|") {
+		return Metric{}, errSkip
+	}
+	m := Metric{SampleRate: 1}
+
+	i := strings.IndexByte(line, ':')
+	if i <= 0 {
+		return Metric{}, errors.New("missing metric name")
+	}
+	m.Name = line[:i]
+	parts := strings.Split(line[i+1:], "|")
+	if len(parts) < 2 {
+		return Metric{}, errors.New("missing metric type")
+	}
+
+	value := parts[0]
+	switch parts[1] {
+	case "c":
+		m.Type = Counter
+	case "g":
+		m.Type = Gauge
+		m.Delta = strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-")
+	case "ms", "h", "d":
+		m.Type = Timer
+	case "s":
+		m.Type = Set
+		m.SetValue = value
+	default:
+		return Metric{}, fmt.Errorf("invalid metric type %q", parts[1])
+	}
+	if m.Type != Set {
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return Metric{}, fmt.Errorf("invalid value %q", value)
+		}
+		m.Value = v
+	} else if value == "" {
+		return Metric{}, errors.New("empty set value")
+	}
+
+	var ls []labels.Label
+	for _, p := range parts[2:] {
+		switch {
+		case strings.HasPrefix(p, "@"):
+			rate, err := strconv.ParseFloat(p[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return Metric{}, fmt.Errorf("invalid sample rate %q", p[1:])
+			}
+			m.SampleRate = rate
+		case strings.HasPrefix(p, "#"):
+			for _, tag := range strings.Split(p[1:], ",") {
+				if tag == "" {
+					continue
+				}
+				var l labels.Label
+				if j := strings.IndexByte(tag, ':'); j >= 0 {
+					l = labels.Label{Name: tag[:j], Value: tag[j+1:]}
+				} else {
+					l = labels.Label{Name: tag, Value: "true"}
+				}
+				if l.Name == "" || l.Name == labels.MetricName {
+					return Metric{}, fmt.Errorf("invalid tag %q", tag)
+				}
+				ls = append(ls, l)
+			}
+		default:
+			// Ignore unknown extensions such as DogStatsD container IDs
+			// and timestamps.
+		}
+	}
+	if len(ls) > 0 {
+		m.Tags = labels.New(ls...)
+	}
+	return m, nil
+}
@@ -0,0 +1,226 @@
+package statsd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/internal/listener"
+	"github.com/hnakamur/timeseries/store"
+)
+
+// maxLineSize is the maximum size of a line received with TCP.
+const maxLineSize = 64 * 1024
+
+// DefaultFlushInterval is the default of Options.FlushInterval.
+const DefaultFlushInterval = 10 * time.Second
+
+// Options is options for a server.
+type Options struct {
+	// FlushInterval is the interval to flush aggregated values to the
+	// appender. It defaults to DefaultFlushInterval.
+	FlushInterval time.Duration
+	// Percentiles is the percentiles of timers. It defaults to
+	// DefaultPercentiles.
+	Percentiles []float64
+}
+
+// Stats is statistics of a server.
+type Stats struct {
+	// Received is the number of received metrics.
+	Received int64
+	// ParseErrors is the number of lines which failed to parse.
+	ParseErrors int64
+	// Flushed is the number of aggregated values appended to the appender.
+	Flushed int64
+	// AppendErrors is the number of aggregated values which failed to be
+	// appended.
+	AppendErrors int64
+}
+
+// ParseError is an error for a line which failed to parse.
+type ParseError struct {
+	Addr string
+	Line string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("statsd: failed to parse line: addr=%s, line=%q, err=%v", e.Addr, e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// Server receives metrics with the StatsD protocol, aggregates them and
+// appends the aggregated values to an appender every flush interval.
+type Server struct {
+	app      store.LabelsAppender
+	agg      *Aggregator
+	interval time.Duration
+
+	// ErrorHandler is called for parse errors and append errors if not nil.
+	// It may be called concurrently.
+	ErrorHandler func(err error)
+
+	// Now returns the current time, which is used for the timestamps of
+	// aggregated values.
+	Now func() time.Time
+
+	received     int64
+	parseErrors  int64
+	flushed      int64
+	appendErrors int64
+
+	flushMu sync.Mutex
+
+	group     *listener.Group
+	done      chan struct{}
+	flusherWG sync.WaitGroup
+}
+
+// ErrServerClosed is returned by the Serve methods after Close.
+var ErrServerClosed = errors.New("statsd: server closed")
+
+// NewServer creates a server which appends aggregated values to the
+// appender, and starts flushing them every flush interval.
+func NewServer(app store.LabelsAppender, opts Options) (*Server, error) {
+	if opts.FlushInterval < 0 {
+		return nil, fmt.Errorf("flush interval must not be negative: %s", opts.FlushInterval)
+	}
+	if opts.FlushInterval == 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+	agg, err := NewAggregator(opts.Percentiles)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		app:      app,
+		agg:      agg,
+		interval: opts.FlushInterval,
+		Now:      time.Now,
+		group:    listener.NewGroup(ErrServerClosed),
+		done:     make(chan struct{}),
+	}
+	s.flusherWG.Add(1)
+	go s.flushLoop()
+	return s, nil
+}
+
+// Stats returns the statistics of the server.
+func (s *Server) Stats() Stats {
+	return Stats{
+		Received:     atomic.LoadInt64(&s.received),
+		ParseErrors:  atomic.LoadInt64(&s.parseErrors),
+		Flushed:      atomic.LoadInt64(&s.flushed),
+		AppendErrors: atomic.LoadInt64(&s.appendErrors),
+	}
+}
+
+// ServeUDP reads datagrams with lines from the connection. It blocks until
+// the connection fails or the server is closed.
+func (s *Server) ServeUDP(conn net.PacketConn) error {
+	return s.group.ServePacket(conn, func(data []byte, addr net.Addr) {
+		for _, line := range strings.Split(string(data), "\n") {
+			s.handleLine(line, addr.String())
+		}
+	})
+}
+
+// ServeTCP accepts connections on the listener and reads lines from them.
+// It blocks until the listener fails or the server is closed.
+func (s *Server) ServeTCP(ln net.Listener) error {
+	return s.group.Serve(ln, s.handleConn)
+}
+
+// Flush appends the values aggregated since the last flush to the appender.
+func (s *Server) Flush() {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	t, err := timeseries.Timestamp(s.Now())
+	if err != nil {
+		s.handleError(fmt.Errorf("failed to flush aggregated values: err=%+v", err))
+		return
+	}
+	for _, sm := range s.agg.Flush(t, s.interval.Seconds()) {
+		err := s.app.AppendLabels(sm.Labels, sm.Point)
+		if err != nil {
+			atomic.AddInt64(&s.appendErrors, 1)
+			s.handleError(fmt.Errorf("failed to append aggregated value: labels=%s, point=%+v, err=%+v", sm.Labels, sm.Point, err))
+			continue
+		}
+		atomic.AddInt64(&s.flushed, 1)
+	}
+}
+
+// Close closes the listeners and connections, waits for the connection
+// handlers to finish and flushes the aggregated values for the last time.
+func (s *Server) Close() error {
+	if !s.group.Close() {
+		return nil
+	}
+	close(s.done)
+	s.flusherWG.Wait()
+	s.Flush()
+	return nil
+}
+
+func (s *Server) flushLoop() {
+	defer s.flusherWG.Done()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.Flush()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	addr := conn.RemoteAddr().String()
+	sc := bufio.NewScanner(conn)
+	sc.Buffer(make([]byte, 4096), maxLineSize)
+	for sc.Scan() {
+		s.handleLine(sc.Text(), addr)
+	}
+	if err := sc.Err(); err != nil && !s.group.IsClosed() {
+		s.parseError(&ParseError{Addr: addr, Err: err})
+	}
+}
+
+func (s *Server) handleLine(line, addr string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	m, err := ParseLine(line)
+	if err == errSkip {
+		return
+	}
+	if err != nil {
+		s.parseError(&ParseError{Addr: addr, Line: line, Err: err})
+		return
+	}
+	atomic.AddInt64(&s.received, 1)
+	s.agg.Add(m)
+}
+
+func (s *Server) parseError(err error) {
+	atomic.AddInt64(&s.parseErrors, 1)
+	s.handleError(err)
+}
+
+func (s *Server) handleError(err error) {
+	if s.ErrorHandler != nil {
+		s.ErrorHandler(err)
+	}
+}
@@ -0,0 +1,211 @@
+package statsd_test
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/labels"
+	"github.com/hnakamur/timeseries/statsd"
+	"github.com/hnakamur/timeseries/store"
+)
+
+func TestParseLine(t *testing.T) {
+	testCases := []struct {
+		line    string
+		want    statsd.Metric
+		wantErr bool
+	}{
+		{line: "hits:1|c", want: statsd.Metric{Name: "hits", Type: statsd.Counter, Value: 1, SampleRate: 1}},
+		{line: "hits:2|c|@0.5", want: statsd.Metric{Name: "hits", Type: statsd.Counter, Value: 2, SampleRate: 0.5}},
+		{line: "temp:21.5|g", want: statsd.Metric{Name: "temp", Type: statsd.Gauge, Value: 21.5, SampleRate: 1}},
+		{line: "temp:-1|g", want: statsd.Metric{Name: "temp", Type: statsd.Gauge, Value: -1, Delta: true, SampleRate: 1}},
+		{line: "temp:+3|g", want: statsd.Metric{Name: "temp", Type: statsd.Gauge, Value: 3, Delta: true, SampleRate: 1}},
+		{line: "latency:320|ms", want: statsd.Metric{Name: "latency", Type: statsd.Timer, Value: 320, SampleRate: 1}},
+		{line: "size:1.5|h|#env:prod,canary", want: statsd.Metric{Name: "size", Type: statsd.Timer, Value: 1.5, SampleRate: 1,
+			Tags: labels.FromStrings("canary", "true", "env", "prod")}},
+		{line: "size:1.5|d|@1|#env:prod|c:abc", want: statsd.Metric{Name: "size", Type: statsd.Timer, Value: 1.5, SampleRate: 1,
+			Tags: labels.FromStrings("env", "prod")}},
+		{line: "users:alice|s", want: statsd.Metric{Name: "users", Type: statsd.Set, SetValue: "alice", SampleRate: 1}},
+		{line: "hits", wantErr: true},
+		{line: ":1|c", wantErr: true},
+		{line: "hits:1", wantErr: true},
+		{line: "hits:1|x", wantErr: true},
+		{line: "hits:abc|c", wantErr: true},
+		{line: "hits:1|c|@0", wantErr: true},
+		{line: "hits:1|c|@2", wantErr: true},
+		{line: "users:|s", wantErr: true},
+		{line: "hits:1|c|#:v", wantErr: true},
+		{line: "hits:1|c|#__name__:v", wantErr: true},
+		{line: "_e{5,4}:title|text", wantErr: true},
+		{line: "_sc|redis|0", wantErr: true},
+	}
+	for _, tc := range testCases {
+		got, err := statsd.ParseLine(tc.line)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("line=%q, got no error, want error", tc.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("failed to parse line: line=%q, err=%+v", tc.line, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("line=%q, got=%+v, want=%+v", tc.line, got, tc.want)
+		}
+	}
+}
+
+func TestAggregator(t *testing.T) {
+	testCases := []struct {
+		name  string
+		lines []string
+		want  map[string]float64
+	}{
+		{
+			name:  "counter",
+			lines: []string{"hits:1|c", "hits:2|c|@0.5", "hits:1|c|#env:prod"},
+			want: map[string]float64{
+				`hits{}`:                5,
+				`hits.rate{}`:           0.5,
+				`hits{env="prod"}`:      1,
+				`hits.rate{env="prod"}`: 0.1,
+			},
+		},
+		{
+			name:  "gauge",
+			lines: []string{"temp:10|g", "temp:+5|g", "temp:-3|g", "load:1|g", "load:2|g"},
+			want:  map[string]float64{`temp{}`: 12, `load{}`: 2},
+		},
+		{
+			name:  "timer",
+			lines: []string{"lat:3|ms", "lat:1|ms", "lat:4|ms", "lat:2|ms|@0.5", "lat:10|ms"},
+			want: map[string]float64{
+				`lat.count{}`:  6,
+				`lat.sum{}`:    20,
+				`lat.mean{}`:   4,
+				`lat.min{}`:    1,
+				`lat.max{}`:    10,
+				`lat.median{}`: 3,
+				`lat.p90{}`:    10,
+				`lat.p99_5{}`:  10,
+			},
+		},
+		{
+			name:  "set",
+			lines: []string{"users:alice|s", "users:bob|s", "users:alice|s"},
+			want:  map[string]float64{`users{}`: 2},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			agg, err := statsd.NewAggregator([]float64{90, 99.5})
+			if err != nil {
+				t.Fatalf("failed to create aggregator: err=%+v", err)
+			}
+			for _, line := range tc.lines {
+				m, err := statsd.ParseLine(line)
+				if err != nil {
+					t.Fatalf("failed to parse line: line=%q, err=%+v", line, err)
+				}
+				agg.Add(m)
+			}
+			got := make(map[string]float64)
+			for _, s := range agg.Flush(100, 10) {
+				if s.Point.Timestamp != 100 {
+					t.Errorf("labels=%s, got timestamp=%d, want=100", s.Labels, s.Point.Timestamp)
+				}
+				got[s.Labels.String()] = s.Point.Value
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got=%v, want=%v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAggregatorFlush(t *testing.T) {
+	agg, err := statsd.NewAggregator(nil)
+	if err != nil {
+		t.Fatalf("failed to create aggregator: err=%+v", err)
+	}
+	for _, line := range []string{"hits:1|c", "temp:5|g"} {
+		m, err := statsd.ParseLine(line)
+		if err != nil {
+			t.Fatalf("failed to parse line: line=%q, err=%+v", line, err)
+		}
+		agg.Add(m)
+	}
+	if got := len(agg.Flush(100, 10)); got != 3 {
+		t.Errorf("first flush, got=%d samples, want=3", got)
+	}
+
+	// Only the gauge is kept across flush intervals.
+	got := agg.Flush(110, 10)
+	want := []statsd.Sample{{Labels: labels.FromStrings(labels.MetricName, "temp"), Point: timeseries.Point{Timestamp: 110, Value: 5}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("second flush, got=%+v, want=%+v", got, want)
+	}
+}
+
+func TestNewAggregatorError(t *testing.T) {
+	for _, p := range [][]float64{{0}, {-1}, {100.5}} {
+		if _, err := statsd.NewAggregator(p); err == nil {
+			t.Errorf("percentiles=%v, got no error, want error", p)
+		}
+	}
+}
+
+func TestServeUDP(t *testing.T) {
+	s, err := store.New(store.Options{})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+	srv, err := statsd.NewServer(s, statsd.Options{FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("failed to create server: err=%+v", err)
+	}
+	srv.Now = func() time.Time { return time.Unix(1427162400, 0) }
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: err=%+v", err)
+	}
+	go srv.ServeUDP(pc)
+	defer srv.Close()
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: err=%+v", err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("hits:1|c|#env:prod\nhits:2|c|#env:prod\nbad line\n_sc|redis|0"))
+	if err != nil {
+		t.Fatalf("failed to write: err=%+v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.Stats().Received < 2 || srv.Stats().ParseErrors < 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out, stats=%+v", srv.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	srv.Flush()
+
+	series, err := s.Select(0, 1427163000, labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "hits"))
+	if err != nil {
+		t.Fatalf("failed to select: err=%+v", err)
+	}
+	want := []store.Series{{
+		Labels: labels.FromStrings(labels.MetricName, "hits", "env", "prod"),
+		Points: []timeseries.Point{{Timestamp: 1427162400, Value: 3}},
+	}}
+	if !reflect.DeepEqual(series, want) {
+		t.Errorf("got=%+v, want=%+v", series, want)
+	}
+	if got, want := srv.Stats(), (statsd.Stats{Received: 2, ParseErrors: 1, Flushed: 2}); got != want {
+		t.Errorf("stats got=%+v, want=%+v", got, want)
+	}
+}
@@ -0,0 +1,27 @@
+package store
+
+import (
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/labels"
+)
+
+// Appender appends data points to the series identified by keys.
+// Store and wal.Appender implement it.
+type Appender interface {
+	Append(key string, p timeseries.Point) error
+}
+
+// AppenderFunc is an adapter to allow the use of ordinary functions as
+// Appender.
+type AppenderFunc func(key string, p timeseries.Point) error
+
+// Append calls f(key, p).
+func (f AppenderFunc) Append(key string, p timeseries.Point) error {
+	return f(key, p)
+}
+
+// LabelsAppender appends data points to the series identified by labels.
+// Store implements it.
+type LabelsAppender interface {
+	AppendLabels(ls labels.Labels, p timeseries.Point) error
+}
@@ -0,0 +1,188 @@
+package store
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/hnakamur/timeseries/labels"
+)
+
+// Index is an inverted index which maps label pairs to postings lists of
+// series references.
+type Index struct {
+	mu       sync.RWMutex
+	postings map[string]map[string][]uint64
+	series   map[uint64]labels.Labels
+	byHash   map[uint64][]uint64
+	all      []uint64
+	nextRef  uint64
+}
+
+// NewIndex creates an empty index.
+func NewIndex() *Index {
+	return &Index{
+		postings: make(map[string]map[string][]uint64),
+		series:   make(map[uint64]labels.Labels),
+		byHash:   make(map[uint64][]uint64),
+		nextRef:  1,
+	}
+}
+
+// Add adds the series for the labels to the index if it does not exist, and
+// returns the series reference. created is true if the series is added.
+func (ix *Index) Add(ls labels.Labels) (ref uint64, created bool) {
+	h := ls.Hash()
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for _, r := range ix.byHash[h] {
+		if ix.series[r].Equal(ls) {
+			return r, false
+		}
+	}
+
+	ref = ix.nextRef
+	ix.nextRef++
+	ix.series[ref] = ls
+	ix.byHash[h] = append(ix.byHash[h], ref)
+	ix.all = append(ix.all, ref)
+	for _, l := range ls {
+		values := ix.postings[l.Name]
+		if values == nil {
+			values = make(map[string][]uint64)
+			ix.postings[l.Name] = values
+		}
+		// References are assigned in increasing order so postings lists
+		// stay sorted.
+		values[l.Value] = append(values[l.Value], ref)
+	}
+	return ref, true
+}
+
+// Labels returns the labels of the series for the reference.
+func (ix *Index) Labels(ref uint64) (labels.Labels, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	ls, ok := ix.series[ref]
+	return ls, ok
+}
+
+// Len returns the number of series in the index.
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.series)
+}
+
+// LabelValues returns the sorted label values for the label name.
+func (ix *Index) LabelValues(name string) []string {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	values := make([]string, 0, len(ix.postings[name]))
+	for v := range ix.postings[name] {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return values
+}
+
+// Select returns the sorted references of the series whose labels match all
+// the matchers. It returns all series if no matcher is given.
+func (ix *Index) Select(ms ...*labels.Matcher) []uint64 {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	refs := ix.all
+	for _, m := range ms {
+		refs = intersect(refs, ix.postingsFor(m))
+		if len(refs) == 0 {
+			return nil
+		}
+	}
+	return append([]uint64(nil), refs...)
+}
+
+// postingsFor returns the sorted references of the series matching m.
+// It must be called with ix.mu held.
+func (ix *Index) postingsFor(m *labels.Matcher) []uint64 {
+	values := ix.postings[m.Name]
+	if m.Matches("") {
+		// The series without the label match, so subtract the series
+		// with a non-matching value from all series.
+		var lists [][]uint64
+		for v, list := range values {
+			if !m.Matches(v) {
+				lists = append(lists, list)
+			}
+		}
+		return subtract(ix.all, union(lists))
+	}
+
+	if m.Type == labels.MatchEqual {
+		return values[m.Value]
+	}
+	var lists [][]uint64
+	for v, list := range values {
+		if m.Matches(v) {
+			lists = append(lists, list)
+		}
+	}
+	return union(lists)
+}
+
+func intersect(a, b []uint64) []uint64 {
+	var res []uint64
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			res = append(res, a[i])
+			i++
+			j++
+		}
+	}
+	return res
+}
+
+func union(lists [][]uint64) []uint64 {
+	switch len(lists) {
+	case 0:
+		return nil
+	case 1:
+		return lists[0]
+	}
+	var res []uint64
+	for _, list := range lists {
+		res = append(res, list...)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i] < res[j]
+	})
+	// Remove duplicates in place.
+	n := 0
+	for i, ref := range res {
+		if i == 0 || ref != res[n-1] {
+			res[n] = ref
+			n++
+		}
+	}
+	return res[:n]
+}
+
+func subtract(a, b []uint64) []uint64 {
+	var res []uint64
+	j := 0
+	for _, ref := range a {
+		for j < len(b) && b[j] < ref {
+			j++
+		}
+		if j < len(b) && b[j] == ref {
+			continue
+		}
+		res = append(res, ref)
+	}
+	return res
+}
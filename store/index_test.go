@@ -0,0 +1,117 @@
+package store_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/labels"
+	"github.com/hnakamur/timeseries/store"
+)
+
+func TestIndexSelect(t *testing.T) {
+	ix := store.NewIndex()
+	series := []labels.Labels{
+		labels.FromStrings(labels.MetricName, "http_requests", "host", "host1", "status", "200"),
+		labels.FromStrings(labels.MetricName, "http_requests", "host", "host1", "status", "500"),
+		labels.FromStrings(labels.MetricName, "http_requests", "host", "host2", "status", "200"),
+		labels.FromStrings(labels.MetricName, "cpu", "host", "host1"),
+	}
+	for i, ls := range series {
+		ref, created := ix.Add(ls)
+		if !created || ref != uint64(i+1) {
+			t.Fatalf("got ref=%d, created=%v, want ref=%d, created=true", ref, created, i+1)
+		}
+	}
+	ref, created := ix.Add(labels.FromMap(series[1].Map()))
+	if created || ref != 2 {
+		t.Errorf("got ref=%d, created=%v, want ref=2, created=false", ref, created)
+	}
+
+	testCases := []struct {
+		ms   []*labels.Matcher
+		want []uint64
+	}{
+		{
+			ms:   nil,
+			want: []uint64{1, 2, 3, 4},
+		},
+		{
+			ms: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "http_requests"),
+			},
+			want: []uint64{1, 2, 3},
+		},
+		{
+			ms: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "http_requests"),
+				labels.MustNewMatcher(labels.MatchEqual, "host", "host1"),
+			},
+			want: []uint64{1, 2},
+		},
+		{
+			ms: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchRegexp, "status", "5.."),
+			},
+			want: []uint64{2},
+		},
+		{
+			ms: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchNotEqual, "status", "200"),
+			},
+			want: []uint64{2, 4},
+		},
+		{
+			ms: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchNotRegexp, labels.MetricName, "http_.*"),
+			},
+			want: []uint64{4},
+		},
+		{
+			ms: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchEqual, "status", ""),
+			},
+			want: []uint64{4},
+		},
+		{
+			ms: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchEqual, "host", "host3"),
+			},
+			want: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		got := ix.Select(tc.ms...)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("matchers=%v, got=%v, want=%v", tc.ms, got, tc.want)
+		}
+	}
+}
+
+func TestStoreSelect(t *testing.T) {
+	s, err := store.New(store.Options{})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+
+	host1 := labels.FromStrings(labels.MetricName, "cpu", "host", "host1")
+	host2 := labels.FromStrings(labels.MetricName, "cpu", "host", "host2")
+	for _, ls := range []labels.Labels{host1, host2, host1} {
+		err = s.AppendLabels(ls, timeseries.Point{Timestamp: ts(2, 0) + uint32(s.Index().Len()), Value: 1})
+		if err != nil {
+			t.Fatalf("failed to append point: labels=%s, err=%+v", ls, err)
+		}
+	}
+	if s.Index().Len() != 2 {
+		t.Errorf("got %d series in index, want 2", s.Index().Len())
+	}
+
+	got, err := s.Select(0, ts(23, 0), labels.MustNewMatcher(labels.MatchEqual, "host", "host1"))
+	if err != nil {
+		t.Fatalf("failed to select series: err=%+v", err)
+	}
+	if len(got) != 1 || !got[0].Labels.Equal(host1) || len(got[0].Points) != 2 {
+		t.Errorf("got=%+v, want series %s with 2 points", got, host1)
+	}
+}
@@ -0,0 +1,425 @@
+// Package store implements an in-memory time-series store keyed by series
+// key, similar to TSmap described in the paper "Gorilla: A Fast, Scalable,
+// In-Memory Time Series Database".
+//
+// Each series has an open block which data points are appended to and a list
+// of sealed blocks. Blocks are encoded with timeseries.Encoder and decoded with
+// timeseries.Decoder. The series map is sharded and each series has its own
+// lock, so appending to and querying different series do not block each other.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/labels"
+)
+
+// DefaultBlockDuration is the default block duration which is two hours as
+// used in the paper.
+const DefaultBlockDuration = 2 * time.Hour
+
+// DefaultShards is the default number of shards of the series map.
+const DefaultShards = 16
+
+// ErrSeriesNotFound is returned when the series for the key does not exist.
+var ErrSeriesNotFound = errors.New("series not found")
+
+// ErrConflict is returned by ReplaceSealedBlocks when the sealed blocks were
+// changed after they were read.
+var ErrConflict = errors.New("sealed blocks were changed concurrently")
+
+// Options is options for a store.
+type Options struct {
+	// BlockDuration is the duration of blocks.
+	// It defaults to DefaultBlockDuration if zero.
+	BlockDuration time.Duration
+
+	// Alignment is the offset of block timestamps from multiples of
+	// BlockDuration. See timeseries.SeriesWriter.
+	Alignment time.Duration
+
+	// Shards is the number of shards of the series map.
+	// It defaults to DefaultShards if zero.
+	Shards int
+}
+
+// BlockInfo is a sealed block and its time range.
+type BlockInfo struct {
+	// T0 is the block timestamp.
+	T0 uint32
+
+	// Last is the timestamp of the last data point in the block.
+	Last uint32
+
+	// Block is the encoded block.
+	Block timeseries.Block
+}
+
+// Stats is statistics of a store.
+type Stats struct {
+	// Series is the number of series.
+	Series int
+
+	// SealedBlocks is the number of sealed blocks.
+	SealedBlocks int
+
+	// Bytes is the total size in bytes of the encoded open and sealed blocks.
+	Bytes int64
+}
+
+// Store is an in-memory time-series store.
+type Store struct {
+	opts   Options
+	shards []*shard
+	index  *Index
+	bytes  int64
+}
+
+type shard struct {
+	mu     sync.RWMutex
+	series map[string]*memSeries
+}
+
+type memSeries struct {
+	mu          sync.Mutex
+	w           *timeseries.SeriesWriter
+	sealed      []BlockInfo
+	sealedBytes int64
+	last        uint32
+}
+
+// New creates a store.
+func New(opts Options) (*Store, error) {
+	if opts.BlockDuration == 0 {
+		opts.BlockDuration = DefaultBlockDuration
+	}
+	if opts.Shards == 0 {
+		opts.Shards = DefaultShards
+	}
+	if opts.Shards < 0 {
+		return nil, fmt.Errorf("invalid number of shards: shards=%d", opts.Shards)
+	}
+	err := timeseries.ValidateBlockDuration(opts.BlockDuration, opts.Alignment)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		opts:   opts,
+		shards: make([]*shard, opts.Shards),
+		index:  NewIndex(),
+	}
+	for i := range s.shards {
+		s.shards[i] = &shard{series: make(map[string]*memSeries)}
+	}
+	return s, nil
+}
+
+// Options returns the options of the store with defaults filled in.
+func (s *Store) Options() Options {
+	return s.opts
+}
+
+// Append appends a data point to the series for the key. The series is
+// created if it does not exist. Data points of a series must be appended in
+// timestamp order, and an error wrapping timeseries.ErrOutOfOrder is returned
+// otherwise.
+func (s *Store) Append(key string, p timeseries.Point) error {
+	sr, err := s.getOrCreate(key)
+	if err != nil {
+		return err
+	}
+
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	before := sr.size()
+	err = sr.w.Write(p)
+	atomic.AddInt64(&s.bytes, sr.size()-before)
+	if err != nil {
+		return err
+	}
+	sr.last = p.Timestamp
+	return nil
+}
+
+// AppendLabels appends a data point to the series identified by the labels.
+// The series is created and added to the index if it does not exist.
+// The key of the series is the string representation of the labels.
+func (s *Store) AppendLabels(ls labels.Labels, p timeseries.Point) error {
+	key := ls.String()
+	if s.get(key) == nil {
+		s.index.Add(ls)
+	}
+	return s.Append(key, p)
+}
+
+// Query returns the data points of the series for the key whose timestamps
+// are between from and to inclusive.
+// It returns ErrSeriesNotFound if the series does not exist.
+func (s *Store) Query(key string, from, to uint32) ([]timeseries.Point, error) {
+	sr := s.get(key)
+	if sr == nil {
+		return nil, ErrSeriesNotFound
+	}
+
+	blocks, err := sr.blocks(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var points []timeseries.Point
+	for _, b := range blocks {
+		_, blockPoints, err := b.Block.Points()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode block: t0=%d, err=%+v", b.T0, err)
+		}
+		for _, p := range blockPoints {
+			if from <= p.Timestamp && p.Timestamp <= to {
+				points = append(points, p)
+			}
+		}
+	}
+	return points, nil
+}
+
+// Series is the labels of a series and its data points.
+type Series struct {
+	Labels labels.Labels
+	Points []timeseries.Point
+}
+
+// Index returns the index of the series appended with AppendLabels.
+func (s *Store) Index() *Index {
+	return s.index
+}
+
+// SelectLabels returns the labels of the series appended with AppendLabels
+// whose labels match all the matchers.
+func (s *Store) SelectLabels(ms ...*labels.Matcher) []labels.Labels {
+	refs := s.index.Select(ms...)
+	lss := make([]labels.Labels, 0, len(refs))
+	for _, ref := range refs {
+		ls, ok := s.index.Labels(ref)
+		if ok {
+			lss = append(lss, ls)
+		}
+	}
+	return lss
+}
+
+// Select returns the series appended with AppendLabels whose labels match
+// all the matchers, with their data points between from and to inclusive.
+// Series without data points in the range are omitted.
+func (s *Store) Select(from, to uint32, ms ...*labels.Matcher) ([]Series, error) {
+	var res []Series
+	for _, ls := range s.SelectLabels(ms...) {
+		points, err := s.Query(ls.String(), from, to)
+		if err == ErrSeriesNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if len(points) > 0 {
+			res = append(res, Series{Labels: ls, Points: points})
+		}
+	}
+	return res, nil
+}
+
+// Keys returns the sorted keys of all series.
+func (s *Store) Keys() []string {
+	var keys []string
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for key := range sh.series {
+			keys = append(keys, key)
+		}
+		sh.mu.RUnlock()
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SealedBlocks returns the sealed blocks of the series for the key in
+// timestamp order. It returns nil if the series does not exist.
+func (s *Store) SealedBlocks(key string) []BlockInfo {
+	sr := s.get(key)
+	if sr == nil {
+		return nil
+	}
+
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	return append([]BlockInfo(nil), sr.sealed...)
+}
+
+// ReplaceSealedBlocks replaces the leading sealed blocks old of the series for
+// the key with blocks. old must be a prefix of the sealed blocks returned by
+// SealedBlocks, otherwise it returns ErrConflict. This is used to compact or
+// delete sealed blocks while data points are being appended.
+func (s *Store) ReplaceSealedBlocks(key string, old, blocks []BlockInfo) error {
+	sr := s.get(key)
+	if sr == nil {
+		return ErrSeriesNotFound
+	}
+
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if len(old) > len(sr.sealed) {
+		return ErrConflict
+	}
+	for i, b := range old {
+		if !sameBlock(b, sr.sealed[i]) {
+			return ErrConflict
+		}
+	}
+
+	var delta int64
+	for _, b := range old {
+		delta -= int64(len(b.Block.Data))
+	}
+	for _, b := range blocks {
+		delta += int64(len(b.Block.Data))
+	}
+	sealed := make([]BlockInfo, 0, len(blocks)+len(sr.sealed)-len(old))
+	sealed = append(sealed, blocks...)
+	sealed = append(sealed, sr.sealed[len(old):]...)
+	sr.sealed = sealed
+	sr.sealedBytes += delta
+	atomic.AddInt64(&s.bytes, delta)
+	return nil
+}
+
+func sameBlock(a, b BlockInfo) bool {
+	if a.T0 != b.T0 || a.Last != b.Last || len(a.Block.Data) != len(b.Block.Data) {
+		return false
+	}
+	return len(a.Block.Data) == 0 || &a.Block.Data[0] == &b.Block.Data[0]
+}
+
+// Flush seals the open blocks of all series.
+func (s *Store) Flush() error {
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		list := make([]*memSeries, 0, len(sh.series))
+		for _, sr := range sh.series {
+			list = append(list, sr)
+		}
+		sh.mu.RUnlock()
+
+		for _, sr := range list {
+			sr.mu.Lock()
+			before := sr.size()
+			err := sr.w.Flush()
+			atomic.AddInt64(&s.bytes, sr.size()-before)
+			sr.mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Bytes returns the total size in bytes of the encoded open and sealed blocks.
+func (s *Store) Bytes() int64 {
+	return atomic.LoadInt64(&s.bytes)
+}
+
+// Stats returns the statistics of the store.
+func (s *Store) Stats() Stats {
+	var st Stats
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		st.Series += len(sh.series)
+		for _, sr := range sh.series {
+			sr.mu.Lock()
+			st.SealedBlocks += len(sr.sealed)
+			sr.mu.Unlock()
+		}
+		sh.mu.RUnlock()
+	}
+	st.Bytes = s.Bytes()
+	return st
+}
+
+func (s *Store) shardFor(key string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+func (s *Store) get(key string) *memSeries {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	return sh.series[key]
+}
+
+func (s *Store) getOrCreate(key string) (*memSeries, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	sr := sh.series[key]
+	sh.mu.RUnlock()
+	if sr != nil {
+		return sr, nil
+	}
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sr = sh.series[key]
+	if sr != nil {
+		return sr, nil
+	}
+	sr = &memSeries{}
+	w, err := timeseries.NewSeriesWriter(s.opts.BlockDuration, s.opts.Alignment,
+		timeseries.BlockSinkFunc(sr.addSealed))
+	if err != nil {
+		return nil, err
+	}
+	sr.w = w
+	sh.series[key] = sr
+	return sr, nil
+}
+
+// addSealed is called by the series writer with sr.mu held. sr.last is still
+// the timestamp of the last point in the sealed block at this time.
+func (sr *memSeries) addSealed(t0 uint32, block timeseries.Block) error {
+	sr.sealed = append(sr.sealed, BlockInfo{T0: t0, Last: sr.last, Block: block})
+	sr.sealedBytes += int64(len(block.Data))
+	return nil
+}
+
+func (sr *memSeries) size() int64 {
+	return sr.sealedBytes + int64(sr.w.Len())
+}
+
+// blocks returns the sealed blocks and a snapshot of the open block which may
+// contain points between from and to.
+func (sr *memSeries) blocks(from, to uint32) ([]BlockInfo, error) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	var blocks []BlockInfo
+	for _, b := range sr.sealed {
+		if b.T0 <= to && from <= b.Last {
+			blocks = append(blocks, b)
+		}
+	}
+
+	t0, block, ok, err := sr.w.OpenBlock()
+	if err != nil {
+		return nil, err
+	}
+	if ok && t0 <= to && from <= sr.last {
+		blocks = append(blocks, BlockInfo{T0: t0, Last: sr.last, Block: block})
+	}
+	return blocks, nil
+}
@@ -0,0 +1,173 @@
+package store_test
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/labels"
+	"github.com/hnakamur/timeseries/store"
+)
+
+func ts(hour, min int) uint32 {
+	return uint32(time.Date(2015, 3, 24, hour, min, 0, 0, time.UTC).Unix())
+}
+
+func TestStoreQuery(t *testing.T) {
+	s, err := store.New(store.Options{})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+
+	points := []timeseries.Point{
+		{Timestamp: ts(2, 0), Value: 1},
+		{Timestamp: ts(3, 0), Value: 2},
+		{Timestamp: ts(4, 0), Value: 3},
+		{Timestamp: ts(5, 0), Value: 4},
+		{Timestamp: ts(6, 30), Value: 5},
+	}
+	for _, p := range points {
+		err = s.Append("cpu.host1", p)
+		if err != nil {
+			t.Fatalf("failed to append point: point=%+v, err=%+v", p, err)
+		}
+	}
+	err = s.Append("cpu.host2", timeseries.Point{Timestamp: ts(2, 0), Value: 10})
+	if err != nil {
+		t.Fatalf("failed to append point: err=%+v", err)
+	}
+
+	testCases := []struct {
+		from uint32
+		to   uint32
+		want []timeseries.Point
+	}{
+		{from: 0, to: ts(23, 0), want: points},
+		{from: ts(3, 0), to: ts(4, 0), want: points[1:3]},
+		{from: ts(5, 0), to: ts(7, 0), want: points[3:]},
+		{from: ts(6, 31), to: ts(7, 0), want: nil},
+	}
+	for _, tc := range testCases {
+		got, err := s.Query("cpu.host1", tc.from, tc.to)
+		if err != nil {
+			t.Fatalf("failed to query: from=%d, to=%d, err=%+v", tc.from, tc.to, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("from=%d, to=%d, got=%+v, want=%+v", tc.from, tc.to, got, tc.want)
+		}
+	}
+
+	_, err = s.Query("mem.host1", 0, ts(23, 0))
+	if err != store.ErrSeriesNotFound {
+		t.Errorf("got err=%v, want %v", err, store.ErrSeriesNotFound)
+	}
+
+	gotKeys := s.Keys()
+	wantKeys := []string{"cpu.host1", "cpu.host2"}
+	if !reflect.DeepEqual(gotKeys, wantKeys) {
+		t.Errorf("gotKeys=%v, wantKeys=%v", gotKeys, wantKeys)
+	}
+
+	st := s.Stats()
+	if st.Series != 2 || st.SealedBlocks != 2 {
+		t.Errorf("got stats=%+v, want 2 series and 2 sealed blocks", st)
+	}
+}
+
+func TestStoreAppendLabels(t *testing.T) {
+	s, err := store.New(store.Options{})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+	// The label names of the first set are written so that a plain
+	// representation would be the same as the one of the second set.
+	sets := []labels.Labels{
+		labels.FromStrings(labels.MetricName, "cpu", `a="1", b`, "2"),
+		labels.FromStrings(labels.MetricName, "cpu", "a", "1", "b", "2"),
+	}
+	for i, ls := range sets {
+		err = s.AppendLabels(ls, timeseries.Point{Timestamp: ts(2, 0), Value: float64(i)})
+		if err != nil {
+			t.Fatalf("failed to append point: labels=%s, err=%+v", ls, err)
+		}
+	}
+	for i, ls := range sets {
+		got, err := s.Query(ls.String(), 0, ts(23, 0))
+		if err != nil {
+			t.Fatalf("failed to query: labels=%s, err=%+v", ls, err)
+		}
+		want := []timeseries.Point{{Timestamp: ts(2, 0), Value: float64(i)}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("labels=%s, got=%+v, want=%+v", ls, got, want)
+		}
+	}
+}
+
+func TestStoreFlush(t *testing.T) {
+	s, err := store.New(store.Options{})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+	err = s.Append("cpu", timeseries.Point{Timestamp: ts(2, 1), Value: 1})
+	if err != nil {
+		t.Fatalf("failed to append point: err=%+v", err)
+	}
+	err = s.Append("cpu", timeseries.Point{Timestamp: ts(2, 2), Value: 2})
+	if err != nil {
+		t.Fatalf("failed to append point: err=%+v", err)
+	}
+	err = s.Flush()
+	if err != nil {
+		t.Fatalf("failed to flush store: err=%+v", err)
+	}
+
+	got := s.SealedBlocks("cpu")
+	if len(got) != 1 {
+		t.Fatalf("got %d sealed blocks, want 1", len(got))
+	}
+	if got[0].T0 != ts(2, 0) || got[0].Last != ts(2, 2) {
+		t.Errorf("got T0=%d, Last=%d, want T0=%d, Last=%d", got[0].T0, got[0].Last, ts(2, 0), ts(2, 2))
+	}
+	if s.Bytes() != int64(len(got[0].Block.Data)) {
+		t.Errorf("got bytes=%d, want %d", s.Bytes(), len(got[0].Block.Data))
+	}
+}
+
+func TestStoreConcurrentAppend(t *testing.T) {
+	s, err := store.New(store.Options{BlockDuration: time.Hour})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+
+	const nSeries = 8
+	const nPoints = 1000
+	var wg sync.WaitGroup
+	for i := 0; i < nSeries; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			for j := 0; j < nPoints; j++ {
+				err := s.Append(key, timeseries.Point{Timestamp: ts(0, 0) + uint32(j*60), Value: float64(j)})
+				if err != nil {
+					t.Errorf("failed to append point: key=%s, err=%+v", key, err)
+					return
+				}
+			}
+		}(fmt.Sprintf("series%d", i))
+	}
+	wg.Wait()
+
+	for i := 0; i < nSeries; i++ {
+		key := fmt.Sprintf("series%d", i)
+		points, err := s.Query(key, 0, ts(23, 59)+86400)
+		if err != nil {
+			t.Fatalf("failed to query: key=%s, err=%+v", key, err)
+		}
+		if len(points) != nPoints {
+			t.Errorf("key=%s, got %d points, want %d", key, len(points), nPoints)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package timeseries
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// MaxFirstDelta is the maximum duration from the block timestamp to the
+// first data point in a block. The first timestamp delta is sized at
+// nBitsFirstDelta bits and the largest value is reserved for the finish marker,
+// so a block window longer than this cannot hold a point at its end as the
+// first point.
+const MaxFirstDelta = (1<<nBitsFirstDelta - 2) * time.Second
+
+var (
+	// MinTime is the minimum time which can be represented as a timestamp.
+	MinTime = time.Unix(0, 0).UTC()
+
+	// MaxTime is the maximum time which can be represented as a timestamp.
+	// It is 2106-02-07 06:28:15 +0000 UTC.
+	MaxTime = time.Unix(math.MaxUint32, 0).UTC()
+)
+
+// Timestamp converts t to seconds since 1970-01-01 00:00:00 +0000 UTC.
+// The sub-second part of t is truncated. It returns an error if t is out of
+// the range between MinTime and MaxTime.
+func Timestamp(t time.Time) (uint32, error) {
+	sec := t.Unix()
+	if sec < 0 || sec > math.MaxUint32 {
+		return 0, fmt.Errorf("time out of range for timestamp: time=%s, min=%s, max=%s",
+			t.Format(time.RFC3339), MinTime.Format(time.RFC3339), MaxTime.Format(time.RFC3339))
+	}
+	return uint32(sec), nil
+}
+
+// TimeOf converts a timestamp to time in UTC.
+func TimeOf(timestamp uint32) time.Time {
+	return time.Unix(int64(timestamp), 0).UTC()
+}
+
+// NewPoint creates a data point at time t. It returns an error if t is out of
+// the range of timestamps.
+func NewPoint(t time.Time, v float64) (Point, error) {
+	timestamp, err := Timestamp(t)
+	if err != nil {
+		return Point{}, err
+	}
+	return Point{Timestamp: timestamp, Value: v}, nil
+}
+
+// Time returns the timestamp of the data point as time in UTC.
+func (p Point) Time() time.Time {
+	return TimeOf(p.Timestamp)
+}
+
+// EncodeHeaderAt encodes the block timestamp t0 to the header bits.
+// It returns an error if t0 is out of the range of timestamps.
+func (e *Encoder) EncodeHeaderAt(t0 time.Time) error {
+	timestamp, err := Timestamp(t0)
+	if err != nil {
+		return err
+	}
+	return e.EncodeHeader(timestamp)
+}
+
+// EncodeAt encodes a data point at time t.
+// It returns an error if t is out of the range of timestamps, t is before the
+// previous data point, or t is the first data point and it is before the block
+// timestamp or after MaxFirstDelta from the block timestamp.
+func (e *Encoder) EncodeAt(t time.Time, v float64) error {
+	p, err := NewPoint(t, v)
+	if err != nil {
+		return err
+	}
+
+	if e.storedTimestamp == 0 {
+		if p.Timestamp < e.headerTimestamp {
+			return fmt.Errorf("first point time is before block time: time=%s, blockTime=%s",
+				p.Time().Format(time.RFC3339), TimeOf(e.headerTimestamp).Format(time.RFC3339))
+		}
+		delta := time.Duration(p.Timestamp-e.headerTimestamp) * time.Second
+		if delta > MaxFirstDelta {
+			return fmt.Errorf("first point time is too far from block time: time=%s, blockTime=%s, delta=%s, maxDelta=%s",
+				p.Time().Format(time.RFC3339), TimeOf(e.headerTimestamp).Format(time.RFC3339), delta, MaxFirstDelta)
+		}
+	} else if p.Timestamp < e.storedTimestamp {
+		return fmt.Errorf("point time is before previous point time: time=%s, previousTime=%s",
+			p.Time().Format(time.RFC3339), TimeOf(e.storedTimestamp).Format(time.RFC3339))
+	}
+
+	return e.EncodePoint(p)
+}
+
+// DecodeHeaderTime decodes header to the block timestamp as time in UTC.
+func (d *Decoder) DecodeHeaderTime() (t0 time.Time, err error) {
+	timestamp, err := d.DecodeHeader()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return TimeOf(timestamp), nil
+}
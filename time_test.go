@@ -0,0 +1,167 @@
+package timeseries_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+)
+
+func TestTimestamp(t *testing.T) {
+	testCases := []struct {
+		t       time.Time
+		want    uint32
+		wantErr bool
+	}{
+		{t: time.Date(2015, 3, 24, 2, 0, 0, 0, time.UTC), want: 1427162400},
+		{t: time.Date(2015, 3, 24, 11, 0, 0, 0, time.FixedZone("JST", 9*60*60)), want: 1427162400},
+		{t: time.Date(2015, 3, 24, 2, 0, 0, 999999999, time.UTC), want: 1427162400},
+		{t: timeseries.MinTime, want: 0},
+		{t: timeseries.MaxTime, want: 0xFFFFFFFF},
+		{t: timeseries.MinTime.Add(-time.Second), wantErr: true},
+		{t: timeseries.MaxTime.Add(time.Second), wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		got, err := timeseries.Timestamp(tc.t)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("t=%s, got no error, want an error", tc.t)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("t=%s, got error %+v, want no error", tc.t, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("t=%s, got=%d, want=%d", tc.t, got, tc.want)
+		}
+	}
+}
+
+func TestEncodeAt(t *testing.T) {
+	t0 := time.Date(2015, 3, 24, 2, 0, 0, 0, time.UTC)
+
+	var b bytes.Buffer
+	enc := timeseries.NewEncoder(&b)
+	err := enc.EncodeHeaderAt(t0)
+	if err != nil {
+		t.Fatalf("failed to encode time series header: err=%+v", err)
+	}
+	for _, p := range []struct {
+		t time.Time
+		v float64
+	}{
+		{t: t0.Add(1*time.Minute + 2*time.Second), v: 12.0},
+		{t: t0.Add(2*time.Minute + 2*time.Second), v: 12.0},
+		{t: t0.Add(3*time.Minute + 2*time.Second), v: 24.0},
+	} {
+		err = enc.EncodeAt(p.t, p.v)
+		if err != nil {
+			t.Fatalf("failed to encode time series point: err=%+v", err)
+		}
+	}
+	err = enc.Finish()
+	if err != nil {
+		t.Fatalf("failed to encode time series finish marker: err=%+v", err)
+	}
+
+	got := hex.EncodeToString(b.Bytes())
+	want := "5510c52000f900a0000000000002fc6b07ffffffffe0"
+	if got != want {
+		t.Errorf("got=%s, want=%s", got, want)
+	}
+
+	dec := timeseries.NewDecoder(&b)
+	gotT0, err := dec.DecodeHeaderTime()
+	if err != nil {
+		t.Fatalf("failed to decode time series header: err=%+v", err)
+	}
+	if !gotT0.Equal(t0) {
+		t.Errorf("gotT0=%s, wantT0=%s", gotT0, t0)
+	}
+	p, err := dec.DecodePoint()
+	if err != nil {
+		t.Fatalf("failed to decode time series point: err=%+v", err)
+	}
+	wantTime := t0.Add(1*time.Minute + 2*time.Second)
+	if !p.Time().Equal(wantTime) {
+		t.Errorf("gotTime=%s, wantTime=%s", p.Time(), wantTime)
+	}
+}
+
+func TestEncodeAtError(t *testing.T) {
+	t0 := time.Date(2015, 3, 24, 2, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name  string
+		times []time.Time
+	}{
+		{
+			name:  "first point before block time",
+			times: []time.Time{t0.Add(-time.Second)},
+		},
+		{
+			name:  "first point too far from block time",
+			times: []time.Time{t0.Add(timeseries.MaxFirstDelta + time.Second)},
+		},
+		{
+			name:  "point before previous point",
+			times: []time.Time{t0.Add(time.Minute), t0.Add(time.Minute - time.Second)},
+		},
+		{
+			name:  "point after max time",
+			times: []time.Time{t0.Add(time.Minute), timeseries.MaxTime.Add(time.Second)},
+		},
+	}
+
+	for _, tc := range testCases {
+		var b bytes.Buffer
+		enc := timeseries.NewEncoder(&b)
+		err := enc.EncodeHeaderAt(t0)
+		if err != nil {
+			t.Fatalf("failed to encode time series header: err=%+v", err)
+		}
+		last := len(tc.times) - 1
+		for i, pt := range tc.times {
+			err = enc.EncodeAt(pt, 1.0)
+			if i < last && err != nil {
+				t.Fatalf("%s: failed to encode time series point: err=%+v", tc.name, err)
+			}
+		}
+		if err == nil {
+			t.Errorf("%s: got no error, want an error", tc.name)
+		}
+	}
+
+	err := timeseries.NewEncoder(&bytes.Buffer{}).EncodeHeaderAt(timeseries.MaxTime.Add(time.Second))
+	if err == nil {
+		t.Error("header after max time: got no error, want an error")
+	}
+
+	var b bytes.Buffer
+	enc := timeseries.NewEncoder(&b)
+	err = enc.EncodeHeaderAt(t0)
+	if err != nil {
+		t.Fatalf("failed to encode time series header: err=%+v", err)
+	}
+	err = enc.EncodeAt(t0.Add(timeseries.MaxFirstDelta), 1.0)
+	if err != nil {
+		t.Errorf("first point at max delta: got error %+v, want no error", err)
+	}
+}
+
+func TestPointTime(t *testing.T) {
+	want := time.Date(2015, 3, 24, 2, 1, 2, 0, time.UTC)
+	p, err := timeseries.NewPoint(want.In(time.FixedZone("JST", 9*60*60)), 12.0)
+	if err != nil {
+		t.Fatalf("failed to create point: err=%+v", err)
+	}
+	got := p.Time()
+	if !got.Equal(want) || got.Location() != time.UTC {
+		t.Errorf("got=%s, want=%s", got, want)
+	}
+}
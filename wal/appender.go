@@ -0,0 +1,103 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/store"
+)
+
+// Appender appends data points to a store and logs them to a WAL.
+//
+// Every segment written by an Appender is self-contained: a series record is
+// logged in a segment before the first data point of the series in it, so
+// segments older than the open blocks can be removed with WAL.Truncate.
+type Appender struct {
+	wal   *WAL
+	store *store.Store
+
+	mu      sync.Mutex
+	refs    map[string]uint64
+	logged  map[uint64]int
+	nextRef uint64
+}
+
+// NewAppender creates an appender.
+func NewAppender(w *WAL, s *store.Store) *Appender {
+	return &Appender{
+		wal:     w,
+		store:   s,
+		refs:    make(map[string]uint64),
+		logged:  make(map[uint64]int),
+		nextRef: 1,
+	}
+}
+
+// Append logs the data point to the WAL and then appends it to the series
+// for the key in the store, so that a point in the store is never lost on a
+// crash. An out of order point the store rejects stays in the WAL and is
+// skipped on replay.
+func (a *Appender) Append(key string, p timeseries.Point) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ref, ok := a.refs[key]
+	if !ok {
+		ref = a.nextRef
+		a.nextRef++
+		a.refs[key] = ref
+	}
+	loggedSeg, ok := a.logged[ref]
+	if !ok {
+		loggedSeg = -1
+	}
+	seg, err := a.wal.logPointWithSeries(ref, key, p, loggedSeg)
+	if err != nil {
+		return err
+	}
+	a.logged[ref] = seg
+
+	return a.store.Append(key, p)
+}
+
+// Replay reads the WAL segments in the directory and appends the logged data
+// points to the store, which rebuilds the open blocks after a restart.
+// Out of order points are skipped, and other errors of the store stop the
+// replay. It must be called before appending with the appender.
+func (a *Appender) Replay(dir string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return Replay(dir, &storeHandler{a: a, keys: make(map[uint64]string)})
+}
+
+type storeHandler struct {
+	a    *Appender
+	keys map[uint64]string
+}
+
+func (h *storeHandler) Series(ref uint64, key string) error {
+	h.keys[ref] = key
+	if _, ok := h.a.refs[key]; !ok {
+		h.a.refs[key] = ref
+		if ref >= h.a.nextRef {
+			h.a.nextRef = ref + 1
+		}
+	}
+	return nil
+}
+
+func (h *storeHandler) Point(ref uint64, p timeseries.Point) error {
+	key, ok := h.keys[ref]
+	if !ok {
+		return fmt.Errorf("WAL point record for unknown series reference: ref=%d", ref)
+	}
+	err := h.a.store.Append(key, p)
+	if errors.Is(err, timeseries.ErrOutOfOrder) {
+		// Out of order points are logged before the store rejects them on
+		// append, so they are skipped here as well.
+		return nil
+	}
+	return err
+}
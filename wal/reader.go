@@ -0,0 +1,116 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+
+	"github.com/hnakamur/timeseries"
+)
+
+// Handler handles records read by Replay.
+type Handler interface {
+	// Series is called for a record which defines the series reference for
+	// the key.
+	Series(ref uint64, key string) error
+
+	// Point is called for a record of the data point for the series reference.
+	Point(ref uint64, p timeseries.Point) error
+}
+
+// CorruptionError is returned by Replay when a segment has a corrupted record
+// which is not at the end of the segment.
+type CorruptionError struct {
+	Segment int
+	Offset  int64
+	Err     error
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("corrupted WAL record: segment=%d, offset=%d, err=%+v", e.Segment, e.Offset, e.Err)
+}
+
+// Replay reads the records in the segments of the directory in order and
+// calls the handler for each record.
+//
+// An incomplete record or a record with a checksum mismatch at the end of a
+// segment is regarded as a torn write by a crash and is ignored. A corrupted
+// record followed by other data is reported with a CorruptionError.
+func Replay(dir string, h Handler) error {
+	segs, err := Segments(dir)
+	if err != nil {
+		return err
+	}
+	for _, seg := range segs {
+		err = replaySegment(seg, segmentName(dir, seg), h)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func replaySegment(seg int, name string, h Handler) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	var off int64
+	for len(data) > 0 {
+		if len(data) < recordHeaderSize {
+			// Torn write of a record header.
+			return nil
+		}
+		n := int(binary.BigEndian.Uint32(data[:4]))
+		sum := binary.BigEndian.Uint32(data[4:8])
+		if len(data)-recordHeaderSize < n {
+			// Torn write of a record payload.
+			return nil
+		}
+		payload := data[recordHeaderSize : recordHeaderSize+n]
+		rest := data[recordHeaderSize+n:]
+		if crc32.Checksum(payload, castagnoli) != sum {
+			if len(rest) == 0 {
+				// Torn write of the last record.
+				return nil
+			}
+			return &CorruptionError{Segment: seg, Offset: off, Err: errors.New("checksum mismatch")}
+		}
+
+		err = handleRecord(payload, h)
+		if err == errInvalidRecord {
+			return &CorruptionError{Segment: seg, Offset: off, Err: err}
+		} else if err != nil {
+			return err
+		}
+
+		off += int64(recordHeaderSize + n)
+		data = rest
+	}
+	return nil
+}
+
+func handleRecord(payload []byte, h Handler) error {
+	if len(payload) == 0 {
+		return errInvalidRecord
+	}
+	switch payload[0] {
+	case recordSeries:
+		ref, key, err := decodeSeries(payload[1:])
+		if err != nil {
+			return err
+		}
+		return h.Series(ref, key)
+	case recordPoint:
+		ref, p, err := decodePoint(payload[1:])
+		if err != nil {
+			return err
+		}
+		return h.Point(ref, p)
+	default:
+		return errInvalidRecord
+	}
+}
@@ -0,0 +1,80 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math"
+
+	"github.com/hnakamur/timeseries"
+)
+
+// A record is framed with a 4 bytes big endian payload length and a 4 bytes
+// big endian CRC32C checksum of the payload, followed by the payload.
+// The first byte of the payload is the record type.
+const recordHeaderSize = 8
+
+const (
+	recordSeries byte = 1
+	recordPoint  byte = 2
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+var errInvalidRecord = errors.New("invalid WAL record")
+
+// appendFrame appends a framed record of the payload to buf.
+func appendFrame(buf, payload []byte) []byte {
+	var hdr [recordHeaderSize]byte
+	binary.BigEndian.PutUint32(hdr[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(hdr[4:], crc32.Checksum(payload, castagnoli))
+	buf = append(buf, hdr[:]...)
+	return append(buf, payload...)
+}
+
+func encodeSeries(buf []byte, ref uint64, key string) []byte {
+	buf = append(buf, recordSeries)
+	buf = appendUvarint(buf, ref)
+	buf = appendUvarint(buf, uint64(len(key)))
+	return append(buf, key...)
+}
+
+func encodePoint(buf []byte, ref uint64, p timeseries.Point) []byte {
+	buf = append(buf, recordPoint)
+	buf = appendUvarint(buf, ref)
+	var b [12]byte
+	binary.BigEndian.PutUint32(b[:4], p.Timestamp)
+	binary.BigEndian.PutUint64(b[4:], math.Float64bits(p.Value))
+	return append(buf, b[:]...)
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	return append(buf, b[:n]...)
+}
+
+func decodeSeries(payload []byte) (ref uint64, key string, err error) {
+	ref, n := binary.Uvarint(payload)
+	if n <= 0 {
+		return 0, "", errInvalidRecord
+	}
+	payload = payload[n:]
+	l, n := binary.Uvarint(payload)
+	if n <= 0 || uint64(len(payload)-n) != l {
+		return 0, "", errInvalidRecord
+	}
+	return ref, string(payload[n:]), nil
+}
+
+func decodePoint(payload []byte) (ref uint64, p timeseries.Point, err error) {
+	ref, n := binary.Uvarint(payload)
+	if n <= 0 || len(payload)-n != 12 {
+		return 0, timeseries.Point{}, errInvalidRecord
+	}
+	payload = payload[n:]
+	return ref, timeseries.Point{
+		Timestamp: binary.BigEndian.Uint32(payload[:4]),
+		Value:     math.Float64frombits(binary.BigEndian.Uint64(payload[4:])),
+	}, nil
+}
@@ -0,0 +1,372 @@
+// Package wal implements a segment-based write-ahead log which records data
+// points appended to open blocks, so that the open blocks can be rebuilt after
+// a process crash.
+//
+// The log is a directory of segment files named by zero-padded sequence
+// numbers. Each segment is a sequence of length-prefixed records with a CRC32C
+// checksum. A record defines a series reference for a series key, or holds a
+// data point for a series reference.
+package wal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+)
+
+// DefaultSegmentSize is the default maximum size of a segment file.
+const DefaultSegmentSize = 64 * 1024 * 1024
+
+// SyncPolicy is the policy when to fsync segment files.
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs after every record.
+	SyncAlways SyncPolicy = iota
+
+	// SyncBatch fsyncs after every BatchSize records.
+	SyncBatch
+
+	// SyncInterval fsyncs every SyncInterval in background.
+	SyncInterval
+)
+
+// Options is options for a WAL.
+type Options struct {
+	// SegmentSize is the maximum size of a segment file. A new segment is
+	// started when the current one exceeds it.
+	// It defaults to DefaultSegmentSize if zero.
+	SegmentSize int64
+
+	// SyncPolicy is the policy when to fsync segment files.
+	SyncPolicy SyncPolicy
+
+	// BatchSize is the number of records per fsync for SyncBatch.
+	BatchSize int
+
+	// SyncInterval is the interval of fsync for SyncInterval.
+	SyncInterval time.Duration
+}
+
+// WAL is a segment-based write-ahead log.
+type WAL struct {
+	dir  string
+	opts Options
+
+	mu       sync.Mutex
+	seg      int
+	f        *os.File
+	bw       *bufio.Writer
+	size     int64
+	unsynced int
+	buf      []byte
+	closed   bool
+	syncErr  error
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Open opens a WAL in the directory. The directory is created if it does not
+// exist. Records are written to a new segment after the existing ones.
+func Open(dir string, opts Options) (*WAL, error) {
+	if opts.SegmentSize == 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+	switch opts.SyncPolicy {
+	case SyncAlways:
+	case SyncBatch:
+		if opts.BatchSize <= 0 {
+			return nil, fmt.Errorf("invalid WAL batch size: %d", opts.BatchSize)
+		}
+	case SyncInterval:
+		if opts.SyncInterval <= 0 {
+			return nil, fmt.Errorf("invalid WAL sync interval: %s", opts.SyncInterval)
+		}
+	default:
+		return nil, fmt.Errorf("invalid WAL sync policy: %d", int(opts.SyncPolicy))
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	segs, err := Segments(dir)
+	if err != nil {
+		return nil, err
+	}
+	next := 0
+	if len(segs) > 0 {
+		next = segs[len(segs)-1] + 1
+	}
+
+	w := &WAL{dir: dir, opts: opts}
+	err = w.openSegment(next)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.SyncPolicy == SyncInterval {
+		w.done = make(chan struct{})
+		w.wg.Add(1)
+		go w.syncLoop()
+	}
+	return w, nil
+}
+
+// Segments returns the sorted sequence numbers of the segment files in the
+// directory.
+func Segments(dir string) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segs []int
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		n, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		segs = append(segs, n)
+	}
+	sort.Ints(segs)
+	return segs, nil
+}
+
+func segmentName(dir string, seg int) string {
+	return filepath.Join(dir, fmt.Sprintf("%08d", seg))
+}
+
+// Segment returns the sequence number of the current segment.
+func (w *WAL) Segment() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.seg
+}
+
+// LogSeries logs a record which defines the series reference for the key.
+func (w *WAL) LogSeries(ref uint64, key string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = encodeSeries(w.buf[:0], ref, key)
+	return w.write(w.buf)
+}
+
+// LogPoint logs a record of the data point for the series reference.
+func (w *WAL) LogPoint(ref uint64, p timeseries.Point) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = encodePoint(w.buf[:0], ref, p)
+	return w.write(w.buf)
+}
+
+// Cut closes the current segment and starts a new one.
+// It returns the sequence number of the new segment.
+func (w *WAL) Cut() (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, errors.New("WAL is closed")
+	}
+	err := w.cut()
+	if err != nil {
+		return 0, err
+	}
+	return w.seg, nil
+}
+
+// Truncate removes the segments whose sequence numbers are less than seg.
+// The current segment is never removed.
+func (w *WAL) Truncate(seg int) error {
+	w.mu.Lock()
+	cur := w.seg
+	w.mu.Unlock()
+
+	segs, err := Segments(w.dir)
+	if err != nil {
+		return err
+	}
+	for _, s := range segs {
+		if s >= seg || s >= cur {
+			break
+		}
+		err = os.Remove(segmentName(w.dir, s))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sync flushes buffered records and fsyncs the current segment.
+func (w *WAL) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	return w.sync()
+}
+
+// Close syncs and closes the WAL.
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mu.Unlock()
+
+	if w.done != nil {
+		close(w.done)
+		w.wg.Wait()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.sync()
+	if err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+// logPointWithSeries logs a data point preceded by the series record unless
+// the series record is already logged in the segment where the point goes.
+// It returns the segment the point is logged in.
+func (w *WAL) logPointWithSeries(ref uint64, key string, p timeseries.Point, loggedSeg int) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	series := encodeSeries(nil, ref, key)
+	w.buf = encodePoint(w.buf[:0], ref, p)
+	err := w.reserve(2*recordHeaderSize + len(series) + len(w.buf))
+	if err != nil {
+		return 0, err
+	}
+	if loggedSeg == w.seg {
+		return w.seg, w.write(w.buf)
+	}
+	return w.seg, w.write(series, w.buf)
+}
+
+// reserve starts a new segment if n bytes do not fit in the current one.
+func (w *WAL) reserve(n int) error {
+	if w.closed {
+		return errors.New("WAL is closed")
+	}
+	if w.syncErr != nil {
+		return w.syncErr
+	}
+	if w.size > 0 && w.size+int64(n) > w.opts.SegmentSize {
+		return w.cut()
+	}
+	return nil
+}
+
+func (w *WAL) write(payloads ...[]byte) error {
+	n := 0
+	for _, payload := range payloads {
+		n += recordHeaderSize + len(payload)
+	}
+	err := w.reserve(n)
+	if err != nil {
+		return err
+	}
+
+	frame := make([]byte, 0, n)
+	for _, payload := range payloads {
+		frame = appendFrame(frame, payload)
+	}
+	_, err = w.bw.Write(frame)
+	if err != nil {
+		return err
+	}
+	w.size += int64(len(frame))
+	w.unsynced += len(payloads)
+
+	switch w.opts.SyncPolicy {
+	case SyncAlways:
+		return w.sync()
+	case SyncBatch:
+		if w.unsynced >= w.opts.BatchSize {
+			return w.sync()
+		}
+	}
+	return nil
+}
+
+func (w *WAL) sync() error {
+	err := w.bw.Flush()
+	if err != nil {
+		return err
+	}
+	if w.unsynced == 0 {
+		return nil
+	}
+	err = w.f.Sync()
+	if err != nil {
+		return err
+	}
+	w.unsynced = 0
+	return nil
+}
+
+func (w *WAL) cut() error {
+	err := w.sync()
+	if err != nil {
+		return err
+	}
+	err = w.f.Close()
+	if err != nil {
+		return err
+	}
+	return w.openSegment(w.seg + 1)
+}
+
+func (w *WAL) openSegment(seg int) error {
+	f, err := os.OpenFile(segmentName(w.dir, seg), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w.seg = seg
+	w.f = f
+	if w.bw == nil {
+		w.bw = bufio.NewWriter(f)
+	} else {
+		w.bw.Reset(f)
+	}
+	w.size = 0
+	return nil
+}
+
+func (w *WAL) syncLoop() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			if !w.closed && w.syncErr == nil {
+				// The error is returned by following writes.
+				w.syncErr = w.sync()
+			}
+			w.mu.Unlock()
+		}
+	}
+}
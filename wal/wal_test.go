@@ -0,0 +1,226 @@
+package wal_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hnakamur/timeseries"
+	"github.com/hnakamur/timeseries/store"
+	"github.com/hnakamur/timeseries/wal"
+)
+
+type record struct {
+	ref   uint64
+	key   string
+	point timeseries.Point
+}
+
+type recorder struct {
+	records []record
+}
+
+func (r *recorder) Series(ref uint64, key string) error {
+	r.records = append(r.records, record{ref: ref, key: key})
+	return nil
+}
+
+func (r *recorder) Point(ref uint64, p timeseries.Point) error {
+	r.records = append(r.records, record{ref: ref, point: p})
+	return nil
+}
+
+func writeRecords(t *testing.T, dir string, opts wal.Options, records []record) {
+	w, err := wal.Open(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to open WAL: err=%+v", err)
+	}
+	for _, r := range records {
+		if r.key != "" {
+			err = w.LogSeries(r.ref, r.key)
+		} else {
+			err = w.LogPoint(r.ref, r.point)
+		}
+		if err != nil {
+			t.Fatalf("failed to log record: record=%+v, err=%+v", r, err)
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("failed to close WAL: err=%+v", err)
+	}
+}
+
+var testRecords = []record{
+	{ref: 1, key: "cpu"},
+	{ref: 1, point: timeseries.Point{Timestamp: 1427162462, Value: 12.0}},
+	{ref: 2, key: "mem"},
+	{ref: 2, point: timeseries.Point{Timestamp: 1427162462, Value: 1024}},
+	{ref: 1, point: timeseries.Point{Timestamp: 1427162522, Value: -24.2}},
+}
+
+func TestReplay(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts wal.Options
+	}{
+		{name: "always", opts: wal.Options{SyncPolicy: wal.SyncAlways}},
+		{name: "batch", opts: wal.Options{SyncPolicy: wal.SyncBatch, BatchSize: 2}},
+		{name: "interval", opts: wal.Options{SyncPolicy: wal.SyncInterval, SyncInterval: time.Millisecond}},
+		{name: "small segments", opts: wal.Options{SegmentSize: 20}},
+	}
+
+	for _, tc := range testCases {
+		dir := t.TempDir()
+		writeRecords(t, dir, tc.opts, testRecords)
+
+		var r recorder
+		err := wal.Replay(dir, &r)
+		if err != nil {
+			t.Fatalf("%s: failed to replay WAL: err=%+v", tc.name, err)
+		}
+		if !reflect.DeepEqual(r.records, testRecords) {
+			t.Errorf("%s: got=%+v, want=%+v", tc.name, r.records, testRecords)
+		}
+	}
+
+	dir := t.TempDir()
+	writeRecords(t, dir, wal.Options{SegmentSize: 20}, testRecords)
+	segs, err := wal.Segments(dir)
+	if err != nil {
+		t.Fatalf("failed to list segments: err=%+v", err)
+	}
+	if len(segs) != len(testRecords) {
+		t.Errorf("got %d segments, want %d", len(segs), len(testRecords))
+	}
+}
+
+func TestReplayTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	writeRecords(t, dir, wal.Options{}, testRecords)
+	name := filepath.Join(dir, "00000000")
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("failed to read segment: err=%+v", err)
+	}
+	// The last record is a point record of 8 bytes header, 1 byte type,
+	// 1 byte reference and 12 bytes point.
+	lastRecordSize := 22
+
+	for n := 1; n <= lastRecordSize; n++ {
+		err = os.WriteFile(name, data[:len(data)-n], 0644)
+		if err != nil {
+			t.Fatalf("failed to write segment: err=%+v", err)
+		}
+
+		var r recorder
+		err = wal.Replay(dir, &r)
+		if err != nil {
+			t.Fatalf("truncated %d bytes: failed to replay WAL: err=%+v", n, err)
+		}
+		want := testRecords[:len(testRecords)-1]
+		if !reflect.DeepEqual(r.records, want) {
+			t.Errorf("truncated %d bytes: got=%+v, want=%+v", n, r.records, want)
+		}
+	}
+
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)-1] ^= 0xff
+	err = os.WriteFile(name, corrupted, 0644)
+	if err != nil {
+		t.Fatalf("failed to write segment: err=%+v", err)
+	}
+	var r recorder
+	err = wal.Replay(dir, &r)
+	if err != nil {
+		t.Fatalf("corrupted last record: failed to replay WAL: err=%+v", err)
+	}
+
+	corrupted = append([]byte(nil), data...)
+	corrupted[10] ^= 0xff
+	err = os.WriteFile(name, corrupted, 0644)
+	if err != nil {
+		t.Fatalf("failed to write segment: err=%+v", err)
+	}
+	err = wal.Replay(dir, &r)
+	if _, ok := err.(*wal.CorruptionError); !ok {
+		t.Errorf("corrupted first record: got err=%v, want *wal.CorruptionError", err)
+	}
+}
+
+func TestAppenderReplay(t *testing.T) {
+	dir := t.TempDir()
+	points := []timeseries.Point{
+		{Timestamp: 1427162462, Value: 12.0},
+		{Timestamp: 1427162522, Value: 12.5},
+		{Timestamp: 1427162582, Value: -24.2},
+	}
+
+	w, err := wal.Open(dir, wal.Options{SegmentSize: 64})
+	if err != nil {
+		t.Fatalf("failed to open WAL: err=%+v", err)
+	}
+	s, err := store.New(store.Options{})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+	a := wal.NewAppender(w, s)
+	for _, p := range points {
+		err = a.Append("cpu", p)
+		if err != nil {
+			t.Fatalf("failed to append point: err=%+v", err)
+		}
+	}
+	err = a.Append("cpu", timeseries.Point{Timestamp: 1427162400, Value: 1})
+	if !errors.Is(err, timeseries.ErrOutOfOrder) {
+		t.Errorf("out of order point: got err=%v, want %v", err, timeseries.ErrOutOfOrder)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("failed to close WAL: err=%+v", err)
+	}
+
+	// Drop the first segment to check every segment is self-contained.
+	segs, err := wal.Segments(dir)
+	if err != nil {
+		t.Fatalf("failed to list segments: err=%+v", err)
+	}
+	if len(segs) < 2 {
+		t.Fatalf("got %d segments, want 2 or more", len(segs))
+	}
+	err = os.Remove(filepath.Join(dir, "00000000"))
+	if err != nil {
+		t.Fatalf("failed to remove segment: err=%+v", err)
+	}
+
+	s2, err := store.New(store.Options{})
+	if err != nil {
+		t.Fatalf("failed to create store: err=%+v", err)
+	}
+	w2, err := wal.Open(dir, wal.Options{})
+	if err != nil {
+		t.Fatalf("failed to open WAL: err=%+v", err)
+	}
+	defer w2.Close()
+	a2 := wal.NewAppender(w2, s2)
+	err = a2.Replay(dir)
+	if err != nil {
+		t.Fatalf("failed to replay WAL: err=%+v", err)
+	}
+	got, err := s2.Query("cpu", 0, 0xFFFFFFFF)
+	if err != nil {
+		t.Fatalf("failed to query: err=%+v", err)
+	}
+	want := points[len(points)-len(got):]
+	if len(got) == 0 || len(got) == len(points) || !reflect.DeepEqual(got, want) {
+		t.Errorf("got=%+v, want a non-empty suffix of %+v", got, points)
+	}
+
+	err = a2.Append("cpu", timeseries.Point{Timestamp: 1427162642, Value: 1})
+	if err != nil {
+		t.Fatalf("failed to append point after replay: err=%+v", err)
+	}
+}